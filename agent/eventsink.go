@@ -27,6 +27,7 @@
 package agent
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/jasonish/evebox/eve"
 	"github.com/jasonish/evebox/util"
@@ -50,8 +51,8 @@ func NewEventChannel(client *Client) *AgentEventSink {
 	return &eventChannel
 }
 
-func (ec *AgentEventSink) Commit() (interface{}, error) {
-	response, err := ec.client.httpClient.PostBytes("api/1/submit",
+func (ec *AgentEventSink) Commit(ctx context.Context) (interface{}, error) {
+	response, err := ec.client.httpClient.WithContext(ctx).PostBytes("api/1/submit",
 		"application/json", ec.buf)
 	if err != nil {
 		return nil, err
@@ -72,7 +73,7 @@ func (ec *AgentEventSink) Commit() (interface{}, error) {
 	return &jsonMap, nil
 }
 
-func (ec *AgentEventSink) Submit(event eve.EveEvent) error {
+func (ec *AgentEventSink) Submit(ctx context.Context, event eve.EveEvent) error {
 	rawEvent, err := json.Marshal(event)
 	if err != nil {
 		return err
@@ -30,7 +30,13 @@ import (
 	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/elasticsearch"
 	"github.com/jasonish/evebox/geoip"
+	"github.com/jasonish/evebox/notify"
+	"github.com/jasonish/evebox/pcapstore"
 	"github.com/jasonish/evebox/sqlite/configdb"
+	"github.com/jasonish/evebox/stream"
+	"github.com/jasonish/evebox/suppression"
+	"github.com/jasonish/evebox/syslog"
+	"time"
 )
 
 type GithubAuthConfig struct {
@@ -47,6 +53,9 @@ type Config struct {
 		TlsKey         string
 		ReverseProxy   bool
 		RequestLogging bool
+
+		// Timeout for API requests, 0 for no timeout.
+		QueryTimeout time.Duration
 	}
 
 	LetsEncryptHostname string
@@ -71,6 +80,23 @@ type AppContext struct {
 	ConfigDB  *configdb.ConfigDB
 	Userstore core.UserStore
 
+	SavedSearchStore core.SavedSearchStore
+	CaseStore        core.CaseStore
+
+	// Suppression rules, nil without a configuration database such as
+	// with oneshot.
+	SuppressionRuleStore core.SuppressionRuleStore
+	SuppressionFilter    *suppression.Filter
+
+	// Notification engine, nil if notifications are not enabled.
+	Notifier *notify.Engine
+
+	// Forwards alerts to syslog, nil if not enabled.
+	SyslogForwarder *syslog.Forwarder
+
+	// Publishes received events and user actions to the stream API.
+	Stream *stream.Broker
+
 	DataStore core.Datastore
 
 	ElasticSearch *elasticsearch.ElasticSearch
@@ -79,6 +105,10 @@ type AppContext struct {
 
 	GeoIpService *geoip.GeoIpService
 
+	// Directory of PCAP files to extract flows from, nil if not
+	// configured.
+	PcapStore *pcapstore.PcapStore
+
 	Features map[core.Feature]bool
 
 	Vars struct {
@@ -27,6 +27,7 @@
 package esimport
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/jasonish/evebox/elasticsearch"
@@ -271,12 +272,12 @@ func Main(args []string) {
 				}
 			}
 
-			indexer.Submit(event)
+			indexer.Submit(context.Background(), event)
 			count++
 		}
 
 		if eof || (count > 0 && count%BATCH_SIZE == 0) {
-			status, err := indexer.Commit()
+			status, err := indexer.Commit(context.Background())
 			if err != nil {
 				log.Fatal(err)
 			}
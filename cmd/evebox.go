@@ -33,6 +33,7 @@ import (
 	"github.com/jasonish/evebox/cmd/esimport"
 	"github.com/jasonish/evebox/cmd/evereader"
 	"github.com/jasonish/evebox/cmd/gencert"
+	"github.com/jasonish/evebox/cmd/migrate"
 	"github.com/jasonish/evebox/cmd/oneshot"
 	"github.com/jasonish/evebox/cmd/pgimport"
 	"github.com/jasonish/evebox/cmd/server"
@@ -58,6 +59,7 @@ Commands:
 	evereader		Run the Eve log reader tool
 	oneshot                 Run one time with an eve.json file
 	gencert                 Generate TLS certificate
+	migrate                 Migrate events between datastores
 
 `, os.Args[0])
 	fmt.Fprint(os.Stderr, usage)
@@ -98,6 +100,9 @@ func main() {
 		case "gencert":
 			gencert.Main(os.Args[2:])
 			return
+		case "migrate":
+			migrate.Main(os.Args[2:])
+			return
 		default:
 			log.Fatalf("Unknown command: %s", os.Args[1])
 		}
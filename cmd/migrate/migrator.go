@@ -0,0 +1,570 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/elasticsearch"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/log"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// The user state changes are made as when there is no user in the event
+// history to attribute them to.
+const MIGRATE_USERNAME = "evebox-migrate"
+
+// Fields used to find a migrated event in the destination datastore.
+var fingerprintFields = []string{
+	"timestamp",
+	"event_type",
+	"host",
+	"flow_id",
+	"tx_id",
+	"src_ip",
+	"src_port",
+	"dest_ip",
+	"dest_port",
+	"alert.signature_id",
+}
+
+// MigrateState is the progress of a migration, saved after each batch so
+// an interrupted migration can be resumed.
+type MigrateState struct {
+	Cursor   string `json:"cursor,omitempty"`
+	Count    int64  `json:"count"`
+	Complete bool   `json:"complete,omitempty"`
+}
+
+// Migrator copies events from one datastore to another. Events are read
+// in time order, submitted to the destination through its event sink,
+// then their archived and escalated state, tags and comments are applied
+// to the new event.
+type Migrator struct {
+	source        *Datastore
+	destination   *Datastore
+	options       core.EventQueryOptions
+	state         MigrateState
+	stateFilename string
+
+	// Set when resuming, until the first batch is migrated. The events
+	// of that batch may already have been submitted to the destination
+	// before the migration was interrupted.
+	resumed bool
+}
+
+func NewMigrator(source *Datastore, destination *Datastore, options core.EventQueryOptions) *Migrator {
+	options.Order = "asc"
+	return &Migrator{
+		source:      source,
+		destination: destination,
+		options:     options,
+	}
+}
+
+// Resume sets the file progress is saved to, continuing from the progress
+// already saved there if it exists.
+func (m *Migrator) Resume(filename string) error {
+	m.stateFilename = filename
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := json.Unmarshal(buf, &m.state); err != nil {
+		return errors.Wrapf(err, "failed to read %s", filename)
+	}
+	log.Info("Resuming migration after %d events", m.state.Count)
+	m.resumed = true
+	return nil
+}
+
+func (m *Migrator) saveState() error {
+	if m.stateFilename == "" {
+		return nil
+	}
+	buf, err := json.Marshal(&m.state)
+	if err != nil {
+		return err
+	}
+	tmp := m.stateFilename + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.stateFilename)
+}
+
+func (m *Migrator) Run(ctx context.Context) error {
+	if m.state.Complete {
+		log.Info("Migration already complete, %d events migrated",
+			m.state.Count)
+		return nil
+	}
+
+	start := time.Now()
+	migrated := int64(0)
+
+	for {
+		options := m.options
+		options.Cursor = m.state.Cursor
+		result, err := m.source.EventQuery(ctx, options)
+		if err != nil {
+			return errors.Wrap(err, "failed to query source events")
+		}
+
+		count, err := m.migrate(ctx, result.Events)
+		if err != nil {
+			return err
+		}
+		migrated += count
+		m.resumed = false
+
+		m.state.Count += count
+		m.state.Cursor = result.Next
+		m.state.Complete = result.Next == ""
+		if err := m.saveState(); err != nil {
+			return errors.Wrap(err, "failed to save migration state")
+		}
+
+		if result.Total > 0 {
+			log.Info("Migrated %d of %d events (%.1f%%)", m.state.Count,
+				result.Total, float64(m.state.Count)*100/float64(result.Total))
+		} else {
+			log.Info("Migrated %d events", m.state.Count)
+		}
+
+		if m.state.Complete {
+			break
+		}
+	}
+
+	log.Info("Migration complete, %d events migrated in %v", migrated,
+		time.Now().Sub(start))
+	return nil
+}
+
+// pendingEvent is an event submitted to the destination that still needs
+// its state applied.
+type pendingEvent struct {
+	sourceId    string
+	fingerprint string
+	timestamp   time.Time
+	eventType   string
+	state       *eventState
+
+	// The ID of the event in the destination, if known.
+	id string
+
+	// Set if the event was already in the destination when resuming.
+	existed bool
+}
+
+// migrate copies a batch of events to the destination returning the
+// number migrated.
+//
+// When resuming, events already in the destination are not submitted
+// again, and only the part of their state not yet applied is restored.
+func (m *Migrator) migrate(ctx context.Context, events []map[string]interface{}) (int64, error) {
+	sink := m.destination.GetEveEventSink()
+	if sink == nil {
+		return 0, errors.New("destination datastore does not provide an event sink")
+	}
+
+	pending := []*pendingEvent{}
+	count := int64(0)
+	submitted := 0
+
+	// Destination event IDs already used, in case the batch contains
+	// events that look the same.
+	claimed := map[string]bool{}
+
+	for _, wrapper := range events {
+		sourceId := fmt.Sprint(wrapper["_id"])
+
+		// The event query doesn't return the history for all datastores,
+		// so get each event in full.
+		full, err := m.source.GetEventById(ctx, sourceId)
+		if err != nil {
+			return count, errors.Wrapf(err, "failed to get event %s", sourceId)
+		}
+		if full == nil {
+			log.Warning("Event %s no longer exists, skipping", sourceId)
+			continue
+		}
+
+		event, err := toEveEvent(full["_source"])
+		if err != nil {
+			return count, errors.Wrapf(err, "failed to decode event %s", sourceId)
+		}
+
+		state := getEventState(event)
+
+		// Submit the event as it would have been received from the eve
+		// log, the state is applied after.
+		delete(event, "evebox")
+		event["tags"] = []interface{}{}
+
+		migrated := &pendingEvent{
+			sourceId:    sourceId,
+			fingerprint: fingerprint(event),
+			timestamp:   event.Timestamp(),
+			eventType:   event.EventType(),
+			state:       state,
+		}
+
+		if m.resumed {
+			id, err := m.findEvent(ctx, migrated, claimed)
+			if err != nil {
+				return count, err
+			}
+			if id != "" {
+				log.Debug("Event %s already migrated", sourceId)
+				claimed[id] = true
+				migrated.id = id
+				migrated.existed = true
+			}
+		}
+
+		if !state.empty() {
+			pending = append(pending, migrated)
+		}
+
+		if !migrated.existed {
+			if err := sink.Submit(ctx, event); err != nil {
+				return count, errors.Wrapf(err, "failed to submit event %s", sourceId)
+			}
+			submitted++
+		}
+		count++
+	}
+
+	if submitted > 0 {
+		if _, err := sink.Commit(ctx); err != nil {
+			return 0, errors.Wrap(err, "failed to commit events")
+		}
+		m.destination.Refresh()
+	}
+
+	for _, event := range pending {
+		if event.id == "" {
+			id, err := m.findEvent(ctx, event, claimed)
+			if err != nil {
+				return count, err
+			}
+			if id == "" {
+				log.Warning("Failed to find migrated event %s in the destination, its state was not migrated",
+					event.sourceId)
+				continue
+			}
+			claimed[id] = true
+			event.id = id
+		}
+
+		// Changes made to the event before the migration was
+		// interrupted are in its history.
+		applied := 0
+		if event.existed {
+			state, err := m.destinationState(ctx, event.id)
+			if err != nil {
+				return count, err
+			}
+			applied = len(state.history)
+		}
+
+		if err := m.restore(ctx, event.id, event.state, applied); err != nil {
+			return count, errors.Wrapf(err, "failed to restore state of event %s", event.sourceId)
+		}
+	}
+
+	return count, nil
+}
+
+// destinationState returns the state of an event in the destination.
+func (m *Migrator) destinationState(ctx context.Context, id string) (*eventState, error) {
+	full, err := m.destination.GetEventById(ctx, id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get destination event %s", id)
+	}
+	if full == nil {
+		return nil, errors.Errorf("destination event %s not found", id)
+	}
+	event, err := toEveEvent(full["_source"])
+	if err != nil {
+		return nil, err
+	}
+	return getEventState(event), nil
+}
+
+// findEvent returns the ID of a submitted event in the destination.
+func (m *Migrator) findEvent(ctx context.Context, event *pendingEvent, claimed map[string]bool) (string, error) {
+	options := core.EventQueryOptions{
+		MinTs:     event.timestamp.Add(-time.Millisecond),
+		MaxTs:     event.timestamp.Add(time.Millisecond),
+		EventType: event.eventType,
+		Order:     "asc",
+	}
+	for {
+		result, err := m.destination.EventQuery(ctx, options)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to query destination events")
+		}
+		for _, wrapper := range result.Events {
+			id := fmt.Sprint(wrapper["_id"])
+			if claimed[id] {
+				continue
+			}
+			candidate, err := toEveEvent(wrapper["_source"])
+			if err != nil {
+				return "", err
+			}
+			if fingerprint(candidate) == event.fingerprint {
+				return id, nil
+			}
+		}
+		if result.Next == "" {
+			return "", nil
+		}
+		options.Cursor = result.Next
+	}
+}
+
+// eventState is the EveBox state of an event.
+type eventState struct {
+	archived  bool
+	escalated bool
+	tags      []string
+	history   []elasticsearch.HistoryEntry
+}
+
+func getEventState(event eve.EveEvent) *eventState {
+	state := &eventState{}
+
+	if tags, ok := event["tags"].([]interface{}); ok {
+		for _, tag := range tags {
+			tag, ok := tag.(string)
+			if !ok {
+				continue
+			}
+			switch tag {
+			case core.TAG_ARCHIVED:
+				// Only alerts are archived by users, other events may be
+				// archived on insertion depending on the datastore.
+				state.archived = event.EventType() == "alert"
+			case core.TAG_ESCALATED:
+				state.escalated = true
+			default:
+				if !core.IsReservedTag(tag) {
+					state.tags = append(state.tags, tag)
+				}
+			}
+		}
+	}
+
+	if metadata, ok := event["evebox"].(map[string]interface{}); ok {
+		if history, ok := metadata["history"]; ok {
+			buf, err := json.Marshal(history)
+			if err == nil {
+				err = json.Unmarshal(buf, &state.history)
+			}
+			if err != nil {
+				log.Warning("Failed to decode event history: %v", err)
+			}
+		}
+	}
+
+	return state
+}
+
+func (s *eventState) empty() bool {
+	return !s.archived && !s.escalated && len(s.tags) == 0 &&
+		len(s.history) == 0
+}
+
+// change is a change to the state of an event in the destination. Each
+// change adds an entry to the history of the event.
+type change func(ctx context.Context) error
+
+// historyImporter is implemented by datastores that can apply an entry
+// from the history of an event in another datastore as is, keeping its
+// timestamp.
+type historyImporter interface {
+	ImportHistoryEntry(ctx context.Context, eventId string, entry elasticsearch.HistoryEntry) error
+}
+
+// historyChange returns the change replaying a history entry, imported
+// with its original timestamp if the destination supports it, otherwise
+// made with apply.
+func (m *Migrator) historyChange(id string, entry elasticsearch.HistoryEntry, apply change) change {
+	importer, ok := m.destination.Datastore.(historyImporter)
+	if !ok {
+		return apply
+	}
+	return func(ctx context.Context) error {
+		return importer.ImportHistoryEntry(ctx, id, entry)
+	}
+}
+
+// changes returns the changes that restore the archived and escalated
+// state, tags and comments of an event. The history is replayed in order,
+// as the user that made each change, then the archived and escalated
+// state is fixed up for any changes not in the history. Replayed history
+// keeps its timestamps where the destination supports it, otherwise they
+// are the time of the migration.
+func (m *Migrator) changes(id string, state *eventState) []change {
+	changes := []change{}
+	archived := false
+	escalated := false
+
+	for _, entry := range state.history {
+		user := core.User{Username: entry.Username}
+		switch entry.Action {
+		case elasticsearch.ACTION_ARCHIVED:
+			if state.archived && !archived {
+				changes = append(changes, m.historyChange(id, entry, func(ctx context.Context) error {
+					return m.destination.ArchiveEvent(ctx, id, user)
+				}))
+				archived = true
+			}
+		case elasticsearch.ACTION_ESCALATED:
+			if !escalated {
+				changes = append(changes, m.historyChange(id, entry, func(ctx context.Context) error {
+					return m.destination.EscalateEvent(ctx, id, user)
+				}))
+				escalated = true
+			}
+		case elasticsearch.ACTION_DEESCALATED:
+			if escalated {
+				changes = append(changes, m.historyChange(id, entry, func(ctx context.Context) error {
+					return m.destination.DeEscalateEvent(ctx, id, user)
+				}))
+				escalated = false
+			}
+		case elasticsearch.ACTION_COMMENT:
+			comment := entry.Comment
+			changes = append(changes, m.historyChange(id, entry, func(ctx context.Context) error {
+				return m.destination.CommentOnEventId(ctx, id, user, comment)
+			}))
+		case elasticsearch.ACTION_TAGGED:
+			if tags := entry.Tags; len(tags) > 0 {
+				changes = append(changes, m.historyChange(id, entry, func(ctx context.Context) error {
+					return m.destination.AddTagsToEvent(ctx, id, tags, user)
+				}))
+			}
+		case elasticsearch.ACTION_UNTAGGED:
+			if tags := entry.Tags; len(tags) > 0 {
+				changes = append(changes, m.historyChange(id, entry, func(ctx context.Context) error {
+					return m.destination.RemoveTagsFromEvent(ctx, id, tags, user)
+				}))
+			}
+		}
+	}
+
+	user := core.User{Username: MIGRATE_USERNAME}
+
+	if state.escalated != escalated {
+		if state.escalated {
+			changes = append(changes, func(ctx context.Context) error {
+				return m.destination.EscalateEvent(ctx, id, user)
+			})
+		} else {
+			changes = append(changes, func(ctx context.Context) error {
+				return m.destination.DeEscalateEvent(ctx, id, user)
+			})
+		}
+	}
+
+	if state.archived && !archived {
+		changes = append(changes, func(ctx context.Context) error {
+			return m.destination.ArchiveEvent(ctx, id, user)
+		})
+	}
+
+	return changes
+}
+
+// restore applies the state to an event in the destination, skipping the
+// first applied changes which were made before the migration was
+// interrupted.
+func (m *Migrator) restore(ctx context.Context, id string, state *eventState, applied int) error {
+	for i, change := range m.changes(id, state) {
+		if i < applied {
+			continue
+		}
+		if err := change(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Tags not restored from the history are added, adding tags the
+	// event already has is not a change so is not recorded.
+	if len(state.tags) > 0 {
+		user := core.User{Username: MIGRATE_USERNAME}
+		if err := m.destination.AddTagsToEvent(ctx, id, state.tags, user); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// toEveEvent converts an event source returned by a datastore to an
+// EveEvent.
+func toEveEvent(source interface{}) (eve.EveEvent, error) {
+	buf, err := json.Marshal(source)
+	if err != nil {
+		return nil, err
+	}
+	return eve.NewEveEventFromBytes(buf)
+}
+
+// fingerprint returns a string made of the fields that together identify
+// an event.
+func fingerprint(event eve.EveEvent) string {
+	values := []string{}
+	for _, field := range fingerprintFields {
+		var value interface{} = map[string]interface{}(event)
+		for _, key := range strings.Split(field, ".") {
+			if object, ok := value.(map[string]interface{}); ok {
+				value = object[key]
+			} else {
+				value = nil
+			}
+		}
+		buf, _ := json.Marshal(value)
+		values = append(values, string(buf))
+	}
+	return strings.Join(values, "|")
+}
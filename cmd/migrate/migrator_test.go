@@ -0,0 +1,273 @@
+// +build cgo
+
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/elasticsearch"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/sqlite"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	alice = core.User{Username: "alice"}
+	bob   = core.User{Username: "bob"}
+)
+
+// The events to migrate, in time order. With a batch size of 2 the
+// third event, with the most state, is the first of the second batch.
+var migrateEvents = []string{
+	`{"timestamp":"2017-06-01T10:00:01.000000+0000","event_type":"alert","src_ip":"10.0.0.1","dest_ip":"10.0.0.2","alert":{"signature_id":1,"signature":"One"}}`,
+	`{"timestamp":"2017-06-01T10:00:02.000000+0000","event_type":"alert","src_ip":"10.0.0.1","dest_ip":"10.0.0.2","alert":{"signature_id":1,"signature":"One"}}`,
+	`{"timestamp":"2017-06-01T10:00:03.000000+0000","event_type":"alert","src_ip":"10.0.0.1","dest_ip":"10.0.0.3","alert":{"signature_id":2,"signature":"Two"}}`,
+	`{"timestamp":"2017-06-01T10:00:04.000000+0000","event_type":"alert","src_ip":"10.0.0.4","dest_ip":"10.0.0.2","alert":{"signature_id":2,"signature":"Two"}}`,
+	`{"timestamp":"2017-06-01T10:00:05.000000+0000","event_type":"dns","src_ip":"10.0.0.1","dest_ip":"10.0.0.53","dns":{"rrname":"example.com"}}`,
+}
+
+func openSqlite(t *testing.T, dir string, name string) *Datastore {
+	datastore, err := sqlite.OpenDataStore(path.Join(dir, name))
+	require.Nil(t, err)
+	return &Datastore{Datastore: datastore}
+}
+
+// eventIds returns the IDs of the events in a datastore by the second of
+// their timestamp.
+func eventIds(t *testing.T, datastore core.Datastore) map[int]string {
+	r := require.New(t)
+	result, err := datastore.EventQuery(context.Background(),
+		core.EventQueryOptions{Size: 100})
+	r.Nil(err)
+	ids := map[int]string{}
+	for _, wrapper := range result.Events {
+		event, err := toEveEvent(wrapper["_source"])
+		r.Nil(err)
+		second := event.Timestamp().Second()
+		_, exists := ids[second]
+		r.False(exists, "duplicate event at second %d", second)
+		ids[second] = fmt.Sprint(wrapper["_id"])
+	}
+	return ids
+}
+
+// setupSource returns a source datastore with the events to migrate and
+// state applied to them.
+func setupSource(t *testing.T, dir string) *Datastore {
+	r := require.New(t)
+	ctx := context.Background()
+	source := openSqlite(t, dir, "source.sqlite")
+
+	sink := source.GetEveEventSink()
+	for _, raw := range migrateEvents {
+		event, err := eve.NewEveEventFromString(raw)
+		r.Nil(err)
+		r.Nil(sink.Submit(ctx, event))
+	}
+	_, err := sink.Commit(ctx)
+	r.Nil(err)
+
+	ids := eventIds(t, source)
+	r.Len(ids, len(migrateEvents))
+	r.Nil(source.EscalateEvent(ctx, ids[1], alice))
+	r.Nil(source.AddTagsToEvent(ctx, ids[2], []string{"incident"}, alice))
+	r.Nil(source.EscalateEvent(ctx, ids[3], alice))
+	r.Nil(source.CommentOnEventId(ctx, ids[3], bob, "Looks bad"))
+	r.Nil(source.DeEscalateEvent(ctx, ids[3], bob))
+	r.Nil(source.ArchiveEvent(ctx, ids[3], bob))
+	r.Nil(source.AddTagsToEvent(ctx, ids[3], []string{"noisy"}, bob))
+	r.Nil(source.ArchiveEvent(ctx, ids[4], alice))
+
+	return source
+}
+
+func requireMigrated(t *testing.T, source core.Datastore, destination core.Datastore) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	sourceIds := eventIds(t, source)
+	ids := eventIds(t, destination)
+	r.Len(ids, len(migrateEvents))
+
+	getState := func(datastore core.Datastore, id string) *eventState {
+		full, err := datastore.GetEventById(ctx, id)
+		r.Nil(err)
+		event, err := toEveEvent(full["_source"])
+		r.Nil(err)
+		return getEventState(event)
+	}
+
+	state := func(second int) *eventState {
+		return getState(destination, ids[second])
+	}
+
+	timestamps := func(state *eventState) []string {
+		timestamps := []string{}
+		for _, entry := range state.history {
+			timestamps = append(timestamps, entry.Timestamp)
+		}
+		return timestamps
+	}
+
+	// The history keeps the timestamps of the source.
+	for second := range ids {
+		r.Equal(timestamps(getState(source, sourceIds[second])),
+			timestamps(state(second)))
+	}
+
+	actions := func(state *eventState) []string {
+		actions := []string{}
+		for _, entry := range state.history {
+			actions = append(actions, fmt.Sprintf("%s/%s/%s",
+				entry.Action, entry.Username, entry.Comment))
+		}
+		return actions
+	}
+
+	s := state(1)
+	r.True(s.escalated)
+	r.False(s.archived)
+	r.Equal([]string{"escalated/alice/"}, actions(s))
+
+	s = state(2)
+	r.False(s.escalated)
+	r.Equal([]string{"incident"}, s.tags)
+	r.Equal([]string{"tagged/alice/"}, actions(s))
+
+	s = state(3)
+	r.False(s.escalated)
+	r.True(s.archived)
+	r.Equal([]string{"noisy"}, s.tags)
+	r.Equal([]string{
+		"escalated/alice/",
+		"comment/bob/Looks bad",
+		"de-escalated/bob/",
+		"archived/bob/",
+		"tagged/bob/",
+	}, actions(s))
+
+	s = state(4)
+	r.True(s.archived)
+	r.Equal([]string{"archived/alice/"}, actions(s))
+
+	r.True(state(5).empty())
+}
+
+func TestMigrate(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "evebox-migrate-test")
+	r.Nil(err)
+	defer os.RemoveAll(dir)
+
+	source := setupSource(t, dir)
+	destination := openSqlite(t, dir, "destination.sqlite")
+
+	migrator := NewMigrator(source, destination, core.EventQueryOptions{Size: 2})
+	r.Nil(migrator.Run(context.Background()))
+
+	requireMigrated(t, source, destination)
+}
+
+// interruptingDatastore fails the first time an event is archived, after
+// the events of the batch have been submitted and part of their state
+// restored.
+type interruptingDatastore struct {
+	core.Datastore
+	interrupted bool
+}
+
+func (d *interruptingDatastore) interrupt() error {
+	if !d.interrupted {
+		d.interrupted = true
+		return errors.New("interrupted")
+	}
+	return nil
+}
+
+func (d *interruptingDatastore) ArchiveEvent(ctx context.Context, id string, user core.User) error {
+	if err := d.interrupt(); err != nil {
+		return err
+	}
+	return d.Datastore.ArchiveEvent(ctx, id, user)
+}
+
+func (d *interruptingDatastore) ImportHistoryEntry(ctx context.Context, id string, entry elasticsearch.HistoryEntry) error {
+	if entry.Action == elasticsearch.ACTION_ARCHIVED {
+		if err := d.interrupt(); err != nil {
+			return err
+		}
+	}
+	return d.Datastore.(historyImporter).ImportHistoryEntry(ctx, id, entry)
+}
+
+func TestMigrateResume(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "evebox-migrate-test")
+	r.Nil(err)
+	defer os.RemoveAll(dir)
+
+	source := setupSource(t, dir)
+	destination := openSqlite(t, dir, "destination.sqlite")
+	stateFilename := path.Join(dir, "migrate.json")
+	options := core.EventQueryOptions{Size: 2}
+
+	interrupting := &interruptingDatastore{Datastore: destination.Datastore}
+	migrator := NewMigrator(source, &Datastore{Datastore: interrupting}, options)
+	r.Nil(migrator.Resume(stateFilename))
+	r.NotNil(migrator.Run(context.Background()))
+	r.True(interrupting.interrupted)
+
+	// Only the first batch was saved as migrated, the events of the
+	// second batch are already in the destination.
+	r.Len(eventIds(t, destination), 4)
+
+	migrator = NewMigrator(source, destination, options)
+	r.Nil(migrator.Resume(stateFilename))
+	r.Equal(int64(2), migrator.state.Count)
+	r.Nil(migrator.Run(context.Background()))
+	r.True(migrator.state.Complete)
+	r.Equal(int64(len(migrateEvents)), migrator.state.Count)
+
+	requireMigrated(t, source, destination)
+
+	// Running again does nothing.
+	migrator = NewMigrator(source, destination, options)
+	r.Nil(migrator.Resume(stateFilename))
+	r.Nil(migrator.Run(context.Background()))
+	requireMigrated(t, source, destination)
+}
@@ -27,6 +27,7 @@
 package oneshot
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/jasonish/evebox/appcontext"
@@ -37,6 +38,7 @@ import (
 	"github.com/jasonish/evebox/log"
 	"github.com/jasonish/evebox/server"
 	"github.com/jasonish/evebox/sqlite"
+	"github.com/jasonish/evebox/stream"
 	"github.com/jasonish/evebox/useragent"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -110,6 +112,7 @@ Example:
 
 	appContext := appcontext.AppContext{}
 	appContext.GeoIpService = geoip.NewGeoIpService()
+	appContext.Stream = stream.NewBroker()
 
 	if opts.InMemory {
 		log.Info("Using in-memory database")
@@ -161,6 +164,7 @@ Example:
 			&eve.TagsFilter{},
 			eve.NewGeoipFilter(appContext.GeoIpService),
 			&useragent.EveUserAgentFilter{},
+			appContext.Stream,
 		}
 	Loop:
 		for i, filename := range flagset.Args() {
@@ -207,7 +211,7 @@ Example:
 						filter.Filter(event)
 					}
 
-					if err := eventSink.Submit(event); err != nil {
+					if err := eventSink.Submit(context.Background(), event); err != nil {
 						log.Fatal(err)
 					}
 					queued++
@@ -220,7 +224,7 @@ Example:
 					if eof && done {
 						log.Info("Adding %d events.", queued)
 					}
-					if _, err := eventSink.Commit(); err != nil {
+					if _, err := eventSink.Commit(context.Background()); err != nil {
 						log.Fatal(err)
 					}
 					queued = 0
@@ -252,7 +256,7 @@ Example:
 				}
 			}
 
-			if _, err := eventSink.Commit(); err != nil {
+			if _, err := eventSink.Commit(context.Background()); err != nil {
 				log.Fatal(err)
 			}
 			log.Info("%s: %d events (100%%)", filename, count)
@@ -27,6 +27,7 @@
 package pgimport
 
 import (
+	"context"
 	"github.com/jasonish/evebox/eve"
 	"github.com/jasonish/evebox/evereader"
 	"github.com/jasonish/evebox/log"
@@ -99,14 +100,14 @@ func Main(args []string) {
 				if event == nil {
 					break
 				}
-				_indexer.Submit(event)
+				_indexer.Submit(context.Background(), event)
 				_count++
 				if _count == 1000 {
-					_indexer.Commit()
+					_indexer.Commit(context.Background())
 					_count = 0
 				}
 			}
-			_indexer.Commit()
+			_indexer.Commit(context.Background())
 			log.Info("Thread %d returning.", thread)
 			wg.Done()
 		}()
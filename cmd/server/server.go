@@ -27,6 +27,7 @@
 package server
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/jasonish/evebox/appcontext"
@@ -37,15 +38,23 @@ import (
 	"github.com/jasonish/evebox/exiter"
 	"github.com/jasonish/evebox/geoip"
 	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/notify"
+	"github.com/jasonish/evebox/pcapstore"
 	"github.com/jasonish/evebox/postgres"
 	"github.com/jasonish/evebox/rules"
 	"github.com/jasonish/evebox/server"
 	"github.com/jasonish/evebox/sqlite"
 	"github.com/jasonish/evebox/sqlite/configdb"
+	"github.com/jasonish/evebox/stream"
+	"github.com/jasonish/evebox/suppression"
+	"github.com/jasonish/evebox/syslog"
 	"github.com/jasonish/evebox/useragent"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -80,6 +89,9 @@ func initViper() {
 	viper.SetDefault("http.request-logging", false)
 	viper.BindEnv("http.request-logging", "EVEBOX_HTTP_REQUEST_LOGGING")
 
+	viper.SetDefault("http.query-timeout", 0)
+	viper.BindEnv("http.query-timeout", "EVEBOX_HTTP_QUERY_TIMEOUT")
+
 	viper.SetDefault("elasticsearch", DEFAULT_ELASTICSEARCH_URL)
 	viper.SetDefault("index", DEFAULT_ELASTICSEARCH_INDEX)
 
@@ -118,6 +130,8 @@ func initViper() {
 
 	viper.SetDefault("database.postgresql.password", "")
 	viper.BindEnv("database.postgresql.password", "PGPASSWORD")
+
+	viper.BindEnv("pcap-store.directory", "EVEBOX_PCAP_STORE_DIRECTORY")
 }
 
 func getElasticSearchKeyword(flagset *pflag.FlagSet) (bool, string) {
@@ -141,6 +155,7 @@ func configure(config *appcontext.Config) {
 
 	config.Http.ReverseProxy = viper.GetBool("http.reverse-proxy")
 	config.Http.RequestLogging = viper.GetBool("http.request-logging")
+	config.Http.QueryTimeout = viper.GetDuration("http.query-timeout")
 
 	config.LetsEncryptHostname = viper.GetString("letsencrypt.hostname")
 
@@ -289,6 +304,13 @@ func Main(args []string) {
 
 	// Not sure about doing this with an in-memory store right now.
 	appContext.Userstore = configdb.NewUserStore(appContext.ConfigDB.DB)
+	appContext.SavedSearchStore = configdb.NewSavedSearchStore(appContext.ConfigDB.DB)
+	appContext.CaseStore = configdb.NewCaseStore(appContext.ConfigDB.DB)
+	appContext.SuppressionRuleStore = configdb.NewSuppressionRuleStore(appContext.ConfigDB.DB)
+	appContext.SuppressionFilter, err = suppression.NewFilter(appContext.SuppressionRuleStore)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	switch viper.GetString("database.type") {
 	case "elasticsearch":
@@ -400,15 +422,30 @@ func Main(args []string) {
 		pgMigrator.Migrate()
 
 		appContext.DataStore = postgres.NewPgDatastore(pg)
+		appContext.ReportService = postgres.NewReportService(pg)
 
+		appContext.SetFeature(core.FEATURE_REPORTING)
 		appContext.SetFeature(core.FEATURE_COMMENTS)
 	default:
 		log.Fatal("unsupported datastore: ",
 			viper.GetString("database.type"))
 	}
 
+	initPcapStore(&appContext)
+	initNotifications(&appContext)
+	initSyslog(&appContext)
+	appContext.Stream = stream.NewBroker()
 	initInternalEveReader(&appContext, *inputStart)
 
+	// Exit cleanly on a signal so queued notifications are sent.
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigchan
+		log.Info("Got signal %d, stopping.", sig)
+		exiter.Exit(0)
+	}()
+
 	httpServer := server.NewServer(appContext)
 	err = httpServer.Start(opts.Host, opts.Port)
 	if err != nil {
@@ -418,6 +455,63 @@ func Main(args []string) {
 	exiter.Exit(0)
 }
 
+func initPcapStore(appContext *appcontext.AppContext) {
+	directory := viper.GetString("pcap-store.directory")
+	if directory == "" {
+		return
+	}
+	info, err := os.Stat(directory)
+	if err != nil {
+		log.Fatalf("Failed to open pcap-store directory: %v", err)
+	}
+	if !info.IsDir() {
+		log.Fatalf("pcap-store directory %s is not a directory", directory)
+	}
+	log.Info("Extracting flows from PCAP files in %s", directory)
+	appContext.PcapStore = pcapstore.NewPcapStore(directory)
+	appContext.SetFeature(core.FEATURE_PCAP_STORE)
+}
+
+func initNotifications(appContext *appcontext.AppContext) {
+	if !viper.GetBool("notifications.enabled") {
+		return
+	}
+	var config notify.Config
+	if err := viper.UnmarshalKey("notifications", &config); err != nil {
+		log.Fatalf("Failed to read notifications configuration: %v", err)
+	}
+	engine, err := notify.NewEngine(config)
+	if err != nil {
+		log.Fatalf("Failed to configure notifications: %v", err)
+	}
+	if err := engine.LoadSeen(context.Background(), appContext.DataStore); err != nil {
+		log.Warning("Failed to load seen signatures, new-signature rules "+
+			"may notify for signatures seen before: %v", err)
+	}
+	log.Info("Notifications enabled with %d rules", len(config.Rules))
+	engine.Start()
+	exiter.AtExit(engine.Stop)
+	appContext.Notifier = engine
+}
+
+func initSyslog(appContext *appcontext.AppContext) {
+	if !viper.GetBool("syslog.enabled") {
+		return
+	}
+	config := syslog.DefaultConfig()
+	if err := viper.UnmarshalKey("syslog", &config); err != nil {
+		log.Fatalf("Failed to read syslog configuration: %v", err)
+	}
+	forwarder, err := syslog.NewForwarder(config)
+	if err != nil {
+		log.Fatalf("Failed to configure syslog forwarding: %v", err)
+	}
+	log.Info("Forwarding alerts to syslog %s over %s as %s",
+		config.Address, config.Protocol, config.Format)
+	forwarder.Start()
+	appContext.SyslogForwarder = forwarder
+}
+
 func initInternalEveReader(appContext *appcontext.AppContext, inputStart bool) {
 	enabled := viper.GetBool("input.enabled")
 	if !enabled {
@@ -453,5 +547,15 @@ func initInternalEveReader(appContext *appcontext.AppContext, inputStart bool) {
 		eveFileProcessor.AddCustomField(field, value)
 	}
 
+	// Last so rules can match on fields added by the other filters.
+	eveFileProcessor.AddFilter(appContext.SuppressionFilter)
+	if appContext.Notifier != nil {
+		eveFileProcessor.AddFilter(appContext.Notifier)
+	}
+	if appContext.SyslogForwarder != nil {
+		eveFileProcessor.AddFilter(appContext.SyslogForwarder)
+	}
+	eveFileProcessor.AddFilter(appContext.Stream)
+
 	eveFileProcessor.Start()
 }
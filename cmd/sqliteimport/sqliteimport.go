@@ -29,6 +29,7 @@
 package sqliteimport
 
 import (
+	"context"
 	"fmt"
 	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/eve"
@@ -70,7 +71,7 @@ func MainLoop(reader *evereader.FollowingReader, indexer core.EveEventSink,
 
 		if event != nil {
 			tagsFilter.Filter(event)
-			indexer.Submit(event)
+			indexer.Submit(context.Background(), event)
 			count++
 		}
 
@@ -78,7 +79,7 @@ func MainLoop(reader *evereader.FollowingReader, indexer core.EveEventSink,
 
 		// Commit every 100ms or so...
 		if eof || now.Sub(lastCommitTs).Seconds() >= 0.1 {
-			indexer.Commit()
+			indexer.Commit(context.Background())
 			lastCommitTs = time.Now()
 
 			if bookmarker != nil {
@@ -103,10 +104,10 @@ func MainLoop(reader *evereader.FollowingReader, indexer core.EveEventSink,
 
 		if eof {
 			if oneshot {
-				indexer.Commit()
+				indexer.Commit(context.Background())
 				break
 			} else {
-				indexer.Commit()
+				indexer.Commit(context.Background())
 				time.Sleep(100 * time.Millisecond)
 			}
 		}
@@ -0,0 +1,191 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package core
+
+import (
+	"github.com/pkg/errors"
+	"time"
+)
+
+// Case statuses.
+const (
+	CASE_STATUS_OPEN        = "open"
+	CASE_STATUS_IN_PROGRESS = "in-progress"
+	CASE_STATUS_CLOSED      = "closed"
+)
+
+// Case severities.
+const (
+	CASE_SEVERITY_LOW      = "low"
+	CASE_SEVERITY_MEDIUM   = "medium"
+	CASE_SEVERITY_HIGH     = "high"
+	CASE_SEVERITY_CRITICAL = "critical"
+)
+
+// Actions recorded in the timeline of a case.
+const (
+	CASE_ACTION_CREATED             = "created"
+	CASE_ACTION_UPDATED             = "updated"
+	CASE_ACTION_COMMENT             = "comment"
+	CASE_ACTION_EVENT_ADDED         = "event-added"
+	CASE_ACTION_EVENT_REMOVED       = "event-removed"
+	CASE_ACTION_ALERT_GROUP_ADDED   = "alert-group-added"
+	CASE_ACTION_ALERT_GROUP_REMOVED = "alert-group-removed"
+)
+
+var ErrCaseNotFound = errors.New("case does not exist")
+
+// Case tracks an investigation, grouping the events and alert groups
+// attached to it.
+type Case struct {
+	Id          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Status      string `json:"status"`
+	Severity    string `json:"severity"`
+	Assignee    string `json:"assignee,omitempty"`
+
+	// The user that opened the case.
+	Owner string `json:"owner"`
+
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+
+	// Only set when a single case is fetched.
+	Events      []CaseEvent         `json:"events,omitempty"`
+	AlertGroups []CaseAlertGroup    `json:"alert_groups,omitempty"`
+	Timeline    []CaseTimelineEntry `json:"timeline,omitempty"`
+}
+
+type CaseEvent struct {
+	EventId  string    `json:"event_id"`
+	Username string    `json:"username"`
+	Added    time.Time `json:"added"`
+}
+
+// CaseAlertGroup is an alert group attached to a case, stored as the
+// parameters that select its events.
+type CaseAlertGroup struct {
+	Id           string    `json:"id"`
+	SignatureId  uint64    `json:"signature_id"`
+	SrcIp        string    `json:"src_ip,omitempty"`
+	DestIp       string    `json:"dest_ip,omitempty"`
+	Host         string    `json:"host,omitempty"`
+	MinTimestamp time.Time `json:"min_timestamp"`
+	MaxTimestamp time.Time `json:"max_timestamp"`
+	GroupBy      []string  `json:"group_by,omitempty"`
+	Username     string    `json:"username"`
+	Added        time.Time `json:"added"`
+}
+
+type CaseTimelineEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Username  string    `json:"username"`
+	Action    string    `json:"action"`
+	Comment   string    `json:"comment,omitempty"`
+}
+
+// CaseQuery limits the cases returned by CaseStore.Find. Empty fields
+// match all cases.
+type CaseQuery struct {
+	Status   string
+	Assignee string
+}
+
+func (c *Case) Validate() error {
+	if c.Title == "" {
+		return errors.New("title is required")
+	}
+	switch c.Status {
+	case CASE_STATUS_OPEN, CASE_STATUS_IN_PROGRESS, CASE_STATUS_CLOSED:
+	default:
+		return errors.Errorf("invalid status: %s", c.Status)
+	}
+	switch c.Severity {
+	case CASE_SEVERITY_LOW, CASE_SEVERITY_MEDIUM, CASE_SEVERITY_HIGH,
+		CASE_SEVERITY_CRITICAL:
+	default:
+		return errors.Errorf("invalid severity: %s", c.Severity)
+	}
+	return nil
+}
+
+func NewCaseAlertGroup(p AlertGroupQueryParams) CaseAlertGroup {
+	return CaseAlertGroup{
+		SignatureId:  p.SignatureID,
+		SrcIp:        p.SrcIP,
+		DestIp:       p.DstIP,
+		Host:         p.Host,
+		MinTimestamp: p.MinTimestamp,
+		MaxTimestamp: p.MaxTimestamp,
+		GroupBy:      p.GroupBy,
+	}
+}
+
+// Params returns the parameters to query the events of the alert group.
+func (g *CaseAlertGroup) Params() AlertGroupQueryParams {
+	return AlertGroupQueryParams{
+		SignatureID:  g.SignatureId,
+		SrcIP:        g.SrcIp,
+		DstIP:        g.DestIp,
+		Host:         g.Host,
+		MinTimestamp: g.MinTimestamp,
+		MaxTimestamp: g.MaxTimestamp,
+		GroupBy:      g.GroupBy,
+	}
+}
+
+// CaseStore stores cases. Changes are recorded in the case timeline as
+// made by the given username.
+type CaseStore interface {
+	// Add a case returning its ID.
+	Add(c Case) (string, error)
+
+	// Update the title, description, status, severity and assignee of
+	// the case with the ID of the provided case.
+	Update(c Case, username string) error
+
+	Delete(id string) error
+
+	// FindById returns a case along with its events, alert groups and
+	// timeline.
+	FindById(id string) (Case, error)
+
+	// Find returns the cases matching the query, most recently updated
+	// first, without their events, alert groups and timeline.
+	Find(query CaseQuery) ([]Case, error)
+
+	AddEvent(caseId string, eventId string, username string) error
+	RemoveEvent(caseId string, eventId string, username string) error
+
+	// AddAlertGroup attaches an alert group to the case, returning the
+	// ID of the attached group.
+	AddAlertGroup(caseId string, group CaseAlertGroup, username string) (string, error)
+	RemoveAlertGroup(caseId string, groupId string, username string) error
+
+	AddComment(caseId string, username string, comment string) error
+}
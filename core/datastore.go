@@ -27,6 +27,7 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"github.com/jasonish/evebox/log"
 	"github.com/pkg/errors"
@@ -40,41 +41,64 @@ func init() {
 
 type Datastore interface {
 	GetEveEventSink() EveEventSink
-	AlertQuery(options AlertQueryOptions) ([]AlertGroup, error)
-	EventQuery(options EventQueryOptions) (interface{}, error)
-	ArchiveAlertGroup(p AlertGroupQueryParams, u User) error
-	EscalateAlertGroup(p AlertGroupQueryParams, u User) error
-	DeEscalateAlertGroup(p AlertGroupQueryParams, u User) error
-	ArchiveEvent(eventId string, user User) error
-	EscalateEvent(eventId string, user User) error
-	DeEscalateEvent(eventId string, user User) error
-	GetEventById(id string) (map[string]interface{}, error)
-	FindFlow(flowId uint64, proto string, timestamp string, srcIp string, destIp string) (interface{}, error)
-	FindNetflow(options EventQueryOptions, sortBy string, order string) (interface{}, error)
-	CommentOnEventId(eventId string, user User, comment string) error
-	CommentOnAlertGroup(p AlertGroupQueryParams, user User, comment string) error
+	AlertQuery(ctx context.Context, options AlertQueryOptions) ([]AlertGroup, error)
+	EventQuery(ctx context.Context, options EventQueryOptions) (*EventQueryResult, error)
+	ArchiveAlertGroup(ctx context.Context, p AlertGroupQueryParams, u User) error
+	EscalateAlertGroup(ctx context.Context, p AlertGroupQueryParams, u User) error
+	DeEscalateAlertGroup(ctx context.Context, p AlertGroupQueryParams, u User) error
+	ArchiveEvent(ctx context.Context, eventId string, user User) error
+	EscalateEvent(ctx context.Context, eventId string, user User) error
+	DeEscalateEvent(ctx context.Context, eventId string, user User) error
+	GetEventById(ctx context.Context, id string) (map[string]interface{}, error)
+	FindFlow(ctx context.Context, flowId uint64, proto string, timestamp string, srcIp string, destIp string) (interface{}, error)
+	FindNetflow(ctx context.Context, options EventQueryOptions, sortBy string, order string) (interface{}, error)
+	CommentOnEventId(ctx context.Context, eventId string, user User, comment string) error
+	CommentOnAlertGroup(ctx context.Context, p AlertGroupQueryParams, user User, comment string) error
+
+	// UpdateAlertsByQuery applies the action to all alerts matching the
+	// query options, returning the number of events updated. Each
+	// updated event gets an entry in its history as with the single
+	// event and alert group actions.
+	UpdateAlertsByQuery(ctx context.Context, options AlertQueryOptions, action AlertAction, user User) (int64, error)
+
+	// Add and remove user tags. The archived and escalated tags are
+	// managed by their own methods. Each event with its tags changed
+	// gets a tagged or untagged entry, with the tags, in its history.
+	AddTagsToEvent(ctx context.Context, eventId string, tags []string, user User) error
+	RemoveTagsFromEvent(ctx context.Context, eventId string, tags []string, user User) error
+	AddTagsToAlertGroup(ctx context.Context, p AlertGroupQueryParams, tags []string, user User) error
+	RemoveTagsFromAlertGroup(ctx context.Context, p AlertGroupQueryParams, tags []string, user User) error
+
+	// TagCounts returns the tags in use on the events matching the
+	// options along with the number of events having each tag.
+	TagCounts(ctx context.Context, options EventQueryOptions) ([]TagCount, error)
+
+	// ExportEvents calls fn with each event matching the options, in
+	// the requested order, without holding the result set in memory.
+	// Size and Cursor are ignored. Stops on the first error from fn.
+	ExportEvents(ctx context.Context, options EventQueryOptions, fn func(event map[string]interface{}) error) error
 }
 
 type UnimplementedDatastore struct {
 }
 
-func (d *UnimplementedDatastore) CommentOnAlertGroup(p AlertGroupQueryParams, user User, comment string) error {
+func (d *UnimplementedDatastore) CommentOnAlertGroup(ctx context.Context, p AlertGroupQueryParams, user User, comment string) error {
 	return errors.New("CommentOnAlertGroup not implemented by active datastore.")
 }
 
-func (d *UnimplementedDatastore) CommentOnEventId(eventId string, user User, comment string) error {
+func (d *UnimplementedDatastore) CommentOnEventId(ctx context.Context, eventId string, user User, comment string) error {
 	return errors.New("CommentOnEventId not implemented by active datastore.")
 }
 
-func (d *UnimplementedDatastore) ArchiveEvent(eventId string, user User) error {
+func (d *UnimplementedDatastore) ArchiveEvent(ctx context.Context, eventId string, user User) error {
 	return errors.New("ArchiveEvent not implemented by this datastore.")
 }
 
-func (d *UnimplementedDatastore) EscalateEvent(eventId string, user User) error {
+func (d *UnimplementedDatastore) EscalateEvent(ctx context.Context, eventId string, user User) error {
 	return errors.New("EscalateEvent not implemented by this datastore.")
 }
 
-func (d *UnimplementedDatastore) DeEscalateEvent(eventId string, user User) error {
+func (d *UnimplementedDatastore) DeEscalateEvent(ctx context.Context, eventId string, user User) error {
 	return errors.New("DeEscalateEvent not implemented by this datastore.")
 }
 
@@ -83,38 +107,66 @@ func (d *UnimplementedDatastore) GetEveEventSink() EveEventSink {
 	return nil
 }
 
-func (s *UnimplementedDatastore) AlertQuery(options AlertQueryOptions) ([]AlertGroup, error) {
+func (s *UnimplementedDatastore) AlertQuery(ctx context.Context, options AlertQueryOptions) ([]AlertGroup, error) {
 	log.Warning("AlertQuery not implemented in this datastore")
 	return nil, NotImplementedError
 }
 
-func (s *UnimplementedDatastore) EventQuery(options EventQueryOptions) (interface{}, error) {
+func (s *UnimplementedDatastore) EventQuery(ctx context.Context, options EventQueryOptions) (*EventQueryResult, error) {
 	log.Warning("EventQuery not implemented in this datastore")
 	return nil, NotImplementedError
 }
 
-func (s *UnimplementedDatastore) DeEscalateAlertGroup(p AlertGroupQueryParams, u User) error {
+func (s *UnimplementedDatastore) DeEscalateAlertGroup(ctx context.Context, p AlertGroupQueryParams, u User) error {
 	log.Warning("UnstarAlertGroup not implemented in this datastore")
 	return NotImplementedError
 }
 
-func (s *UnimplementedDatastore) GetEventById(id string) (map[string]interface{}, error) {
+func (s *UnimplementedDatastore) GetEventById(ctx context.Context, id string) (map[string]interface{}, error) {
 	log.Warning("GetEventById not implement by this datastore")
 	return nil, NotImplementedError
 }
 
-func (s *UnimplementedDatastore) FindFlow(flowId uint64, proto string, timestamp string, srcIp string, destIp string) (interface{}, error) {
+func (s *UnimplementedDatastore) FindFlow(ctx context.Context, flowId uint64, proto string, timestamp string, srcIp string, destIp string) (interface{}, error) {
 	return nil, NotImplementedError
 }
 
-func (s *UnimplementedDatastore) ArchiveAlertGroup(p AlertGroupQueryParams, u User) error {
+func (s *UnimplementedDatastore) ArchiveAlertGroup(ctx context.Context, p AlertGroupQueryParams, u User) error {
 	return NotImplementedError
 }
 
-func (s *UnimplementedDatastore) EscalateAlertGroup(p AlertGroupQueryParams, u User) error {
+func (s *UnimplementedDatastore) EscalateAlertGroup(ctx context.Context, p AlertGroupQueryParams, u User) error {
 	return NotImplementedError
 }
 
-func (s *UnimplementedDatastore) FindNetflow(options EventQueryOptions, sortBy string, order string) (interface{}, error) {
+func (s *UnimplementedDatastore) FindNetflow(ctx context.Context, options EventQueryOptions, sortBy string, order string) (interface{}, error) {
 	return nil, NotImplementedError
 }
+
+func (s *UnimplementedDatastore) UpdateAlertsByQuery(ctx context.Context, options AlertQueryOptions, action AlertAction, user User) (int64, error) {
+	return 0, NotImplementedError
+}
+
+func (s *UnimplementedDatastore) AddTagsToEvent(ctx context.Context, eventId string, tags []string, user User) error {
+	return NotImplementedError
+}
+
+func (s *UnimplementedDatastore) RemoveTagsFromEvent(ctx context.Context, eventId string, tags []string, user User) error {
+	return NotImplementedError
+}
+
+func (s *UnimplementedDatastore) AddTagsToAlertGroup(ctx context.Context, p AlertGroupQueryParams, tags []string, user User) error {
+	return NotImplementedError
+}
+
+func (s *UnimplementedDatastore) RemoveTagsFromAlertGroup(ctx context.Context, p AlertGroupQueryParams, tags []string, user User) error {
+	return NotImplementedError
+}
+
+func (s *UnimplementedDatastore) TagCounts(ctx context.Context, options EventQueryOptions) ([]TagCount, error) {
+	return nil, NotImplementedError
+}
+
+func (s *UnimplementedDatastore) ExportEvents(ctx context.Context, options EventQueryOptions, fn func(event map[string]interface{}) error) error {
+	return NotImplementedError
+}
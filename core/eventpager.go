@@ -0,0 +1,198 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package core
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/pkg/errors"
+)
+
+const DEFAULT_EVENT_QUERY_SIZE = 500
+
+// EventQueryResult is the result of an event query.
+type EventQueryResult struct {
+	Events []map[string]interface{} `json:"data"`
+
+	// Total number of events matching the query, ignoring the cursor. If
+	// Estimated is set this is a lower bound and not an exact count.
+	Total     int64 `json:"total"`
+	Estimated bool  `json:"estimated,omitempty"`
+
+	// Cursors for the next and previous pages, empty if there are no
+	// more events in that direction.
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// EventCursor marks a position in an event query result set. The
+// position is datastore specific, and the cursor is opaque to clients.
+type EventCursor struct {
+	Position string `json:"p"`
+
+	// The order of the query the cursor was created for.
+	Order string `json:"o"`
+
+	// Set if the cursor pages backwards, to the previous page.
+	Reverse bool `json:"r,omitempty"`
+}
+
+func (c EventCursor) Encode() string {
+	buf, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func DecodeEventCursor(encoded string) (EventCursor, error) {
+	cursor := EventCursor{}
+	buf, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, errors.Wrap(err, "invalid cursor")
+	}
+	if err := json.Unmarshal(buf, &cursor); err != nil {
+		return cursor, errors.Wrap(err, "invalid cursor")
+	}
+	if cursor.Position == "" {
+		return cursor, errors.New("invalid cursor: no position")
+	}
+	return cursor, nil
+}
+
+// EventPager implements the paging logic common to all datastores. The
+// datastore sorts in the direction given by Ascending, starting after
+// Position (if set), fetching Limit events. The fetched events and their
+// cursor positions are then passed to Result.
+type EventPager struct {
+	Size     int64
+	Order    string
+	Reverse  bool
+	Position string
+}
+
+func NewEventPager(options EventQueryOptions) (*EventPager, error) {
+	pager := &EventPager{
+		Size:  DEFAULT_EVENT_QUERY_SIZE,
+		Order: "desc",
+	}
+	if options.Size > 0 {
+		pager.Size = options.Size
+	}
+	if options.Order == "asc" {
+		pager.Order = "asc"
+	}
+	if options.Cursor != "" {
+		cursor, err := DecodeEventCursor(options.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		// The cursor order wins so a page isn't read in a different
+		// order than the one before it.
+		if cursor.Order == "asc" {
+			pager.Order = "asc"
+		} else {
+			pager.Order = "desc"
+		}
+		pager.Reverse = cursor.Reverse
+		pager.Position = cursor.Position
+	}
+	return pager, nil
+}
+
+// Ascending returns true if the datastore should sort in ascending order.
+// This is the reverse of the requested order when paging backwards.
+func (p *EventPager) Ascending() bool {
+	return (p.Order == "asc") != p.Reverse
+}
+
+// Limit is the number of events to fetch. One more than the page size is
+// fetched to know if there is another page.
+func (p *EventPager) Limit() int64 {
+	return p.Size + 1
+}
+
+// Result builds the page from the events fetched by the datastore,
+// positions holding the cursor position of each event.
+func (p *EventPager) Result(events []map[string]interface{}, positions []string) *EventQueryResult {
+	more := int64(len(events)) > p.Size
+	if more {
+		events = events[:p.Size]
+		positions = positions[:p.Size]
+	}
+
+	if p.Reverse {
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+			positions[i], positions[j] = positions[j], positions[i]
+		}
+	}
+
+	result := &EventQueryResult{
+		Events: events,
+	}
+
+	if len(events) == 0 {
+		return result
+	}
+
+	first := positions[0]
+	last := positions[len(positions)-1]
+
+	// Moving forward there is a next page if more events were fetched
+	// than fit, and a previous page if we started from a cursor. Moving
+	// backwards it is the other way around.
+	if (!p.Reverse && more) || (p.Reverse && p.Position != "") {
+		result.Next = EventCursor{Position: last, Order: p.Order}.Encode()
+	}
+	if (p.Reverse && more) || (!p.Reverse && p.Position != "") {
+		result.Prev = EventCursor{Position: first, Order: p.Order,
+			Reverse: true}.Encode()
+	}
+
+	return result
+}
+
+// ExportEventsByCursor implements ExportEvents for a datastore by paging
+// through EventQuery with the cursor of each page.
+func ExportEventsByCursor(ctx context.Context, datastore Datastore, options EventQueryOptions, fn func(event map[string]interface{}) error) error {
+	options.Size = DEFAULT_EVENT_QUERY_SIZE
+	options.Cursor = ""
+	for {
+		result, err := datastore.EventQuery(ctx, options)
+		if err != nil {
+			return err
+		}
+		for _, event := range result.Events {
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+		if result.Next == "" {
+			return nil
+		}
+		options.Cursor = result.Next
+	}
+}
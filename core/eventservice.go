@@ -27,21 +27,115 @@
 package core
 
 import (
+	"context"
+	"fmt"
 	"github.com/jasonish/evebox/eve"
 	"github.com/pkg/errors"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// The keys alerts can be grouped by.
+const (
+	ALERT_GROUP_BY_SIGNATURE_ID = "signature_id"
+	ALERT_GROUP_BY_SRC_IP       = "src_ip"
+	ALERT_GROUP_BY_DEST_IP      = "dest_ip"
+	ALERT_GROUP_BY_HOST         = "host"
+)
+
+// DefaultAlertGroupBy is the grouping used when none is specified.
+var DefaultAlertGroupBy = []string{
+	ALERT_GROUP_BY_SIGNATURE_ID,
+	ALERT_GROUP_BY_SRC_IP,
+	ALERT_GROUP_BY_DEST_IP,
+}
+
+// ParseAlertGroupBy parses a comma separated list of alert group keys,
+// returning the default grouping if empty.
+func ParseAlertGroupBy(value string) ([]string, error) {
+	if value == "" {
+		return DefaultAlertGroupBy, nil
+	}
+	keys := []string{}
+	for _, key := range strings.Split(value, ",") {
+		key = strings.TrimSpace(key)
+		switch key {
+		case ALERT_GROUP_BY_SIGNATURE_ID, ALERT_GROUP_BY_SRC_IP,
+			ALERT_GROUP_BY_DEST_IP, ALERT_GROUP_BY_HOST:
+		default:
+			return nil, errors.Errorf("invalid alert group key: %s", key)
+		}
+		for _, existing := range keys {
+			if existing == key {
+				return nil, errors.Errorf("duplicate alert group key: %s", key)
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
 // AlertGroupQueryParams holds the parameters for querying a specific
-// group of alerts.
+// group of alerts. Only the fields for the keys in GroupBy are used.
 type AlertGroupQueryParams struct {
 	SignatureID  uint64
 	SrcIP        string
 	DstIP        string
+	Host         string
 	MinTimestamp time.Time
 	MaxTimestamp time.Time
+
+	// The keys the alerts were grouped by, DefaultAlertGroupBy if empty.
+	GroupBy []string
+}
+
+// Keys returns the keys the alert group was grouped by.
+func (p AlertGroupQueryParams) Keys() []string {
+	if len(p.GroupBy) == 0 {
+		return DefaultAlertGroupBy
+	}
+	return p.GroupBy
+}
+
+// Value returns the value of the alert group for a key.
+func (p AlertGroupQueryParams) Value(key string) interface{} {
+	switch key {
+	case ALERT_GROUP_BY_SIGNATURE_ID:
+		return p.SignatureID
+	case ALERT_GROUP_BY_SRC_IP:
+		return p.SrcIP
+	case ALERT_GROUP_BY_DEST_IP:
+		return p.DstIP
+	case ALERT_GROUP_BY_HOST:
+		return p.Host
+	}
+	return nil
+}
+
+// EventQueryOptions returns the options to query the events in the alert
+// group. Events without a host are grouped under an empty host, which
+// can't be queried for, so the host is only matched when set.
+func (p AlertGroupQueryParams) EventQueryOptions() EventQueryOptions {
+	terms := []string{}
+	for _, key := range p.Keys() {
+		switch key {
+		case ALERT_GROUP_BY_SIGNATURE_ID:
+			terms = append(terms, fmt.Sprintf("alert.signature_id:%d", p.SignatureID))
+		case ALERT_GROUP_BY_SRC_IP, ALERT_GROUP_BY_DEST_IP, ALERT_GROUP_BY_HOST:
+			if value, _ := p.Value(key).(string); value != "" {
+				terms = append(terms, fmt.Sprintf(`%s:"%s"`, key, value))
+			}
+		}
+	}
+	return EventQueryOptions{
+		QueryString: strings.Join(terms, " "),
+		EventType:   "alert",
+		MinTs:       p.MinTimestamp,
+		MaxTs:       p.MaxTimestamp,
+		Order:       "asc",
+	}
 }
 
 // AlertQueryOptions includes the options for querying alerts which are then
@@ -63,6 +157,58 @@ type AlertQueryOptions struct {
 
 	MinTs time.Time
 	MaxTs time.Time
+
+	// The keys to group alerts by, DefaultAlertGroupBy if empty.
+	GroupBy []string
+}
+
+// EventQueryOptions returns the options to query the events of the alerts
+// matching the options, with the tags added to the query string.
+func (o AlertQueryOptions) EventQueryOptions() EventQueryOptions {
+	terms := []string{}
+	for _, tag := range o.MustHaveTags {
+		terms = append(terms, fmt.Sprintf(`tags:"%s"`, tag))
+	}
+	for _, tag := range o.MustNotHaveTags {
+		terms = append(terms, fmt.Sprintf(`-tags:"%s"`, tag))
+	}
+	if o.QueryString != "" {
+		terms = append(terms, fmt.Sprintf("(%s)", o.QueryString))
+	}
+	return EventQueryOptions{
+		QueryString: strings.Join(terms, " "),
+		EventType:   "alert",
+		TimeRange:   o.TimeRange,
+		MinTs:       o.MinTs,
+		MaxTs:       o.MaxTs,
+		Order:       "asc",
+	}
+}
+
+// Actions that can be applied to all alerts matching a query.
+const (
+	ALERT_ACTION_ARCHIVE    = "archive"
+	ALERT_ACTION_ESCALATE   = "escalate"
+	ALERT_ACTION_DEESCALATE = "de-escalate"
+	ALERT_ACTION_TAG        = "tag"
+)
+
+// AlertAction is an action to apply to alerts in bulk.
+type AlertAction struct {
+	Action string
+
+	// The tags to add with ALERT_ACTION_TAG.
+	Tags []string
+}
+
+func (a AlertAction) Validate() error {
+	switch a.Action {
+	case ALERT_ACTION_ARCHIVE, ALERT_ACTION_ESCALATE, ALERT_ACTION_DEESCALATE:
+		return nil
+	case ALERT_ACTION_TAG:
+		return ValidateTags(a.Tags)
+	}
+	return errors.Errorf("invalid alert action: %s", a.Action)
 }
 
 type EventQueryOptions struct {
@@ -83,6 +229,9 @@ type EventQueryOptions struct {
 	EventType string
 
 	Order string
+
+	// Cursor from a previous EventQueryResult to continue paging from.
+	Cursor string
 }
 
 func EventQueryOptionsFromHttpRequest(r *http.Request) (EventQueryOptions, error) {
@@ -134,8 +283,34 @@ type ReportOptions struct {
 	DnsType string
 }
 
+// ParseHistogramInterval converts a histogram interval as used by the
+// reporting API (second, minute, hour, day, or a duration string like 6h)
+// into a time.Duration.
+func ParseHistogramInterval(interval string) (time.Duration, error) {
+	switch interval {
+	case "second":
+		return time.Second, nil
+	case "minute":
+		return time.Minute, nil
+	case "hour":
+		return time.Hour, nil
+	case "day":
+		return time.Hour * 24, nil
+	case "week":
+		return time.Hour * 24 * 7, nil
+	}
+	duration, err := time.ParseDuration(interval)
+	if err != nil {
+		return 0, errors.Wrapf(err, "bad histogram interval: %s", interval)
+	}
+	if duration <= 0 {
+		return 0, errors.Errorf("bad histogram interval: %s", interval)
+	}
+	return duration, nil
+}
+
 type ReportService interface {
-	ReportDnsRequestRrnames(options ReportOptions) (interface{}, error)
+	ReportDnsRequestRrnames(ctx context.Context, options ReportOptions) (interface{}, error)
 
 	// Create aggregations reports where the result is a count and a key
 	// in descending order.
@@ -147,7 +322,7 @@ type ReportService interface {
 	// - alert.signature
 	// - src_port
 	// - dest_port
-	ReportAggs(agg string, options ReportOptions) (interface{}, error)
+	ReportAggs(ctx context.Context, agg string, options ReportOptions) (interface{}, error)
 
-	ReportHistogram(interval string, options ReportOptions) (interface{}, error)
+	ReportHistogram(ctx context.Context, interval string, options ReportOptions) (interface{}, error)
 }
@@ -37,6 +37,9 @@ const (
 
 	// Server supports event commenting.
 	FEATURE_COMMENTS
+
+	// Server can extract flows from a directory of PCAP files.
+	FEATURE_PCAP_STORE
 )
 
 func (f Feature) String() string {
@@ -45,6 +48,8 @@ func (f Feature) String() string {
 		return "reporting"
 	case FEATURE_COMMENTS:
 		return "comments"
+	case FEATURE_PCAP_STORE:
+		return "pcap-store"
 	}
 	return ""
 }
@@ -0,0 +1,140 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package core
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+// The views a saved search can be loaded into.
+const (
+	SAVED_SEARCH_ALERTS = "alerts"
+	SAVED_SEARCH_EVENTS = "events"
+)
+
+var ErrSavedSearchNotFound = errors.New("saved search does not exist")
+
+// SavedSearch is a named query, owned by a user, that maps onto the
+// AlertQueryOptions or EventQueryOptions of its type.
+type SavedSearch struct {
+	Id    string `json:"id"`
+	Owner string `json:"owner"`
+	Name  string `json:"name"`
+
+	// SAVED_SEARCH_ALERTS or SAVED_SEARCH_EVENTS.
+	Type string `json:"type"`
+
+	QueryString     string   `json:"query_string,omitempty"`
+	TimeRange       string   `json:"time_range,omitempty"`
+	MustHaveTags    []string `json:"must_have_tags,omitempty"`
+	MustNotHaveTags []string `json:"must_not_have_tags,omitempty"`
+
+	// Alert searches only.
+	GroupBy []string `json:"group_by,omitempty"`
+
+	// Event searches only.
+	EventType string `json:"event_type,omitempty"`
+
+	// Shared searches are visible to all users, but only editable by
+	// the owner.
+	Shared bool `json:"shared"`
+
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+func (s *SavedSearch) Validate() error {
+	if s.Name == "" {
+		return errors.New("name is required")
+	}
+	switch s.Type {
+	case SAVED_SEARCH_ALERTS:
+		if s.EventType != "" {
+			return errors.New("event_type not allowed for alert searches")
+		}
+		if len(s.GroupBy) > 0 {
+			if _, err := ParseAlertGroupBy(strings.Join(s.GroupBy, ",")); err != nil {
+				return err
+			}
+		}
+	case SAVED_SEARCH_EVENTS:
+		if len(s.GroupBy) > 0 {
+			return errors.New("group_by not allowed for event searches")
+		}
+		if len(s.MustHaveTags) > 0 || len(s.MustNotHaveTags) > 0 {
+			return errors.New("tags not allowed for event searches")
+		}
+	default:
+		return errors.Errorf("invalid type: %s", s.Type)
+	}
+	if s.TimeRange != "" {
+		if _, err := time.ParseDuration(s.TimeRange); err != nil {
+			return errors.Errorf("invalid time_range: %s", s.TimeRange)
+		}
+	}
+	return nil
+}
+
+// VisibleTo returns true if the search can be viewed by the user.
+func (s *SavedSearch) VisibleTo(user User) bool {
+	return s.Shared || s.Owner == user.Username
+}
+
+func (s *SavedSearch) AlertQueryOptions() AlertQueryOptions {
+	return AlertQueryOptions{
+		MustHaveTags:    s.MustHaveTags,
+		MustNotHaveTags: s.MustNotHaveTags,
+		QueryString:     s.QueryString,
+		TimeRange:       s.TimeRange,
+		GroupBy:         s.GroupBy,
+	}
+}
+
+func (s *SavedSearch) EventQueryOptions() EventQueryOptions {
+	return EventQueryOptions{
+		QueryString: s.QueryString,
+		TimeRange:   s.TimeRange,
+		EventType:   s.EventType,
+	}
+}
+
+type SavedSearchStore interface {
+	// Add a saved search returning its ID.
+	Add(search SavedSearch) (string, error)
+
+	// Update the saved search with the ID of the provided search.
+	Update(search SavedSearch) error
+
+	Delete(id string) error
+	FindById(id string) (SavedSearch, error)
+
+	// FindVisible returns the searches owned by the user along with
+	// those shared by others.
+	FindVisible(username string) ([]SavedSearch, error)
+}
@@ -0,0 +1,100 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package core
+
+import (
+	"github.com/jasonish/evebox/querystring"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// Tag added to events archived by a suppression rule.
+const TAG_SUPPRESSED = "suppressed"
+
+var ErrSuppressionRuleNotFound = errors.New("suppression rule does not exist")
+
+// SuppressionRule archives matching alerts as they are received, before
+// they are stored. All the criteria that are set must match.
+type SuppressionRule struct {
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+
+	SignatureId uint64 `json:"signature_id,omitempty"`
+
+	// An address, a network in CIDR notation or an address range.
+	SrcIp  string `json:"src_ip,omitempty"`
+	DestIp string `json:"dest_ip,omitempty"`
+
+	SrcPort  uint16 `json:"src_port,omitempty"`
+	DestPort uint16 `json:"dest_port,omitempty"`
+
+	// The sensor name, the host field of the event.
+	Host string `json:"host,omitempty"`
+
+	QueryString string `json:"query_string,omitempty"`
+
+	Comment string    `json:"comment,omitempty"`
+	Owner   string    `json:"owner"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+func (r *SuppressionRule) Validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if r.SignatureId == 0 && r.SrcIp == "" && r.DestIp == "" &&
+		r.SrcPort == 0 && r.DestPort == 0 && r.Host == "" &&
+		r.QueryString == "" {
+		return errors.New("at least one match criteria is required")
+	}
+	for _, addr := range []string{r.SrcIp, r.DestIp} {
+		if addr == "" {
+			continue
+		}
+		if _, err := querystring.ParseAddressRange(addr); err != nil {
+			return err
+		}
+	}
+	if _, err := querystring.CompileString(r.QueryString); err != nil {
+		return errors.Wrap(err, "invalid query_string")
+	}
+	return nil
+}
+
+type SuppressionRuleStore interface {
+	// Add a rule returning its ID.
+	Add(rule SuppressionRule) (string, error)
+
+	// Update the rule with the ID of the provided rule.
+	Update(rule SuppressionRule) error
+
+	Delete(id string) error
+	FindById(id string) (SuppressionRule, error)
+	FindAll() ([]SuppressionRule, error)
+}
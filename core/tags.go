@@ -0,0 +1,80 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package core
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+	"unicode"
+)
+
+// Tags managed by EveBox with the archive and escalate actions.
+const (
+	TAG_ARCHIVED  = "archived"
+	TAG_ESCALATED = "escalated"
+)
+
+// The maximum length of a user tag.
+const MAX_TAG_LENGTH = 64
+
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int64  `json:"count"`
+}
+
+// IsReservedTag returns true if the tag is managed by EveBox and cannot be
+// added or removed as a user tag.
+func IsReservedTag(tag string) bool {
+	return tag == TAG_ARCHIVED || tag == TAG_ESCALATED ||
+		strings.HasPrefix(tag, "evebox.")
+}
+
+// ValidateTags checks that a list of user tags is not empty and that each
+// tag is usable in a query string.
+func ValidateTags(tags []string) error {
+	if len(tags) == 0 {
+		return errors.New("no tags provided")
+	}
+	for _, tag := range tags {
+		if tag == "" {
+			return errors.New("empty tag")
+		}
+		if len(tag) > MAX_TAG_LENGTH {
+			return errors.Errorf("tag too long: %s", tag)
+		}
+		if strings.IndexFunc(tag, func(r rune) bool {
+			return unicode.IsSpace(r) || unicode.IsControl(r) ||
+				strings.ContainsRune(`,"()`, r)
+		}) > -1 {
+			return errors.Errorf("invalid character in tag: %s", tag)
+		}
+		if IsReservedTag(tag) {
+			return errors.Errorf("reserved tag: %s", tag)
+		}
+	}
+	return nil
+}
@@ -26,18 +26,21 @@
 
 package core
 
-import "github.com/jasonish/evebox/eve"
+import (
+	"context"
+	"github.com/jasonish/evebox/eve"
+)
 
 // EveEventSink is an interface representing an event sink. An implementation
 // will write the event to a datastore, or in the case of the EveBox agent,
 // to the EveBox server.
 type EveEventSink interface {
 	// Submit takes an event for submission to the datastore.
-	Submit(event eve.EveEvent) error
+	Submit(ctx context.Context, event eve.EveEvent) error
 
 	// Commit commits, or flushes out the event to the datastore. In some
 	// cases this might be a no-op.
 	//
 	// TODO Don't use an interface as the return status.
-	Commit() (status interface{}, err error)
+	Commit(ctx context.Context) (status interface{}, err error)
 }
@@ -0,0 +1,939 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Package datastoretest provides a conformance suite that is run against
+// each core.Datastore implementation to make sure they all behave the same.
+package datastoretest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Corpus is the fixed set of eve events submitted to each datastore before
+// a test is run.
+var Corpus = []string{
+	`{"timestamp":"2017-06-01T10:00:01.000000+0000","event_type":"alert","src_ip":"10.0.0.1","src_port":1000,"dest_ip":"10.0.0.2","dest_port":80,"proto":"TCP","flow_id":1000,"alert":{"signature_id":1,"signature":"SIG ONE","category":"Cat A"}}`,
+	`{"timestamp":"2017-06-01T10:00:02.000000+0000","event_type":"alert","src_ip":"10.0.0.1","src_port":1000,"dest_ip":"10.0.0.2","dest_port":80,"proto":"TCP","flow_id":1000,"alert":{"signature_id":1,"signature":"SIG ONE","category":"Cat A"}}`,
+	`{"timestamp":"2017-06-01T10:01:00.000000+0000","event_type":"alert","src_ip":"10.0.0.1","src_port":1001,"dest_ip":"10.0.0.3","dest_port":80,"proto":"TCP","flow_id":1001,"alert":{"signature_id":1,"signature":"SIG ONE","category":"Cat A"}}`,
+	`{"timestamp":"2017-06-01T10:05:00.000000+0000","event_type":"alert","src_ip":"10.0.0.4","src_port":1002,"dest_ip":"10.0.0.2","dest_port":443,"proto":"TCP","flow_id":1002,"alert":{"signature_id":2,"signature":"SIG TWO","category":"Cat B"}}`,
+	`{"timestamp":"2017-06-01T10:00:03.000000+0000","event_type":"dns","src_ip":"10.0.0.1","src_port":5353,"dest_ip":"8.8.8.8","dest_port":53,"proto":"UDP","dns":{"type":"query","rrname":"example.com"}}`,
+	`{"timestamp":"2017-06-01T10:00:04.000000+0000","event_type":"dns","src_ip":"10.0.0.1","src_port":5353,"dest_ip":"8.8.8.8","dest_port":53,"proto":"UDP","dns":{"type":"query","rrname":"example.org"}}`,
+	`{"timestamp":"2017-06-01T10:00:10.000000+0000","event_type":"flow","src_ip":"10.0.0.1","src_port":1000,"dest_ip":"10.0.0.2","dest_port":80,"proto":"TCP","flow_id":1000,"flow":{"start":"2017-06-01T10:00:00.000000+0000","end":"2017-06-01T10:00:09.000000+0000","bytes_toserver":100,"bytes_toclient":200}}`,
+	`{"timestamp":"2017-06-01T10:01:10.000000+0000","event_type":"flow","src_ip":"10.0.0.1","src_port":1001,"dest_ip":"10.0.0.3","dest_port":80,"proto":"TCP","flow_id":1001,"flow":{"start":"2017-06-01T10:00:59.000000+0000","end":"2017-06-01T10:01:09.000000+0000","bytes_toserver":100,"bytes_toclient":200}}`,
+	`{"timestamp":"2017-06-01T10:10:00.000000+0000","event_type":"stats","stats":{"uptime":600}}`,
+}
+
+// Backend describes a datastore to run the conformance suite against.
+type Backend struct {
+	// Setup returns a new empty datastore and a function to tear it
+	// down when the test is done.
+	Setup func(t *testing.T) (core.Datastore, func())
+
+	// Refresh, if set, is called after events are submitted or updated
+	// to make the changes visible to queries.
+	Refresh func(datastore core.Datastore)
+}
+
+var testUser = core.User{Username: "conformance"}
+
+// Run runs the conformance suite against the backend. Each test gets its
+// own datastore loaded with the Corpus.
+func Run(t *testing.T, backend Backend) {
+	tests := []struct {
+		name string
+		test func(*testing.T, *suite)
+	}{
+		{"AlertQuery", testAlertQuery},
+		{"EventQuery", testEventQuery},
+		{"EventQueryPaging", testEventQueryPaging},
+		{"QueryString", testQueryString},
+		{"FindFlow", testFindFlow},
+		{"FindNetflow", testFindNetflow},
+		{"ArchiveAlertGroup", testArchiveAlertGroup},
+		{"AlertGroupBy", testAlertGroupBy},
+		{"UpdateAlertsByQuery", testUpdateAlertsByQuery},
+		{"EscalateAlertGroup", testEscalateAlertGroup},
+		{"ArchiveEscalateEvent", testArchiveEscalateEvent},
+		{"Comments", testComments},
+		{"Tags", testTags},
+		{"History", testHistory},
+		{"Export", testExport},
+		{"AlertGroupEvents", testAlertGroupEvents},
+		{"SubmitTagged", testSubmitTagged},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			datastore, teardown := backend.Setup(t)
+			defer teardown()
+			s := &suite{
+				ctx:       context.Background(),
+				r:         require.New(t),
+				datastore: datastore,
+				refresh:   backend.Refresh,
+			}
+			s.submit(Corpus...)
+			test.test(t, s)
+		})
+	}
+}
+
+type suite struct {
+	ctx       context.Context
+	r         *require.Assertions
+	datastore core.Datastore
+	refresh   func(datastore core.Datastore)
+}
+
+func (s *suite) submit(events ...string) {
+	sink := s.datastore.GetEveEventSink()
+	s.r.NotNil(sink)
+	for _, raw := range events {
+		event, err := eve.NewEveEventFromBytes([]byte(raw))
+		s.r.Nil(err)
+		s.r.Nil(sink.Submit(s.ctx, event))
+	}
+	_, err := sink.Commit(s.ctx)
+	s.r.Nil(err)
+	s.sync()
+}
+
+func (s *suite) sync() {
+	if s.refresh != nil {
+		s.refresh(s.datastore)
+	}
+}
+
+// alertGroups runs an alert query returning the groups keyed by
+// "signature_id/src_ip/dest_ip".
+func (s *suite) alertGroups(options core.AlertQueryOptions) map[string]core.AlertGroup {
+	groups, err := s.datastore.AlertQuery(s.ctx, options)
+	s.r.Nil(err)
+	keyed := map[string]core.AlertGroup{}
+	for _, group := range groups {
+		source := normalize(group.Event)["_source"].(map[string]interface{})
+		key := fmt.Sprintf("%v/%v/%v",
+			source["alert"].(map[string]interface{})["signature_id"],
+			source["src_ip"], source["dest_ip"])
+		keyed[key] = group
+	}
+	return keyed
+}
+
+func (s *suite) groupParams(group core.AlertGroup) core.AlertGroupQueryParams {
+	source := normalize(group.Event)["_source"].(map[string]interface{})
+	minTs, err := eve.ParseTimestamp(group.MinTs)
+	s.r.Nil(err)
+	maxTs, err := eve.ParseTimestamp(group.MaxTs)
+	s.r.Nil(err)
+	return core.AlertGroupQueryParams{
+		SignatureID:  uint64(source["alert"].(map[string]interface{})["signature_id"].(float64)),
+		SrcIP:        source["src_ip"].(string),
+		DstIP:        source["dest_ip"].(string),
+		MinTimestamp: minTs,
+		MaxTimestamp: maxTs,
+	}
+}
+
+// eventId returns the ID of the first event of the given type and
+// timestamp as found with EventQuery.
+func (s *suite) eventId(eventType string, timestamp string) string {
+	for _, event := range s.events(core.EventQueryOptions{EventType: eventType}) {
+		source := event["_source"].(map[string]interface{})
+		if source["timestamp"] == timestamp {
+			return fmt.Sprintf("%v", event["_id"])
+		}
+	}
+	s.r.FailNow("event not found", "%s %s", eventType, timestamp)
+	return ""
+}
+
+func (s *suite) events(options core.EventQueryOptions) []map[string]interface{} {
+	response, err := s.datastore.EventQuery(s.ctx, options)
+	s.r.Nil(err)
+	return hits(normalize(response)["data"])
+}
+
+// event returns the _source of an event fetched with GetEventById.
+func (s *suite) event(id string) map[string]interface{} {
+	event, err := s.datastore.GetEventById(s.ctx, id)
+	s.r.Nil(err)
+	s.r.NotNil(event)
+	return normalize(event)["_source"].(map[string]interface{})
+}
+
+// normalize converts a response to its generic JSON representation so
+// the results from different datastores can be compared.
+func normalize(v interface{}) map[string]interface{} {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(buf, &normalized); err != nil {
+		panic(err)
+	}
+	return normalized
+}
+
+func hits(v interface{}) []map[string]interface{} {
+	hits := []map[string]interface{}{}
+	if v == nil {
+		return hits
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	if err := json.Unmarshal(buf, &hits); err != nil {
+		panic(err)
+	}
+	return hits
+}
+
+func tags(source map[string]interface{}) []interface{} {
+	if tags, ok := source["tags"].([]interface{}); ok {
+		return tags
+	}
+	return []interface{}{}
+}
+
+func history(source map[string]interface{}) []map[string]interface{} {
+	if evebox, ok := source["evebox"].(map[string]interface{}); ok {
+		return hits(evebox["history"])
+	}
+	return hits(nil)
+}
+
+func (s *suite) requireTimestamp(expected string, actual string) {
+	expectedTs, err := eve.ParseTimestamp(expected)
+	s.r.Nil(err)
+	actualTs, err := eve.ParseTimestamp(actual)
+	s.r.Nil(err)
+	s.r.True(expectedTs.Equal(actualTs), "expected %s, got %s",
+		expected, actual)
+}
+
+func testAlertQuery(t *testing.T, s *suite) {
+	groups := s.alertGroups(core.AlertQueryOptions{
+		MustNotHaveTags: []string{"archived"},
+	})
+	s.r.Len(groups, 3)
+	s.r.Equal(int64(2), groups["1/10.0.0.1/10.0.0.2"].Count)
+	s.requireTimestamp("2017-06-01T10:00:01.000000+0000",
+		groups["1/10.0.0.1/10.0.0.2"].MinTs)
+	s.requireTimestamp("2017-06-01T10:00:02.000000+0000",
+		groups["1/10.0.0.1/10.0.0.2"].MaxTs)
+	s.r.Equal(int64(1), groups["1/10.0.0.1/10.0.0.3"].Count)
+	s.r.Equal(int64(1), groups["2/10.0.0.4/10.0.0.2"].Count)
+	for _, group := range groups {
+		s.r.Equal(int64(0), group.EscalatedCount)
+	}
+
+	groups = s.alertGroups(core.AlertQueryOptions{
+		MustNotHaveTags: []string{"archived"},
+		QueryString:     "10.0.0.3",
+	})
+	s.r.Len(groups, 1)
+	s.r.Contains(groups, "1/10.0.0.1/10.0.0.3")
+}
+
+func testEventQuery(t *testing.T, s *suite) {
+	// Stats events are never returned, and the newest event is first.
+	events := s.events(core.EventQueryOptions{})
+	s.r.Len(events, len(Corpus)-1)
+	for _, event := range events {
+		s.r.NotEqual("stats", event["_source"].(map[string]interface{})["event_type"])
+	}
+	s.requireTimestamp("2017-06-01T10:05:00.000000+0000",
+		events[0]["_source"].(map[string]interface{})["timestamp"].(string))
+
+	events = s.events(core.EventQueryOptions{EventType: "dns", Order: "asc"})
+	s.r.Len(events, 2)
+	s.r.Equal("example.com",
+		events[0]["_source"].(map[string]interface{})["dns"].(map[string]interface{})["rrname"])
+
+	events = s.events(core.EventQueryOptions{Size: 3})
+	s.r.Len(events, 3)
+
+	minTs, _ := eve.ParseTimestamp("2017-06-01T10:01:00.000000+0000")
+	maxTs, _ := eve.ParseTimestamp("2017-06-01T10:02:00.000000+0000")
+	events = s.events(core.EventQueryOptions{MinTs: minTs, MaxTs: maxTs})
+	s.r.Len(events, 2)
+}
+
+func testQueryString(t *testing.T, s *suite) {
+	tests := []struct {
+		query    string
+		expected int
+	}{
+		{"event_type:dns OR event_type:flow", 4},
+		{"(event_type:dns OR event_type:flow) dest_ip:8.8.8.8", 2},
+		{"dest_port:[1 TO 100]", 7},
+		{"dest_port:{53 TO 443]", 6},
+		{"-src_ip:10.0.0.1", 1},
+		{"event_type:alert AND NOT dest_port:80", 1},
+		{"dns.rrname:example.*", 2},
+		{`alert.signature:"SIG TWO"`, 1},
+		{"src_ip:10.0.0.0/30", 7},
+		{"ip:8.8.8.0/24", 2},
+		{"ip:10.0.0.4", 1},
+		{"dest_ip:10.0.0.2-10.0.0.3", 6},
+		{"src_ip:[10.0.0.2 TO 10.0.0.4]", 1},
+		{"event_type:dns -ip:8.0.0.0/8", 0},
+	}
+	for _, test := range tests {
+		events := s.events(core.EventQueryOptions{QueryString: test.query})
+		s.r.Len(events, test.expected, test.query)
+	}
+
+	s.submit(`{"timestamp":"2017-06-01T10:30:00.000000+0000","event_type":"dns","src_ip":"2001:db8::1","src_port":5353,"dest_ip":"2001:4860:4860::8888","dest_port":53,"proto":"UDP","dns":{"type":"query","rrname":"example.net"}}`)
+	s.r.Len(s.events(core.EventQueryOptions{QueryString: "src_ip:2001:db8::/32"}), 1)
+	s.r.Len(s.events(core.EventQueryOptions{QueryString: "ip:2001:4860:4860::8888"}), 1)
+	s.r.Len(s.events(core.EventQueryOptions{QueryString: "src_ip:10.0.0.0/8"}), 8)
+
+	// was: matches actions in the history of an event, even if undone.
+	id := s.eventId("alert", "2017-06-01T10:05:00.000000+0000")
+	s.r.Nil(s.datastore.EscalateEvent(s.ctx, id, testUser))
+	s.sync()
+	s.r.Nil(s.datastore.DeEscalateEvent(s.ctx, id, testUser))
+	s.sync()
+	s.r.Len(s.events(core.EventQueryOptions{QueryString: "is:escalated"}), 0)
+	s.r.Len(s.events(core.EventQueryOptions{QueryString: "was:escalated"}), 1)
+	s.r.Len(s.events(core.EventQueryOptions{QueryString: "was:de-escalated"}), 1)
+	s.r.Len(s.events(core.EventQueryOptions{QueryString: "was:archived"}), 0)
+
+	_, err := s.datastore.EventQuery(s.ctx, core.EventQueryOptions{
+		QueryString: "dest_port:[1 TO 100",
+	})
+	s.r.NotNil(err)
+}
+
+// Pages through events sharing a timestamp, as seen on a busy sensor,
+// which must not be skipped or repeated.
+func testEventQueryPaging(t *testing.T, s *suite) {
+	for i := 0; i < 5; i++ {
+		s.submit(fmt.Sprintf(`{"timestamp":"2017-06-01T10:20:00.000000+0000","event_type":"http","src_ip":"10.0.0.1","dest_ip":"10.0.0.2","http":{"url":"/%d"}}`, i))
+	}
+
+	query := func(options core.EventQueryOptions) (*core.EventQueryResult, []string) {
+		options.EventType = "http"
+		options.Size = 2
+		result, err := s.datastore.EventQuery(s.ctx, options)
+		s.r.Nil(err)
+		urls := []string{}
+		for _, event := range hits(normalize(result)["data"]) {
+			source := event["_source"].(map[string]interface{})
+			urls = append(urls, source["http"].(map[string]interface{})["url"].(string))
+		}
+		return result, urls
+	}
+
+	first, urls := query(core.EventQueryOptions{})
+	s.r.Equal(int64(5), first.Total)
+	s.r.Len(urls, 2)
+	s.r.Empty(first.Prev)
+	s.r.NotEmpty(first.Next)
+
+	seen := map[string]bool{}
+	pages := [][]string{urls}
+	result := first
+	for result.Next != "" {
+		result, urls = query(core.EventQueryOptions{Cursor: result.Next})
+		s.r.NotEmpty(result.Prev)
+		pages = append(pages, urls)
+	}
+	s.r.Len(pages, 3)
+	for _, page := range pages {
+		for _, url := range page {
+			s.r.False(seen[url], "duplicate event %s", url)
+			seen[url] = true
+		}
+	}
+	s.r.Len(seen, 5)
+
+	// Paging back from the last page returns the same events again.
+	result, urls = query(core.EventQueryOptions{Cursor: result.Prev})
+	s.r.Equal(pages[1], urls)
+	s.r.NotEmpty(result.Next)
+	result, urls = query(core.EventQueryOptions{Cursor: result.Prev})
+	s.r.Equal(pages[0], urls)
+	s.r.Empty(result.Prev)
+
+	_, err := s.datastore.EventQuery(s.ctx, core.EventQueryOptions{Cursor: "bogus"})
+	s.r.NotNil(err)
+}
+
+func testFindFlow(t *testing.T, s *suite) {
+	response, err := s.datastore.FindFlow(s.ctx, 1000, "TCP",
+		"2017-06-01T10:00:01.000000+0000", "10.0.0.1", "10.0.0.2")
+	s.r.Nil(err)
+	flows := hits(response)
+	s.r.Len(flows, 1)
+	s.r.Equal(float64(1000), flows[0]["_source"].(map[string]interface{})["flow_id"])
+
+	// The addresses may be given in either direction.
+	response, err = s.datastore.FindFlow(s.ctx, 1000, "TCP",
+		"2017-06-01T10:00:01.000000+0000", "10.0.0.2", "10.0.0.1")
+	s.r.Nil(err)
+	s.r.Len(hits(response), 1)
+
+	// Outside of the flow's time range.
+	response, err = s.datastore.FindFlow(s.ctx, 1000, "TCP",
+		"2017-06-01T10:05:00.000000+0000", "10.0.0.1", "10.0.0.2")
+	s.r.Nil(err)
+	s.r.Len(hits(response), 0)
+
+	// Wrong address.
+	response, err = s.datastore.FindFlow(s.ctx, 1000, "TCP",
+		"2017-06-01T10:00:01.000000+0000", "10.0.0.1", "10.0.0.3")
+	s.r.Nil(err)
+	s.r.Len(hits(response), 0)
+}
+
+func testFindNetflow(t *testing.T, s *suite) {
+	s.submit(
+		`{"timestamp":"2017-06-01T10:20:00.000000+0000","event_type":"netflow","src_ip":"10.0.0.1","dest_ip":"10.0.0.2","proto":"TCP","netflow":{"bytes":100,"pkts":1}}`,
+		`{"timestamp":"2017-06-01T10:21:00.000000+0000","event_type":"netflow","src_ip":"10.0.0.1","dest_ip":"10.0.0.3","proto":"TCP","netflow":{"bytes":300,"pkts":3}}`,
+	)
+
+	response, err := s.datastore.FindNetflow(s.ctx, core.EventQueryOptions{},
+		"netflow.bytes", "desc")
+	s.r.Nil(err)
+	flows := hits(normalize(response)["data"])
+	s.r.Len(flows, 2)
+
+	first := flows[0]["_source"].(map[string]interface{})
+	s.r.Equal("10.0.0.3", first["dest_ip"])
+
+	// All datastores return @timestamp for the webapp.
+	for _, flow := range flows {
+		source := flow["_source"].(map[string]interface{})
+		s.r.NotNil(source["@timestamp"], "%v", source)
+		s.requireTimestamp(source["timestamp"].(string),
+			source["@timestamp"].(string))
+	}
+}
+
+func testArchiveAlertGroup(t *testing.T, s *suite) {
+	groups := s.alertGroups(core.AlertQueryOptions{
+		MustNotHaveTags: []string{"archived"},
+	})
+	params := s.groupParams(groups["1/10.0.0.1/10.0.0.2"])
+	s.r.Nil(s.datastore.ArchiveAlertGroup(s.ctx, params, testUser))
+	s.sync()
+
+	groups = s.alertGroups(core.AlertQueryOptions{
+		MustNotHaveTags: []string{"archived"},
+	})
+	s.r.Len(groups, 2)
+	s.r.NotContains(groups, "1/10.0.0.1/10.0.0.2")
+
+	groups = s.alertGroups(core.AlertQueryOptions{
+		MustHaveTags: []string{"archived"},
+	})
+	s.r.Len(groups, 1)
+	s.r.Equal(int64(2), groups["1/10.0.0.1/10.0.0.2"].Count)
+
+	// Archiving again is not an error.
+	s.r.Nil(s.datastore.ArchiveAlertGroup(s.ctx, params, testUser))
+}
+
+func testAlertGroupBy(t *testing.T, s *suite) {
+	// Returns the counts of each group keyed by signature ID, and the
+	// params to act on the group.
+	query := func(options core.AlertQueryOptions) (map[float64][]int64, map[float64]core.AlertGroupQueryParams) {
+		groups, err := s.datastore.AlertQuery(s.ctx, options)
+		s.r.Nil(err)
+		counts := map[float64][]int64{}
+		params := map[float64]core.AlertGroupQueryParams{}
+		for _, group := range groups {
+			source := normalize(group.Event)["_source"].(map[string]interface{})
+			sid := source["alert"].(map[string]interface{})["signature_id"].(float64)
+			counts[sid] = append(counts[sid], group.Count)
+			p := s.groupParams(group)
+			p.GroupBy = options.GroupBy
+			params[sid] = p
+		}
+		return counts, params
+	}
+
+	counts, params := query(core.AlertQueryOptions{
+		GroupBy: []string{core.ALERT_GROUP_BY_SIGNATURE_ID},
+	})
+	s.r.Equal([]int64{3}, counts[1])
+	s.r.Equal([]int64{1}, counts[2])
+
+	counts, _ = query(core.AlertQueryOptions{
+		GroupBy: []string{
+			core.ALERT_GROUP_BY_SIGNATURE_ID,
+			core.ALERT_GROUP_BY_DEST_IP,
+		},
+	})
+	s.r.ElementsMatch([]int64{2, 1}, counts[1])
+	s.r.Equal([]int64{1}, counts[2])
+
+	// Archiving by signature archives the alerts of all addresses.
+	s.r.Nil(s.datastore.ArchiveAlertGroup(s.ctx, params[1], testUser))
+	s.sync()
+	counts, _ = query(core.AlertQueryOptions{
+		MustNotHaveTags: []string{"archived"},
+		GroupBy:         []string{core.ALERT_GROUP_BY_SIGNATURE_ID},
+	})
+	s.r.Len(counts, 1)
+	s.r.Contains(counts, float64(2))
+
+	// None of the corpus events have a host, they are grouped together
+	// under an empty host.
+	counts, params = query(core.AlertQueryOptions{
+		GroupBy: []string{
+			core.ALERT_GROUP_BY_SIGNATURE_ID,
+			core.ALERT_GROUP_BY_HOST,
+		},
+	})
+	s.r.Equal([]int64{3}, counts[1])
+	s.r.Nil(s.datastore.EscalateAlertGroup(s.ctx, params[1], testUser))
+	s.sync()
+	groups, err := s.datastore.AlertQuery(s.ctx, core.AlertQueryOptions{
+		MustHaveTags: []string{"escalated"},
+		GroupBy:      []string{core.ALERT_GROUP_BY_SIGNATURE_ID},
+	})
+	s.r.Nil(err)
+	s.r.Len(groups, 1)
+	s.r.Equal(int64(3), groups[0].EscalatedCount)
+}
+
+func testUpdateAlertsByQuery(t *testing.T, s *suite) {
+	update := func(options core.AlertQueryOptions, action string) int64 {
+		count, err := s.datastore.UpdateAlertsByQuery(s.ctx, options,
+			core.AlertAction{Action: action, Tags: []string{"bulk"}}, testUser)
+		s.r.Nil(err)
+		s.sync()
+		return count
+	}
+
+	minTs, _ := eve.ParseTimestamp("2017-06-01T10:00:30.000000+0000")
+	inbox := core.AlertQueryOptions{
+		MustNotHaveTags: []string{"archived"},
+		MinTs:           minTs,
+	}
+	s.r.Equal(int64(2), update(inbox, core.ALERT_ACTION_ARCHIVE))
+
+	groups := s.alertGroups(core.AlertQueryOptions{
+		MustNotHaveTags: []string{"archived"},
+	})
+	s.r.Len(groups, 1)
+	s.r.Contains(groups, "1/10.0.0.1/10.0.0.2")
+
+	// Only events not already archived are updated.
+	s.r.Equal(int64(0), update(inbox, core.ALERT_ACTION_ARCHIVE))
+
+	// Bulk updates are recorded in the history like any other action.
+	archived := s.events(core.EventQueryOptions{QueryString: "tags:archived"})
+	s.r.Len(archived, 2)
+	for _, event := range archived {
+		entries := history(s.event(fmt.Sprintf("%v", event["_id"])))
+		s.r.Len(entries, 1)
+		s.r.Equal("archived", entries[0]["action"])
+		s.r.Equal(testUser.Username, entries[0]["username"])
+	}
+
+	s.r.Equal(int64(4), update(core.AlertQueryOptions{}, core.ALERT_ACTION_ESCALATE))
+	groups = s.alertGroups(core.AlertQueryOptions{
+		MustHaveTags: []string{"escalated"},
+	})
+	s.r.Len(groups, 3)
+
+	s.r.Equal(int64(2), update(core.AlertQueryOptions{MinTs: minTs},
+		core.ALERT_ACTION_DEESCALATE))
+	groups = s.alertGroups(core.AlertQueryOptions{
+		MustHaveTags: []string{"escalated"},
+	})
+	s.r.Len(groups, 1)
+
+	// The events of the alerts matching the options, as exported to
+	// forward escalated alerts.
+	s.r.Len(s.events(core.AlertQueryOptions{
+		MustHaveTags:    []string{"escalated"},
+		MustNotHaveTags: []string{"archived"},
+		QueryString:     "event_type:alert OR event_type:flow",
+	}.EventQueryOptions()), 2)
+	s.r.Len(s.events(core.AlertQueryOptions{
+		MustHaveTags: []string{"escalated"},
+		MinTs:        minTs,
+	}.EventQueryOptions()), 0)
+
+	s.r.Equal(int64(2), update(core.AlertQueryOptions{MinTs: minTs},
+		core.ALERT_ACTION_TAG))
+	tagged := s.events(core.EventQueryOptions{QueryString: "tags:bulk"})
+	s.r.Len(tagged, 2)
+	for _, event := range tagged {
+		entries := history(s.event(fmt.Sprintf("%v", event["_id"])))
+		last := entries[len(entries)-1]
+		s.r.Equal("tagged", last["action"])
+		s.r.Equal([]interface{}{"bulk"}, last["tags"])
+		s.r.Equal(testUser.Username, last["username"])
+	}
+
+	_, err := s.datastore.UpdateAlertsByQuery(s.ctx, core.AlertQueryOptions{},
+		core.AlertAction{Action: "bogus"}, testUser)
+	s.r.NotNil(err)
+}
+
+func testEscalateAlertGroup(t *testing.T, s *suite) {
+	groups := s.alertGroups(core.AlertQueryOptions{})
+	params := s.groupParams(groups["1/10.0.0.1/10.0.0.2"])
+	s.r.Nil(s.datastore.EscalateAlertGroup(s.ctx, params, testUser))
+	s.sync()
+
+	groups = s.alertGroups(core.AlertQueryOptions{})
+	s.r.Equal(int64(2), groups["1/10.0.0.1/10.0.0.2"].EscalatedCount)
+	s.r.Equal(int64(0), groups["1/10.0.0.1/10.0.0.3"].EscalatedCount)
+
+	groups = s.alertGroups(core.AlertQueryOptions{
+		MustHaveTags: []string{"escalated"},
+	})
+	s.r.Len(groups, 1)
+	s.r.Contains(groups, "1/10.0.0.1/10.0.0.2")
+
+	s.r.Nil(s.datastore.DeEscalateAlertGroup(s.ctx, params, testUser))
+	s.sync()
+
+	groups = s.alertGroups(core.AlertQueryOptions{})
+	s.r.Equal(int64(0), groups["1/10.0.0.1/10.0.0.2"].EscalatedCount)
+}
+
+func testArchiveEscalateEvent(t *testing.T, s *suite) {
+	id := s.eventId("alert", "2017-06-01T10:05:00.000000+0000")
+
+	s.r.Nil(s.datastore.ArchiveEvent(s.ctx, id, testUser))
+	s.sync()
+	s.r.Nil(s.datastore.EscalateEvent(s.ctx, id, testUser))
+	s.sync()
+
+	source := s.event(id)
+	s.r.Contains(tags(source), "archived")
+	s.r.Contains(tags(source), "escalated")
+	entries := history(source)
+	s.r.Len(entries, 2)
+	s.r.Equal("archived", entries[0]["action"])
+	s.r.Equal(testUser.Username, entries[0]["username"])
+	s.r.Equal("escalated", entries[1]["action"])
+
+	groups := s.alertGroups(core.AlertQueryOptions{
+		MustNotHaveTags: []string{"archived"},
+	})
+	s.r.NotContains(groups, "2/10.0.0.4/10.0.0.2")
+
+	s.r.Nil(s.datastore.DeEscalateEvent(s.ctx, id, testUser))
+	s.sync()
+
+	source = s.event(id)
+	s.r.NotContains(tags(source), "escalated")
+	entries = history(source)
+	s.r.Len(entries, 3)
+	s.r.Equal("de-escalated", entries[2]["action"])
+}
+
+func testComments(t *testing.T, s *suite) {
+	id := s.eventId("alert", "2017-06-01T10:05:00.000000+0000")
+
+	s.r.Nil(s.datastore.CommentOnEventId(s.ctx, id, testUser, "event comment"))
+	s.sync()
+
+	entries := history(s.event(id))
+	s.r.Len(entries, 1)
+	s.r.Equal("comment", entries[0]["action"])
+	s.r.Equal("event comment", entries[0]["comment"])
+	s.r.Equal(testUser.Username, entries[0]["username"])
+
+	groups := s.alertGroups(core.AlertQueryOptions{})
+	params := s.groupParams(groups["1/10.0.0.1/10.0.0.2"])
+	s.r.Nil(s.datastore.CommentOnAlertGroup(s.ctx, params, testUser, "group comment"))
+	s.sync()
+
+	for _, timestamp := range []string{
+		"2017-06-01T10:00:01.000000+0000",
+		"2017-06-01T10:00:02.000000+0000",
+	} {
+		entries := history(s.event(s.eventId("alert", timestamp)))
+		s.r.Len(entries, 1)
+		s.r.Equal("group comment", entries[0]["comment"])
+	}
+
+	// Events outside of the group are not commented on.
+	entries = history(s.event(s.eventId("alert",
+		"2017-06-01T10:01:00.000000+0000")))
+	s.r.Len(entries, 0)
+}
+
+func testTags(t *testing.T, s *suite) {
+	id := s.eventId("alert", "2017-06-01T10:05:00.000000+0000")
+
+	s.r.Nil(s.datastore.AddTagsToEvent(s.ctx, id,
+		[]string{"false-positive", "review"}, testUser))
+	s.sync()
+	source := s.event(id)
+	s.r.Contains(tags(source), "false-positive")
+	s.r.Contains(tags(source), "review")
+
+	groups := s.alertGroups(core.AlertQueryOptions{
+		MustHaveTags: []string{"false-positive"},
+	})
+	s.r.Len(groups, 1)
+	s.r.Contains(tags(normalize(groups["2/10.0.0.4/10.0.0.2"].Event)["_source"].(map[string]interface{})),
+		"false-positive")
+	groups = s.alertGroups(core.AlertQueryOptions{
+		MustNotHaveTags: []string{"false-positive"},
+	})
+	s.r.Len(groups, 2)
+	s.r.Len(s.events(core.EventQueryOptions{QueryString: "tags:review"}), 1)
+
+	counts, err := s.datastore.TagCounts(s.ctx, core.EventQueryOptions{})
+	s.r.Nil(err)
+	s.r.Contains(counts, core.TagCount{Tag: "false-positive", Count: 1})
+	s.r.Contains(counts, core.TagCount{Tag: "review", Count: 1})
+
+	s.r.Nil(s.datastore.RemoveTagsFromEvent(s.ctx, id, []string{"review"}, testUser))
+	s.sync()
+	source = s.event(id)
+	s.r.Contains(tags(source), "false-positive")
+	s.r.NotContains(tags(source), "review")
+
+	// Only changes to the tags are recorded in the history.
+	s.r.Nil(s.datastore.AddTagsToEvent(s.ctx, id, []string{"false-positive"}, testUser))
+	s.r.Nil(s.datastore.RemoveTagsFromEvent(s.ctx, id, []string{"review"}, testUser))
+	s.sync()
+	entries := history(s.event(id))
+	s.r.Len(entries, 2)
+	s.r.Equal("tagged", entries[0]["action"])
+	s.r.Equal([]interface{}{"false-positive", "review"}, entries[0]["tags"])
+	s.r.Equal(testUser.Username, entries[0]["username"])
+	s.r.Equal("untagged", entries[1]["action"])
+	s.r.Equal([]interface{}{"review"}, entries[1]["tags"])
+	s.r.Len(s.events(core.EventQueryOptions{QueryString: "was:untagged"}), 1)
+
+	// Tags are added to and removed from every event in an alert group.
+	params := s.groupParams(s.alertGroups(core.AlertQueryOptions{})["1/10.0.0.1/10.0.0.2"])
+	s.r.Nil(s.datastore.AddTagsToAlertGroup(s.ctx, params, []string{"incident"}, testUser))
+	s.sync()
+	groups = s.alertGroups(core.AlertQueryOptions{
+		MustHaveTags: []string{"incident"},
+	})
+	s.r.Len(groups, 1)
+	s.r.Equal(int64(2), groups["1/10.0.0.1/10.0.0.2"].Count)
+
+	counts, err = s.datastore.TagCounts(s.ctx, core.EventQueryOptions{
+		QueryString: "src_ip:10.0.0.1",
+	})
+	s.r.Nil(err)
+	s.r.Equal([]core.TagCount{{Tag: "incident", Count: 2}}, counts)
+
+	s.r.Nil(s.datastore.RemoveTagsFromAlertGroup(s.ctx, params, []string{"incident"}, testUser))
+	s.sync()
+	s.r.Len(s.alertGroups(core.AlertQueryOptions{
+		MustHaveTags: []string{"incident"},
+	}), 0)
+	for _, timestamp := range []string{
+		"2017-06-01T10:00:01.000000+0000",
+		"2017-06-01T10:00:02.000000+0000",
+	} {
+		entries := history(s.event(s.eventId("alert", timestamp)))
+		s.r.Len(entries, 2)
+		s.r.Equal("tagged", entries[0]["action"])
+		s.r.Equal("untagged", entries[1]["action"])
+		s.r.Equal([]interface{}{"incident"}, entries[1]["tags"])
+	}
+	s.r.Len(history(s.event(s.eventId("alert", "2017-06-01T10:01:00.000000+0000"))), 0)
+
+	// Tagging by query only updates the events without the tags.
+	tagAction := core.AlertAction{Action: core.ALERT_ACTION_TAG, Tags: []string{"noisy"}}
+	query := core.AlertQueryOptions{QueryString: "alert.signature_id:1"}
+	count, err := s.datastore.UpdateAlertsByQuery(s.ctx, query, tagAction, testUser)
+	s.r.Nil(err)
+	s.r.Equal(int64(3), count)
+	s.sync()
+	count, err = s.datastore.UpdateAlertsByQuery(s.ctx, query, tagAction, testUser)
+	s.r.Nil(err)
+	s.r.Equal(int64(0), count)
+	s.r.Len(s.events(core.EventQueryOptions{QueryString: "tags:noisy"}), 3)
+
+	// Reserved and invalid tags are rejected, as are unknown events.
+	s.r.NotNil(s.datastore.AddTagsToEvent(s.ctx, id, []string{"archived"}, testUser))
+	s.r.NotNil(s.datastore.AddTagsToEvent(s.ctx, id, []string{"has space"}, testUser))
+	s.r.NotNil(s.datastore.AddTagsToEvent(s.ctx, "999999", []string{"tag"}, testUser))
+}
+
+func testHistory(t *testing.T, s *suite) {
+	groups := s.alertGroups(core.AlertQueryOptions{})
+	params := s.groupParams(groups["1/10.0.0.1/10.0.0.2"])
+	s.r.Nil(s.datastore.EscalateAlertGroup(s.ctx, params, testUser))
+	s.sync()
+	s.r.Nil(s.datastore.DeEscalateAlertGroup(s.ctx, params, testUser))
+	s.sync()
+	s.r.Nil(s.datastore.ArchiveAlertGroup(s.ctx, params, testUser))
+	s.sync()
+
+	for _, timestamp := range []string{
+		"2017-06-01T10:00:01.000000+0000",
+		"2017-06-01T10:00:02.000000+0000",
+	} {
+		entries := history(s.event(s.eventId("alert", timestamp)))
+		s.r.Len(entries, 3)
+		s.r.Equal("escalated", entries[0]["action"])
+		s.r.Equal("de-escalated", entries[1]["action"])
+		s.r.Equal("archived", entries[2]["action"])
+		s.r.Equal(testUser.Username, entries[2]["username"])
+		s.r.NotEmpty(entries[2]["timestamp"])
+	}
+
+	count, err := s.datastore.UpdateAlertsByQuery(s.ctx,
+		core.AlertQueryOptions{QueryString: "alert.signature_id:2"},
+		core.AlertAction{Action: core.ALERT_ACTION_ESCALATE}, testUser)
+	s.r.Nil(err)
+	s.r.Equal(int64(1), count)
+	s.sync()
+
+	// Alert groups include the history of their most recent event.
+	groups = s.alertGroups(core.AlertQueryOptions{})
+	source := normalize(groups["1/10.0.0.1/10.0.0.2"].Event)["_source"].(map[string]interface{})
+	s.r.Len(history(source), 3)
+	source = normalize(groups["2/10.0.0.4/10.0.0.2"].Event)["_source"].(map[string]interface{})
+	entries := history(source)
+	s.r.Len(entries, 1)
+	s.r.Equal("escalated", entries[0]["action"])
+	source = normalize(groups["1/10.0.0.1/10.0.0.3"].Event)["_source"].(map[string]interface{})
+	s.r.Len(history(source), 0)
+}
+
+func testExport(t *testing.T, s *suite) {
+	// Enough events to need more than one page.
+	count := core.DEFAULT_EVENT_QUERY_SIZE + 10
+	start := time.Date(2017, 6, 1, 11, 0, 0, 0, time.UTC)
+	events := []string{}
+	for i := 0; i < count; i++ {
+		events = append(events, fmt.Sprintf(`{"timestamp":"%s","event_type":"tls","src_ip":"10.0.0.1","dest_ip":"10.0.0.2","tls":{"sni":"%d.example.com"}}`,
+			eve.FormatTimestampUTC(start.Add(time.Duration(i)*time.Second)), i))
+	}
+	s.submit(events...)
+
+	export := func(options core.EventQueryOptions) []string {
+		options.EventType = "tls"
+		timestamps := []string{}
+		err := s.datastore.ExportEvents(s.ctx, options, func(event map[string]interface{}) error {
+			source := normalize(event)["_source"].(map[string]interface{})
+			timestamps = append(timestamps, source["timestamp"].(string))
+			return nil
+		})
+		s.r.Nil(err)
+		return timestamps
+	}
+
+	timestamps := export(core.EventQueryOptions{Order: "asc"})
+	s.r.Len(timestamps, count)
+	s.r.True(sort.StringsAreSorted(timestamps))
+	seen := map[string]bool{}
+	for _, timestamp := range timestamps {
+		s.r.False(seen[timestamp], "duplicate event %s", timestamp)
+		seen[timestamp] = true
+	}
+
+	timestamps = export(core.EventQueryOptions{})
+	s.r.Len(timestamps, count)
+	s.r.Equal(eve.FormatTimestampUTC(start.Add(time.Duration(count-1)*time.Second)),
+		timestamps[0])
+
+	timestamps = export(core.EventQueryOptions{
+		MinTs: start.Add(time.Duration(count-5) * time.Second),
+	})
+	s.r.Len(timestamps, 5)
+
+	// An error from the callback stops the export.
+	calls := 0
+	err := s.datastore.ExportEvents(s.ctx, core.EventQueryOptions{EventType: "tls"},
+		func(event map[string]interface{}) error {
+			calls++
+			if calls == 3 {
+				return errors.New("stop")
+			}
+			return nil
+		})
+	s.r.NotNil(err)
+	s.r.Equal(3, calls)
+}
+
+func testAlertGroupEvents(t *testing.T, s *suite) {
+	groups := s.alertGroups(core.AlertQueryOptions{})
+	for key, group := range groups {
+		count := int64(0)
+		options := s.groupParams(group).EventQueryOptions()
+		err := s.datastore.ExportEvents(s.ctx, options, func(event map[string]interface{}) error {
+			count++
+			return nil
+		})
+		s.r.Nil(err)
+		s.r.Equal(group.Count, count, "alert group %s", key)
+	}
+}
+
+// testSubmitTagged checks that events submitted already archived, tagged
+// and with history, as done by suppression rules, are stored as such.
+func testSubmitTagged(t *testing.T, s *suite) {
+	s.submit(`{"timestamp":"2017-06-01T10:20:00.000000+0000","event_type":"alert","src_ip":"10.0.0.5","src_port":1003,"dest_ip":"10.0.0.2","dest_port":80,"proto":"TCP","flow_id":1003,"tags":["archived","evebox.archived","suppressed"],"evebox":{"history":[{"timestamp":"2017-06-01T10:20:01.000000Z","username":"","action":"archived","comment":"Suppressed by rule scanner"}]},"alert":{"signature_id":3,"signature":"SIG THREE","category":"Cat C"}}`)
+
+	groups := s.alertGroups(core.AlertQueryOptions{
+		MustNotHaveTags: []string{core.TAG_ARCHIVED},
+	})
+	s.r.Len(groups, 3)
+	s.r.NotContains(groups, "3/10.0.0.5/10.0.0.2")
+
+	groups = s.alertGroups(core.AlertQueryOptions{
+		MustHaveTags: []string{core.TAG_SUPPRESSED},
+	})
+	s.r.Len(groups, 1)
+	s.r.Contains(groups, "3/10.0.0.5/10.0.0.2")
+
+	events := s.events(core.EventQueryOptions{QueryString: "tags:suppressed"})
+	s.r.Len(events, 1)
+	source := events[0]["_source"].(map[string]interface{})
+	s.r.Contains(tags(source), core.TAG_ARCHIVED)
+	s.r.Contains(tags(source), core.TAG_SUPPRESSED)
+
+	entries := history(s.event(fmt.Sprintf("%v", events[0]["_id"])))
+	s.r.Len(entries, 1)
+	s.r.Equal("archived", entries[0]["action"])
+	s.r.Equal("Suppressed by rule scanner", entries[0]["comment"])
+	s.r.Len(s.events(core.EventQueryOptions{QueryString: "was:archived tags:suppressed"}), 1)
+}
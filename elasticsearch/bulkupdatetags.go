@@ -27,6 +27,7 @@
 package elasticsearch
 
 import (
+	"context"
 	"github.com/jasonish/evebox/log"
 	"github.com/jasonish/evebox/util"
 	"net/http"
@@ -35,7 +36,7 @@ import (
 
 // BulkUpdateTags will add and/or remove tags from a set of documents using
 // the Elastic Search bulk API.
-func BulkUpdateTags(es *ElasticSearch, documents []map[string]interface{},
+func BulkUpdateTags(ctx context.Context, es *ElasticSearch, documents []map[string]interface{},
 	addTags []string, rmTags []string) (bool, error) {
 
 	bulk := make([]string, 0)
@@ -82,7 +83,7 @@ func BulkUpdateTags(es *ElasticSearch, documents []map[string]interface{},
 	// Needs to finish with a new line.
 	bulk = append(bulk, "")
 	bulkString := strings.Join(bulk, "\n")
-	httpResponse, err := es.HttpClient.PostString("_bulk", "application/json", bulkString)
+	httpResponse, err := es.HttpClient.WithContext(ctx).PostString("_bulk", "application/json", bulkString)
 	if err != nil {
 		log.Error("Failed to update event tags: %v", err)
 		return false, err
@@ -0,0 +1,144 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package elasticsearch
+
+import (
+	"context"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/datastoretest"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestConformance runs the datastore conformance suite against the
+// Elastic Search found at the URL in EVEBOX_TEST_ELASTICSEARCH_URL. This is
+// the conformance gate for the Elastic Search datastore. Each test uses its
+// own index which is removed when the test is done.
+func TestConformance(t *testing.T) {
+	url := os.Getenv("EVEBOX_TEST_ELASTICSEARCH_URL")
+	if url == "" {
+		t.Skip("EVEBOX_TEST_ELASTICSEARCH_URL not set")
+	}
+	runConformance(t, func() (string, func()) {
+		return url, func() {}
+	})
+}
+
+// TestConformanceStandIn runs the datastore conformance suite against the
+// in-memory stand-in so the requests EveBox makes are exercised without a
+// cluster. The stand-in only emulates Elastic Search, including its
+// scripts, aggregations and paging, so passing here is not evidence of
+// behaviour on a real Elastic Search, TestConformance is.
+func TestConformanceStandIn(t *testing.T) {
+	runConformance(t, func() (string, func()) {
+		server := newStandIn().start()
+		return server.URL, server.Close
+	})
+}
+
+// runConformance runs the conformance suite with each test connecting to
+// the Elastic Search returned by connect.
+func runConformance(t *testing.T, connect func() (url string, close func())) {
+	datastoretest.Run(t, datastoretest.Backend{
+		Setup: func(t *testing.T) (core.Datastore, func()) {
+			r := require.New(t)
+
+			url, closeServer := connect()
+
+			index := fmt.Sprintf("evebox-conformance-%d",
+				time.Now().UnixNano())
+			es := New(url)
+			es.SetEventIndex(index)
+			es.SetKeyword("keyword")
+			_, err := es.Ping()
+			r.Nil(err)
+
+			datastore, err := NewDataStore(es)
+			r.Nil(err)
+
+			return datastore, func() {
+				defer closeServer()
+				for _, path := range []string{
+					fmt.Sprintf("%s-*", index),
+					fmt.Sprintf("_template/%s", index),
+				} {
+					response, err := es.HttpClient.Delete(path, "", nil)
+					if err != nil {
+						t.Logf("Failed to delete %s: %v", path, err)
+						continue
+					}
+					es.HttpClient.DiscardResponse(response)
+				}
+			}
+		},
+		Refresh: func(datastore core.Datastore) {
+			datastore.(*DataStore).es.Refresh()
+		},
+	})
+}
+
+// Events indexed before the template was installed have src_ip and
+// dest_ip mapped as text, network and range queries must fail rather than
+// silently match nothing.
+func TestIpNotMapped(t *testing.T) {
+	r := require.New(t)
+
+	server := newStandIn().start()
+	defer server.Close()
+
+	es := New(server.URL)
+	es.SetEventIndex("logstash")
+	es.SetKeyword("keyword")
+	_, err := es.Ping()
+	r.Nil(err)
+
+	response, err := es.HttpClient.PostString("_bulk", "application/json",
+		`{"create":{"_index":"logstash-2017.06.01","_type":"log","_id":"1"}}
+{"timestamp":"2017-06-01T10:00:00.000000+0000","@timestamp":"2017-06-01T10:00:00Z","event_type":"dns","src_ip":"10.0.0.1","dest_ip":"8.8.8.8"}
+`)
+	r.Nil(err)
+	r.Equal(200, response.StatusCode)
+	es.HttpClient.DiscardResponse(response)
+
+	datastore, err := NewDataStore(es)
+	r.Nil(err)
+
+	for _, query := range []string{"src_ip:10.0.0.0/8", "dest_ip:8.8.8.1-8.8.8.9"} {
+		_, err := datastore.EventQuery(context.Background(),
+			core.EventQueryOptions{QueryString: query})
+		r.NotNil(err, query)
+		r.Contains(err.Error(), "not mapped as ip in logstash-2017.06.01")
+	}
+
+	events, err := datastore.EventQuery(context.Background(),
+		core.EventQueryOptions{QueryString: "src_ip:10.0.0.1"})
+	r.Nil(err)
+	r.Len(events.Events, 1)
+}
@@ -27,11 +27,13 @@
 package elasticsearch
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/eve"
 	"github.com/jasonish/evebox/log"
 	"github.com/jasonish/evebox/util"
+	"github.com/pkg/errors"
 	"sort"
 	"time"
 )
@@ -52,65 +54,99 @@ func (a AlertGroupList) Swap(i, j int) {
 	a[i], a[j] = a[j], a[i]
 }
 
-// Return a 3 tuple aggregation: signature, source, dest...
-func (s *DataStore) get3TupleAggs() map[string]interface{} {
+// alertGroupField returns the field to aggregate or filter on for an alert
+// group key.
+func (s *DataStore) alertGroupField(key string) string {
+	if key == core.ALERT_GROUP_BY_SIGNATURE_ID {
+		return "alert.signature_id"
+	}
+	return s.es.FormatKeyword(key)
+}
+
+// getAlertGroupAggs returns nested terms aggregations, one level for each
+// group key, with the aggregations describing the alert group at the
+// bottom level.
+func (s *DataStore) getAlertGroupAggs(groupBy []string) map[string]interface{} {
 
 	size := 10000
 
 	aggs := map[string]interface{}{
-		"signatures": map[string]interface{}{
-			"terms": map[string]interface{}{
-				"field": "alert.signature_id",
-				"size":  size,
+		"newest": map[string]interface{}{
+			"top_hits": map[string]interface{}{
+				"sort": []interface{}{
+					Sort("@timestamp", "desc"),
+				},
+				"size": 1,
 			},
-			"aggs": map[string]interface{}{
-				"sources": map[string]interface{}{
-					"terms": map[string]interface{}{
-						"field": s.es.FormatKeyword("src_ip"),
-						"size":  size,
-					},
-					"aggs": map[string]interface{}{
-						"destinations": map[string]interface{}{
-							"terms": map[string]interface{}{
-								"field": s.es.FormatKeyword("dest_ip"),
-								"size":  size,
-							},
-							"aggs": map[string]interface{}{
-								"newest": map[string]interface{}{
-									"top_hits": map[string]interface{}{
-										"sort": []interface{}{
-											Sort("@timestamp", "desc"),
-										},
-										"size": 1,
-									},
-								},
-								"oldest": map[string]interface{}{
-									"top_hits": map[string]interface{}{
-										"sort": []interface{}{
-											Sort("@timestamp", "asc"),
-										},
-										"size": 1,
-									},
-								},
-								"escalated": map[string]interface{}{
-									"filter": map[string]interface{}{
-										"term": map[string]interface{}{
-											"tags": "escalated",
-										},
-									},
-								},
-							},
-						},
-					},
+		},
+		"oldest": map[string]interface{}{
+			"top_hits": map[string]interface{}{
+				"sort": []interface{}{
+					Sort("@timestamp", "asc"),
 				},
+				"size": 1,
 			},
 		},
+		"escalated": map[string]interface{}{
+			"filter": map[string]interface{}{
+				"term": map[string]interface{}{
+					"tags": "escalated",
+				},
+			},
+		},
+	}
+
+	for i := len(groupBy) - 1; i >= 0; i-- {
+		terms := map[string]interface{}{
+			"field": s.alertGroupField(groupBy[i]),
+			"size":  size,
+		}
+		// Not all events have a host, group those without together.
+		if groupBy[i] == core.ALERT_GROUP_BY_HOST {
+			terms["missing"] = ""
+		}
+		aggs = map[string]interface{}{
+			"group": map[string]interface{}{
+				"terms": terms,
+				"aggs":  aggs,
+			},
+		}
 	}
+
 	return aggs
 }
 
-func (s *DataStore) AlertQuery(options core.AlertQueryOptions) ([]core.AlertGroup, error) {
+// collectAlertGroups walks the nested group aggregations, appending an
+// alert group for each bucket at the bottom level.
+func collectAlertGroups(aggs util.JsonMap, depth int, alertGroups *AlertGroupList) {
+	for _, bucket := range aggs.GetMap("group").GetMapList("buckets") {
+		if depth > 1 {
+			collectAlertGroups(bucket, depth-1, alertGroups)
+			continue
+		}
+
+		alertGroup := core.AlertGroup{}
+		alertGroup.Count, _ = bucket.Get("doc_count").(json.Number).Int64()
+		alertGroup.EscalatedCount, _ = bucket.GetMap("escalated").Get("doc_count").(json.Number).Int64()
+
+		minEvent := bucket.GetMap("oldest").GetMap("hits").GetMapList("hits")[0]
+		maxEvent := bucket.GetMap("newest").GetMap("hits").GetMapList("hits")[0]
+
+		alertGroup.MinTs = minEvent.GetMap("_source").GetString("@timestamp")
+		alertGroup.MaxTs = maxEvent.GetMap("_source").GetString("@timestamp")
+
+		alertGroup.Event = maxEvent
+
+		if maxEvent["_source"].(map[string]interface{})["tags"] == nil {
+			maxEvent["_source"].(map[string]interface{})["tags"] = []string{}
+		}
+
+		*alertGroups = append(*alertGroups, alertGroup)
+	}
+}
 
+// buildAlertQuery returns a query for the alerts matching the options.
+func (s *DataStore) buildAlertQuery(ctx context.Context, options core.AlertQueryOptions) (EventQuery, error) {
 	query := NewEventQuery()
 
 	// Limit to alerts.
@@ -118,21 +154,27 @@ func (s *DataStore) AlertQuery(options core.AlertQueryOptions) ([]core.AlertGrou
 
 	// Set must have tags, for example to get escalated alerts.
 	for _, tag := range options.MustHaveTags {
-		query.AddFilter(TermQuery("tags", tag))
+		query.AddFilter(s.es.TagQuery(tag))
 	}
 
 	// Set must not have tags. For example, the inbox must not have
 	// archive tags set.
 	for _, tag := range options.MustNotHaveTags {
-		query.MustNot(TermQuery("tags", tag))
+		query.MustNot(s.es.TagQuery(tag))
 	}
 
 	if options.QueryString != "" {
-		query.AddFilter(QueryString(options.QueryString))
+		filter, err := s.es.QueryStringFilter(ctx, options.QueryString)
+		if err != nil {
+			return query, err
+		}
+		query.AddFilter(filter)
 	}
 
 	if options.TimeRange != "" {
-		query.AddTimeRangeFilter(options.TimeRange)
+		if err := query.AddTimeRangeFilter(options.TimeRange); err != nil {
+			return query, errors.Wrap(err, "failed to parse time range")
+		}
 	} else {
 		if !options.MaxTs.IsZero() {
 			query.AddFilter(RangeLte("@timestamp",
@@ -144,46 +186,32 @@ func (s *DataStore) AlertQuery(options core.AlertQueryOptions) ([]core.AlertGrou
 		}
 	}
 
-	// Set the aggs for grouping by sig, source, then dest...
-	query.Aggs = s.get3TupleAggs()
+	return query, nil
+}
+
+func (s *DataStore) AlertQuery(ctx context.Context, options core.AlertQueryOptions) ([]core.AlertGroup, error) {
+
+	query, err := s.buildAlertQuery(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	groupBy := options.GroupBy
+	if len(groupBy) == 0 {
+		groupBy = core.DefaultAlertGroupBy
+	}
+	query.Aggs = s.getAlertGroupAggs(groupBy)
 
 	qStart := time.Now()
-	results, err := s.es.Search(query)
+	results, err := s.es.Search(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 	log.Info("Query elapsed time: %v", time.Now().Sub(qStart))
 
 	alertGroups := AlertGroupList{}
-
-	aggs := util.JsonMap(results.Aggregations)
-	signatures := aggs.GetMap("signatures")
-	for _, bucket0 := range signatures.GetMapList("buckets") {
-		sources := bucket0.GetMap("sources")
-		for _, bucket1 := range sources.GetMapList("buckets") {
-			destinations := bucket1.GetMap("destinations")
-			for _, bucket2 := range destinations.GetMapList("buckets") {
-
-				alertGroup := core.AlertGroup{}
-				alertGroup.Count, _ = bucket2.Get("doc_count").(json.Number).Int64()
-				alertGroup.EscalatedCount, _ = bucket2.GetMap("escalated").Get("doc_count").(json.Number).Int64()
-
-				minEvent := bucket2.GetMap("oldest").GetMap("hits").GetMapList("hits")[0]
-				maxEvent := bucket2.GetMap("newest").GetMap("hits").GetMapList("hits")[0]
-
-				alertGroup.MinTs = minEvent.GetMap("_source").GetString("@timestamp")
-				alertGroup.MaxTs = maxEvent.GetMap("_source").GetString("@timestamp")
-
-				alertGroup.Event = maxEvent
-
-				if maxEvent["_source"].(map[string]interface{})["tags"] == nil {
-					maxEvent["_source"].(map[string]interface{})["tags"] = []string{}
-				}
-
-				alertGroups = append(alertGroups, alertGroup)
-			}
-		}
-	}
+	collectAlertGroups(util.JsonMap(results.Aggregations), len(groupBy),
+		&alertGroups)
 
 	sort.Sort(sort.Reverse(alertGroups))
 
@@ -27,152 +27,103 @@
 package elasticsearch
 
 import (
+	"context"
 	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/log"
 	"github.com/pkg/errors"
 	"time"
 )
 
-// ArchiveEvent archives an individual event by ID.
-func (s *DataStore) ArchiveEvent(eventId string, user core.User) error {
-	event, err := s.GetEventById(eventId)
+// getEventDocument returns the document of an event by ID.
+func (s *DataStore) getEventDocument(ctx context.Context, eventId string) (Document, error) {
+	event, err := s.GetEventById(ctx, eventId)
 	if err != nil {
-		return errors.Wrap(err, "failed to get event")
+		return Document{}, errors.Wrap(err, "failed to get event")
 	}
 	if event == nil {
-		return core.NewEventNotFoundError(eventId)
+		return Document{}, core.NewEventNotFoundError(eventId)
 	}
-	eventDoc := Document{event}
+	return Document{event}, nil
+}
 
+// updateDocument runs addTagsScript or removeTagsScript against a single
+// event, recording the action in its history if not nil.
+func (s *DataStore) updateDocument(ctx context.Context, doc Document, tags []string, script string, action *HistoryEntry) error {
 	request := map[string]interface{}{
-		"script": map[string]interface{}{
-			"lang": "painless",
-			"inline": `
-			    if (ctx._source.tags == null) {
-			        ctx._source.tags = new ArrayList();
-			    }
-			    for (tag in params.tags) {
-			        if (!ctx._source.tags.contains(tag)) {
-			            ctx._source.tags.add(tag);
-			        }
-			    }
-			    if (ctx._source.evebox == null) {
-			        ctx._source.evebox = new HashMap();
-			    }
-			    if (ctx._source.evebox.history == null) {
-			        ctx._source.evebox.history = new ArrayList();
-			    }
-			    ctx._source.evebox.history.add(params.action);
-			`,
-			"params": map[string]interface{}{
-				"tags": []string{"archived", "evebox.archived"},
-				"action": HistoryEntry{
-					Action:    ACTION_ARCHIVED,
-					Timestamp: FormatTimestampUTC(time.Now()),
-					Username:  user.Username,
-				},
-			},
+		"script": &Script{
+			Lang:   "painless",
+			Inline: script,
+			Params: tagsScriptParams(tags, action),
 		},
 	}
-
-	_, err = s.es.Update(eventDoc.Index(), eventDoc.Type(), eventDoc.Id(), request)
+	_, err := s.es.Update(ctx, doc.Index(), doc.Type(), doc.Id(), request)
 	if err != nil {
 		log.Error("update error: %v", err)
 		return err
 	}
-
 	return nil
 }
 
-// EscalateEvent escalated an individual event by ID.
-func (s *DataStore) EscalateEvent(eventId string, user core.User) error {
-	event, err := s.GetEventById(eventId)
-	if err != nil {
-		return errors.Wrap(err, "failed to get event")
-	}
-	eventDoc := Document{event}
-
-	request := map[string]interface{}{
-		"script": map[string]interface{}{
-			"lang": "painless",
-			"inline": `
-			    if (ctx._source.tags == null) {
-			        ctx._source.tags = new ArrayList();
-			    }
-			    for (tag in params.tags) {
-			        if (!ctx._source.tags.contains(tag)) {
-			            ctx._source.tags.add(tag);
-			        }
-			    }
-			    if (ctx._source.evebox == null) {
-			        ctx._source.evebox = new HashMap();
-			    }
-			    if (ctx._source.evebox.history == null) {
-			        ctx._source.evebox.history = new ArrayList();
-			    }
-			    ctx._source.evebox.history.add(params.action);
-			`,
-			"params": map[string]interface{}{
-				"tags": []string{"escalated", "evebox.escalated"},
-				"action": HistoryEntry{
-					Action:    ACTION_ESCALATED,
-					Timestamp: FormatTimestampUTC(time.Now()),
-					Username:  user.Username,
-				},
-			},
-		},
-	}
-
-	_, err = s.es.Update(eventDoc.Index(), eventDoc.Type(), eventDoc.Id(), request)
+// updateEvent runs one of the tag scripts against a single event by ID,
+// recording the action in its history.
+func (s *DataStore) updateEvent(ctx context.Context, eventId string, tags []string, script string, action HistoryEntry) error {
+	doc, err := s.getEventDocument(ctx, eventId)
 	if err != nil {
-		log.Error("update error: %v", err)
 		return err
 	}
+	return s.updateDocument(ctx, doc, tags, script, &action)
+}
 
-	return nil
+// ArchiveEvent archives an individual event by ID.
+func (s *DataStore) ArchiveEvent(ctx context.Context, eventId string, user core.User) error {
+	return s.updateEvent(ctx, eventId, []string{"archived", "evebox.archived"},
+		addTagsScript, HistoryEntry{
+			Action:    ACTION_ARCHIVED,
+			Timestamp: FormatTimestampUTC(time.Now()),
+			Username:  user.Username,
+		})
 }
 
-// DeEscalateEvent de-escalates an individual event by ID.
-func (s *DataStore) DeEscalateEvent(eventId string, user core.User) error {
-	event, err := s.GetEventById(eventId)
-	if err != nil {
-		return errors.Wrap(err, "failed to get event")
-	}
-	eventDoc := Document{event}
+// EscalateEvent escalated an individual event by ID.
+func (s *DataStore) EscalateEvent(ctx context.Context, eventId string, user core.User) error {
+	return s.updateEvent(ctx, eventId, []string{"escalated", "evebox.escalated"},
+		addTagsScript, HistoryEntry{
+			Action:    ACTION_ESCALATED,
+			Timestamp: FormatTimestampUTC(time.Now()),
+			Username:  user.Username,
+		})
+}
 
-	request := map[string]interface{}{
-		"script": map[string]interface{}{
-			"lang": "painless",
-			"inline": `
-			    if (ctx._source.tags != null) {
-			        for (tag in params.tags) {
-			            ctx._source.tags.removeIf(entry -> entry == tag);
-			        }
-			    }
-			    if (ctx._source.evebox == null) {
-			        ctx._source.evebox = new HashMap();
-			    }
-			    if (ctx._source.evebox.history == null) {
-			        ctx._source.evebox.history = new ArrayList();
-			    }
-			    ctx._source.evebox.history.add(params.action);
-			`,
-			"params": map[string]interface{}{
-				"tags": []string{"escalated", "evebox.escalated"},
-				"action": HistoryEntry{
-					Action:    ACTION_DEESCALATED,
-					Timestamp: FormatTimestampUTC(time.Now()),
-					Username:  user.Username,
-				},
-			},
-		},
-	}
+// DeEscalateEvent de-escalates an individual event by ID.
+func (s *DataStore) DeEscalateEvent(ctx context.Context, eventId string, user core.User) error {
+	return s.updateEvent(ctx, eventId, []string{"escalated", "evebox.escalated"},
+		removeTagsScript, HistoryEntry{
+			Action:    ACTION_DEESCALATED,
+			Timestamp: FormatTimestampUTC(time.Now()),
+			Username:  user.Username,
+		})
+}
 
-	_, err = s.es.Update(eventDoc.Index(), eventDoc.Type(), eventDoc.Id(), request)
-	if err != nil {
-		log.Error("update error: %v", err)
-		return err
+// ImportHistoryEntry applies an action from the history of an event in
+// another datastore, recording it with its original timestamp and user.
+// Only used when migrating events.
+func (s *DataStore) ImportHistoryEntry(ctx context.Context, eventId string, entry HistoryEntry) error {
+	switch entry.Action {
+	case ACTION_ARCHIVED:
+		return s.updateEvent(ctx, eventId, []string{"archived", "evebox.archived"},
+			addTagsScript, entry)
+	case ACTION_ESCALATED:
+		return s.updateEvent(ctx, eventId, []string{"escalated", "evebox.escalated"},
+			addTagsScript, entry)
+	case ACTION_DEESCALATED:
+		return s.updateEvent(ctx, eventId, []string{"escalated", "evebox.escalated"},
+			removeTagsScript, entry)
+	case ACTION_TAGGED:
+		return s.updateEventTags(ctx, eventId, entry.Tags, addTagsScript, entry)
+	case ACTION_UNTAGGED:
+		return s.updateEventTags(ctx, eventId, entry.Tags, removeTagsScript, entry)
+	case ACTION_COMMENT:
+		return s.updateEvent(ctx, eventId, nil, addTagsScript, entry)
 	}
-
-	return nil
+	return errors.Errorf("unsupported history action: %s", entry.Action)
 }
@@ -27,29 +27,143 @@
 package elasticsearch
 
 import (
+	"context"
+	"fmt"
 	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/log"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
 )
 
-func (s *DataStore) EventQuery(options core.EventQueryOptions) (interface{}, error) {
+// Number of events fetched per scroll request when exporting.
+const exportScrollSize = 1000
+
+// The tie breaking sort field for events with the same timestamp. Sorting
+// on _id is only supported from Elastic Search 7, before that _uid, which
+// is prefixed with the document type, must be used.
+func (s *DataStore) idSortField() string {
+	if s.es.MajorVersion < 7 {
+		return "_uid"
+	}
+	return "_id"
+}
+
+// eventPosition returns the cursor position of a hit. Events indexed by
+// EveBox have a ULID as their ID which also encodes the timestamp, so
+// that is all that is needed. For other IDs, such as those assigned by
+// Logstash, the sort values are used.
+func (s *DataStore) eventPosition(hit map[string]interface{}) string {
+	id, _ := hit["_id"].(string)
+	sort, _ := hit["sort"].([]interface{})
+	if len(sort) != 2 {
+		return id
+	}
+	timestamp := fmt.Sprintf("%v", sort[0])
+	tiebreak := fmt.Sprintf("%v", sort[1])
+	if parsed, err := ulid.Parse(id); err == nil {
+		if fmt.Sprintf("%d", parsed.Time()) == timestamp &&
+			tiebreak == s.ulidSortValue(id) {
+			return id
+		}
+	}
+	return fmt.Sprintf("%s:%s", timestamp, tiebreak)
+}
+
+func (s *DataStore) ulidSortValue(id string) string {
+	if s.es.MajorVersion < 7 {
+		return fmt.Sprintf("log#%s", id)
+	}
+	return id
+}
+
+// searchAfter converts a cursor position into search_after values.
+func (s *DataStore) searchAfter(position string) ([]interface{}, error) {
+	if parts := strings.SplitN(position, ":", 2); len(parts) == 2 {
+		timestamp, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid cursor position: %s", position)
+		}
+		return []interface{}{timestamp, parts[1]}, nil
+	}
+	parsed, err := ulid.Parse(position)
+	if err != nil {
+		return nil, errors.Errorf("invalid cursor position: %s", position)
+	}
+	return []interface{}{parsed.Time(), s.ulidSortValue(position)}, nil
+}
+
+func (s *DataStore) EventQuery(ctx context.Context, options core.EventQueryOptions) (*core.EventQueryResult, error) {
+	pager, err := core.NewEventPager(options)
+	if err != nil {
+		return nil, err
+	}
+
+	order := "desc"
+	if pager.Ascending() {
+		order = "asc"
+	}
+	query, err := s.eventQuery(ctx, options, order)
+	if err != nil {
+		return nil, err
+	}
+
+	query.Size = pager.Limit()
+
+	if pager.Position != "" {
+		query.SearchAfter, err = s.searchAfter(pager.Position)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response, err := s.es.Search(ctx, query)
+	if err != nil {
+		log.Error("%v", err)
+		return nil, err
+	}
+	if response.IsError() {
+		return nil, response.AsError()
+	}
+
+	hits := response.Hits.Hits
+	positions := make([]string, len(hits))
+	for i, hit := range hits {
+		positions[i] = s.eventPosition(hit)
+	}
+
+	result := pager.Result(hits, positions)
+	result.Total = int64(response.Hits.Total)
+	return result, nil
+}
+
+// eventQuery returns the query for the events matching the options,
+// sorted in the order given.
+func (s *DataStore) eventQuery(ctx context.Context, options core.EventQueryOptions, order string) (EventQuery, error) {
 	query := NewEventQuery()
 
 	query.MustNot(TermQuery("event_type", "stats"))
 
-	if options.Order == "asc" {
-		query.SortBy("@timestamp", "asc")
-	} else {
-		query.SortBy("@timestamp", "desc")
+	query.Sort = []interface{}{
+		Sort("@timestamp", order),
+		Sort(s.idSortField(), order),
 	}
 
-	if options.Size > 0 {
-		query.Size = options.Size
-	} else {
-		query.Size = 500
+	if options.QueryString != "" {
+		filter, err := s.es.QueryStringFilter(ctx, options.QueryString)
+		if err != nil {
+			return query, err
+		}
+		query.AddFilter(filter)
 	}
 
-	if options.QueryString != "" {
-		query.AddFilter(QueryString(options.QueryString))
+	if options.TimeRange != "" {
+		if err := query.AddTimeRangeFilter(options.TimeRange); err != nil {
+			return query, errors.Wrap(err, "failed to parse time range")
+		}
 	}
 
 	if !options.MinTs.IsZero() {
@@ -66,14 +180,56 @@ func (s *DataStore) EventQuery(options core.EventQueryOptions) (interface{}, err
 		query.AddFilter(TermQuery("event_type", options.EventType))
 	}
 
-	response, err := s.es.Search(query)
+	return query, nil
+}
+
+// ExportEvents uses a scroll to walk all the events matching the options
+// without the deep paging limits of a search.
+func (s *DataStore) ExportEvents(ctx context.Context, options core.EventQueryOptions, fn func(event map[string]interface{}) error) error {
+	order := "desc"
+	if options.Order == "asc" {
+		order = "asc"
+	}
+	query, err := s.eventQuery(ctx, options, order)
 	if err != nil {
-		log.Error("%v", err)
+		return err
 	}
+	query.Size = exportScrollSize
 
-	hits := response.Hits.Hits
+	response, err := s.es.SearchScroll(ctx, query, "1m")
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize scroll")
+	}
+	scrollID := response.ScrollId
+	defer func() {
+		// Clean up the scroll even if ctx was cancelled.
+		response, err := s.es.DeleteScroll(context.Background(), scrollID)
+		if err != nil {
+			log.Error("Failed to delete scroll id: %v", err)
+			return
+		}
+		io.Copy(ioutil.Discard, response.Body)
+		response.Body.Close()
+	}()
+
+	for len(response.Hits.Hits) > 0 {
+		for _, hit := range response.Hits.Hits {
+			if err := fn(hit); err != nil {
+				return err
+			}
+		}
+
+		response, err = s.es.Scroll(ctx, scrollID, "1m")
+		if err != nil {
+			return errors.Wrap(err, "failed to fetch from scroll")
+		}
+		if response.IsError() {
+			return response.AsError()
+		}
+		if response.ScrollId != "" {
+			scrollID = response.ScrollId
+		}
+	}
 
-	return map[string]interface{}{
-		"data": hits,
-	}, nil
+	return nil
 }
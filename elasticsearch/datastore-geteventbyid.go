@@ -26,6 +26,8 @@
 
 package elasticsearch
 
+import "context"
+
 type getEventByIdQuery struct {
 	Query struct {
 		Bool struct {
@@ -40,10 +42,10 @@ type getEventByIdQuery struct {
 
 // GetEventById returns the event with the given ID. If not event is found
 // nil will be returned for the event and error will not be set.
-func (s *DataStore) GetEventById(id string) (map[string]interface{}, error) {
+func (s *DataStore) GetEventById(ctx context.Context, id string) (map[string]interface{}, error) {
 	query := getEventByIdQuery{}
 	query.Query.Bool.Filter.Term.ID = id
-	result, err := s.es.Search(query)
+	result, err := s.es.Search(ctx, query)
 	if err != nil {
 		return nil, err
 	}
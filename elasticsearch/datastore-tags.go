@@ -0,0 +1,192 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Event tags.
+
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/util"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+// updateEventTags runs one of the tag scripts against a single event,
+// recording the action in its history if its tags are changed.
+func (s *DataStore) updateEventTags(ctx context.Context, eventId string, tags []string, script string, action HistoryEntry) error {
+	if err := core.ValidateTags(tags); err != nil {
+		return err
+	}
+	doc, err := s.getEventDocument(ctx, eventId)
+	if err != nil {
+		return err
+	}
+	var history *HistoryEntry
+	if tagsChanged(doc.GetMap("_source").GetAsStrings("tags"), tags, action.Action) {
+		history = &action
+	}
+	return s.updateDocument(ctx, doc, tags, script, history)
+}
+
+// tagsChanged returns true if adding, or removing, the tags changes the
+// current tags of an event.
+func tagsChanged(current []string, tags []string, action string) bool {
+	has := map[string]bool{}
+	for _, tag := range current {
+		has[tag] = true
+	}
+	for _, tag := range tags {
+		if has[tag] == (action == ACTION_UNTAGGED) {
+			return true
+		}
+	}
+	return false
+}
+
+// newTagsHistoryEntry returns the history entry for user tags added or
+// removed by the user.
+func newTagsHistoryEntry(action string, tags []string, user core.User) *HistoryEntry {
+	return &HistoryEntry{
+		Username:  user.Username,
+		Timestamp: FormatTimestampUTC(time.Now()),
+		Action:    action,
+		Tags:      tags,
+	}
+}
+
+// AddTagsToEvent adds user tags to a single event.
+func (s *DataStore) AddTagsToEvent(ctx context.Context, eventId string, tags []string, user core.User) error {
+	return s.updateEventTags(ctx, eventId, tags, addTagsScript,
+		*newTagsHistoryEntry(ACTION_TAGGED, tags, user))
+}
+
+// RemoveTagsFromEvent removes user tags from a single event.
+func (s *DataStore) RemoveTagsFromEvent(ctx context.Context, eventId string, tags []string, user core.User) error {
+	return s.updateEventTags(ctx, eventId, tags, removeTagsScript,
+		*newTagsHistoryEntry(ACTION_UNTAGGED, tags, user))
+}
+
+// AddTagsToAlertGroup adds user tags to each event in the alert group.
+func (s *DataStore) AddTagsToAlertGroup(ctx context.Context, p core.AlertGroupQueryParams, tags []string, user core.User) error {
+	if err := core.ValidateTags(tags); err != nil {
+		return err
+	}
+	if s.es.MajorVersion < 5 {
+		return s.addTagsToAlertGroupScroll(ctx, p, tags)
+	}
+	return s.AddTagsToAlertGroupsByQuery(ctx, p, tags,
+		newTagsHistoryEntry(ACTION_TAGGED, tags, user))
+}
+
+// RemoveTagsFromAlertGroup removes user tags from each event in the alert
+// group.
+func (s *DataStore) RemoveTagsFromAlertGroup(ctx context.Context, p core.AlertGroupQueryParams, tags []string, user core.User) error {
+	if err := core.ValidateTags(tags); err != nil {
+		return err
+	}
+	if s.es.MajorVersion < 5 {
+		return s.removeTagsFromAlertGroupScroll(ctx, p, tags)
+	}
+	return s.RemoveTagsFromAlertGroupsByQuery(ctx, p, tags,
+		newTagsHistoryEntry(ACTION_UNTAGGED, tags, user))
+}
+
+// The maximum number of distinct tags returned by TagCounts.
+const tagCountsSize = 1000
+
+// TagCounts returns the number of events matching the options with each
+// tag. The internal evebox. tags are not included.
+func (s *DataStore) TagCounts(ctx context.Context, options core.EventQueryOptions) ([]core.TagCount, error) {
+	query := NewEventQuery()
+	query.Size = 0
+	query.Sort = nil
+
+	if options.QueryString != "" {
+		filter, err := s.es.QueryStringFilter(ctx, options.QueryString)
+		if err != nil {
+			return nil, err
+		}
+		query.AddFilter(filter)
+	}
+
+	if options.TimeRange != "" {
+		if err := query.AddTimeRangeFilter(options.TimeRange); err != nil {
+			return nil, errors.Wrap(err, "failed to parse time range")
+		}
+	} else {
+		if !options.MinTs.IsZero() {
+			query.AddFilter(RangeGte("@timestamp",
+				FormatTimestampUTC(options.MinTs)))
+		}
+		if !options.MaxTs.IsZero() {
+			query.AddFilter(RangeLte("@timestamp",
+				FormatTimestampUTC(options.MaxTs)))
+		}
+	}
+
+	if options.EventType != "" {
+		query.AddFilter(TermQuery("event_type", options.EventType))
+	}
+
+	query.Aggs["tags"] = map[string]interface{}{
+		"terms": map[string]interface{}{
+			"field": s.es.FormatKeyword("tags"),
+			"size":  tagCountsSize,
+		},
+	}
+
+	response, err := s.es.Search(ctx, query)
+	if err != nil {
+		log.Error("%v", err)
+		return nil, err
+	}
+	if response.IsError() {
+		return nil, response.AsError()
+	}
+
+	counts := []core.TagCount{}
+	agg, ok := response.Aggregations["tags"].(map[string]interface{})
+	if !ok {
+		return counts, nil
+	}
+	for _, bucket := range util.JsonMap(agg).GetMapList("buckets") {
+		tag, ok := bucket.Get("key").(string)
+		if !ok || strings.HasPrefix(tag, "evebox.") {
+			continue
+		}
+		count, _ := bucket.Get("doc_count").(json.Number).Int64()
+		counts = append(counts, core.TagCount{
+			Tag:   tag,
+			Count: count,
+		})
+	}
+	return counts, nil
+}
@@ -27,11 +27,12 @@
 package elasticsearch
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/eve"
 	"github.com/jasonish/evebox/log"
-	"github.com/jasonish/evebox/util"
 	"github.com/pkg/errors"
 	"io"
 	"io/ioutil"
@@ -59,7 +60,7 @@ func (s *DataStore) asKeyword(keyword string) string {
 }
 
 // FindFlow finds the flow events matching the query parameters in options.
-func (d *DataStore) FindFlow(flowId uint64, proto string, timestamp string,
+func (d *DataStore) FindFlow(ctx context.Context, flowId uint64, proto string, timestamp string,
 	srcIp string, destIp string) (interface{}, error) {
 
 	query := NewEventQuery()
@@ -67,13 +68,24 @@ func (d *DataStore) FindFlow(flowId uint64, proto string, timestamp string,
 
 	query.EventType("flow")
 	query.AddFilter(TermQuery("flow_id", flowId))
-	query.AddFilter(TermQuery("proto", proto))
+	query.AddFilter(KeywordTermQuery("proto", proto, d.es.keyword))
 	query.AddFilter(RangeLte("flow.start", timestamp))
 	query.AddFilter(RangeGte("flow.end", timestamp))
-	query.ShouldHaveIp(srcIp, d.es.keyword)
-	query.ShouldHaveIp(destIp, d.es.keyword)
 
-	response, err := d.es.Search(query)
+	// Both addresses must be present, in either direction.
+	for _, addr := range []string{srcIp, destIp} {
+		query.AddFilter(map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should": []interface{}{
+					KeywordTermQuery("src_ip", addr, d.es.keyword),
+					KeywordTermQuery("dest_ip", addr, d.es.keyword),
+				},
+				"minimum_should_match": 1,
+			},
+		})
+	}
+
+	response, err := d.es.Search(ctx, query)
 	if err != nil {
 		log.Error("%v", err)
 		return nil, err
@@ -83,7 +95,7 @@ func (d *DataStore) FindFlow(flowId uint64, proto string, timestamp string,
 }
 
 // FindNetflow finds netflow events matching the parameters in options.
-func (s *DataStore) FindNetflow(options core.EventQueryOptions, sortBy string,
+func (s *DataStore) FindNetflow(ctx context.Context, options core.EventQueryOptions, sortBy string,
 	order string) (interface{}, error) {
 
 	size := int64(10)
@@ -104,7 +116,11 @@ func (s *DataStore) FindNetflow(options core.EventQueryOptions, sortBy string,
 	}
 
 	if options.QueryString != "" {
-		query.AddFilter(QueryString(options.QueryString))
+		filter, err := s.es.QueryStringFilter(ctx, options.QueryString)
+		if err != nil {
+			return nil, err
+		}
+		query.AddFilter(filter)
 	}
 
 	if sortBy != "" {
@@ -113,7 +129,7 @@ func (s *DataStore) FindNetflow(options core.EventQueryOptions, sortBy string,
 		query.Size = size
 	}
 
-	response, err := s.es.Search(query)
+	response, err := s.es.Search(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -126,19 +142,20 @@ func (s *DataStore) FindNetflow(options core.EventQueryOptions, sortBy string,
 	}, nil
 }
 
-// AddTagsToAlertGroup adds the provided tags to all alerts that match the
-// provided alert group parameters.
-func (s *DataStore) AddTagsToAlertGroup(p core.AlertGroupQueryParams, tags []string) error {
+// addTagsToAlertGroupScroll adds the provided tags to all alerts that
+// match the provided alert group parameters, updating each document for
+// Elastic Search versions without update by query.
+func (s *DataStore) addTagsToAlertGroupScroll(ctx context.Context, p core.AlertGroupQueryParams, tags []string) error {
 
 	mustNot := []interface{}{}
 	for _, tag := range tags {
-		mustNot = append(mustNot, TermQuery("tags", tag))
+		mustNot = append(mustNot, s.es.TagQuery(tag))
 	}
 
 	query := map[string]interface{}{
 		"query": map[string]interface{}{
 			"bool": map[string]interface{}{
-				"filter": []interface{}{
+				"filter": append([]interface{}{
 					ExistsQuery("event_type"),
 					KeywordTermQuery("event_type", "alert", s.es.keyword),
 					RangeQuery{
@@ -146,10 +163,7 @@ func (s *DataStore) AddTagsToAlertGroup(p core.AlertGroupQueryParams, tags []str
 						Gte:   eve.FormatTimestampUTC(p.MinTimestamp),
 						Lte:   eve.FormatTimestampUTC(p.MaxTimestamp),
 					},
-					KeywordTermQuery("src_ip", p.SrcIP, s.es.keyword),
-					KeywordTermQuery("dest_ip", p.DstIP, s.es.keyword),
-					TermQuery("alert.signature_id", p.SignatureID),
-				},
+				}, s.alertGroupFilters(p)...),
 				"must_not": mustNot,
 			},
 		},
@@ -160,14 +174,15 @@ func (s *DataStore) AddTagsToAlertGroup(p core.AlertGroupQueryParams, tags []str
 		"size": 10000,
 	}
 
-	searchResponse, err := s.es.SearchScroll(query, "1m")
+	searchResponse, err := s.es.SearchScroll(ctx, query, "1m")
 	if err != nil {
 		log.Error("Failed to initialize scroll: %v", err)
 		return err
 	}
 	scrollID := searchResponse.ScrollId
 	defer func() {
-		response, err := s.es.DeleteScroll(scrollID)
+		// Clean up the scroll even if ctx was cancelled.
+		response, err := s.es.DeleteScroll(context.Background(), scrollID)
 		if err != nil {
 			log.Error("Failed to delete scroll id: %v", err)
 		}
@@ -188,7 +203,7 @@ func (s *DataStore) AddTagsToAlertGroup(p core.AlertGroupQueryParams, tags []str
 		maxRetries := 5
 		retries := 0
 		for {
-			retry, err := BulkUpdateTags(s.es, searchResponse.Hits.Hits,
+			retry, err := BulkUpdateTags(ctx, s.es, searchResponse.Hits.Hits,
 				tags, nil)
 			if err != nil {
 				log.Error("BulkAddTags failed: %v", err)
@@ -205,7 +220,7 @@ func (s *DataStore) AddTagsToAlertGroup(p core.AlertGroupQueryParams, tags []str
 		}
 
 		// Get next set of events to archive.
-		searchResponse, err = s.es.Scroll(scrollID, "1m")
+		searchResponse, err = s.es.Scroll(ctx, scrollID, "1m")
 		if err != nil {
 			log.Error("Failed to fetch from scroll: %v", err)
 			return err
@@ -226,11 +241,72 @@ const ACTION_DEESCALATED = "de-escalated"
 
 const ACTION_COMMENT = "comment"
 
+// User tags added or removed, the tags are in the history entry.
+const ACTION_TAGGED = "tagged"
+
+const ACTION_UNTAGGED = "untagged"
+
 type HistoryEntry struct {
-	Timestamp string `json:"timestamp"`
-	Username  string `json:"username"`
-	Action    string `json:"action"`
-	Comment   string `json:"comment,omitempty"`
+	Timestamp string   `json:"timestamp"`
+	Username  string   `json:"username"`
+	Action    string   `json:"action"`
+	Comment   string   `json:"comment,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// Painless script for update by query to add params.tags to an event and
+// record params.action, if set, in its history.
+const addTagsScript = `
+		        if (params.tags != null) {
+			        if (ctx._source.tags == null) {
+			            ctx._source.tags = new ArrayList();
+			        }
+			        for (tag in params.tags) {
+			            if (!ctx._source.tags.contains(tag)) {
+			                ctx._source.tags.add(tag);
+			            }
+			        }
+			    }
+			    if (params.action != null) {
+			        if (ctx._source.evebox == null) {
+			            ctx._source.evebox = new HashMap();
+			        }
+			        if (ctx._source.evebox.history == null) {
+			            ctx._source.evebox.history = new ArrayList();
+			        }
+			        ctx._source.evebox.history.add(params.action);
+			    }
+		`
+
+// Painless script for update by query to remove params.tags from an event
+// and record params.action, if set, in its history.
+const removeTagsScript = `
+			    if (ctx._source.tags != null) {
+			        for (tag in params.tags) {
+			            ctx._source.tags.removeIf(entry -> entry == tag);
+			        }
+			    }
+			    if (params.action != null) {
+			        if (ctx._source.evebox == null) {
+			            ctx._source.evebox = new HashMap();
+			        }
+			        if (ctx._source.evebox.history == null) {
+			            ctx._source.evebox.history = new ArrayList();
+			        }
+			        ctx._source.evebox.history.add(params.action);
+			    }
+		`
+
+// tagsScriptParams returns the parameters for addTagsScript and
+// removeTagsScript. No history is recorded if action is nil.
+func tagsScriptParams(tags []string, action *HistoryEntry) map[string]interface{} {
+	params := map[string]interface{}{
+		"tags": tags,
+	}
+	if action != nil {
+		params["action"] = action
+	}
+	return params
 }
 
 func (s *DataStore) buildAlertGroupQuery(p core.AlertGroupQueryParams) *EventQuery {
@@ -242,20 +318,40 @@ func (s *DataStore) buildAlertGroupQuery(p core.AlertGroupQueryParams) *EventQue
 		Gte:   eve.FormatTimestampUTC(p.MinTimestamp),
 		Lte:   eve.FormatTimestampUTC(p.MaxTimestamp),
 	})
-	q.AddFilter(KeywordTermQuery("src_ip", p.SrcIP, s.es.keyword))
-	q.AddFilter(KeywordTermQuery("dest_ip", p.DstIP, s.es.keyword))
-	q.AddFilter(TermQuery("alert.signature_id", p.SignatureID))
+	for _, filter := range s.alertGroupFilters(p) {
+		q.AddFilter(filter)
+	}
 	return &q
 }
 
+// alertGroupFilters returns the filters matching the events of an alert
+// group on the keys it was grouped by.
+func (s *DataStore) alertGroupFilters(p core.AlertGroupQueryParams) []interface{} {
+	filters := []interface{}{}
+	for _, key := range p.Keys() {
+		value := p.Value(key)
+		if key == core.ALERT_GROUP_BY_HOST && value == "" {
+			// Events without a host are grouped under an empty host.
+			filters = append(filters, map[string]interface{}{
+				"bool": map[string]interface{}{
+					"must_not": ExistsQuery("host"),
+				},
+			})
+			continue
+		}
+		filters = append(filters, TermQuery(s.alertGroupField(key), value))
+	}
+	return filters
+}
+
 // ArchiveAlertGroupByQuery uses the Elastic Search update_by_query API to
 // archive events with a query instead of updating each document. This is
 // only available in Elastic Search v5+.
-func (s *DataStore) AddTagsToAlertGroupsByQuery(p core.AlertGroupQueryParams, tags []string, action HistoryEntry) error {
+func (s *DataStore) AddTagsToAlertGroupsByQuery(ctx context.Context, p core.AlertGroupQueryParams, tags []string, action *HistoryEntry) error {
 	log.Println("AddTagsToAlertGroupsByQuery")
 	mustNot := []interface{}{}
 	for _, tag := range tags {
-		mustNot = append(mustNot, TermQuery("tags", tag))
+		mustNot = append(mustNot, s.es.TagQuery(tag))
 	}
 
 	query := s.buildAlertGroupQuery(p)
@@ -263,33 +359,12 @@ func (s *DataStore) AddTagsToAlertGroupsByQuery(p core.AlertGroupQueryParams, ta
 		query.Query.Bool.MustNot = mustNot
 	}
 	query.Script = &Script{
-		Lang: "painless",
-		Inline: `
-		        if (params.tags != null) {
-			        if (ctx._source.tags == null) {
-			            ctx._source.tags = new ArrayList();
-			        }
-			        for (tag in params.tags) {
-			            if (!ctx._source.tags.contains(tag)) {
-			                ctx._source.tags.add(tag);
-			            }
-			        }
-			    }
-			    if (ctx._source.evebox == null) {
-			        ctx._source.evebox = new HashMap();
-			    }
-			    if (ctx._source.evebox.history == null) {
-			        ctx._source.evebox.history = new ArrayList();
-			    }
-			    ctx._source.evebox.history.add(params.action);
-		`,
-		Params: map[string]interface{}{
-			"tags":   tags,
-			"action": action,
-		},
+		Lang:   "painless",
+		Inline: addTagsScript,
+		Params: tagsScriptParams(tags, action),
 	}
 
-	response, err := s.es.doUpdateByQuery(query)
+	response, err := s.es.doUpdateByQuery(ctx, query)
 	if err != nil {
 		log.Error("failed to update by query: %v", err)
 		return err
@@ -301,12 +376,12 @@ func (s *DataStore) AddTagsToAlertGroupsByQuery(p core.AlertGroupQueryParams, ta
 }
 
 // ArchiveAlertGroup is a specialization of AddTagsToAlertGroup.
-func (s *DataStore) ArchiveAlertGroup(p core.AlertGroupQueryParams, user core.User) error {
+func (s *DataStore) ArchiveAlertGroup(ctx context.Context, p core.AlertGroupQueryParams, user core.User) error {
 	tags := []string{"archived", "evebox.archived"}
 	if s.es.MajorVersion < 5 {
-		return s.AddTagsToAlertGroup(p, tags)
+		return s.addTagsToAlertGroupScroll(ctx, p, tags)
 	}
-	return s.AddTagsToAlertGroupsByQuery(p, tags, HistoryEntry{
+	return s.AddTagsToAlertGroupsByQuery(ctx, p, tags, &HistoryEntry{
 		Action:    ACTION_ARCHIVED,
 		Timestamp: FormatTimestampUTC(time.Now()),
 		Username:  user.Username,
@@ -314,63 +389,48 @@ func (s *DataStore) ArchiveAlertGroup(p core.AlertGroupQueryParams, user core.Us
 }
 
 // EscalateAlertGroup is a specialization of AddTagsToAlertGroup.
-func (s *DataStore) EscalateAlertGroup(p core.AlertGroupQueryParams, user core.User) error {
+func (s *DataStore) EscalateAlertGroup(ctx context.Context, p core.AlertGroupQueryParams, user core.User) error {
 	tags := []string{"escalated", "evebox.escalated"}
 	if s.es.MajorVersion < 5 {
-		return s.AddTagsToAlertGroup(p, tags)
+		return s.addTagsToAlertGroupScroll(ctx, p, tags)
 	}
-	history := HistoryEntry{
+	history := &HistoryEntry{
 		Username:  user.Username,
 		Action:    ACTION_ESCALATED,
 		Timestamp: FormatTimestampUTC(time.Now()),
 	}
-	return s.AddTagsToAlertGroupsByQuery(p, tags, history)
+	return s.AddTagsToAlertGroupsByQuery(ctx, p, tags, history)
 }
 
-func (s *DataStore) DeEscalateAlertGroup(p core.AlertGroupQueryParams, user core.User) error {
+func (s *DataStore) DeEscalateAlertGroup(ctx context.Context, p core.AlertGroupQueryParams, user core.User) error {
 	tags := []string{"escalated", "evebox.escalated"}
 	if s.es.MajorVersion < 5 {
-		return s.RemoveTagsFromAlertGroup(p, tags)
+		return s.removeTagsFromAlertGroupScroll(ctx, p, tags)
 	}
-	return s.RemoveTagsFromAlertGroupsByQuery(p, tags, HistoryEntry{
+	return s.RemoveTagsFromAlertGroupsByQuery(ctx, p, tags, &HistoryEntry{
 		Username:  user.Username,
 		Timestamp: FormatTimestampUTC(time.Now()),
 		Action:    ACTION_DEESCALATED,
 	})
 }
 
-func (s *DataStore) RemoveTagsFromAlertGroupsByQuery(p core.AlertGroupQueryParams,
-	tags []string, action HistoryEntry) error {
+func (s *DataStore) RemoveTagsFromAlertGroupsByQuery(ctx context.Context, p core.AlertGroupQueryParams,
+	tags []string, action *HistoryEntry) error {
 	should := []interface{}{}
 	for _, tag := range tags {
-		should = append(should, TermQuery("tags", tag))
+		should = append(should, s.es.TagQuery(tag))
 	}
 
 	query := s.buildAlertGroupQuery(p)
 	query.Query.Bool.Should = should
+	query.Query.Bool.MinimumShouldMatch = 1
 	query.Script = &Script{
-		Lang: "painless",
-		Inline: `
-			    if (ctx._source.tags != null) {
-			        for (tag in params.tags) {
-			            ctx._source.tags.removeIf(entry -> entry == tag);
-			        }
-			    }
-			    if (ctx._source.evebox == null) {
-			        ctx._source.evebox = new HashMap();
-			    }
-			    if (ctx._source.evebox.history == null) {
-			        ctx._source.evebox.history = new ArrayList();
-			    }
-			    ctx._source.evebox.history.add(params.action);
-		`,
-		Params: map[string]interface{}{
-			"tags":   tags,
-			"action": action,
-		},
+		Lang:   "painless",
+		Inline: removeTagsScript,
+		Params: tagsScriptParams(tags, action),
 	}
 
-	response, err := s.es.doUpdateByQuery(query)
+	response, err := s.es.doUpdateByQuery(ctx, query)
 	if err != nil {
 		log.Error("failed to update by query: %v", err)
 		return err
@@ -381,9 +441,10 @@ func (s *DataStore) RemoveTagsFromAlertGroupsByQuery(p core.AlertGroupQueryParam
 	return nil
 }
 
-// RemoveTagsFromAlertGroup removes the given tags from all alerts matching
-// the provided parameters.
-func (s *DataStore) RemoveTagsFromAlertGroup(p core.AlertGroupQueryParams, tags []string) error {
+// removeTagsFromAlertGroupScroll removes the given tags from all alerts
+// matching the provided parameters, updating each document for Elastic
+// Search versions without update by query.
+func (s *DataStore) removeTagsFromAlertGroupScroll(ctx context.Context, p core.AlertGroupQueryParams, tags []string) error {
 
 	filter := []interface{}{
 		ExistsQuery("event_type"),
@@ -393,13 +454,11 @@ func (s *DataStore) RemoveTagsFromAlertGroup(p core.AlertGroupQueryParams, tags
 			Gte:   eve.FormatTimestampUTC(p.MinTimestamp),
 			Lte:   eve.FormatTimestampUTC(p.MaxTimestamp),
 		},
-		KeywordTermQuery("src_ip", p.SrcIP, s.es.keyword),
-		KeywordTermQuery("dest_ip", p.DstIP, s.es.keyword),
-		TermQuery("alert.signature_id", p.SignatureID),
 	}
+	filter = append(filter, s.alertGroupFilters(p)...)
 
 	for _, tag := range tags {
-		filter = append(filter, TermQuery("tags", tag))
+		filter = append(filter, s.es.TagQuery(tag))
 	}
 
 	query := map[string]interface{}{
@@ -415,14 +474,15 @@ func (s *DataStore) RemoveTagsFromAlertGroup(p core.AlertGroupQueryParams, tags
 		"size": 10000,
 	}
 
-	searchResponse, err := s.es.SearchScroll(query, "1m")
+	searchResponse, err := s.es.SearchScroll(ctx, query, "1m")
 	if err != nil {
 		log.Error("Failed to initialize scroll: %v", err)
 		return err
 	}
 	scrollID := searchResponse.ScrollId
 	defer func() {
-		response, err := s.es.DeleteScroll(scrollID)
+		// Clean up the scroll even if ctx was cancelled.
+		response, err := s.es.DeleteScroll(context.Background(), scrollID)
 		if err != nil {
 			log.Error("Failed to delete scroll id: %v", err)
 		}
@@ -443,7 +503,7 @@ func (s *DataStore) RemoveTagsFromAlertGroup(p core.AlertGroupQueryParams, tags
 		maxRetries := 5
 		retries := 0
 		for {
-			retry, err := BulkUpdateTags(s.es, searchResponse.Hits.Hits,
+			retry, err := BulkUpdateTags(ctx, s.es, searchResponse.Hits.Hits,
 				nil, tags)
 			if err != nil {
 				log.Error("BulkAddTags failed: %v", err)
@@ -460,7 +520,7 @@ func (s *DataStore) RemoveTagsFromAlertGroup(p core.AlertGroupQueryParams, tags
 		}
 
 		// Get next set of events to archive.
-		searchResponse, err = s.es.Scroll(scrollID, "1m")
+		searchResponse, err = s.es.Scroll(ctx, scrollID, "1m")
 		if err != nil {
 			log.Error("Failed to fetch from scroll: %v", err)
 			return err
@@ -473,72 +533,99 @@ func (s *DataStore) RemoveTagsFromAlertGroup(p core.AlertGroupQueryParams, tags
 	return nil
 }
 
-func (s *DataStore) CommentOnAlertGroup(p core.AlertGroupQueryParams, user core.User, comment string) error {
-	history := HistoryEntry{
+func (s *DataStore) CommentOnAlertGroup(ctx context.Context, p core.AlertGroupQueryParams, user core.User, comment string) error {
+	history := &HistoryEntry{
 		Username:  user.Username,
 		Action:    ACTION_COMMENT,
 		Comment:   comment,
 		Timestamp: FormatTimestampUTC(time.Now()),
 	}
-	return s.AddTagsToAlertGroupsByQuery(p, nil, history)
+	return s.AddTagsToAlertGroupsByQuery(ctx, p, nil, history)
+}
+
+func (s *DataStore) CommentOnEventId(ctx context.Context, eventId string, user core.User, comment string) error {
+	return s.updateEvent(ctx, eventId, nil, addTagsScript, HistoryEntry{
+		Username:  user.Username,
+		Action:    ACTION_COMMENT,
+		Comment:   comment,
+		Timestamp: FormatTimestampUTC(time.Now()),
+	})
 }
 
-func (s *DataStore) CommentOnEventId(eventId string, user core.User, comment string) error {
+// UpdateAlertsByQuery applies the action to all alerts matching the options
+// with a single update by query request.
+func (s *DataStore) UpdateAlertsByQuery(ctx context.Context, options core.AlertQueryOptions, action core.AlertAction, user core.User) (int64, error) {
+	if err := action.Validate(); err != nil {
+		return 0, err
+	}
+	if s.es.MajorVersion < 5 {
+		return 0, errors.New("updating alerts by query requires Elastic Search 5 or newer")
+	}
 
-	event, err := s.GetEventById(eventId)
+	query, err := s.buildAlertQuery(ctx, options)
 	if err != nil {
-		return errors.Wrapf(err, "failed to find event with ID %s", eventId)
+		return 0, err
 	}
-	doc := Document{event}
 
-	action := HistoryEntry{
+	history := &HistoryEntry{
 		Username:  user.Username,
-		Action:    ACTION_COMMENT,
-		Comment:   comment,
 		Timestamp: FormatTimestampUTC(time.Now()),
 	}
 
-	query := EventQuery{}
+	script := addTagsScript
+	var tags []string
+	switch action.Action {
+	case core.ALERT_ACTION_ARCHIVE:
+		tags = []string{"archived", "evebox.archived"}
+		history.Action = ACTION_ARCHIVED
+	case core.ALERT_ACTION_ESCALATE:
+		tags = []string{"escalated", "evebox.escalated"}
+		history.Action = ACTION_ESCALATED
+	case core.ALERT_ACTION_DEESCALATE:
+		tags = []string{"escalated", "evebox.escalated"}
+		history.Action = ACTION_DEESCALATED
+		script = removeTagsScript
+		query.AddFilter(s.es.TagQuery("escalated"))
+	case core.ALERT_ACTION_TAG:
+		tags = action.Tags
+		history.Action = ACTION_TAGGED
+		history.Tags = action.Tags
+	}
+
+	if script == addTagsScript {
+		// Skip events that already have all the tags.
+		hasTags := []interface{}{}
+		for _, tag := range tags {
+			hasTags = append(hasTags, s.es.TagQuery(tag))
+		}
+		query.MustNot(map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": hasTags,
+			},
+		})
+	}
+
 	query.Script = &Script{
-		Lang: "painless",
-		Inline: `
-			    if (ctx._source.evebox == null) {
-			        ctx._source.evebox = new HashMap();
-			    }
-			    if (ctx._source.evebox.history == null) {
-			        ctx._source.evebox.history = new ArrayList();
-			    }
-			    ctx._source.evebox.history.add(params.action);
-		`,
-		Params: map[string]interface{}{
-			"action": action,
-		},
+		Lang:   "painless",
+		Inline: script,
+		Params: tagsScriptParams(tags, history),
 	}
+	query.Sort = nil
+	query.Aggs = nil
 
-	//query := map[string]interface{}{
-	//	"script": &Script{
-	//		Lang: "painless",
-	//		Inline: `
-	//		    if (ctx._source.evebox == null) {
-	//		        ctx._source.evebox = new HashMap();
-	//		    }
-	//		    if (ctx._source.evebox.history == null) {
-	//		        ctx._source.evebox.history = new ArrayList();
-	//		    }
-	//		    ctx._source.evebox.history.add(params.action);
-	//	`,
-	//		Params: map[string]interface{}{
-	//			"action": action,
-	//		},
-	//	},
-	//}
-
-	log.Println(util.ToJson(query))
-
-	_, err = s.es.Update(doc.Index(), doc.Type(), doc.Id(), query)
+	response, err := s.es.doUpdateByQuery(ctx, query)
 	if err != nil {
-		log.Error("error: %v", err)
+		log.Error("failed to update by query: %v", err)
+		return 0, err
 	}
+	log.Info("Events updated: %v; failures=%d",
+		response.Get("updated"), len(response.GetMapList("failures")))
 
-	return errors.New("CommentOnEventId not implemented by Elastic Search datastore.")
+	switch updated := response.Get("updated").(type) {
+	case float64:
+		return int64(updated), nil
+	case json.Number:
+		return updated.Int64()
+	}
+	return 0, nil
 }
@@ -27,11 +27,14 @@
 package elasticsearch
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strings"
+	"sync/atomic"
 
 	"github.com/jasonish/evebox/httputil"
 
@@ -66,6 +69,11 @@ type ElasticSearch struct {
 	// Set to true if keyword checks should not be done.
 	noKeyword bool
 
+	// Set to 1 once all event indices have been found to map src_ip
+	// and dest_ip as ip, as required for network and range queries.
+	// Accessed atomically as queries are translated concurrently.
+	ipMapped int32
+
 	HttpClient *httputil.HttpClient
 }
 
@@ -219,6 +227,59 @@ func (es *ElasticSearch) GetKeywordType(index string) (string, error) {
 	return "", nil
 }
 
+// CheckIpMapping returns an error unless src_ip and dest_ip are mapped as
+// ip in all the event indices. Indices created before the EveBox template
+// mapped them as ip, or with a template that does not, will not match
+// network or range queries and need to be reindexed.
+func (es *ElasticSearch) CheckIpMapping(ctx context.Context) error {
+	if atomic.LoadInt32(&es.ipMapped) == 1 {
+		return nil
+	}
+
+	path := fmt.Sprintf("%s/_mapping/field/src_ip,dest_ip", es.EventSearchIndex)
+	response, err := es.HttpClient.WithContext(ctx).Get(path)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return DecodeResponseAsError(response)
+	}
+	indices := util.JsonMap{}
+	if err := es.Decode(response, &indices); err != nil {
+		return err
+	}
+
+	unmapped := []string{}
+	for index := range indices {
+		ok := true
+		mappings := indices.GetMap(index).GetMap("mappings")
+		for docType := range mappings {
+			fields := mappings.GetMap(docType)
+			for field := range fields {
+				fieldType := fields.GetMap(field).
+					GetMap("mapping").
+					GetMap(field).
+					GetString("type")
+				if fieldType != "ip" {
+					ok = false
+				}
+			}
+		}
+		if !ok {
+			unmapped = append(unmapped, index)
+		}
+	}
+	if len(unmapped) > 0 {
+		sort.Strings(unmapped)
+		return errors.Errorf("src_ip and dest_ip not mapped as ip in %s, "+
+			"reindex to use network and range queries",
+			strings.Join(unmapped, ", "))
+	}
+
+	atomic.StoreInt32(&es.ipMapped, 1)
+	return nil
+}
+
 func (es *ElasticSearch) SetKeyword(keyword string) {
 	if keyword == "" {
 		es.noKeyword = true
@@ -303,14 +364,14 @@ func (e *DatastoreError) Error() string {
 	return ""
 }
 
-func (es *ElasticSearch) Search(query interface{}) (*Response, error) {
+func (es *ElasticSearch) Search(ctx context.Context, query interface{}) (*Response, error) {
 	if es.keyword == "" && !es.noKeyword {
 		log.Warning("Search keyword not known, trying again.")
 		es.InitKeyword()
 	}
 
 	path := fmt.Sprintf("%s/_search", es.EventSearchIndex)
-	response, err := es.HttpClient.PostJson(path, query)
+	response, err := es.HttpClient.WithContext(ctx).PostJson(path, query)
 	if err != nil {
 		return nil, errors.WithStack(&DatastoreError{
 			Message: "Failed to connect to Elastic Search",
@@ -321,9 +382,9 @@ func (es *ElasticSearch) Search(query interface{}) (*Response, error) {
 	return DecodeResponse(response)
 }
 
-func (es *ElasticSearch) SearchScroll(body interface{}, duration string) (*Response, error) {
+func (es *ElasticSearch) SearchScroll(ctx context.Context, body interface{}, duration string) (*Response, error) {
 	path := fmt.Sprintf("%s/_search?scroll=%s", es.EventSearchIndex, duration)
-	response, err := es.HttpClient.PostJson(path, body)
+	response, err := es.HttpClient.WithContext(ctx).PostJson(path, body)
 	if err != nil {
 		return nil, err
 	}
@@ -336,12 +397,12 @@ func (es *ElasticSearch) SearchScroll(body interface{}, duration string) (*Respo
 	return DecodeResponse(response)
 }
 
-func (es *ElasticSearch) Scroll(scrollId string, duration string) (*Response, error) {
+func (es *ElasticSearch) Scroll(ctx context.Context, scrollId string, duration string) (*Response, error) {
 	body := map[string]interface{}{
 		"scroll_id": scrollId,
 		"scroll":    duration,
 	}
-	response, err := es.HttpClient.PostJson("_search/scroll", body)
+	response, err := es.HttpClient.WithContext(ctx).PostJson("_search/scroll", body)
 	if err != nil {
 		return nil, err
 	}
@@ -350,8 +411,8 @@ func (es *ElasticSearch) Scroll(scrollId string, duration string) (*Response, er
 	return DecodeResponse(response)
 }
 
-func (es *ElasticSearch) DeleteScroll(scrollId string) (*http.Response, error) {
-	return es.HttpClient.Delete("_search/scroll", "application/json",
+func (es *ElasticSearch) DeleteScroll(ctx context.Context, scrollId string) (*http.Response, error) {
+	return es.HttpClient.WithContext(ctx).Delete("_search/scroll", "application/json",
 		strings.NewReader(scrollId))
 }
 
@@ -375,9 +436,9 @@ func IsError(response *http.Response) error {
 	return errors.Errorf("%s %s", response.Status, string(body))
 }
 
-func (es *ElasticSearch) Update(index string, docType string, docId string,
+func (es *ElasticSearch) Update(ctx context.Context, index string, docType string, docId string,
 	body interface{}) (*Response, error) {
-	response, err := es.HttpClient.PostJson(fmt.Sprintf("%s/%s/%s/_update?refresh=true",
+	response, err := es.HttpClient.WithContext(ctx).PostJson(fmt.Sprintf("%s/%s/%s/_update?refresh=true",
 		index, docType, docId), body)
 	if err != nil {
 		return nil, errors.Wrap(err, "http request failed")
@@ -407,9 +468,14 @@ func (es *ElasticSearch) FormatKeyword(keyword string) string {
 	return fmt.Sprintf("%s.%s", keyword, es.keyword)
 }
 
-func (s *ElasticSearch) doUpdateByQuery(query interface{}) (util.JsonMap, error) {
+// TagQuery returns a term query matching events with the tag.
+func (es *ElasticSearch) TagQuery(tag string) map[string]interface{} {
+	return TermQuery(es.FormatKeyword("tags"), tag)
+}
+
+func (s *ElasticSearch) doUpdateByQuery(ctx context.Context, query interface{}) (util.JsonMap, error) {
 	var response util.JsonMap
-	err := s.HttpClient.PostJsonDecodeResponse(
+	err := s.HttpClient.WithContext(ctx).PostJsonDecodeResponse(
 		fmt.Sprintf("%s/_update_by_query?refresh=true&conflicts=proceed",
 			s.EventSearchIndex), query, &response)
 	if err != nil {
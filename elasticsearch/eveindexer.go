@@ -27,6 +27,7 @@
 package elasticsearch
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/jasonish/evebox/eve"
@@ -81,7 +82,7 @@ func (i *BulkEveIndexer) DecodeResponse(response *http.Response) (*Response, err
 	return DecodeResponse(response)
 }
 
-func (i *BulkEveIndexer) Submit(event eve.EveEvent) error {
+func (i *BulkEveIndexer) Submit(ctx context.Context, event eve.EveEvent) error {
 
 	timestamp := event.Timestamp()
 	event["@timestamp"] = timestamp.UTC().Format(AtTimestampFormat)
@@ -108,7 +109,7 @@ func (i *BulkEveIndexer) Submit(event eve.EveEvent) error {
 	return nil
 }
 
-func (i *BulkEveIndexer) Commit() (interface{}, error) {
+func (i *BulkEveIndexer) Commit(ctx context.Context) (interface{}, error) {
 
 	// Check if the template exists for the index before adding events.
 	// If not, try to install it.
@@ -138,7 +139,7 @@ func (i *BulkEveIndexer) Commit() (interface{}, error) {
 	templateCheckLock.Unlock()
 
 	if len(i.buf) > 0 {
-		response, err := i.es.HttpClient.PostBytes("_bulk",
+		response, err := i.es.HttpClient.WithContext(ctx).PostBytes("_bulk",
 			"application/json", i.buf)
 		if err != nil {
 			return nil, err
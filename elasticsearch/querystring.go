@@ -0,0 +1,279 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package elasticsearch
+
+import (
+	"context"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/querystring"
+	"github.com/pkg/errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Characters that must be escaped in a query_string query.
+var queryStringEscaper = strings.NewReplacer(
+	`\`, `\\`, `"`, `\"`,
+)
+
+// QueryStringFilter parses a query string and returns it as an
+// Elasticsearch query.
+func (es *ElasticSearch) QueryStringFilter(ctx context.Context, queryString string) (interface{}, error) {
+	node, err := querystring.Parse(queryString)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse query string")
+	}
+	if node == nil {
+		return map[string]interface{}{
+			"match_all": map[string]interface{}{},
+		}, nil
+	}
+	return es.translateQueryString(ctx, node)
+}
+
+func (es *ElasticSearch) translateQueryString(ctx context.Context, node querystring.Node) (interface{}, error) {
+	switch node := node.(type) {
+	case *querystring.And:
+		filters, err := es.translateQueryStringNodes(ctx, node.Nodes)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": filters,
+			},
+		}, nil
+	case *querystring.Or:
+		should, err := es.translateQueryStringNodes(ctx, node.Nodes)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should":               should,
+				"minimum_should_match": 1,
+			},
+		}, nil
+	case *querystring.Not:
+		query, err := es.translateQueryString(ctx, node.Node)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must_not": []interface{}{query},
+			},
+		}, nil
+	case *querystring.Term:
+		return es.queryStringTerm(ctx, node)
+	case *querystring.Range:
+		if querystring.IsAddressField(node.Field) {
+			addresses, err := node.AddressRange()
+			if err != nil {
+				return nil, err
+			}
+			return es.queryStringAddress(ctx, node.Field, "", addresses)
+		}
+		return es.queryStringRange(node), nil
+	}
+	return nil, errors.Errorf("unsupported query node: %v", node)
+}
+
+func (es *ElasticSearch) translateQueryStringNodes(ctx context.Context, nodes []querystring.Node) ([]interface{}, error) {
+	queries := []interface{}{}
+	for _, node := range nodes {
+		query, err := es.translateQueryString(ctx, node)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, query)
+	}
+	return queries, nil
+}
+
+func (es *ElasticSearch) queryStringTerm(ctx context.Context, term *querystring.Term) (interface{}, error) {
+	switch term.Field {
+	case "":
+		if term.Wildcard {
+			return QueryString(term.Value), nil
+		}
+		return QueryString(`"` + queryStringEscaper.Replace(term.Value) + `"`), nil
+	case "tags":
+		return es.TagQuery(term.Value), nil
+	case "is":
+		switch term.Value {
+		case core.TAG_ARCHIVED, core.TAG_ESCALATED:
+			return es.TagQuery(term.Value), nil
+		}
+		return nil, errors.Errorf("unsupported is: value: %s", term.Value)
+	case "was":
+		return TermQuery(es.FormatKeyword("evebox.history.action"), term.Value), nil
+	case "has":
+		if term.Value == "comment" {
+			return TermQuery(es.FormatKeyword("evebox.history.action"),
+				ACTION_COMMENT), nil
+		}
+		return nil, errors.Errorf("unsupported has: value: %s", term.Value)
+	case "comment":
+		return map[string]interface{}{
+			"match_phrase": map[string]interface{}{
+				"evebox.history.comment": term.Value,
+			},
+		}, nil
+	}
+
+	if querystring.IsAddressField(term.Field) && !term.Wildcard {
+		addresses, err := querystring.ParseAddressRange(term.Value)
+		if err != nil {
+			return nil, err
+		}
+		return es.queryStringAddress(ctx, term.Field, term.Value, addresses)
+	}
+
+	if term.Wildcard {
+		return map[string]interface{}{
+			"wildcard": map[string]interface{}{
+				es.queryStringField(term.Field): term.Value,
+			},
+		}, nil
+	}
+
+	// Numeric values are matched against the field itself as numeric
+	// fields have no keyword sub-field.
+	if number, ok := queryStringNumber(term.Value); ok {
+		return TermQuery(term.Field, number), nil
+	}
+
+	return TermQuery(es.queryStringField(term.Field), term.Value), nil
+}
+
+// queryStringNumber parses a numeric value. Integers are parsed as such so
+// large values, like a flow_id, are not rounded by a conversion to float.
+func queryStringNumber(value string) (interface{}, bool) {
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return i, true
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f, true
+	}
+	return nil, false
+}
+
+// AddressFilter returns a query for events where the source or destination
+// address is the address, or within the network or range of addresses.
+func (es *ElasticSearch) AddressFilter(ctx context.Context, address string) (interface{}, error) {
+	addresses, err := querystring.ParseAddressRange(address)
+	if err != nil {
+		return nil, err
+	}
+	return es.queryStringAddress(ctx, "ip", address, addresses)
+}
+
+// queryStringAddress matches an address field against a range of
+// addresses. Single addresses are matched on the keyword using the value
+// as given, networks and ranges require the field to be mapped as an ip
+// field as is done by the EveBox template.
+func (es *ElasticSearch) queryStringAddress(ctx context.Context, field string, value string, addresses *querystring.AddressRange) (interface{}, error) {
+	if !addresses.IsSingle() {
+		if err := es.CheckIpMapping(ctx); err != nil {
+			return nil, err
+		}
+	} else if net.ParseIP(value) == nil {
+		// A network or range of a single address.
+		value = addresses.Start.String()
+	}
+	should := []interface{}{}
+	for _, field := range querystring.AddressFields(field) {
+		if addresses.IsSingle() {
+			should = append(should, TermQuery(es.FormatKeyword(field), value))
+		} else if addresses.Network != nil {
+			should = append(should, TermQuery(field,
+				addresses.Network.String()))
+		} else {
+			should = append(should, map[string]interface{}{
+				"range": map[string]interface{}{
+					field: map[string]interface{}{
+						"gte": addresses.Start.String(),
+						"lte": addresses.End.String(),
+					},
+				},
+			})
+		}
+	}
+	if len(should) == 1 {
+		return should[0], nil
+	}
+	return map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should":               should,
+			"minimum_should_match": 1,
+		},
+	}, nil
+}
+
+func (es *ElasticSearch) queryStringRange(r *querystring.Range) interface{} {
+	bounds := map[string]interface{}{}
+	value := func(v string) interface{} {
+		if number, ok := queryStringNumber(v); ok {
+			return number
+		}
+		return v
+	}
+	if r.Min != "" {
+		if r.MinInclusive {
+			bounds["gte"] = value(r.Min)
+		} else {
+			bounds["gt"] = value(r.Min)
+		}
+	}
+	if r.Max != "" {
+		if r.MaxInclusive {
+			bounds["lte"] = value(r.Max)
+		} else {
+			bounds["lt"] = value(r.Max)
+		}
+	}
+	if len(bounds) == 0 {
+		return ExistsQuery(r.Field)
+	}
+	return map[string]interface{}{
+		"range": map[string]interface{}{
+			r.Field: bounds,
+		},
+	}
+}
+
+// queryStringField returns the field to match a string value against,
+// which is the keyword sub-field for all but the timestamp fields.
+func (es *ElasticSearch) queryStringField(field string) string {
+	switch field {
+	case "@timestamp", "timestamp":
+		return field
+	}
+	return es.FormatKeyword(field)
+}
@@ -0,0 +1,136 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package elasticsearch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/jasonish/evebox/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMappingServer(t *testing.T, srcType string) (*ElasticSearch, *httptest.Server) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/logstash-*/_mapping/field/src_ip,dest_ip", r.URL.Path)
+		fmt.Fprintf(w, `{
+			"logstash-2017.07.01": {"mappings": {"log": {
+				"src_ip": {"full_name": "src_ip", "mapping": {"src_ip": {"type": "%s"}}},
+				"dest_ip": {"full_name": "dest_ip", "mapping": {"dest_ip": {"type": "ip"}}}
+			}}}
+		}`, srcType)
+	}))
+	es := New(server.URL)
+	es.SetEventIndex("logstash")
+	es.SetKeyword("keyword")
+	return es, server
+}
+
+func TestQueryStringAddress(t *testing.T) {
+	es, server := newMappingServer(t, "ip")
+	defer server.Close()
+
+	// Single addresses are matched on the keyword as given.
+	query, err := es.QueryStringFilter(context.Background(), "src_ip:2001:DB8::1")
+	require.Nil(t, err)
+	assert.Equal(t, "2001:DB8::1",
+		util.JsonMap(query.(map[string]interface{})).
+			GetMap("term").Get("src_ip.keyword"))
+
+	query, err = es.QueryStringFilter(context.Background(), "src_ip:10.0.0.1/32")
+	require.Nil(t, err)
+	assert.Equal(t, "10.0.0.1",
+		util.JsonMap(query.(map[string]interface{})).
+			GetMap("term").Get("src_ip.keyword"))
+
+	query, err = es.QueryStringFilter(context.Background(), "src_ip:10.0.0.0/8")
+	require.Nil(t, err)
+	assert.Equal(t, "10.0.0.0/8",
+		util.JsonMap(query.(map[string]interface{})).
+			GetMap("term").Get("src_ip"))
+}
+
+func TestQueryStringAddressNotMapped(t *testing.T) {
+	es, server := newMappingServer(t, "string")
+	defer server.Close()
+
+	_, err := es.QueryStringFilter(context.Background(), "src_ip:10.0.0.0/8")
+	assert.NotNil(t, err)
+	_, err = es.AddressFilter(context.Background(), "10.0.0.1-10.0.0.50")
+	assert.NotNil(t, err)
+
+	// Single addresses don't need the ip mapping.
+	_, err = es.AddressFilter(context.Background(), "10.0.0.1")
+	assert.Nil(t, err)
+}
+
+func TestCheckIpMapping(t *testing.T) {
+	es, server := newMappingServer(t, "ip")
+	defer server.Close()
+
+	// The mapping check uses the context of the query.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := es.QueryStringFilter(ctx, "src_ip:10.0.0.0/8")
+	assert.NotNil(t, err)
+
+	// And may be done by concurrent queries.
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := es.QueryStringFilter(context.Background(), "src_ip:10.0.0.0/8")
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestQueryStringNumber(t *testing.T) {
+	es := New("http://localhost:9200")
+	es.SetKeyword("keyword")
+
+	// Large integers must not be rounded by a conversion to float.
+	query, err := es.QueryStringFilter(context.Background(), "flow_id:1234567890123456789")
+	require.Nil(t, err)
+	assert.Equal(t, `{"term":{"flow_id":1234567890123456789}}`, util.ToJson(query))
+
+	query, err = es.QueryStringFilter(context.Background(), "flow_id:[1234567890123456789 TO *]")
+	require.Nil(t, err)
+	assert.Equal(t, `{"range":{"flow_id":{"gte":1234567890123456789}}}`,
+		util.ToJson(query))
+
+	query, err = es.QueryStringFilter(context.Background(), "alert.severity:1.5")
+	require.Nil(t, err)
+	assert.Equal(t, `{"term":{"alert.severity":1.5}}`, util.ToJson(query))
+}
@@ -72,6 +72,9 @@ type EventQuery struct {
 	Size   int64                  `json:"size,omitempty"`
 	Sort   []interface{}          `json:"sort,omitempty"`
 	Aggs   map[string]interface{} `json:"aggs,omitempty"`
+
+	// Sort values of the hit to continue the search after.
+	SearchAfter []interface{} `json:"search_after,omitempty"`
 }
 
 func NewEventQuery() EventQuery {
@@ -27,11 +27,13 @@
 package elasticsearch
 
 import (
+	"context"
 	"fmt"
 	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/log"
 	"github.com/jasonish/evebox/util"
 	"github.com/pkg/errors"
+	"strings"
 	"time"
 )
 
@@ -46,7 +48,7 @@ func NewReportService(es *ElasticSearch) *ReportService {
 }
 
 // ReportDnsRequestRrnames returns the top requests rrnames.
-func (s *ReportService) ReportDnsRequestRrnames(options core.ReportOptions) (interface{}, error) {
+func (s *ReportService) ReportDnsRequestRrnames(ctx context.Context, options core.ReportOptions) (interface{}, error) {
 
 	size := int64(10)
 
@@ -61,7 +63,11 @@ func (s *ReportService) ReportDnsRequestRrnames(options core.ReportOptions) (int
 	}
 
 	if options.QueryString != "" {
-		query.AddFilter(QueryString(options.QueryString))
+		filter, err := s.es.QueryStringFilter(ctx, options.QueryString)
+		if err != nil {
+			return nil, err
+		}
+		query.AddFilter(filter)
 	}
 
 	if options.Size > 0 {
@@ -76,7 +82,7 @@ func (s *ReportService) ReportDnsRequestRrnames(options core.ReportOptions) (int
 	}
 	query.Aggs["topRrnames"] = agg
 
-	response, err := s.es.Search(query)
+	response, err := s.es.Search(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -95,16 +101,28 @@ func (s *ReportService) ReportDnsRequestRrnames(options core.ReportOptions) (int
 	return data, nil
 }
 
-func (s *ReportService) ReportHistogram(interval string, options core.ReportOptions) (interface{}, error) {
+func (s *ReportService) ReportHistogram(ctx context.Context, interval string, options core.ReportOptions) (interface{}, error) {
 
 	query := NewEventQuery()
 
 	if options.AddressFilter != "" {
-		query.ShouldHaveIp(options.AddressFilter, s.es.keyword)
+		if strings.HasSuffix(options.AddressFilter, ".") {
+			query.ShouldHaveIp(options.AddressFilter, s.es.keyword)
+		} else {
+			filter, err := s.es.AddressFilter(ctx, options.AddressFilter)
+			if err != nil {
+				return nil, err
+			}
+			query.AddFilter(filter)
+		}
 	}
 
 	if options.QueryString != "" {
-		query.AddFilter(QueryString(options.QueryString))
+		filter, err := s.es.QueryStringFilter(ctx, options.QueryString)
+		if err != nil {
+			return nil, err
+		}
+		query.AddFilter(filter)
 	}
 
 	if options.TimeRange != "" {
@@ -142,7 +160,7 @@ func (s *ReportService) ReportHistogram(interval string, options core.ReportOpti
 		}
 	}
 
-	response, err := s.es.Search(query)
+	response, err := s.es.Search(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -163,7 +181,7 @@ func (s *ReportService) ReportHistogram(interval string, options core.ReportOpti
 	}, nil
 }
 
-func (s *ReportService) ReportAggs(agg string, options core.ReportOptions) (interface{}, error) {
+func (s *ReportService) ReportAggs(ctx context.Context, agg string, options core.ReportOptions) (interface{}, error) {
 
 	size := int64(10)
 
@@ -180,11 +198,23 @@ func (s *ReportService) ReportAggs(agg string, options core.ReportOptions) (inte
 	}
 
 	if options.QueryString != "" {
-		query.AddFilter(QueryString(options.QueryString))
+		filter, err := s.es.QueryStringFilter(ctx, options.QueryString)
+		if err != nil {
+			return nil, err
+		}
+		query.AddFilter(filter)
 	}
 
 	if options.AddressFilter != "" {
-		query.ShouldHaveIp(options.AddressFilter, s.es.keyword)
+		if strings.HasSuffix(options.AddressFilter, ".") {
+			query.ShouldHaveIp(options.AddressFilter, s.es.keyword)
+		} else {
+			filter, err := s.es.AddressFilter(ctx, options.AddressFilter)
+			if err != nil {
+				return nil, err
+			}
+			query.AddFilter(filter)
+		}
 	}
 
 	if options.TimeRange != "" {
@@ -242,7 +272,7 @@ func (s *ReportService) ReportAggs(agg string, options core.ReportOptions) (inte
 		}
 	}
 
-	response, err := s.es.Search(query)
+	response, err := s.es.Search(ctx, query)
 	if err != nil {
 		return nil, err
 	}
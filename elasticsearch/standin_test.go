@@ -0,0 +1,1539 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package elasticsearch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/jasonish/evebox/eve"
+)
+
+// standIn is an in-memory stand-in for Elastic Search 5 implementing the
+// parts of the API EveBox uses, so the conformance suite can run without
+// a cluster. It is an emulation written from the Elastic Search
+// documentation, results against it say nothing about how a real Elastic
+// Search behaves.
+//
+// Fields are mapped from the installed template, then dynamically as
+// Elastic Search does: strings that parse as a date are dates, other
+// strings are text with a keyword sub-field. Text is analyzed into
+// lower case tokens, so term queries against text fields only match
+// single lower case words as they would on Elastic Search.
+//
+// Painless scripts are not interpreted. Instead the tag and history
+// updates made by the EveBox scripts are applied based on what the
+// script refers to.
+type standIn struct {
+	lock      sync.Mutex
+	templates map[string]map[string]interface{}
+	indices   map[string]*standInIndex
+	scrolls   map[string]*standInScroll
+	scrollId  int
+	sequence  int
+}
+
+type standInField struct {
+	// The mapped type: text, keyword, date, long, float, boolean or ip.
+	Type string
+
+	// The path of the field in the source, differs from the field name
+	// for sub-fields like src_ip.keyword.
+	source string
+}
+
+type standInIndex struct {
+	name    string
+	mapping map[string]standInField
+	docs    map[string]*standInDoc
+}
+
+type standInDoc struct {
+	index    *standInIndex
+	docType  string
+	id       string
+	source   map[string]interface{}
+	sequence int
+}
+
+// standInScroll holds the remaining documents of a scroll.
+type standInScroll struct {
+	docs  []*standInDoc
+	sorts []standInSort
+	size  int
+	total int
+}
+
+// standInError is returned as an Elastic Search error response.
+type standInError struct {
+	status int
+	reason string
+}
+
+func (e *standInError) Error() string {
+	return e.reason
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &standInError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &standInError{http.StatusNotFound, fmt.Sprintf(format, args...)}
+}
+
+func newStandIn() *standIn {
+	return &standIn{
+		templates: map[string]map[string]interface{}{},
+		indices:   map[string]*standInIndex{},
+		scrolls:   map[string]*standInScroll{},
+	}
+}
+
+func (s *standIn) start() *httptest.Server {
+	return httptest.NewServer(s)
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := s.handle(r, body)
+	w.Header().Set("content-type", "application/json")
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err, ok := err.(*standInError); ok {
+			status = err.status
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": map[string]interface{}{
+				"type":   "stand_in_exception",
+				"reason": err.Error(),
+			},
+			"status": status,
+		})
+		return
+	}
+	if response == nil {
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *standIn) handle(r *http.Request, body []byte) (interface{}, error) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] == "" {
+		parts = nil
+	}
+
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		return map[string]interface{}{
+			"cluster_name": "evebox-stand-in",
+			"version": map[string]interface{}{
+				"number": "5.6.0",
+			},
+		}, nil
+	case len(parts) == 2 && parts[0] == "_template":
+		return s.handleTemplate(r.Method, parts[1], body)
+	case len(parts) == 1 && parts[0] == "_bulk" && r.Method == http.MethodPost:
+		return s.bulk(body)
+	case len(parts) == 1 && parts[0] == "_refresh":
+		return map[string]interface{}{}, nil
+	case len(parts) == 2 && parts[0] == "_search" && parts[1] == "scroll":
+		return s.handleScroll(r.Method, body)
+	case len(parts) == 2 && parts[1] == "_search" && r.Method == http.MethodPost:
+		return s.search(parts[0], r.URL.Query().Get("scroll") != "", body)
+	case len(parts) == 2 && parts[1] == "_update_by_query" && r.Method == http.MethodPost:
+		return s.updateByQuery(parts[0], body)
+	case len(parts) == 4 && parts[1] == "_mapping" && parts[2] == "field":
+		return s.fieldMapping(parts[0], strings.Split(parts[3], ",")), nil
+	case len(parts) == 4 && parts[3] == "_update" && r.Method == http.MethodPost:
+		return s.update(parts[0], parts[1], parts[2], body)
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		for _, index := range s.matchIndices(parts[0]) {
+			delete(s.indices, index.name)
+		}
+		return map[string]interface{}{"acknowledged": true}, nil
+	}
+
+	return nil, badRequest("unsupported request: %s %s", r.Method, r.URL.Path)
+}
+
+func decodeJson(body []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return badRequest("failed to parse request: %v", err)
+	}
+	return nil
+}
+
+func (s *standIn) handleTemplate(method string, name string, body []byte) (interface{}, error) {
+	switch method {
+	case http.MethodHead, http.MethodGet:
+		template, ok := s.templates[name]
+		if !ok {
+			return nil, notFound("template %s not found", name)
+		}
+		if method == http.MethodHead {
+			return nil, nil
+		}
+		return map[string]interface{}{name: template}, nil
+	case http.MethodPut:
+		template := map[string]interface{}{}
+		if err := decodeJson(body, &template); err != nil {
+			return nil, err
+		}
+		s.templates[name] = template
+		return map[string]interface{}{"acknowledged": true}, nil
+	case http.MethodDelete:
+		delete(s.templates, name)
+		return map[string]interface{}{"acknowledged": true}, nil
+	}
+	return nil, badRequest("unsupported template request: %s", method)
+}
+
+// matchIndices returns the indices matching a comma separated list of
+// index names or wildcard patterns.
+func (s *standIn) matchIndices(patterns string) []*standInIndex {
+	indices := []*standInIndex{}
+	for _, index := range s.indices {
+		for _, pattern := range strings.Split(patterns, ",") {
+			if ok, _ := path.Match(pattern, index.name); ok {
+				indices = append(indices, index)
+				break
+			}
+		}
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		return indices[i].name < indices[j].name
+	})
+	return indices
+}
+
+// getIndex returns the named index, creating it with the mapping of the
+// matching templates if it doesn't exist.
+func (s *standIn) getIndex(name string) *standInIndex {
+	if index, ok := s.indices[name]; ok {
+		return index
+	}
+	index := &standInIndex{
+		name:    name,
+		mapping: map[string]standInField{},
+		docs:    map[string]*standInDoc{},
+	}
+	for _, template := range s.templates {
+		pattern, _ := template["template"].(string)
+		if ok, _ := path.Match(pattern, name); !ok {
+			continue
+		}
+		mappings, _ := template["mappings"].(map[string]interface{})
+		for _, mapping := range mappings {
+			mapping, _ := mapping.(map[string]interface{})
+			properties, _ := mapping["properties"].(map[string]interface{})
+			index.addProperties("", properties)
+		}
+	}
+	s.indices[name] = index
+	return index
+}
+
+func (i *standInIndex) addProperties(prefix string, properties map[string]interface{}) {
+	for name, property := range properties {
+		property, _ := property.(map[string]interface{})
+		field := prefix + name
+		if properties, ok := property["properties"].(map[string]interface{}); ok {
+			i.addProperties(field+".", properties)
+			continue
+		}
+		if fieldType, ok := property["type"].(string); ok {
+			i.mapping[field] = standInField{Type: fieldType, source: field}
+		}
+		fields, _ := property["fields"].(map[string]interface{})
+		for sub, subProperty := range fields {
+			subProperty, _ := subProperty.(map[string]interface{})
+			if fieldType, ok := subProperty["type"].(string); ok {
+				i.mapping[field+"."+sub] = standInField{Type: fieldType, source: field}
+			}
+		}
+	}
+}
+
+// mapDynamic adds mappings for the fields of a document not already
+// mapped.
+func (i *standInIndex) mapDynamic(prefix string, value interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			i.mapDynamic(prefix+key+".", child)
+		}
+		return
+	case []interface{}:
+		for _, child := range value {
+			i.mapDynamic(prefix, child)
+		}
+		return
+	case nil:
+		return
+	}
+
+	field := strings.TrimSuffix(prefix, ".")
+	if _, ok := i.mapping[field]; ok {
+		return
+	}
+	switch value := value.(type) {
+	case string:
+		if _, ok := parseStandInDate(value); ok {
+			i.mapping[field] = standInField{Type: "date", source: field}
+		} else {
+			i.mapping[field] = standInField{Type: "text", source: field}
+			i.mapping[field+".keyword"] = standInField{Type: "keyword", source: field}
+		}
+	case json.Number:
+		if _, err := value.Int64(); err == nil {
+			i.mapping[field] = standInField{Type: "long", source: field}
+		} else {
+			i.mapping[field] = standInField{Type: "float", source: field}
+		}
+	case bool:
+		i.mapping[field] = standInField{Type: "boolean", source: field}
+	}
+}
+
+func parseStandInDate(value interface{}) (int64, bool) {
+	switch value := value.(type) {
+	case json.Number:
+		millis, err := value.Int64()
+		return millis, err == nil
+	case string:
+		for _, layout := range []string{time.RFC3339Nano,
+			eve.EveTimestampFormat, "2006-01-02"} {
+			if ts, err := time.Parse(layout, value); err == nil {
+				return ts.UnixNano() / int64(time.Millisecond), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// sourceValues returns the values at the dotted path of the source,
+// flattening arrays.
+func sourceValues(value interface{}, path string) []interface{} {
+	for path != "" {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			break
+		}
+		key := path
+		if i := strings.Index(path, "."); i > -1 {
+			key, path = path[:i], path[i+1:]
+		} else {
+			path = ""
+		}
+		value, ok = object[key]
+		if !ok {
+			return nil
+		}
+		if list, ok := value.([]interface{}); ok && path != "" {
+			values := []interface{}{}
+			for _, item := range list {
+				values = append(values, sourceValues(item, path)...)
+			}
+			return values
+		}
+	}
+	switch value := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		values := []interface{}{}
+		for _, item := range value {
+			if item != nil {
+				values = append(values, item)
+			}
+		}
+		return values
+	case map[string]interface{}:
+		return nil
+	}
+	return []interface{}{value}
+}
+
+// fieldValues returns the mapping and values of a field in a document.
+func (d *standInDoc) fieldValues(field string) (standInField, []interface{}) {
+	switch field {
+	case "_id":
+		return standInField{Type: "keyword"}, []interface{}{d.id}
+	case "_uid":
+		return standInField{Type: "keyword"}, []interface{}{d.docType + "#" + d.id}
+	case "_index":
+		return standInField{Type: "keyword"}, []interface{}{d.index.name}
+	}
+	mapping, ok := d.index.mapping[field]
+	if !ok {
+		return mapping, nil
+	}
+	return mapping, sourceValues(d.source, mapping.source)
+}
+
+func toString(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case json.Number:
+		f, err := value.Float64()
+		return f, err == nil
+	case float64:
+		return value, true
+	case string:
+		f, err := strconv.ParseFloat(value, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toIp(value interface{}) net.IP {
+	ip := net.ParseIP(toString(value))
+	if ip == nil {
+		return nil
+	}
+	return ip.To16()
+}
+
+// analyze splits text into lower case tokens as the standard analyzer
+// would for the kind of values found in eve events.
+func analyze(text string) []string {
+	tokens := []string{}
+	for _, token := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '.'
+	}) {
+		token = strings.Trim(token, ".")
+		if token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// compareValues compares a document value to a query value as the type
+// of the field, returning false if they are not comparable.
+func compareValues(fieldType string, docValue interface{}, queryValue interface{}) (int, bool) {
+	switch fieldType {
+	case "long", "integer", "short", "byte", "float", "half_float", "double":
+		a, ok := toFloat(docValue)
+		b, ok2 := toFloat(queryValue)
+		if !ok || !ok2 {
+			return 0, false
+		}
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, true
+	case "date":
+		a, ok := parseStandInDate(docValue)
+		b, ok2 := parseStandInDate(queryValue)
+		if !ok || !ok2 {
+			return 0, false
+		}
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, true
+	case "ip":
+		a := toIp(docValue)
+		b := toIp(queryValue)
+		if a == nil || b == nil {
+			return 0, false
+		}
+		return bytes.Compare(a, b), true
+	}
+	return strings.Compare(toString(docValue), toString(queryValue)), true
+}
+
+// termValues returns the indexed terms of a value for text fields, or the
+// value itself for other fields.
+func termValues(fieldType string, values []interface{}) []interface{} {
+	if fieldType != "text" {
+		return values
+	}
+	terms := []interface{}{}
+	for _, value := range values {
+		for _, token := range analyze(toString(value)) {
+			terms = append(terms, token)
+		}
+	}
+	return terms
+}
+
+func termMatches(field standInField, values []interface{}, queryValue interface{}) bool {
+	if field.Type == "ip" {
+		if _, network, err := net.ParseCIDR(toString(queryValue)); err == nil {
+			for _, value := range values {
+				if ip := net.ParseIP(toString(value)); ip != nil && network.Contains(ip) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	for _, value := range termValues(field.Type, values) {
+		if c, ok := compareValues(field.Type, value, queryValue); ok && c == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// singleEntry returns the key and value of a single entry object as used
+// in the query DSL.
+func singleEntry(v interface{}) (string, interface{}, error) {
+	object, ok := v.(map[string]interface{})
+	if !ok || len(object) != 1 {
+		return "", nil, badRequest("expected an object with a single entry: %v", v)
+	}
+	for key, value := range object {
+		return key, value, nil
+	}
+	return "", nil, nil
+}
+
+func asList(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return v
+	}
+	return []interface{}{v}
+}
+
+func (d *standInDoc) matches(query interface{}) (bool, error) {
+	if query == nil {
+		return true, nil
+	}
+	queryType, body, err := singleEntry(query)
+	if err != nil {
+		return false, err
+	}
+	params, _ := body.(map[string]interface{})
+
+	switch queryType {
+	case "match_all":
+		return true, nil
+	case "bool":
+		return d.matchesBool(params)
+	case "term":
+		field, value, err := singleEntry(params)
+		if err != nil {
+			return false, err
+		}
+		if object, ok := value.(map[string]interface{}); ok {
+			value = object["value"]
+		}
+		mapping, values := d.fieldValues(field)
+		return termMatches(mapping, values, value), nil
+	case "terms":
+		field, list, err := singleEntry(params)
+		if err != nil {
+			return false, err
+		}
+		mapping, values := d.fieldValues(field)
+		for _, value := range asList(list) {
+			if termMatches(mapping, values, value) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "exists":
+		_, values := d.fieldValues(toString(params["field"]))
+		return len(values) > 0, nil
+	case "range":
+		field, bounds, err := singleEntry(params)
+		if err != nil {
+			return false, err
+		}
+		return d.matchesRange(field, bounds)
+	case "prefix", "wildcard":
+		field, value, err := singleEntry(params)
+		if err != nil {
+			return false, err
+		}
+		if object, ok := value.(map[string]interface{}); ok {
+			value = object["value"]
+		}
+		pattern := toString(value)
+		if queryType == "prefix" {
+			pattern = strings.NewReplacer(`*`, `\*`, `?`, `\?`, `[`, `\[`,
+				`\`, `\\`).Replace(pattern) + "*"
+		}
+		mapping, values := d.fieldValues(field)
+		for _, value := range termValues(mapping.Type, values) {
+			if ok, _ := path.Match(pattern, toString(value)); ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "match_phrase":
+		field, value, err := singleEntry(params)
+		if err != nil {
+			return false, err
+		}
+		_, values := d.fieldValues(field)
+		return phraseMatches(values, toString(value)), nil
+	case "query_string":
+		return d.matchesQueryString(toString(params["query"]))
+	}
+
+	return false, badRequest("unsupported query: %s", queryType)
+}
+
+func (d *standInDoc) matchesBool(params map[string]interface{}) (bool, error) {
+	required := append(asList(params["filter"]), asList(params["must"])...)
+	for _, query := range required {
+		if ok, err := d.matches(query); err != nil || !ok {
+			return false, err
+		}
+	}
+	for _, query := range asList(params["must_not"]) {
+		if ok, err := d.matches(query); err != nil || ok {
+			return false, err
+		}
+	}
+
+	should := asList(params["should"])
+	minimumShouldMatch := 0
+	if len(required) == 0 && len(should) > 0 {
+		minimumShouldMatch = 1
+	}
+	if value, ok := params["minimum_should_match"]; ok {
+		if f, ok := toFloat(value); ok {
+			minimumShouldMatch = int(f)
+		}
+	}
+	matched := 0
+	for _, query := range should {
+		ok, err := d.matches(query)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			matched++
+		}
+	}
+	return matched >= minimumShouldMatch, nil
+}
+
+func (d *standInDoc) matchesRange(field string, bounds interface{}) (bool, error) {
+	object, ok := bounds.(map[string]interface{})
+	if !ok {
+		return false, badRequest("invalid range on %s", field)
+	}
+	mapping, values := d.fieldValues(field)
+	for _, value := range termValues(mapping.Type, values) {
+		matched := true
+		for op, bound := range object {
+			c, ok := compareValues(mapping.Type, value, bound)
+			if !ok {
+				matched = false
+				break
+			}
+			switch op {
+			case "gt":
+				matched = c > 0
+			case "gte":
+				matched = c >= 0
+			case "lt":
+				matched = c < 0
+			case "lte":
+				matched = c <= 0
+			case "format", "time_zone":
+			default:
+				return false, badRequest("unsupported range parameter: %s", op)
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// phraseMatches returns true if the tokens of the phrase are found in
+// order in one of the values.
+func phraseMatches(values []interface{}, phrase string) bool {
+	want := analyze(phrase)
+	if len(want) == 0 {
+		return false
+	}
+	for _, value := range values {
+		tokens := analyze(toString(value))
+		for i := 0; i+len(want) <= len(tokens); i++ {
+			found := true
+			for j := range want {
+				if tokens[i+j] != want[j] {
+					found = false
+					break
+				}
+			}
+			if found {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesQueryString supports the query strings EveBox sends, a single
+// quoted phrase or a single word with wildcards, matched against all
+// values of the event as with the _all field.
+func (d *standInDoc) matchesQueryString(query string) (bool, error) {
+	values := []interface{}{}
+	collectValues(d.source, &values)
+
+	if len(query) > 1 && strings.HasPrefix(query, `"`) && strings.HasSuffix(query, `"`) {
+		phrase := strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(query[1 : len(query)-1])
+		return phraseMatches(values, phrase), nil
+	}
+	if strings.ContainsAny(query, " \t\"():") {
+		return false, badRequest("unsupported query string: %s", query)
+	}
+	pattern := strings.ToLower(query)
+	for _, value := range termValues("text", values) {
+		if ok, _ := path.Match(pattern, toString(value)); ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func collectValues(value interface{}, values *[]interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for _, child := range value {
+			collectValues(child, values)
+		}
+	case []interface{}:
+		for _, child := range value {
+			collectValues(child, values)
+		}
+	case nil:
+	default:
+		*values = append(*values, value)
+	}
+}
+
+// standInSort is a parsed sort specification.
+type standInSort struct {
+	field      string
+	descending bool
+}
+
+func parseSort(spec interface{}) ([]standInSort, error) {
+	sorts := []standInSort{}
+	for _, entry := range asList(spec) {
+		if field, ok := entry.(string); ok {
+			sorts = append(sorts, standInSort{field: field})
+			continue
+		}
+		field, options, err := singleEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		order := toString(options)
+		if object, ok := options.(map[string]interface{}); ok {
+			order, _ = object["order"].(string)
+		}
+		sorts = append(sorts, standInSort{field: field, descending: order == "desc"})
+	}
+	return sorts, nil
+}
+
+// sortValue returns the value a document is sorted on for a field, nil if
+// missing.
+func (d *standInDoc) sortValue(field string) interface{} {
+	if field == "_doc" {
+		return int64(d.sequence)
+	}
+	mapping, values := d.fieldValues(field)
+	if len(values) == 0 {
+		return nil
+	}
+	switch mapping.Type {
+	case "date":
+		millis, _ := parseStandInDate(values[0])
+		return millis
+	case "long", "integer", "short", "byte", "float", "half_float", "double":
+		f, _ := toFloat(values[0])
+		return f
+	case "text":
+		return nil
+	}
+	return toString(values[0])
+}
+
+// compareSortValues compares two sort values, with missing values last.
+func compareSortValues(a interface{}, b interface{}, descending bool) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return 1
+		}
+		return -1
+	}
+	c := 0
+	switch a := a.(type) {
+	case int64:
+		bv, _ := toFloat(json.Number(toString(b)))
+		switch {
+		case float64(a) < bv:
+			c = -1
+		case float64(a) > bv:
+			c = 1
+		}
+	case float64:
+		bv, _ := toFloat(json.Number(toString(b)))
+		switch {
+		case a < bv:
+			c = -1
+		case a > bv:
+			c = 1
+		}
+	default:
+		c = strings.Compare(toString(a), toString(b))
+	}
+	if descending {
+		return -c
+	}
+	return c
+}
+
+func sortDocs(docs []*standInDoc, sorts []standInSort) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, s := range sorts {
+			c := compareSortValues(docs[i].sortValue(s.field),
+				docs[j].sortValue(s.field), s.descending)
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return docs[i].sequence < docs[j].sequence
+	})
+}
+
+func (d *standInDoc) hit(sorts []standInSort) map[string]interface{} {
+	hit := map[string]interface{}{
+		"_index":  d.index.name,
+		"_type":   d.docType,
+		"_id":     d.id,
+		"_score":  nil,
+		"_source": d.source,
+	}
+	if len(sorts) > 0 {
+		values := []interface{}{}
+		for _, s := range sorts {
+			values = append(values, d.sortValue(s.field))
+		}
+		hit["sort"] = values
+	}
+	return hit
+}
+
+func hitList(docs []*standInDoc, sorts []standInSort, total int) map[string]interface{} {
+	hits := []interface{}{}
+	for _, doc := range docs {
+		hits = append(hits, doc.hit(sorts))
+	}
+	return map[string]interface{}{
+		"total":     total,
+		"max_score": nil,
+		"hits":      hits,
+	}
+}
+
+// query returns the documents in the indices matching the query.
+func (s *standIn) query(indices string, query interface{}) ([]*standInDoc, error) {
+	docs := []*standInDoc{}
+	for _, index := range s.matchIndices(indices) {
+		for _, doc := range index.docs {
+			ok, err := doc.matches(query)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				docs = append(docs, doc)
+			}
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].sequence < docs[j].sequence
+	})
+	return docs, nil
+}
+
+func (s *standIn) search(indices string, scroll bool, body []byte) (interface{}, error) {
+	request := map[string]interface{}{}
+	if err := decodeJson(body, &request); err != nil {
+		return nil, err
+	}
+
+	docs, err := s.query(indices, request["query"])
+	if err != nil {
+		return nil, err
+	}
+	total := len(docs)
+
+	if err := s.checkSort(indices, request["sort"]); err != nil {
+		return nil, err
+	}
+	sorts, err := parseSort(request["sort"])
+	if err != nil {
+		return nil, err
+	}
+	sortDocs(docs, sorts)
+
+	if searchAfter := asList(request["search_after"]); len(searchAfter) > 0 {
+		if len(searchAfter) != len(sorts) {
+			return nil, badRequest("search_after has %d values, sort has %d",
+				len(searchAfter), len(sorts))
+		}
+		after := []*standInDoc{}
+		for _, doc := range docs {
+			for i, s := range sorts {
+				c := compareSortValues(doc.sortValue(s.field), searchAfter[i],
+					s.descending)
+				if c > 0 {
+					after = append(after, doc)
+				}
+				if c != 0 {
+					break
+				}
+			}
+		}
+		docs = after
+	}
+
+	size := 10
+	if value, ok := toFloat(request["size"]); ok {
+		size = int(value)
+	}
+
+	response := map[string]interface{}{
+		"took":      0,
+		"timed_out": false,
+	}
+
+	aggs := request["aggs"]
+	if aggs == nil {
+		aggs = request["aggregations"]
+	}
+	if aggs != nil {
+		aggregations, err := s.aggregate(docs, aggs)
+		if err != nil {
+			return nil, err
+		}
+		response["aggregations"] = aggregations
+	}
+
+	if source, ok := request["_source"]; ok {
+		docs = filterSource(docs, source)
+	}
+
+	page := docs
+	if len(page) > size {
+		page = page[:size]
+	}
+	response["hits"] = hitList(page, sorts, total)
+
+	if scroll {
+		s.scrollId++
+		id := fmt.Sprintf("scroll-%d", s.scrollId)
+		s.scrolls[id] = &standInScroll{
+			docs:  docs[len(page):],
+			sorts: sorts,
+			size:  size,
+			total: total,
+		}
+		response["_scroll_id"] = id
+	}
+
+	return response, nil
+}
+
+func filterSource(docs []*standInDoc, spec interface{}) []*standInDoc {
+	include := []string{}
+	for _, field := range asList(spec) {
+		include = append(include, toString(field))
+	}
+	filtered := []*standInDoc{}
+	for _, doc := range docs {
+		source := map[string]interface{}{}
+		for _, field := range include {
+			if value, ok := doc.source[field]; ok {
+				source[field] = value
+			}
+		}
+		copy := *doc
+		copy.source = source
+		filtered = append(filtered, &copy)
+	}
+	return filtered
+}
+
+func isNumeric(fieldType string) bool {
+	switch fieldType {
+	case "long", "integer", "short", "byte", "float", "half_float", "double":
+		return true
+	}
+	return false
+}
+
+// checkSort returns an error for sorts Elastic Search would reject, sorting
+// on text or on a field not mapped in an index without an unmapped_type.
+func (s *standIn) checkSort(indices string, spec interface{}) error {
+	for _, entry := range asList(spec) {
+		field, options, err := singleEntry(entry)
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(field, "_") {
+			continue
+		}
+		unmappedType := ""
+		if object, ok := options.(map[string]interface{}); ok {
+			unmappedType, _ = object["unmapped_type"].(string)
+		}
+		for _, index := range s.matchIndices(indices) {
+			mapping, ok := index.mapping[field]
+			if !ok && unmappedType == "" {
+				return badRequest("No mapping found for [%s] in order to sort on", field)
+			}
+			if mapping.Type == "text" {
+				return badRequest("Fielddata is disabled on text fields by default, "+
+					"can't sort on [%s]", field)
+			}
+		}
+	}
+	return nil
+}
+
+// aggregate runs the aggregations over the documents.
+func (s *standIn) aggregate(docs []*standInDoc, aggs interface{}) (map[string]interface{}, error) {
+	specs, ok := aggs.(map[string]interface{})
+	if !ok {
+		return nil, badRequest("invalid aggregations: %v", aggs)
+	}
+	results := map[string]interface{}{}
+	for name, spec := range specs {
+		spec, ok := spec.(map[string]interface{})
+		if !ok {
+			return nil, badRequest("invalid aggregation %s", name)
+		}
+		var subAggs interface{}
+		var aggType string
+		var params map[string]interface{}
+		for key, value := range spec {
+			if key == "aggs" || key == "aggregations" {
+				subAggs = value
+				continue
+			}
+			aggType = key
+			params, _ = value.(map[string]interface{})
+		}
+
+		var result map[string]interface{}
+		var err error
+		switch aggType {
+		case "terms":
+			result, err = s.termsAgg(docs, params, subAggs)
+		case "top_hits":
+			result, err = topHitsAgg(docs, params)
+		case "filter":
+			matched := []*standInDoc{}
+			for _, doc := range docs {
+				ok, err := doc.matches(params)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					matched = append(matched, doc)
+				}
+			}
+			result, err = s.bucket(matched, subAggs)
+		default:
+			return nil, badRequest("unsupported aggregation: %s", aggType)
+		}
+		if err != nil {
+			return nil, err
+		}
+		results[name] = result
+	}
+	return results, nil
+}
+
+// bucket returns a bucket of documents with its sub-aggregations.
+func (s *standIn) bucket(docs []*standInDoc, subAggs interface{}) (map[string]interface{}, error) {
+	bucket := map[string]interface{}{
+		"doc_count": len(docs),
+	}
+	if subAggs != nil {
+		results, err := s.aggregate(docs, subAggs)
+		if err != nil {
+			return nil, err
+		}
+		for name, result := range results {
+			bucket[name] = result
+		}
+	}
+	return bucket, nil
+}
+
+func (s *standIn) termsAgg(docs []*standInDoc, params map[string]interface{}, subAggs interface{}) (map[string]interface{}, error) {
+	field := toString(params["field"])
+	size := 10
+	if value, ok := toFloat(params["size"]); ok {
+		size = int(value)
+	}
+	missing, hasMissing := params["missing"]
+
+	keys := map[string]interface{}{}
+	groups := map[string][]*standInDoc{}
+	for _, doc := range docs {
+		mapping, values := doc.fieldValues(field)
+		if mapping.Type == "text" {
+			return nil, badRequest("Fielddata is disabled on text fields by default, "+
+				"can't aggregate on [%s]", field)
+		}
+		if len(values) == 0 {
+			if !hasMissing {
+				continue
+			}
+			values = []interface{}{missing}
+		}
+		seen := map[string]bool{}
+		for _, value := range values {
+			key := toString(value)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if isNumeric(mapping.Type) {
+				keys[key] = value
+			} else {
+				keys[key] = key
+			}
+			groups[key] = append(groups[key], doc)
+		}
+	}
+
+	order := []string{}
+	for key := range groups {
+		order = append(order, key)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if len(groups[order[i]]) != len(groups[order[j]]) {
+			return len(groups[order[i]]) > len(groups[order[j]])
+		}
+		return order[i] < order[j]
+	})
+
+	buckets := []interface{}{}
+	other := 0
+	for i, key := range order {
+		if i >= size {
+			other += len(groups[key])
+			continue
+		}
+		bucket, err := s.bucket(groups[key], subAggs)
+		if err != nil {
+			return nil, err
+		}
+		bucket["key"] = keys[key]
+		buckets = append(buckets, bucket)
+	}
+
+	return map[string]interface{}{
+		"doc_count_error_upper_bound": 0,
+		"sum_other_doc_count":         other,
+		"buckets":                     buckets,
+	}, nil
+}
+
+func topHitsAgg(docs []*standInDoc, params map[string]interface{}) (map[string]interface{}, error) {
+	sorts, err := parseSort(params["sort"])
+	if err != nil {
+		return nil, err
+	}
+	size := 3
+	if value, ok := toFloat(params["size"]); ok {
+		size = int(value)
+	}
+	sorted := append([]*standInDoc{}, docs...)
+	sortDocs(sorted, sorts)
+	if len(sorted) > size {
+		sorted = sorted[:size]
+	}
+	return map[string]interface{}{
+		"hits": hitList(sorted, sorts, len(docs)),
+	}, nil
+}
+
+func (s *standIn) handleScroll(method string, body []byte) (interface{}, error) {
+	if method == http.MethodDelete {
+		id := strings.TrimSpace(string(body))
+		if _, ok := s.scrolls[id]; !ok {
+			return nil, notFound("scroll %s not found", id)
+		}
+		delete(s.scrolls, id)
+		return map[string]interface{}{"succeeded": true, "num_freed": 1}, nil
+	}
+
+	request := map[string]interface{}{}
+	if err := decodeJson(body, &request); err != nil {
+		return nil, err
+	}
+	id := toString(request["scroll_id"])
+	scroll, ok := s.scrolls[id]
+	if !ok {
+		return nil, notFound("scroll %s not found", id)
+	}
+	page := scroll.docs
+	if len(page) > scroll.size {
+		page = page[:scroll.size]
+	}
+	scroll.docs = scroll.docs[len(page):]
+	return map[string]interface{}{
+		"_scroll_id": id,
+		"took":       0,
+		"timed_out":  false,
+		"hits":       hitList(page, scroll.sorts, scroll.total),
+	}, nil
+}
+
+func (s *standIn) bulk(body []byte) (interface{}, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, len(body)+1)
+
+	items := []interface{}{}
+	hasErrors := false
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		header := map[string]interface{}{}
+		if err := decodeJson(scanner.Bytes(), &header); err != nil {
+			return nil, err
+		}
+		action, meta, err := singleEntry(header)
+		if err != nil {
+			return nil, err
+		}
+		params, _ := meta.(map[string]interface{})
+		index := toString(params["_index"])
+		docType := toString(params["_type"])
+		id := toString(params["_id"])
+
+		var source map[string]interface{}
+		if action != "delete" {
+			if !scanner.Scan() {
+				return nil, badRequest("bulk %s without a document", action)
+			}
+			if err := decodeJson(scanner.Bytes(), &source); err != nil {
+				return nil, err
+			}
+		}
+
+		status := http.StatusOK
+		switch action {
+		case "create", "index":
+			status, err = s.put(index, docType, id, source, action == "create")
+		case "update":
+			status = http.StatusOK
+			err = s.updateDoc(index, id, source)
+		case "delete":
+			status = http.StatusOK
+			if i, ok := s.indices[index]; ok && i.docs[id] != nil {
+				delete(i.docs, id)
+			} else {
+				err = notFound("document %s not found", id)
+			}
+		default:
+			return nil, badRequest("unsupported bulk action: %s", action)
+		}
+
+		item := map[string]interface{}{
+			"_index": index,
+			"_type":  docType,
+			"_id":    id,
+			"status": status,
+		}
+		if err != nil {
+			hasErrors = true
+			if err, ok := err.(*standInError); ok {
+				item["status"] = err.status
+			}
+			item["error"] = map[string]interface{}{
+				"type":   "stand_in_exception",
+				"reason": err.Error(),
+			}
+		}
+		items = append(items, map[string]interface{}{action: item})
+	}
+
+	return map[string]interface{}{
+		"took":   0,
+		"errors": hasErrors,
+		"items":  items,
+	}, nil
+}
+
+// put adds a document to an index, creating the index if required.
+func (s *standIn) put(indexName string, docType string, id string,
+	source map[string]interface{}, create bool) (int, error) {
+	index := s.getIndex(indexName)
+	if existing, ok := index.docs[id]; ok {
+		if create {
+			return 0, &standInError{http.StatusConflict,
+				fmt.Sprintf("[%s][%s]: version conflict, document already exists",
+					docType, id)}
+		}
+		existing.source = source
+		index.mapDynamic("", source)
+		return http.StatusOK, nil
+	}
+	s.sequence++
+	index.docs[id] = &standInDoc{
+		index:    index,
+		docType:  docType,
+		id:       id,
+		source:   source,
+		sequence: s.sequence,
+	}
+	index.mapDynamic("", source)
+	return http.StatusCreated, nil
+}
+
+// updateDoc applies a partial document or script update to a document.
+func (s *standIn) updateDoc(indexName string, id string, request map[string]interface{}) error {
+	index, ok := s.indices[indexName]
+	if !ok {
+		return notFound("no such index: %s", indexName)
+	}
+	doc, ok := index.docs[id]
+	if !ok {
+		return notFound("[%s]: document missing", id)
+	}
+	if partial, ok := request["doc"].(map[string]interface{}); ok {
+		for key, value := range partial {
+			doc.source[key] = value
+		}
+	} else if script, ok := request["script"].(map[string]interface{}); ok {
+		if err := runScript(doc.source, script); err != nil {
+			return err
+		}
+	} else {
+		return badRequest("update requires a doc or script")
+	}
+	index.mapDynamic("", doc.source)
+	return nil
+}
+
+// runScript applies one of the EveBox painless scripts to a document
+// source. The scripts add or remove params.tags, and record params.action
+// in the history.
+func runScript(source map[string]interface{}, script map[string]interface{}) error {
+	inline := toString(script["inline"])
+	params, _ := script["params"].(map[string]interface{})
+
+	supported := false
+
+	if tags := asList(params["tags"]); strings.Contains(inline, "removeIf") {
+		supported = true
+		if existing, ok := source["tags"].([]interface{}); ok {
+			remaining := []interface{}{}
+			for _, tag := range existing {
+				remove := false
+				for _, other := range tags {
+					if tag == other {
+						remove = true
+					}
+				}
+				if !remove {
+					remaining = append(remaining, tag)
+				}
+			}
+			source["tags"] = remaining
+		}
+	} else if strings.Contains(inline, "tags.add(tag)") {
+		supported = true
+		if params["tags"] != nil {
+			existing, _ := source["tags"].([]interface{})
+			for _, tag := range tags {
+				found := false
+				for _, other := range existing {
+					if tag == other {
+						found = true
+					}
+				}
+				if !found {
+					existing = append(existing, tag)
+				}
+			}
+			source["tags"] = existing
+		}
+	}
+
+	if strings.Contains(inline, "history.add(params.action)") {
+		supported = true
+		if action := params["action"]; action != nil {
+			evebox, ok := source["evebox"].(map[string]interface{})
+			if !ok {
+				evebox = map[string]interface{}{}
+				source["evebox"] = evebox
+			}
+			history, _ := evebox["history"].([]interface{})
+			evebox["history"] = append(history, action)
+		}
+	}
+
+	if !supported {
+		return badRequest("unsupported script: %s", inline)
+	}
+	return nil
+}
+
+func (s *standIn) update(indexName string, docType string, id string, body []byte) (interface{}, error) {
+	request := map[string]interface{}{}
+	if err := decodeJson(body, &request); err != nil {
+		return nil, err
+	}
+	if err := s.updateDoc(indexName, id, request); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"_index": indexName,
+		"_type":  docType,
+		"_id":    id,
+		"result": "updated",
+	}, nil
+}
+
+func (s *standIn) updateByQuery(indices string, body []byte) (interface{}, error) {
+	request := map[string]interface{}{}
+	if err := decodeJson(body, &request); err != nil {
+		return nil, err
+	}
+	script, ok := request["script"].(map[string]interface{})
+	if !ok {
+		return nil, badRequest("update by query requires a script")
+	}
+	docs, err := s.query(indices, request["query"])
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		if err := runScript(doc.source, script); err != nil {
+			return nil, err
+		}
+		doc.index.mapDynamic("", doc.source)
+	}
+	return map[string]interface{}{
+		"took":      0,
+		"timed_out": false,
+		"total":     len(docs),
+		"updated":   len(docs),
+		"failures":  []interface{}{},
+	}, nil
+}
+
+// fieldMapping returns the mapping of the fields in each index.
+func (s *standIn) fieldMapping(indices string, fields []string) interface{} {
+	response := map[string]interface{}{}
+	for _, index := range s.matchIndices(indices) {
+		types := map[string]interface{}{}
+		for _, doc := range index.docs {
+			types[doc.docType] = true
+		}
+		mappings := map[string]interface{}{}
+		for docType := range types {
+			mapping := map[string]interface{}{}
+			for _, field := range fields {
+				if m, ok := index.mapping[field]; ok {
+					name := field[strings.LastIndex(field, ".")+1:]
+					mapping[field] = map[string]interface{}{
+						"full_name": field,
+						"mapping": map[string]interface{}{
+							name: map[string]interface{}{"type": m.Type},
+						},
+					}
+				}
+			}
+			mappings[docType] = mapping
+		}
+		response[index.name] = map[string]interface{}{"mappings": mappings}
+	}
+	return response
+}
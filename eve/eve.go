@@ -27,15 +27,18 @@
 package eve
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
+	"github.com/jasonish/evebox/log"
 	"github.com/jasonish/evebox/pcap"
 )
 
@@ -80,7 +83,43 @@ func ProtoNumber(proto string) (layers.IPProtocol, error) {
 // Convert the packet of an EveEvent to a PCAP file. A buffer
 // representing a complete PCAP file is returned.
 func EvePacket2Pcap(event EveEvent) ([]byte, error) {
-	return pcap.CreatePcap(event.Timestamp(), event.Packet(), layers.LinkTypeEthernet)
+	return pcap.CreatePcap(event.Timestamp(), event.Packet(), packetLinkType(event))
+}
+
+// EvePacket2PcapNg is like EvePacket2Pcap but returns a PCAP-NG file with
+// a comment on the packet describing the event.
+func EvePacket2PcapNg(event EveEvent, id string, application string) ([]byte, error) {
+	return pcap.CreatePcapNgFromPackets([]pcap.Packet{{
+		Timestamp: event.Timestamp(),
+		Data:      event.Packet(),
+		Comment:   PacketComment(event, id),
+	}}, packetLinkType(event), application)
+}
+
+// packetLinkType returns the link type of the packet logged with an
+// event. Older versions of Suricata don't log the link type, in which
+// case Ethernet is assumed.
+func packetLinkType(event EveEvent) layers.LinkType {
+	info, ok := event["packet_info"].(map[string]interface{})
+	if !ok {
+		return layers.LinkTypeEthernet
+	}
+	var linktype int64
+	switch value := info["linktype"].(type) {
+	case json.Number:
+		linktype, _ = value.Int64()
+	case float64:
+		linktype = int64(value)
+	default:
+		return layers.LinkTypeEthernet
+	}
+	switch linktype {
+	case 12, 14:
+		// DLT_RAW as logged by Suricata, which is LINKTYPE_RAW in a
+		// PCAP file.
+		return layers.LinkTypeRaw
+	}
+	return layers.LinkType(linktype)
 }
 
 // Given an EvePacket, convert the payload to a PCAP faking out the
@@ -88,6 +127,29 @@ func EvePacket2Pcap(event EveEvent) ([]byte, error) {
 //
 // A buffer containing the 1 packet pcap file will be returned.
 func EvePayloadToPcap(event EveEvent) ([]byte, error) {
+	packet, err := evePayloadToPacket(event)
+	if err != nil {
+		return nil, err
+	}
+	return pcap.CreatePcap(event.Timestamp(), packet, layers.LinkTypeRaw)
+}
+
+// EvePayloadToPcapNg is like EvePayloadToPcap but returns a PCAP-NG file
+// with a comment on the packet describing the event.
+func EvePayloadToPcapNg(event EveEvent, id string, application string) ([]byte, error) {
+	packet, err := evePayloadToPacket(event)
+	if err != nil {
+		return nil, err
+	}
+	return pcap.CreatePcapNgFromPackets([]pcap.Packet{{
+		Timestamp: event.Timestamp(),
+		Data:      packet,
+		Comment:   PacketComment(event, id),
+	}}, layers.LinkTypeRaw, application)
+}
+
+// evePayloadToPacket builds an IP packet around the payload of an event.
+func evePayloadToPacket(event EveEvent) ([]byte, error) {
 	buffer := gopacket.NewSerializeBuffer()
 	options := gopacket.SerializeOptions{
 		FixLengths:       true,
@@ -167,6 +229,151 @@ func EvePayloadToPcap(event EveEvent) ([]byte, error) {
 		ip6Layer.SerializeTo(buffer, options)
 	}
 
-	return pcap.CreatePcap(event.Timestamp(),
-		buffer.Bytes(), layers.LinkTypeRaw)
+	return buffer.Bytes(), nil
+}
+
+// EveEventsToPcap converts a list of events to a PCAP file with a packet
+// for each event, ordered by timestamp. The packet logged with the event
+// is used, otherwise one is built from the payload. Events with neither
+// are skipped. A buffer containing the PCAP file is returned along with
+// the number of packets in it.
+//
+// If the packets don't all have the same link type they are written as
+// Ethernet, with a fake Ethernet header added to raw IP packets.
+func EveEventsToPcap(events []EveEvent) ([]byte, int, error) {
+	packets, linktype := eveEventsToPackets(events, nil, false)
+	buf, err := pcap.CreatePcapFromPackets(packets, linktype)
+	if err != nil {
+		return nil, 0, err
+	}
+	return buf, len(packets), nil
+}
+
+// EveEventsToPcapNg is like EveEventsToPcap but returns a PCAP-NG file
+// with a comment on each packet describing its event. ids are the EveBox
+// IDs of the events, in the same order, and may be nil. The application
+// is recorded as the creator of the file.
+func EveEventsToPcapNg(events []EveEvent, ids []string, application string) ([]byte, int, error) {
+	packets, linktype := eveEventsToPackets(events, ids, true)
+	buf, err := pcap.CreatePcapNgFromPackets(packets, linktype, application)
+	if err != nil {
+		return nil, 0, err
+	}
+	return buf, len(packets), nil
+}
+
+func eveEventsToPackets(events []EveEvent, ids []string, comments bool) ([]pcap.Packet, layers.LinkType) {
+	type linkPacket struct {
+		pcap.Packet
+		linktype layers.LinkType
+	}
+
+	packets := []linkPacket{}
+	for i, event := range events {
+		packet := linkPacket{
+			Packet: pcap.Packet{Timestamp: event.Timestamp()},
+		}
+		if data := event.Packet(); len(data) > 0 {
+			packet.Data = data
+			packet.linktype = packetLinkType(event)
+		} else if len(event.Payload()) > 0 {
+			data, err := evePayloadToPacket(event)
+			if err != nil {
+				log.Debug("Failed to convert payload to packet: %v", err)
+				continue
+			}
+			packet.Data = data
+			packet.linktype = layers.LinkTypeRaw
+		} else {
+			continue
+		}
+		if comments {
+			id := ""
+			if i < len(ids) {
+				id = ids[i]
+			}
+			packet.Comment = PacketComment(event, id)
+		}
+		packets = append(packets, packet)
+	}
+
+	sort.SliceStable(packets, func(i, j int) bool {
+		return packets[i].Timestamp.Before(packets[j].Timestamp)
+	})
+
+	linktype := layers.LinkTypeEthernet
+	if len(packets) > 0 {
+		linktype = packets[0].linktype
+		for _, packet := range packets {
+			if packet.linktype != linktype {
+				linktype = layers.LinkTypeEthernet
+				break
+			}
+		}
+	}
+
+	output := []pcap.Packet{}
+	for _, packet := range packets {
+		if packet.linktype != linktype {
+			if packet.linktype != layers.LinkTypeRaw {
+				log.Debug("Skipping packet with link type %v", packet.linktype)
+				continue
+			}
+			data, err := rawToEthernet(packet.Data)
+			if err != nil {
+				log.Debug("Failed to convert raw packet to Ethernet: %v", err)
+				continue
+			}
+			packet.Data = data
+		}
+		output = append(output, packet.Packet)
+	}
+
+	return output, linktype
+}
+
+// PacketComment returns a comment describing an event for its packet in a
+// PCAP-NG file. The id is the EveBox ID of the event, if known.
+func PacketComment(event EveEvent, id string) string {
+	lines := []string{}
+	add := func(name string, value interface{}) {
+		if value != nil && value != "" {
+			lines = append(lines, fmt.Sprintf("%s: %v", name, value))
+		}
+	}
+	if alert := event.GetAlert(); alert != nil {
+		add("signature", alert["signature"])
+		add("signature_id", alert["signature_id"])
+	}
+	add("flow_id", event["flow_id"])
+	add("host", event["host"])
+	add("evebox_id", id)
+	return strings.Join(lines, "\n")
+}
+
+// rawToEthernet adds an Ethernet header, with zero addresses, to a raw IP
+// packet.
+func rawToEthernet(packet []byte) ([]byte, error) {
+	if len(packet) == 0 {
+		return nil, errors.New("empty packet")
+	}
+	ethernetLayer := layers.Ethernet{
+		SrcMAC: make(net.HardwareAddr, 6),
+		DstMAC: make(net.HardwareAddr, 6),
+	}
+	switch packet[0] >> 4 {
+	case 4:
+		ethernetLayer.EthernetType = layers.EthernetTypeIPv4
+	case 6:
+		ethernetLayer.EthernetType = layers.EthernetTypeIPv6
+	default:
+		return nil, errors.New("not an IP packet")
+	}
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{}
+	gopacket.Payload(packet).SerializeTo(buffer, options)
+	if err := ethernetLayer.SerializeTo(buffer, options); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
 }
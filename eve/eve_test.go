@@ -27,6 +27,9 @@
 package eve
 
 import (
+	"bytes"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
 	"github.com/stretchr/testify/require"
 	"testing"
 )
@@ -114,3 +117,71 @@ func TestEvePayloadToPcap(t *testing.T) {
 		t.Fatalf("Expected pcap length to be 146, not %d.", len(pcap))
 	}
 }
+
+func TestEveEventsToPcap(t *testing.T) {
+	r := require.New(t)
+
+	packetEvent, err := NewEveEventFromString(tcp4EventAlert)
+	r.Nil(err)
+
+	// Only has a payload, and is before the packet event.
+	payloadEvent, err := NewEveEventFromString(icmp4EveAlert)
+	r.Nil(err)
+	delete(payloadEvent, "packet")
+	r.True(payloadEvent.Timestamp().Before(packetEvent.Timestamp()))
+
+	// No packet or payload, skipped.
+	emptyEvent, err := NewEveEventFromString(`{"timestamp":"2016-02-11T08:07:42.815726-0600","event_type":"alert"}`)
+	r.Nil(err)
+
+	buf, count, err := EveEventsToPcap([]EveEvent{packetEvent, emptyEvent, payloadEvent})
+	r.Nil(err)
+	r.Equal(2, count)
+
+	reader, err := pcapgo.NewReader(bytes.NewReader(buf))
+	r.Nil(err)
+	r.Equal(layers.LinkTypeEthernet, reader.LinkType())
+
+	// The payload packet is first with a fake Ethernet header.
+	data, ci, err := reader.ReadPacketData()
+	r.Nil(err)
+	r.True(ci.Timestamp.Equal(payloadEvent.Timestamp()))
+	r.Equal([]byte{0x08, 0x00}, data[12:14])
+
+	data, ci, err = reader.ReadPacketData()
+	r.Nil(err)
+	r.True(ci.Timestamp.Equal(packetEvent.Timestamp()))
+	r.Equal(packetEvent.Packet(), data)
+
+	// With only payloads the packets are raw IP.
+	buf, count, err = EveEventsToPcap([]EveEvent{payloadEvent})
+	r.Nil(err)
+	r.Equal(1, count)
+	reader, err = pcapgo.NewReader(bytes.NewReader(buf))
+	r.Nil(err)
+	r.Equal(layers.LinkTypeRaw, reader.LinkType())
+}
+
+func TestEveEventsToPcapNg(t *testing.T) {
+	r := require.New(t)
+
+	event, err := NewEveEventFromString(tcp4EventAlert)
+	r.Nil(err)
+
+	comment := PacketComment(event, "event-id")
+	r.Equal("signature: ET GAMES MINECRAFT Server response inbound\n"+
+		"signature_id: 2021701\n"+
+		"flow_id: 140467580480416\n"+
+		"host: home-firewall\n"+
+		"evebox_id: event-id", comment)
+
+	buf, count, err := EveEventsToPcapNg([]EveEvent{event}, []string{"event-id"}, "EveBox test")
+	r.Nil(err)
+	r.Equal(1, count)
+
+	// Section header block type and byte order magic.
+	r.Equal([]byte{0x0a, 0x0d, 0x0d, 0x0a}, buf[0:4])
+	r.Equal([]byte{0x4d, 0x3c, 0x2b, 0x1a}, buf[8:12])
+	r.True(bytes.Contains(buf, []byte("EveBox test")))
+	r.True(bytes.Contains(buf, []byte(comment)))
+}
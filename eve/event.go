@@ -182,6 +182,19 @@ func (e EveEvent) GetAlertSignatureId() (uint64, bool) {
 	return 0, false
 }
 
+// Tags returns the tags of the event, ignoring any that are not strings.
+func (e EveEvent) Tags() []string {
+	tags := []string{}
+	if list, ok := e["tags"].([]interface{}); ok {
+		for _, tag := range list {
+			if tag, ok := tag.(string); ok {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
 func (e EveEvent) AddTag(tag string) {
 	if e["tags"] == nil {
 		log.Println("Tags is null...")
@@ -202,6 +215,27 @@ func (e EveEvent) AddTag(tag string) {
 	e["tags"] = tags
 }
 
+// History returns the entries in the evebox.history field of the event, as
+// recorded by EveBox for actions taken on it.
+func (e EveEvent) History() []interface{} {
+	if evebox, ok := e["evebox"].(map[string]interface{}); ok {
+		if history, ok := evebox["history"].([]interface{}); ok {
+			return history
+		}
+	}
+	return nil
+}
+
+// AddHistory appends an entry to the evebox.history field of the event.
+func (e EveEvent) AddHistory(entry map[string]interface{}) {
+	evebox, ok := e["evebox"].(map[string]interface{})
+	if !ok {
+		evebox = map[string]interface{}{}
+		e["evebox"] = evebox
+	}
+	evebox["history"] = append(e.History(), entry)
+}
+
 func asUint16(in interface{}) uint16 {
 	if number, ok := in.(json.Number); ok {
 		asInt64, err := number.Int64()
@@ -27,6 +27,7 @@
 package evereader
 
 import (
+	"context"
 	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/eve"
 	"github.com/jasonish/evebox/log"
@@ -141,11 +142,13 @@ func (p *EveFileProcessor) process(reader *FollowingReader, bookmarker *Bookmark
 		}
 
 		if !eof {
+			// Custom fields first so filters, such as suppression
+			// rules, can match on them.
+			p.addCustomFields(event)
 			for _, filter := range p.filters {
 				filter.Filter(event)
 			}
-			p.addCustomFields(event)
-			if err := p.Sink.Submit(event); err != nil {
+			if err := p.Sink.Submit(context.Background(), event); err != nil {
 				log.Error("Failed to submit event: %v", err)
 				continue
 			}
@@ -193,7 +196,7 @@ func (p *EveFileProcessor) process(reader *FollowingReader, bookmarker *Bookmark
 
 func (p *EveFileProcessor) commit() error {
 	for {
-		_, err := p.Sink.Commit()
+		_, err := p.Sink.Commit(context.Background())
 		if err == nil {
 			return nil
 		}
@@ -28,6 +28,7 @@ package httputil
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -47,6 +48,9 @@ type HttpClient struct {
 	password         string
 	disableCertCheck bool
 	httpClient       *http.Client
+
+	// Optional context requests are bound to, see WithContext.
+	ctx context.Context
 }
 
 func NewHttpClient() *HttpClient {
@@ -57,6 +61,14 @@ func NewHttpClient() *HttpClient {
 	return httpClient
 }
 
+// WithContext returns a shallow copy of the client with its requests bound
+// to ctx, so they are aborted if ctx is cancelled.
+func (c *HttpClient) WithContext(ctx context.Context) *HttpClient {
+	client := *c
+	client.ctx = ctx
+	return &client
+}
+
 func (c *HttpClient) SetBaseUrl(baseUrl string) {
 	c.baseUrl = baseUrl
 }
@@ -121,6 +133,9 @@ func (c *HttpClient) Request(method string, path string, contentType string, bod
 	if contentType != "" {
 		request.Header.Set("Content-Type", contentType)
 	}
+	if c.ctx != nil {
+		request = request.WithContext(c.ctx)
+	}
 	return c.Do(request)
 }
 
@@ -0,0 +1,138 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package notify
+
+import (
+	"github.com/pkg/errors"
+	"time"
+)
+
+// Config is the notifications section of the server configuration.
+type Config struct {
+	Outputs []OutputConfig `mapstructure:"outputs"`
+	Rules   []RuleConfig   `mapstructure:"rules"`
+}
+
+// OutputConfig configures a webhook or SMTP output. Templates are Go
+// text/template templates executed with a Notification.
+type OutputConfig struct {
+	Name     string `mapstructure:"name"`
+	Type     string `mapstructure:"type"`
+	Disabled bool   `mapstructure:"disabled"`
+
+	// Template for the webhook request body or email body.
+	Template string `mapstructure:"template"`
+
+	// Webhook.
+	Url     string            `mapstructure:"url"`
+	Headers map[string]string `mapstructure:"headers"`
+
+	// SMTP. Host is host:port, the port defaults to 25.
+	Host     string   `mapstructure:"host"`
+	Username string   `mapstructure:"username"`
+	Password string   `mapstructure:"password"`
+	From     string   `mapstructure:"from"`
+	To       []string `mapstructure:"to"`
+	Subject  string   `mapstructure:"subject"`
+}
+
+// RuleConfig configures a notification rule. All the criteria that are
+// set must match an alert for it to be notified.
+type RuleConfig struct {
+	Name     string   `mapstructure:"name"`
+	Disabled bool     `mapstructure:"disabled"`
+	Outputs  []string `mapstructure:"outputs"`
+
+	SignatureIds []uint64 `mapstructure:"signature-ids"`
+
+	// Signature, * and ? are wildcards, case insensitive.
+	Signature string `mapstructure:"signature"`
+
+	// The highest, or numerically largest, severity to match. Severity
+	// 1 is the most severe.
+	Severity int `mapstructure:"severity"`
+
+	// Addresses, networks in CIDR notation or address ranges. An alert
+	// matches if its address is in any of them. Ip matches either the
+	// source or destination address.
+	SrcIp  []string `mapstructure:"src-ip"`
+	DestIp []string `mapstructure:"dest-ip"`
+	Ip     []string `mapstructure:"ip"`
+
+	// Only notify the first time a signature is seen since the server
+	// was started.
+	NewSignature bool `mapstructure:"new-signature"`
+
+	// Only notify once there are Count matching alerts within Period,
+	// per signature, source and destination.
+	Threshold Rate `mapstructure:"threshold"`
+
+	// Don't notify again for the same signature, source and destination
+	// within this duration, for example "1h".
+	DedupWindow string `mapstructure:"dedup-window"`
+
+	// Send no more than Count notifications within Period for this rule.
+	Throttle Rate `mapstructure:"throttle"`
+}
+
+// Rate is a count of events within a period, such as "5m". A zero count
+// disables it.
+type Rate struct {
+	Count  int    `mapstructure:"count"`
+	Period string `mapstructure:"period"`
+}
+
+func (r Rate) period() (time.Duration, error) {
+	if r.Count == 0 {
+		return 0, nil
+	}
+	if r.Count < 0 {
+		return 0, errors.Errorf("invalid count: %d", r.Count)
+	}
+	period, err := parseDuration(r.Period)
+	if err != nil {
+		return 0, err
+	}
+	if period == 0 {
+		return 0, errors.New("period is required with a count")
+	}
+	return period, nil
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Errorf("invalid duration: %s", value)
+	}
+	if duration < 0 {
+		return 0, errors.Errorf("negative duration: %s", value)
+	}
+	return duration, nil
+}
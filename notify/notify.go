@@ -0,0 +1,349 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Package notify sends notifications for received alerts matching
+// notification rules to webhook and SMTP outputs.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/log"
+	"github.com/pkg/errors"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// The number of notifications waiting to be sent before new ones are
+// dropped.
+const queueSize = 1000
+
+// How often rule state that no longer matters is removed.
+const expireInterval = time.Minute
+
+var ErrUnknownOutput = errors.New("unknown or disabled output")
+
+// Notification is the data a notification template is executed with.
+type Notification struct {
+	Rule      string    `json:"rule"`
+	Timestamp time.Time `json:"timestamp"`
+
+	// Number of matching alerts since the last notification for the
+	// signature, source and destination of the alert, including this
+	// one.
+	Count int `json:"count"`
+
+	// The alert that triggered the notification.
+	Event eve.EveEvent `json:"event"`
+}
+
+// Output sends notifications.
+type Output interface {
+	Name() string
+	Send(notification Notification) error
+}
+
+type job struct {
+	output       Output
+	notification Notification
+}
+
+// Engine is an eve.EveFilter that evaluates the notification rules for
+// each alert. Notifications are sent in the background so the ingest of
+// events is not slowed down.
+type Engine struct {
+	// Outputs by name, nil if disabled.
+	outputs map[string]Output
+	rules   []*rule
+
+	lock       sync.Mutex
+	lastExpire time.Time
+	stopped    bool
+
+	queue chan job
+	wg    sync.WaitGroup
+
+	// The current time, replaced by tests.
+	now func() time.Time
+}
+
+func NewEngine(config Config) (*Engine, error) {
+	engine := &Engine{
+		outputs: map[string]Output{},
+		queue:   make(chan job, queueSize),
+		now:     time.Now,
+	}
+
+	for _, outputConfig := range config.Outputs {
+		if outputConfig.Name == "" {
+			return nil, errors.New("notification output name is required")
+		}
+		if _, ok := engine.outputs[outputConfig.Name]; ok {
+			return nil, errors.Errorf("duplicate notification output: %s",
+				outputConfig.Name)
+		}
+		// Disabled outputs can still be referenced by rules.
+		if outputConfig.Disabled {
+			engine.outputs[outputConfig.Name] = nil
+			continue
+		}
+		output, err := newOutput(outputConfig)
+		if err != nil {
+			return nil, errors.Wrapf(err, "notification output %s",
+				outputConfig.Name)
+		}
+		engine.outputs[outputConfig.Name] = output
+	}
+
+	for _, ruleConfig := range config.Rules {
+		if ruleConfig.Disabled {
+			continue
+		}
+		rule, err := newRule(ruleConfig, engine.outputs)
+		if err != nil {
+			return nil, errors.Wrapf(err, "notification rule %s",
+				ruleConfig.Name)
+		}
+		engine.rules = append(engine.rules, rule)
+	}
+
+	return engine, nil
+}
+
+func newOutput(config OutputConfig) (Output, error) {
+	switch config.Type {
+	case "webhook":
+		return NewWebhookOutput(config)
+	case "smtp":
+		return NewSmtpOutput(config)
+	}
+	return nil, errors.Errorf("unsupported type: %s", config.Type)
+}
+
+// Start sending notifications.
+func (e *Engine) Start() {
+	e.wg.Add(1)
+	go e.run()
+}
+
+// Stop sends the queued notifications and stops. Alerts filtered after
+// stopping are ignored.
+func (e *Engine) Stop() {
+	e.lock.Lock()
+	if e.stopped {
+		e.lock.Unlock()
+		return
+	}
+	e.stopped = true
+	close(e.queue)
+	e.lock.Unlock()
+	e.wg.Wait()
+}
+
+// LoadSeen marks the signatures of the alerts already in the datastore as
+// seen by the new-signature rules they match, so they are not notified as
+// new again after a restart.
+func (e *Engine) LoadSeen(ctx context.Context, datastore core.Datastore) error {
+	rules := []*rule{}
+	for _, rule := range e.rules {
+		if rule.newSignature {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	groups, err := datastore.AlertQuery(ctx, core.AlertQueryOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to query alerts")
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for _, group := range groups {
+		// The datastores differ in how numbers are decoded.
+		buf, err := json.Marshal(group.Event["_source"])
+		if err != nil {
+			return err
+		}
+		event, err := eve.NewEveEventFromBytes(buf)
+		if err != nil {
+			return err
+		}
+		signatureId, ok := event.GetAlertSignatureId()
+		if !ok {
+			continue
+		}
+		for _, rule := range rules {
+			if rule.matches(event) {
+				rule.seen[signatureId] = true
+			}
+		}
+	}
+	return nil
+}
+
+func (e *Engine) run() {
+	defer e.wg.Done()
+	for job := range e.queue {
+		if err := job.output.Send(job.notification); err != nil {
+			log.Error("Failed to send notification for rule %s to %s: %v",
+				job.notification.Rule, job.output.Name(), err)
+		}
+	}
+}
+
+// Filter evaluates the rules for an alert. Archived alerts, such as
+// those suppressed, are ignored.
+func (e *Engine) Filter(event eve.EveEvent) {
+	if event.EventType() != "alert" {
+		return
+	}
+	for _, tag := range event.Tags() {
+		if tag == core.TAG_ARCHIVED {
+			return
+		}
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.stopped {
+		return
+	}
+	now := e.now()
+	notifications := []job{}
+	var copied eve.EveEvent
+	for _, rule := range e.rules {
+		if !rule.matches(event) {
+			continue
+		}
+		count := rule.evaluate(event, now)
+		if count == 0 {
+			continue
+		}
+		// The event is sent from another goroutine, so send a copy
+		// in case it is modified when stored.
+		if copied == nil {
+			var err error
+			if copied, err = copyEvent(event); err != nil {
+				log.Error("Failed to copy event for notification: %v", err)
+				break
+			}
+		}
+		for _, output := range rule.outputs {
+			notifications = append(notifications, job{
+				output: output,
+				notification: Notification{
+					Rule:      rule.name,
+					Timestamp: now,
+					Count:     count,
+					Event:     copied,
+				},
+			})
+		}
+	}
+	if now.Sub(e.lastExpire) >= expireInterval {
+		for _, rule := range e.rules {
+			rule.expire(now)
+		}
+		e.lastExpire = now
+	}
+
+	for _, job := range notifications {
+		select {
+		case e.queue <- job:
+		default:
+			log.Warning("Notification queue full, dropping notification for rule %s",
+				job.notification.Rule)
+		}
+	}
+}
+
+func copyEvent(event eve.EveEvent) (eve.EveEvent, error) {
+	buf, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return eve.NewEveEventFromBytes(buf)
+}
+
+// Test sends a test notification to an output, returning any error.
+func (e *Engine) Test(name string) error {
+	output := e.outputs[name]
+	if output == nil {
+		return ErrUnknownOutput
+	}
+	event, err := eve.NewEveEventFromString(testEvent)
+	if err != nil {
+		return err
+	}
+	event.SetTimestamp(e.now())
+	return output.Send(Notification{
+		Rule:      "test",
+		Timestamp: e.now(),
+		Count:     1,
+		Event:     event,
+	})
+}
+
+const testEvent = `{"timestamp":"2017-01-01T00:00:00.000000+0000","event_type":"alert","src_ip":"10.0.0.1","src_port":1024,"dest_ip":"10.0.0.2","dest_port":80,"proto":"TCP","alert":{"signature_id":0,"signature":"EveBox test notification","category":"Test","severity":3}}`
+
+// Functions available to templates, json encodes a value as JSON for use
+// in JSON request bodies.
+var templateFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		buf, err := json.Marshal(value)
+		return string(buf), err
+	},
+}
+
+// executeTemplate executes a template with a notification, returning the
+// result.
+func executeTemplate(t *template.Template, notification Notification) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, notification); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// parseTemplate parses a template, or the default if the template is
+// not set.
+func parseTemplate(name string, value string, defaultValue string) (*template.Template, error) {
+	if value == "" {
+		value = defaultValue
+	}
+	t, err := template.New(name).Funcs(templateFuncs).Parse(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s template", name)
+	}
+	return t, nil
+}
@@ -0,0 +1,367 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func alert(t *testing.T, signatureId int, severity int, src string, dest string) eve.EveEvent {
+	event, err := eve.NewEveEventFromString(fmt.Sprintf(
+		`{"timestamp":"2017-06-01T10:00:00.000000+0000","event_type":"alert","src_ip":%q,"src_port":1000,"dest_ip":%q,"dest_port":80,"proto":"TCP","tags":[],"alert":{"signature_id":%d,"signature":"ET TROJAN Test %d","category":"Trojan","severity":%d}}`,
+		src, dest, signatureId, signatureId, severity))
+	require.Nil(t, err)
+	return event
+}
+
+// testOutput records the notifications sent to it.
+type testOutput struct {
+	lock          sync.Mutex
+	notifications []Notification
+}
+
+func (o *testOutput) Name() string {
+	return "test"
+}
+
+func (o *testOutput) Send(notification Notification) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.notifications = append(o.notifications, notification)
+	return nil
+}
+
+// testEngine returns an engine with a single rule sending to a
+// testOutput, and a clock that can be moved.
+func testEngine(t *testing.T, config RuleConfig) (*Engine, *testOutput, *time.Time) {
+	output := &testOutput{}
+	config.Name = "test"
+	config.Outputs = []string{"test"}
+	compiled, err := newRule(config, map[string]Output{"test": output})
+	require.Nil(t, err)
+	now := time.Date(2017, 6, 1, 10, 0, 0, 0, time.UTC)
+	engine := &Engine{
+		rules: []*rule{compiled},
+		queue: make(chan job, queueSize),
+		now: func() time.Time {
+			return now
+		},
+	}
+	engine.Start()
+	return engine, output, &now
+}
+
+// flush stops the engine, waiting for the queued notifications to be
+// sent, and returns the counts of the notifications sent.
+func flush(engine *Engine, output *testOutput) []int {
+	engine.Stop()
+	counts := []int{}
+	for _, notification := range output.notifications {
+		counts = append(counts, notification.Count)
+	}
+	return counts
+}
+
+func TestRuleMatch(t *testing.T) {
+	r := require.New(t)
+
+	rule, err := newRule(RuleConfig{
+		Name:         "match",
+		Outputs:      []string{"test"},
+		SignatureIds: []uint64{1, 2},
+		Signature:    "et trojan *",
+		Severity:     2,
+		SrcIp:        []string{"10.0.0.0/8"},
+		Ip:           []string{"192.168.1.1-192.168.1.10"},
+	}, map[string]Output{"test": &testOutput{}})
+	r.Nil(err)
+
+	r.True(rule.matches(alert(t, 1, 1, "10.0.0.1", "192.168.1.5")))
+	r.True(rule.matches(alert(t, 2, 2, "10.0.0.1", "192.168.1.5")))
+	r.False(rule.matches(alert(t, 3, 1, "10.0.0.1", "192.168.1.5")))
+	r.False(rule.matches(alert(t, 1, 3, "10.0.0.1", "192.168.1.5")))
+	r.False(rule.matches(alert(t, 1, 1, "11.0.0.1", "192.168.1.5")))
+	r.False(rule.matches(alert(t, 1, 1, "10.0.0.1", "192.168.1.11")))
+
+	_, err = newRule(RuleConfig{Name: "a", Outputs: []string{"missing"}}, nil)
+	r.NotNil(err)
+	_, err = newRule(RuleConfig{Name: "a", Outputs: []string{"test"},
+		Threshold: Rate{Count: 5}}, map[string]Output{"test": &testOutput{}})
+	r.NotNil(err)
+	_, err = newRule(RuleConfig{Name: "a", Outputs: []string{"test"},
+		DedupWindow: "1 hour"}, map[string]Output{"test": &testOutput{}})
+	r.NotNil(err)
+	_, err = newRule(RuleConfig{Name: "a", Outputs: []string{"test"},
+		Signature: "\xff*"}, map[string]Output{"test": &testOutput{}})
+	r.NotNil(err)
+}
+
+func TestNewSignature(t *testing.T) {
+	engine, output, _ := testEngine(t, RuleConfig{NewSignature: true})
+	engine.Filter(alert(t, 1, 1, "10.0.0.1", "10.0.0.2"))
+	engine.Filter(alert(t, 1, 1, "10.0.0.3", "10.0.0.4"))
+	engine.Filter(alert(t, 2, 1, "10.0.0.1", "10.0.0.2"))
+	engine.Filter(alert(t, 1, 1, "10.0.0.1", "10.0.0.2"))
+	require.Equal(t, []int{1, 1}, flush(engine, output))
+}
+
+// alertGroupDatastore returns alert groups from AlertQuery.
+type alertGroupDatastore struct {
+	core.Datastore
+	groups []core.AlertGroup
+}
+
+func (d *alertGroupDatastore) AlertQuery(ctx context.Context, options core.AlertQueryOptions) ([]core.AlertGroup, error) {
+	return d.groups, nil
+}
+
+func TestNewSignatureLoadSeen(t *testing.T) {
+	r := require.New(t)
+
+	engine, output, _ := testEngine(t, RuleConfig{
+		NewSignature: true,
+		SrcIp:        []string{"10.0.0.0/8"},
+	})
+
+	// As decoded by the datastore, not necessarily with json.Number.
+	group := func(signatureId int, src string) core.AlertGroup {
+		buf, err := json.Marshal(alert(t, signatureId, 1, src, "10.0.0.2"))
+		r.Nil(err)
+		event := map[string]interface{}{}
+		r.Nil(json.Unmarshal(buf, &event))
+		return core.AlertGroup{Event: map[string]interface{}{"_source": event}}
+	}
+	r.Nil(engine.LoadSeen(context.Background(), &alertGroupDatastore{
+		groups: []core.AlertGroup{
+			group(1, "10.0.0.1"),
+			// Does not match the rule, so not seen by it.
+			group(2, "192.168.1.1"),
+		},
+	}))
+
+	engine.Filter(alert(t, 1, 1, "10.0.0.1", "10.0.0.2"))
+	engine.Filter(alert(t, 2, 1, "10.0.0.1", "10.0.0.2"))
+	engine.Filter(alert(t, 3, 1, "10.0.0.1", "10.0.0.2"))
+	r.Equal([]int{1, 1}, flush(engine, output))
+
+	// Alerts filtered after stopping are ignored.
+	engine.Filter(alert(t, 4, 1, "10.0.0.1", "10.0.0.2"))
+	engine.Stop()
+	r.Len(output.notifications, 2)
+}
+
+func TestThreshold(t *testing.T) {
+	engine, output, now := testEngine(t, RuleConfig{
+		Threshold: Rate{Count: 3, Period: "1m"},
+	})
+	engine.Filter(alert(t, 1, 1, "10.0.0.1", "10.0.0.2"))
+	engine.Filter(alert(t, 1, 1, "10.0.0.1", "10.0.0.2"))
+
+	// Other alerts don't count towards the threshold.
+	engine.Filter(alert(t, 1, 1, "10.0.0.3", "10.0.0.2"))
+
+	// The earlier alerts fall out of the period.
+	*now = now.Add(time.Minute)
+	engine.Filter(alert(t, 1, 1, "10.0.0.1", "10.0.0.2"))
+	engine.Filter(alert(t, 1, 1, "10.0.0.1", "10.0.0.2"))
+	engine.Filter(alert(t, 1, 1, "10.0.0.1", "10.0.0.2"))
+
+	require.Equal(t, []int{5}, flush(engine, output))
+}
+
+func TestDedupWindow(t *testing.T) {
+	engine, output, now := testEngine(t, RuleConfig{DedupWindow: "1h"})
+	engine.Filter(alert(t, 1, 1, "10.0.0.1", "10.0.0.2"))
+	engine.Filter(alert(t, 1, 1, "10.0.0.1", "10.0.0.2"))
+	engine.Filter(alert(t, 2, 1, "10.0.0.1", "10.0.0.2"))
+	*now = now.Add(30 * time.Minute)
+	engine.Filter(alert(t, 1, 1, "10.0.0.1", "10.0.0.2"))
+	*now = now.Add(30 * time.Minute)
+	engine.Filter(alert(t, 1, 1, "10.0.0.1", "10.0.0.2"))
+	require.Equal(t, []int{1, 1, 3}, flush(engine, output))
+}
+
+func TestThrottle(t *testing.T) {
+	engine, output, now := testEngine(t, RuleConfig{
+		Throttle: Rate{Count: 2, Period: "10m"},
+	})
+	for i := 0; i < 5; i++ {
+		engine.Filter(alert(t, 1, 1, "10.0.0.1", "10.0.0.2"))
+	}
+	*now = now.Add(10 * time.Minute)
+	engine.Filter(alert(t, 1, 1, "10.0.0.1", "10.0.0.2"))
+	require.Equal(t, []int{1, 1, 4}, flush(engine, output))
+}
+
+func TestArchivedNotNotified(t *testing.T) {
+	engine, output, _ := testEngine(t, RuleConfig{})
+	event := alert(t, 1, 1, "10.0.0.1", "10.0.0.2")
+	event.AddTag("archived")
+	engine.Filter(event)
+	require.Empty(t, flush(engine, output))
+}
+
+func TestWebhookOutput(t *testing.T) {
+	r := require.New(t)
+
+	// Request bodies, prefixed with the method and token header.
+	bodies := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		bodies <- fmt.Sprintf("%s %s %s", req.Method, req.Header.Get("X-Token"), body)
+	}))
+	defer server.Close()
+
+	engine, err := NewEngine(Config{
+		Outputs: []OutputConfig{
+			{Name: "json", Type: "webhook", Url: server.URL,
+				Headers: map[string]string{"X-Token": "secret"}},
+			{Name: "text", Type: "webhook", Url: server.URL,
+				Headers:  map[string]string{"X-Token": "secret"},
+				Template: `{"text": {{json .Event.alert.signature}}}`},
+			{Name: "disabled", Type: "webhook", Disabled: true},
+		},
+		Rules: []RuleConfig{
+			{Name: "Trojans", Outputs: []string{"json", "text", "disabled"}, Signature: "ET TROJAN*"},
+		},
+	})
+	r.Nil(err)
+	engine.Start()
+	engine.Filter(alert(t, 1, 1, "10.0.0.1", "10.0.0.2"))
+	engine.Stop()
+
+	body := <-bodies
+	r.True(strings.HasPrefix(body, "POST secret {"), body)
+	var notification map[string]interface{}
+	r.Nil(json.Unmarshal([]byte(strings.TrimPrefix(body, "POST secret ")), &notification))
+	r.Equal("Trojans", notification["rule"])
+	r.Equal(float64(1), notification["count"])
+	r.Equal("10.0.0.1", notification["event"].(map[string]interface{})["src_ip"])
+	r.Equal(`POST secret {"text": "ET TROJAN Test 1"}`, <-bodies)
+
+	// Unknown type and outputs.
+	_, err = NewEngine(Config{Outputs: []OutputConfig{{Name: "a", Type: "pager"}}})
+	r.NotNil(err)
+	_, err = NewEngine(Config{Rules: []RuleConfig{{Name: "a", Outputs: []string{"disabled"}}}})
+	r.NotNil(err)
+}
+
+// smtpServer is a minimal SMTP server accepting a single message.
+func smtpServer(t *testing.T) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	messages := make(chan string, 1)
+
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		fmt.Fprintf(conn, "220 localhost ESMTP\r\n")
+		var message []string
+		data := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if data {
+				if line == "." {
+					data = false
+					messages <- strings.Join(message, "\n")
+					fmt.Fprintf(conn, "250 OK\r\n")
+				} else {
+					message = append(message, line)
+				}
+				continue
+			}
+			switch {
+			case strings.HasPrefix(line, "EHLO"), strings.HasPrefix(line, "HELO"):
+				fmt.Fprintf(conn, "250 localhost\r\n")
+			case strings.HasPrefix(line, "DATA"):
+				data = true
+				fmt.Fprintf(conn, "354 Go ahead\r\n")
+			case strings.HasPrefix(line, "QUIT"):
+				fmt.Fprintf(conn, "221 Bye\r\n")
+				return
+			default:
+				fmt.Fprintf(conn, "250 OK\r\n")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), messages
+}
+
+func TestSmtpOutput(t *testing.T) {
+	r := require.New(t)
+
+	host, messages := smtpServer(t)
+	output, err := NewSmtpOutput(OutputConfig{
+		Name: "email",
+		Host: host,
+		From: "evebox@example.com",
+		To:   []string{"soc@example.com"},
+	})
+	r.Nil(err)
+
+	engine := &Engine{
+		outputs: map[string]Output{"email": output},
+		now:     time.Now,
+	}
+	r.Nil(engine.Test("email"))
+	r.Equal(ErrUnknownOutput, engine.Test("missing"))
+
+	message := <-messages
+	r.Contains(message, "From: evebox@example.com")
+	r.Contains(message, "To: soc@example.com")
+	r.Contains(message, "Subject: EveBox: test: EveBox test notification")
+	r.Contains(message, "Source: 10.0.0.1:1024")
+	r.Contains(message, "Destination: 10.0.0.2:80")
+	r.NotContains(message, "Sensor:")
+
+	_, err = NewSmtpOutput(OutputConfig{Name: "email", Host: host})
+	r.NotNil(err)
+}
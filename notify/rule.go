@@ -0,0 +1,257 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package notify
+
+import (
+	"fmt"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/querystring"
+	"github.com/pkg/errors"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// rule is a compiled RuleConfig along with its state.
+type rule struct {
+	name    string
+	outputs []Output
+
+	signatureIds map[uint64]bool
+	signature    *regexp.Regexp
+	severity     int
+	srcIp        []*querystring.AddressRange
+	destIp       []*querystring.AddressRange
+	ip           []*querystring.AddressRange
+	newSignature bool
+
+	thresholdCount  int
+	thresholdPeriod time.Duration
+	dedupWindow     time.Duration
+	throttleCount   int
+	throttlePeriod  time.Duration
+
+	// Signatures seen, for new-signature rules.
+	seen map[uint64]bool
+
+	// State by signature, source and destination.
+	keys map[string]*keyState
+
+	// Times notifications were sent, for throttling.
+	sent []time.Time
+}
+
+type keyState struct {
+	// Matches since the last notification.
+	matches int
+
+	// Times of matches within the threshold period.
+	times []time.Time
+
+	lastSent time.Time
+}
+
+func newRule(config RuleConfig, outputs map[string]Output) (*rule, error) {
+	var err error
+
+	if config.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	r := &rule{
+		name:         config.Name,
+		signatureIds: map[uint64]bool{},
+		severity:     config.Severity,
+		newSignature: config.NewSignature,
+		seen:         map[uint64]bool{},
+		keys:         map[string]*keyState{},
+	}
+
+	if len(config.Outputs) == 0 {
+		return nil, errors.New("no outputs")
+	}
+	for _, name := range config.Outputs {
+		output, ok := outputs[name]
+		if !ok {
+			return nil, errors.Errorf("unknown output: %s", name)
+		}
+		if output != nil {
+			r.outputs = append(r.outputs, output)
+		}
+	}
+
+	for _, id := range config.SignatureIds {
+		r.signatureIds[id] = true
+	}
+	if config.Signature != "" {
+		if r.signature, err = querystring.WildcardToRegexp(config.Signature, true); err != nil {
+			return nil, errors.Wrap(err, "signature")
+		}
+	}
+	if r.srcIp, err = parseAddresses(config.SrcIp); err != nil {
+		return nil, err
+	}
+	if r.destIp, err = parseAddresses(config.DestIp); err != nil {
+		return nil, err
+	}
+	if r.ip, err = parseAddresses(config.Ip); err != nil {
+		return nil, err
+	}
+
+	r.thresholdCount = config.Threshold.Count
+	if r.thresholdPeriod, err = config.Threshold.period(); err != nil {
+		return nil, errors.Wrap(err, "threshold")
+	}
+	r.throttleCount = config.Throttle.Count
+	if r.throttlePeriod, err = config.Throttle.period(); err != nil {
+		return nil, errors.Wrap(err, "throttle")
+	}
+	if r.dedupWindow, err = parseDuration(config.DedupWindow); err != nil {
+		return nil, errors.Wrap(err, "dedup-window")
+	}
+
+	return r, nil
+}
+
+func parseAddresses(values []string) ([]*querystring.AddressRange, error) {
+	ranges := []*querystring.AddressRange{}
+	for _, value := range values {
+		r, err := querystring.ParseAddressRange(value)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+func addressMatches(ranges []*querystring.AddressRange, addrs ...string) bool {
+	if len(ranges) == 0 {
+		return true
+	}
+	for _, r := range ranges {
+		for _, addr := range addrs {
+			if r.Contains(addr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matches returns true if the alert matches the criteria of the rule,
+// not including new-signature.
+func (r *rule) matches(event eve.EveEvent) bool {
+	alert := event.GetAlert()
+	if len(r.signatureIds) > 0 {
+		id, ok := event.GetAlertSignatureId()
+		if !ok || !r.signatureIds[id] {
+			return false
+		}
+	}
+	if r.signature != nil && !r.signature.MatchString(alert.GetString("signature")) {
+		return false
+	}
+	if r.severity > 0 {
+		severity, err := strconv.Atoi(fmt.Sprint(alert.Get("severity")))
+		if err != nil || severity > r.severity {
+			return false
+		}
+	}
+	if !addressMatches(r.srcIp, event.SrcIp()) ||
+		!addressMatches(r.destIp, event.DestIp()) ||
+		!addressMatches(r.ip, event.SrcIp(), event.DestIp()) {
+		return false
+	}
+	return true
+}
+
+// evaluate updates the state of the rule for a matching alert, returning
+// the number of matches to notify for, or 0 if no notification is to be
+// sent.
+func (r *rule) evaluate(event eve.EveEvent, now time.Time) int {
+	signatureId, _ := event.GetAlertSignatureId()
+
+	if r.newSignature {
+		if r.seen[signatureId] {
+			return 0
+		}
+		r.seen[signatureId] = true
+	}
+
+	key := fmt.Sprintf("%d/%s/%s", signatureId, event.SrcIp(), event.DestIp())
+	state := r.keys[key]
+	if state == nil {
+		state = &keyState{}
+		r.keys[key] = state
+	}
+	state.matches++
+
+	if r.thresholdCount > 0 {
+		state.times = append(prune(state.times, now, r.thresholdPeriod), now)
+		if len(state.times) < r.thresholdCount {
+			return 0
+		}
+	}
+
+	if r.dedupWindow > 0 && !state.lastSent.IsZero() &&
+		now.Sub(state.lastSent) < r.dedupWindow {
+		return 0
+	}
+
+	if r.throttleCount > 0 {
+		r.sent = prune(r.sent, now, r.throttlePeriod)
+		if len(r.sent) >= r.throttleCount {
+			return 0
+		}
+		r.sent = append(r.sent, now)
+	}
+
+	count := state.matches
+	state.matches = 0
+	state.times = nil
+	state.lastSent = now
+	return count
+}
+
+// expire removes the state of keys that no longer affect notifications.
+func (r *rule) expire(now time.Time) {
+	for key, state := range r.keys {
+		state.times = prune(state.times, now, r.thresholdPeriod)
+		if len(state.times) == 0 && now.Sub(state.lastSent) >= r.dedupWindow {
+			delete(r.keys, key)
+		}
+	}
+}
+
+// prune removes the times older than period.
+func prune(times []time.Time, now time.Time, period time.Duration) []time.Time {
+	i := 0
+	for i < len(times) && now.Sub(times[i]) >= period {
+		i++
+	}
+	return times[i:]
+}
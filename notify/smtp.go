@@ -0,0 +1,186 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"github.com/pkg/errors"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const smtpTimeout = 30 * time.Second
+
+const defaultSubject = `EveBox: {{.Rule}}: {{.Event.alert.signature}}`
+
+const defaultBody = `Rule: {{.Rule}}
+Count: {{.Count}}
+
+Timestamp: {{.Event.timestamp}}
+Signature: {{.Event.alert.signature}}
+Signature ID: {{.Event.alert.signature_id}}
+Category: {{.Event.alert.category}}
+Severity: {{.Event.alert.severity}}
+Protocol: {{.Event.proto}}
+Source: {{.Event.src_ip}}:{{.Event.src_port}}
+Destination: {{.Event.dest_ip}}:{{.Event.dest_port}}
+{{- if .Event.host}}
+Sensor: {{.Event.host}}
+{{- end}}
+`
+
+// SmtpOutput emails notifications. STARTTLS is used if the server
+// supports it, and authentication only if a username is set.
+type SmtpOutput struct {
+	name     string
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+	subject  *template.Template
+	body     *template.Template
+}
+
+func NewSmtpOutput(config OutputConfig) (*SmtpOutput, error) {
+	var err error
+	if config.Host == "" {
+		return nil, errors.New("host is required")
+	}
+	if config.From == "" {
+		return nil, errors.New("from is required")
+	}
+	if len(config.To) == 0 {
+		return nil, errors.New("to is required")
+	}
+	output := &SmtpOutput{
+		name:     config.Name,
+		host:     config.Host,
+		username: config.Username,
+		password: config.Password,
+		from:     config.From,
+		to:       config.To,
+	}
+	if _, _, err := net.SplitHostPort(output.host); err != nil {
+		output.host = net.JoinHostPort(output.host, "25")
+	}
+	if output.subject, err = parseTemplate("subject", config.Subject, defaultSubject); err != nil {
+		return nil, err
+	}
+	if output.body, err = parseTemplate("body", config.Template, defaultBody); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+func (o *SmtpOutput) Name() string {
+	return o.name
+}
+
+// message returns the email, headers and body, for a notification.
+func (o *SmtpOutput) message(notification Notification) ([]byte, error) {
+	subject, err := executeTemplate(o.subject, notification)
+	if err != nil {
+		return nil, err
+	}
+	body, err := executeTemplate(o.body, notification)
+	if err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", o.from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(o.to, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", strings.Replace(
+		strings.Replace(string(subject), "\r", "", -1), "\n", " ", -1))
+	fmt.Fprintf(&message, "Date: %s\r\n", notification.Timestamp.Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	message.WriteString("\r\n")
+	message.WriteString(strings.Replace(
+		strings.Replace(string(body), "\r\n", "\n", -1), "\n", "\r\n", -1))
+
+	return message.Bytes(), nil
+}
+
+func (o *SmtpOutput) Send(notification Notification) error {
+	message, err := o.message(notification)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", o.host, smtpTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	host, _, _ := net.SplitHostPort(o.host)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return errors.Wrap(err, "starttls failed")
+		}
+	}
+	if o.username != "" {
+		auth := smtp.PlainAuth("", o.username, o.password, host)
+		if err := client.Auth(auth); err != nil {
+			return errors.Wrap(err, "authentication failed")
+		}
+	}
+
+	if err := client.Mail(o.from); err != nil {
+		return err
+	}
+	for _, to := range o.to {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
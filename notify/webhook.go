@@ -0,0 +1,110 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// WebhookOutput POSTs notifications to a URL. Without a template the body
+// is the notification as JSON.
+type WebhookOutput struct {
+	name     string
+	url      string
+	headers  map[string]string
+	template *template.Template
+	client   *http.Client
+}
+
+func NewWebhookOutput(config OutputConfig) (*WebhookOutput, error) {
+	if config.Url == "" {
+		return nil, errors.New("url is required")
+	}
+	output := &WebhookOutput{
+		name:    config.Name,
+		url:     config.Url,
+		headers: config.Headers,
+		client: &http.Client{
+			Timeout: webhookTimeout,
+		},
+	}
+	if config.Template != "" {
+		t, err := parseTemplate("body", config.Template, "")
+		if err != nil {
+			return nil, err
+		}
+		output.template = t
+	}
+	return output, nil
+}
+
+func (o *WebhookOutput) Name() string {
+	return o.name
+}
+
+func (o *WebhookOutput) Send(notification Notification) error {
+	var body []byte
+	var err error
+	if o.template != nil {
+		body, err = executeTemplate(o.template, notification)
+	} else {
+		body, err = json.Marshal(notification)
+	}
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("POST", o.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("content-type", "application/json")
+	for key, value := range o.headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := o.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return errors.Errorf("webhook returned status %s", response.Status)
+	}
+	return nil
+}
@@ -35,11 +35,26 @@ import (
 	"github.com/google/gopacket/pcapgo"
 )
 
+// Packet is a packet with the time it was captured.
+type Packet struct {
+	Timestamp time.Time
+	Data      []byte
+
+	// Comment for the packet, only written to PCAP-NG files.
+	Comment string
+}
+
 // Create a 1 packet PCAP buffer.
 //
 // Give a timestamp, a packet and a linktype return a []byte buffer
 // containing a complete PCAP file.
 func CreatePcap(timestamp time.Time, packet []byte, linktype layers.LinkType) ([]byte, error) {
+	return CreatePcapFromPackets([]Packet{{Timestamp: timestamp, Data: packet}}, linktype)
+}
+
+// CreatePcapFromPackets returns a []byte buffer containing a complete
+// PCAP file with all the packets, in the order given.
+func CreatePcapFromPackets(packets []Packet, linktype layers.LinkType) ([]byte, error) {
 	var output bytes.Buffer
 	var err error
 
@@ -49,15 +64,17 @@ func CreatePcap(timestamp time.Time, packet []byte, linktype layers.LinkType) ([
 		return nil, err
 	}
 
-	captureInfo := gopacket.CaptureInfo{
-		Timestamp:     timestamp,
-		CaptureLength: len(packet),
-		Length:        len(packet),
-	}
+	for _, packet := range packets {
+		captureInfo := gopacket.CaptureInfo{
+			Timestamp:     packet.Timestamp,
+			CaptureLength: len(packet.Data),
+			Length:        len(packet.Data),
+		}
 
-	err = pcapWriter.WritePacket(captureInfo, packet)
-	if err != nil {
-		return nil, err
+		err = pcapWriter.WritePacket(captureInfo, packet.Data)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return output.Bytes(), nil
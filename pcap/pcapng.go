@@ -0,0 +1,165 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// PCAP-NG block types.
+const (
+	ngBlockTypeInterfaceDescription = 0x00000001
+	ngBlockTypeEnhancedPacket       = 0x00000006
+	ngBlockTypeSectionHeader        = 0x0A0D0D0A
+)
+
+// PCAP-NG option codes.
+const (
+	ngOptionEndOfOptions = 0
+	ngOptionComment      = 1
+	ngOptionUserAppl     = 4
+)
+
+const ngByteOrderMagic = 0x1A2B3C4D
+
+// NgWriter writes a PCAP-NG file with a single section and interface.
+// Unlike a classic PCAP file each packet can have a comment, which is
+// displayed by tools such as Wireshark.
+type NgWriter struct {
+	w io.Writer
+}
+
+// NewNgWriter writes the section header, recording the application that
+// created the file, and the interface description for the link type.
+func NewNgWriter(w io.Writer, linktype layers.LinkType, application string) (*NgWriter, error) {
+	writer := &NgWriter{w: w}
+
+	shb := &bytes.Buffer{}
+	binary.Write(shb, binary.LittleEndian, uint32(ngByteOrderMagic))
+	binary.Write(shb, binary.LittleEndian, uint16(1))
+	binary.Write(shb, binary.LittleEndian, uint16(0))
+	// Section length, unspecified.
+	binary.Write(shb, binary.LittleEndian, int64(-1))
+	if application != "" {
+		writeNgOption(shb, ngOptionUserAppl, application)
+		writeNgEndOfOptions(shb)
+	}
+	if err := writer.writeBlock(ngBlockTypeSectionHeader, shb.Bytes()); err != nil {
+		return nil, err
+	}
+
+	idb := &bytes.Buffer{}
+	binary.Write(idb, binary.LittleEndian, uint16(linktype))
+	binary.Write(idb, binary.LittleEndian, uint16(0))
+	// Snap length, 0 for no limit as packets are written whole.
+	binary.Write(idb, binary.LittleEndian, uint32(0))
+	if err := writer.writeBlock(ngBlockTypeInterfaceDescription, idb.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+// WritePacket writes a packet with an optional comment. Timestamps have
+// microsecond resolution, the default for PCAP-NG.
+func (w *NgWriter) WritePacket(timestamp time.Time, packet []byte, comment string) error {
+	epb := &bytes.Buffer{}
+	micros := uint64(timestamp.UnixNano() / int64(time.Microsecond))
+	// Interface ID.
+	binary.Write(epb, binary.LittleEndian, uint32(0))
+	binary.Write(epb, binary.LittleEndian, uint32(micros>>32))
+	binary.Write(epb, binary.LittleEndian, uint32(micros))
+	binary.Write(epb, binary.LittleEndian, uint32(len(packet)))
+	binary.Write(epb, binary.LittleEndian, uint32(len(packet)))
+	epb.Write(packet)
+	epb.Write(make([]byte, ngPadding(len(packet))))
+	if comment != "" {
+		writeNgOption(epb, ngOptionComment, comment)
+		writeNgEndOfOptions(epb)
+	}
+	return w.writeBlock(ngBlockTypeEnhancedPacket, epb.Bytes())
+}
+
+// writeBlock writes a block, the body of which must already be padded to
+// 32 bits.
+func (w *NgWriter) writeBlock(blockType uint32, body []byte) error {
+	length := uint32(len(body) + 12)
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, blockType)
+	binary.Write(buf, binary.LittleEndian, length)
+	buf.Write(body)
+	binary.Write(buf, binary.LittleEndian, length)
+	_, err := w.w.Write(buf.Bytes())
+	return err
+}
+
+func writeNgOption(buf *bytes.Buffer, code uint16, value string) {
+	// Option values are limited to 65535 bytes.
+	if len(value) > 0xffff {
+		value = value[:0xffff]
+	}
+	binary.Write(buf, binary.LittleEndian, code)
+	binary.Write(buf, binary.LittleEndian, uint16(len(value)))
+	buf.WriteString(value)
+	buf.Write(make([]byte, ngPadding(len(value))))
+}
+
+func writeNgEndOfOptions(buf *bytes.Buffer) {
+	binary.Write(buf, binary.LittleEndian, uint16(ngOptionEndOfOptions))
+	binary.Write(buf, binary.LittleEndian, uint16(0))
+}
+
+// ngPadding returns the number of bytes needed to pad length to 32 bits.
+func ngPadding(length int) int {
+	return (4 - length%4) % 4
+}
+
+// CreatePcapNgFromPackets returns a []byte buffer containing a complete
+// PCAP-NG file with all the packets, and their comments, in the order
+// given.
+func CreatePcapNgFromPackets(packets []Packet, linktype layers.LinkType, application string) ([]byte, error) {
+	var output bytes.Buffer
+
+	writer, err := NewNgWriter(&output, linktype, application)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, packet := range packets {
+		err := writer.WritePacket(packet.Timestamp, packet.Data, packet.Comment)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return output.Bytes(), nil
+}
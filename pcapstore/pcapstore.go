@@ -0,0 +1,366 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Package pcapstore indexes a directory of PCAP files, such as those
+// written by the Suricata pcap-log output, and extracts the packets of a
+// flow from them.
+package pcapstore
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/pcap"
+	"github.com/pkg/errors"
+)
+
+// Files are written to in buffered chunks, so the modification time of a
+// file may lag behind the time of the last packet written to it.
+const fileSlack = time.Minute
+
+// Packet timestamps may not exactly match the flow timestamps logged by
+// Suricata.
+const packetSlack = time.Second
+
+// The default limits on the packets extracted for a flow, so a flow
+// without an end time can't read all later files into memory.
+const (
+	DefaultMaxPackets = 100000
+	DefaultMaxBytes   = 256 * 1024 * 1024
+)
+
+var ErrNoFiles = errors.New("no pcap files cover the requested time range")
+
+var ErrFlowTooLarge = errors.New("flow exceeds the packet limit")
+
+// PcapFile is an indexed PCAP file.
+type PcapFile struct {
+	Path     string
+	LinkType layers.LinkType
+
+	// Time of the first packet.
+	Start time.Time
+
+	// Time of the last write to the file.
+	End time.Time
+
+	size int64
+}
+
+// Flow describes the packets to extract. An unset End means there is no
+// upper bound on the time range.
+type Flow struct {
+	SrcIp    net.IP
+	SrcPort  uint16
+	DestIp   net.IP
+	DestPort uint16
+	Proto    layers.IPProtocol
+	Start    time.Time
+	End      time.Time
+}
+
+type PcapStore struct {
+	directory string
+
+	// Limits on the number of packets and bytes of packet data
+	// extracted for a flow.
+	MaxPackets int
+	MaxBytes   int64
+
+	lock  sync.Mutex
+	files map[string]*PcapFile
+}
+
+func NewPcapStore(directory string) *PcapStore {
+	return &PcapStore{
+		directory:  directory,
+		MaxPackets: DefaultMaxPackets,
+		MaxBytes:   DefaultMaxBytes,
+		files:      map[string]*PcapFile{},
+	}
+}
+
+func (s *PcapStore) Directory() string {
+	return s.directory
+}
+
+// Files scans the directory, updating the index, and returns the PCAP
+// files ordered by start time. Files that are not PCAP files are ignored.
+func (s *PcapStore) Files() ([]PcapFile, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	seen := map[string]bool{}
+
+	err := filepath.Walk(s.directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		seen[path] = true
+
+		file := s.files[path]
+		if file != nil && file.size == info.Size() {
+			file.End = info.ModTime()
+			return nil
+		}
+
+		file, err = indexFile(path, info)
+		if err != nil {
+			log.Debug("Ignoring %s: %v", path, err)
+			delete(s.files, path)
+			return nil
+		}
+		s.files[path] = file
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to scan %s", s.directory)
+	}
+
+	files := []PcapFile{}
+	for path, file := range s.files {
+		if !seen[path] {
+			delete(s.files, path)
+			continue
+		}
+		files = append(files, *file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Start.Before(files[j].Start)
+	})
+
+	return files, nil
+}
+
+// indexFile reads the link type and the time of the first packet of a
+// PCAP file.
+func indexFile(path string, info os.FileInfo) (*PcapFile, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	reader, err := pcapgo.NewReader(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	_, ci, err := reader.ReadPacketData()
+	if err != nil {
+		// Includes files that have no packets yet, they will be indexed
+		// again once their size changes.
+		return nil, err
+	}
+
+	return &PcapFile{
+		Path:     path,
+		LinkType: reader.LinkType(),
+		Start:    ci.Timestamp,
+		End:      info.ModTime(),
+		size:     info.Size(),
+	}, nil
+}
+
+// FilesForRange returns the files that may contain packets between start
+// and end.
+func (s *PcapStore) FilesForRange(start time.Time, end time.Time) ([]PcapFile, error) {
+	files, err := s.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []PcapFile{}
+	for _, file := range files {
+		if !end.IsZero() && file.Start.After(end.Add(packetSlack)) {
+			continue
+		}
+		if file.End.Add(fileSlack).Before(start.Add(-packetSlack)) {
+			continue
+		}
+		matches = append(matches, file)
+	}
+	return matches, nil
+}
+
+// ExtractFlow returns the packets, in either direction, of a flow from
+// all files covering the time range of the flow. Files with a link type
+// different from the first matching file are skipped. ErrFlowTooLarge is
+// returned if the flow has more packets than the limits allow, and the
+// context error if it is done before all files are read.
+func (s *PcapStore) ExtractFlow(ctx context.Context, flow Flow) ([]pcap.Packet, layers.LinkType, error) {
+	files, err := s.FilesForRange(flow.Start, flow.End)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(files) == 0 {
+		return nil, 0, ErrNoFiles
+	}
+
+	linktype := files[0].LinkType
+	extraction := &extraction{
+		packets: []pcap.Packet{},
+	}
+
+	for _, file := range files {
+		if file.LinkType != linktype {
+			log.Warning("Skipping %s: link type %v does not match %v",
+				file.Path, file.LinkType, linktype)
+			continue
+		}
+		if err := s.extractFromFile(ctx, file.Path, flow, extraction); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	packets := extraction.packets
+	sort.SliceStable(packets, func(i, j int) bool {
+		return packets[i].Timestamp.Before(packets[j].Timestamp)
+	})
+
+	return packets, linktype, nil
+}
+
+// extraction holds the packets extracted so far for a flow.
+type extraction struct {
+	packets []pcap.Packet
+	bytes   int64
+}
+
+func (s *PcapStore) extractFromFile(ctx context.Context, path string, flow Flow, extraction *extraction) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	reader, err := pcapgo.NewReader(fd)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %s", path)
+	}
+	linktype := reader.LinkType()
+
+	start := flow.Start.Add(-packetSlack)
+	end := flow.End.Add(packetSlack)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		data, ci, err := reader.ReadPacketData()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// An unexpected EOF is a file still being written to.
+			break
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to read %s", path)
+		}
+
+		if ci.Timestamp.Before(start) {
+			continue
+		}
+		if !flow.End.IsZero() && ci.Timestamp.After(end) {
+			// Packets are written in order, so there is nothing more
+			// to find in this file.
+			break
+		}
+
+		packet := gopacket.NewPacket(data, linktype, gopacket.DecodeOptions{
+			Lazy:   true,
+			NoCopy: true,
+		})
+		if flow.matches(packet) {
+			if len(extraction.packets) >= s.MaxPackets ||
+				extraction.bytes+int64(len(data)) > s.MaxBytes {
+				return ErrFlowTooLarge
+			}
+			extraction.packets = append(extraction.packets, pcap.Packet{
+				Timestamp: ci.Timestamp,
+				Data:      data,
+			})
+			extraction.bytes += int64(len(data))
+		}
+	}
+
+	return nil
+}
+
+func (f *Flow) matches(packet gopacket.Packet) bool {
+	var srcIp, destIp net.IP
+	var proto layers.IPProtocol
+
+	switch layer := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		srcIp, destIp, proto = layer.SrcIP, layer.DstIP, layer.Protocol
+	case *layers.IPv6:
+		srcIp, destIp, proto = layer.SrcIP, layer.DstIP, layer.NextHeader
+		if packet.Layer(layers.LayerTypeICMPv6) != nil {
+			proto = layers.IPProtocolICMPv6
+		}
+	default:
+		return false
+	}
+
+	var srcPort, destPort uint16
+	switch layer := packet.TransportLayer().(type) {
+	case *layers.TCP:
+		srcPort, destPort = uint16(layer.SrcPort), uint16(layer.DstPort)
+		proto = layers.IPProtocolTCP
+	case *layers.UDP:
+		srcPort, destPort = uint16(layer.SrcPort), uint16(layer.DstPort)
+		proto = layers.IPProtocolUDP
+	case *layers.SCTP:
+		srcPort, destPort = uint16(layer.SrcPort), uint16(layer.DstPort)
+		proto = layers.IPProtocolSCTP
+	}
+
+	if proto != f.Proto {
+		return false
+	}
+
+	if srcIp.Equal(f.SrcIp) && destIp.Equal(f.DestIp) &&
+		srcPort == f.SrcPort && destPort == f.DestPort {
+		return true
+	}
+	if srcIp.Equal(f.DestIp) && destIp.Equal(f.SrcIp) &&
+		srcPort == f.DestPort && destPort == f.SrcPort {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package pcapstore
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/require"
+)
+
+func buildPacket(t *testing.T, src string, sport uint16, dst string, dport uint16) []byte {
+	ethernet := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.ParseIP(src),
+		DstIP:    net.ParseIP(dst),
+	}
+	tcp := &layers.TCP{
+		SrcPort: layers.TCPPort(sport),
+		DstPort: layers.TCPPort(dport),
+	}
+	tcp.SetNetworkLayerForChecksum(ip)
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	err := gopacket.SerializeLayers(buffer, options, ethernet, ip, tcp)
+	require.Nil(t, err)
+	return buffer.Bytes()
+}
+
+func writePcapFile(t *testing.T, filename string, timestamps []time.Time, packets [][]byte) {
+	file, err := os.Create(filename)
+	require.Nil(t, err)
+	defer file.Close()
+	writer := pcapgo.NewWriter(file)
+	require.Nil(t, writer.WriteFileHeader(0xffff, layers.LinkTypeEthernet))
+	for i, packet := range packets {
+		require.Nil(t, writer.WritePacket(gopacket.CaptureInfo{
+			Timestamp:     timestamps[i],
+			CaptureLength: len(packet),
+			Length:        len(packet),
+		}, packet))
+	}
+}
+
+func TestExtractFlow(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "evebox-pcapstore-test")
+	r.Nil(err)
+	defer os.RemoveAll(dir)
+
+	base := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	request := buildPacket(t, "10.0.0.1", 40000, "10.0.0.2", 80)
+	response := buildPacket(t, "10.0.0.2", 80, "10.0.0.1", 40000)
+	other := buildPacket(t, "10.0.0.3", 40000, "10.0.0.2", 80)
+
+	// The flow spans two files, and a third file is well outside of
+	// the flow.
+	first := path.Join(dir, "log.pcap.1")
+	writePcapFile(t, first,
+		[]time.Time{base, base.Add(time.Second), base.Add(2 * time.Second)},
+		[][]byte{request, other, response})
+	os.Chtimes(first, base.Add(10*time.Second), base.Add(10*time.Second))
+
+	second := path.Join(dir, "log.pcap.2")
+	writePcapFile(t, second,
+		[]time.Time{base.Add(11 * time.Second), base.Add(12 * time.Second)},
+		[][]byte{request, other})
+	os.Chtimes(second, base.Add(20*time.Second), base.Add(20*time.Second))
+
+	third := path.Join(dir, "log.pcap.3")
+	writePcapFile(t, third,
+		[]time.Time{base.Add(time.Hour)},
+		[][]byte{request})
+
+	// Not a pcap file, should be ignored.
+	r.Nil(ioutil.WriteFile(path.Join(dir, "README"), []byte("not a pcap"), 0644))
+
+	store := NewPcapStore(dir)
+
+	files, err := store.Files()
+	r.Nil(err)
+	r.Len(files, 3)
+	r.Equal(first, files[0].Path)
+	r.Equal(base, files[0].Start.UTC())
+
+	flow := Flow{
+		SrcIp:    net.ParseIP("10.0.0.1"),
+		SrcPort:  40000,
+		DestIp:   net.ParseIP("10.0.0.2"),
+		DestPort: 80,
+		Proto:    layers.IPProtocolTCP,
+		Start:    base,
+		End:      base.Add(12 * time.Second),
+	}
+
+	files, err = store.FilesForRange(flow.Start, flow.End)
+	r.Nil(err)
+	r.Len(files, 2)
+
+	packets, linktype, err := store.ExtractFlow(context.Background(), flow)
+	r.Nil(err)
+	r.Equal(layers.LinkTypeEthernet, linktype)
+	r.Len(packets, 3)
+	r.Equal(request, packets[0].Data)
+	r.Equal(response, packets[1].Data)
+	r.Equal(request, packets[2].Data)
+	r.True(packets[2].Timestamp.Equal(base.Add(11 * time.Second)))
+
+	// Wrong protocol.
+	flow.Proto = layers.IPProtocolUDP
+	packets, _, err = store.ExtractFlow(context.Background(), flow)
+	r.Nil(err)
+	r.Len(packets, 0)
+
+	// No files for the time range.
+	flow.Start = base.Add(-24 * time.Hour)
+	flow.End = base.Add(-23 * time.Hour)
+	_, _, err = store.ExtractFlow(context.Background(), flow)
+	r.Equal(ErrNoFiles, err)
+
+	// Without an end time, limited to the packets allowed.
+	flow.Proto = layers.IPProtocolTCP
+	flow.Start = base
+	flow.End = time.Time{}
+	packets, _, err = store.ExtractFlow(context.Background(), flow)
+	r.Nil(err)
+	r.Len(packets, 4)
+	store.MaxPackets = 3
+	_, _, err = store.ExtractFlow(context.Background(), flow)
+	r.Equal(ErrFlowTooLarge, err)
+	store.MaxPackets = DefaultMaxPackets
+	store.MaxBytes = int64(len(request) * 3)
+	_, _, err = store.ExtractFlow(context.Background(), flow)
+	r.Equal(ErrFlowTooLarge, err)
+	store.MaxBytes = DefaultMaxBytes
+
+	// Stops when the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = store.ExtractFlow(ctx, flow)
+	r.Equal(context.Canceled, err)
+
+	// Removed files are dropped from the index.
+	r.Nil(os.Remove(third))
+	files, err = store.Files()
+	r.Nil(err)
+	r.Len(files, 2)
+}
@@ -0,0 +1,71 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package postgres
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/datastoretest"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// TestConformance runs the datastore conformance suite against a managed
+// PostgreSQL instance started in a temporary directory. The tables are
+// truncated between tests.
+func TestConformance(t *testing.T) {
+	if _, err := GetVersion(); err != nil {
+		t.Skipf("PostgreSQL not available: %v", err)
+	}
+
+	r := require.New(t)
+
+	directory, err := ioutil.TempDir("", "evebox-pg-conformance")
+	r.Nil(err)
+	defer os.RemoveAll(directory)
+
+	manager, err := ConfigureManaged(directory)
+	r.Nil(err)
+	r.Nil(manager.Start())
+	defer manager.StopFast()
+
+	pgConfig, err := ManagedConfig(directory)
+	r.Nil(err)
+	pg, err := NewPgDatabase(pgConfig)
+	r.Nil(err)
+	defer pg.Close()
+	r.Nil(NewSqlMigrator(pg, "postgres").Migrate())
+
+	datastoretest.Run(t, datastoretest.Backend{
+		Setup: func(t *testing.T) (core.Datastore, func()) {
+			_, err := pg.Exec("TRUNCATE events, events_source")
+			require.Nil(t, err)
+			return NewPgDatastore(pg), func() {}
+		},
+	})
+}
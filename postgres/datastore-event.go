@@ -29,15 +29,25 @@
 package postgres
 
 import (
+	"context"
 	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/elasticsearch"
 	"github.com/jasonish/evebox/eve"
 	"github.com/jasonish/evebox/log"
 	"github.com/jasonish/evebox/util"
+	"github.com/pkg/errors"
 	"time"
 )
 
-func (d *PgDatastore) ArchiveEvent(eventId string, user core.User) error {
+func (d *PgDatastore) ArchiveEvent(ctx context.Context, eventId string, user core.User) error {
+	return d.archiveEvent(ctx, eventId, elasticsearch.HistoryEntry{
+		Action:    elasticsearch.ACTION_ARCHIVED,
+		Username:  user.Username,
+		Timestamp: eve.FormatTimestampUTC(time.Now()),
+	})
+}
+
+func (d *PgDatastore) archiveEvent(ctx context.Context, eventId string, history elasticsearch.HistoryEntry) error {
 	sqlTemplate := `update events
 set
   archived = true,
@@ -50,19 +60,21 @@ set
 where
 uuid = $1`
 
-	history := elasticsearch.HistoryEntry{
-		Action:    elasticsearch.ACTION_ARCHIVED,
-		Username:  user.Username,
-		Timestamp: eve.FormatTimestampUTC(time.Now()),
-	}
-
 	start := time.Now()
-	_, err := d.pg.Exec(sqlTemplate, eventId, util.ToJson(history))
+	_, err := d.pg.ExecContext(ctx, sqlTemplate, eventId, util.ToJson(history))
 	log.Info("Archive event took %v", time.Now().Sub(start))
 	return err
 }
 
-func (d *PgDatastore) EscalateEvent(eventId string, user core.User) error {
+func (d *PgDatastore) EscalateEvent(ctx context.Context, eventId string, user core.User) error {
+	return d.escalateEvent(ctx, eventId, elasticsearch.HistoryEntry{
+		Action:    elasticsearch.ACTION_ESCALATED,
+		Username:  user.Username,
+		Timestamp: eve.FormatTimestampUTC(time.Now()),
+	})
+}
+
+func (d *PgDatastore) escalateEvent(ctx context.Context, eventId string, history elasticsearch.HistoryEntry) error {
 	sqlTemplate := `update events
 set
   escalated = true,
@@ -76,17 +88,19 @@ where
 uuid = $1
 and escalated = false`
 
-	history := elasticsearch.HistoryEntry{
-		Action:    elasticsearch.ACTION_ESCALATED,
+	_, err := d.pg.ExecContext(ctx, sqlTemplate, eventId, util.ToJson(history))
+	return err
+}
+
+func (d *PgDatastore) DeEscalateEvent(ctx context.Context, eventId string, user core.User) error {
+	return d.deEscalateEvent(ctx, eventId, elasticsearch.HistoryEntry{
+		Action:    elasticsearch.ACTION_DEESCALATED,
 		Username:  user.Username,
 		Timestamp: eve.FormatTimestampUTC(time.Now()),
-	}
-
-	_, err := d.pg.Exec(sqlTemplate, eventId, util.ToJson(history))
-	return err
+	})
 }
 
-func (d *PgDatastore) DeEscalateEvent(eventId string, user core.User) error {
+func (d *PgDatastore) deEscalateEvent(ctx context.Context, eventId string, history elasticsearch.HistoryEntry) error {
 	sqlTemplate := `update events
 set
   escalated = false,
@@ -100,12 +114,25 @@ where
 uuid = $1
 and escalated = true`
 
-	history := elasticsearch.HistoryEntry{
-		Action:    elasticsearch.ACTION_DEESCALATED,
-		Username:  user.Username,
-		Timestamp: eve.FormatTimestampUTC(time.Now()),
-	}
-
-	_, err := d.pg.Exec(sqlTemplate, eventId, util.ToJson(history))
+	_, err := d.pg.ExecContext(ctx, sqlTemplate, eventId, util.ToJson(history))
 	return err
 }
+
+// ImportHistoryEntry applies an action from the history of an event in
+// another datastore, recording it with its original timestamp and user.
+// Only used when migrating events.
+func (d *PgDatastore) ImportHistoryEntry(ctx context.Context, eventId string, entry elasticsearch.HistoryEntry) error {
+	switch entry.Action {
+	case elasticsearch.ACTION_ARCHIVED:
+		return d.archiveEvent(ctx, eventId, entry)
+	case elasticsearch.ACTION_ESCALATED:
+		return d.escalateEvent(ctx, eventId, entry)
+	case elasticsearch.ACTION_DEESCALATED:
+		return d.deEscalateEvent(ctx, eventId, entry)
+	case elasticsearch.ACTION_TAGGED, elasticsearch.ACTION_UNTAGGED:
+		return d.updateEventTags(ctx, eventId, entry.Tags, entry)
+	case elasticsearch.ACTION_COMMENT:
+		return d.commentOnEvent(ctx, eventId, entry)
+	}
+	return errors.Errorf("unsupported history action: %s", entry.Action)
+}
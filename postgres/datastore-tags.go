@@ -0,0 +1,332 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Event tags.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/elasticsearch"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/util"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+	"strings"
+	"time"
+)
+
+// User tags are stored as an array in the tags field of the event
+// metadata. The archived and escalated tags are columns.
+
+// tagsJson returns the JSON to match events having all of the tags with
+// the @> operator.
+func tagsJson(tags []string) string {
+	return util.ToJson(map[string]interface{}{
+		"tags": tags,
+	})
+}
+
+// addTagsSql returns an expression for the metadata with the tags in the
+// JSON array argument n added.
+func addTagsSql(n int) string {
+	return fmt.Sprintf(`jsonb_set(
+    coalesce(metadata, '{}'::jsonb),
+    '{"tags"}',
+    (select coalesce(jsonb_agg(distinct tag), '[]'::jsonb)
+      from jsonb_array_elements_text(
+        coalesce(metadata->'tags', '[]'::jsonb) || $%d::jsonb) as tag)
+    )`, n)
+}
+
+// removeTagsSql returns an expression for the metadata with the tags in
+// the JSON array argument n removed.
+func removeTagsSql(n int) string {
+	return fmt.Sprintf(`jsonb_set(
+    coalesce(metadata, '{}'::jsonb),
+    '{"tags"}',
+    (select coalesce(jsonb_agg(tag), '[]'::jsonb)
+      from jsonb_array_elements_text(
+        coalesce(metadata->'tags', '[]'::jsonb)) as tag
+      where not $%d::jsonb @> to_jsonb(tag))
+    )`, n)
+}
+
+// tagsSql returns an expression for the metadata with the tags in the
+// JSON array argument n added, or removed for the untagged action, and
+// the history entry in argument h appended to its history if the tags
+// are changed.
+func tagsSql(action string, n int, h int) string {
+	set := addTagsSql(n)
+	changed := fmt.Sprintf(`exists (select 1
+      from jsonb_array_elements_text($%d::jsonb) as tag
+      where not coalesce(metadata->'tags', '[]'::jsonb) @> to_jsonb(tag))`, n)
+	if action == elasticsearch.ACTION_UNTAGGED {
+		set = removeTagsSql(n)
+		changed = fmt.Sprintf(`exists (select 1
+      from jsonb_array_elements_text(coalesce(metadata->'tags', '[]'::jsonb)) as tag
+      where $%d::jsonb @> to_jsonb(tag))`, n)
+	}
+	return fmt.Sprintf(`jsonb_set(
+    %s,
+    '{"history"}',
+    coalesce(metadata->'history', '[]'::jsonb) ||
+      case when %s then $%d::jsonb else '[]'::jsonb end
+    )`, set, changed, h)
+}
+
+// newTagsHistoryEntry returns the history entry for user tags added or
+// removed by the user.
+func newTagsHistoryEntry(action string, tags []string, user core.User) elasticsearch.HistoryEntry {
+	return elasticsearch.HistoryEntry{
+		Timestamp: eve.FormatTimestampUTC(time.Now()),
+		Username:  user.Username,
+		Action:    action,
+		Tags:      tags,
+	}
+}
+
+// tagFilters returns the filters on events matching events with all of
+// the mustHave tags and none of the mustNot tags, appending the filter
+// arguments to args.
+func tagFilters(mustHave []string, mustNot []string, args *[]interface{}) []string {
+	filters := []string{}
+	for _, tag := range mustHave {
+		switch tag {
+		case core.TAG_ARCHIVED, core.TAG_ESCALATED:
+			filters = append(filters, fmt.Sprintf("events.%s = true", tag))
+		default:
+			*args = append(*args, tagsJson([]string{tag}))
+			filters = append(filters, fmt.Sprintf(
+				"events.metadata @> $%d::jsonb", len(*args)))
+		}
+	}
+	for _, tag := range mustNot {
+		switch tag {
+		case core.TAG_ARCHIVED, core.TAG_ESCALATED:
+			filters = append(filters, fmt.Sprintf("events.%s = false", tag))
+		default:
+			*args = append(*args, tagsJson([]string{tag}))
+			filters = append(filters, fmt.Sprintf(
+				"not coalesce(events.metadata @> $%d::jsonb, false)", len(*args)))
+		}
+	}
+	return filters
+}
+
+// addEventTags adds the tags selected from the event metadata to an event.
+func addEventTags(event eve.EveEvent, rawTags sql.NullString) {
+	if !rawTags.Valid {
+		return
+	}
+	var tags []string
+	if err := json.Unmarshal([]byte(rawTags.String), &tags); err != nil {
+		log.Error("Failed to decode event tags: %v", err)
+		return
+	}
+	for _, tag := range tags {
+		event.AddTag(tag)
+	}
+}
+
+// updateEventTags adds, or removes, the tags of a single event, recording
+// the history entry if its tags are changed.
+func (d *PgDatastore) updateEventTags(ctx context.Context, eventId string, tags []string, history elasticsearch.HistoryEntry) error {
+	if err := core.ValidateTags(tags); err != nil {
+		return err
+	}
+	if _, err := uuid.FromString(eventId); err != nil {
+		return core.NewEventNotFoundError(eventId)
+	}
+	query := fmt.Sprintf("update events set metadata = %s where uuid = $2",
+		tagsSql(history.Action, 1, 3))
+	result, err := d.pg.ExecContext(ctx, query, util.ToJson(tags), eventId,
+		util.ToJson(history))
+	if err != nil {
+		return errors.Wrap(err, "update query failed")
+	}
+	if count, err := result.RowsAffected(); err == nil && count == 0 {
+		return core.NewEventNotFoundError(eventId)
+	}
+	return nil
+}
+
+func (d *PgDatastore) updateAlertGroupTags(ctx context.Context, p core.AlertGroupQueryParams, tags []string, action string, user core.User) error {
+	if err := core.ValidateTags(tags); err != nil {
+		return err
+	}
+
+	maxTime := time.Now()
+	if !p.MaxTimestamp.IsZero() {
+		maxTime = p.MaxTimestamp
+	}
+
+	sqlTemplate := `
+update events
+set
+  metadata = %s
+where
+  timestamp <= $1
+  and timestamp >= $2
+  and uuid in (
+    select uuid from events_source
+    where
+      source->>'event_type' = 'alert'
+      AND %%ALERT_GROUP%%
+      AND timestamp <= $1
+      AND timestamp >= $2
+    )
+`
+	args := []interface{}{
+		maxTime,
+		p.MinTimestamp,
+		util.ToJson(tags),
+		util.ToJson(newTagsHistoryEntry(action, tags, user)),
+	}
+	sqlTemplate = strings.Replace(fmt.Sprintf(sqlTemplate, tagsSql(action, 3, 4)),
+		"%ALERT_GROUP%", alertGroupFilters(p, &args), 1)
+
+	qstart := time.Now()
+	result, err := d.pg.ExecContext(ctx, sqlTemplate, args...)
+	if err != nil {
+		return errors.Wrap(err, "query failed")
+	}
+	count, _ := result.RowsAffected()
+	log.Debug("Updated tags on %d events in %v", count, time.Now().Sub(qstart))
+	return nil
+}
+
+// AddTagsToEvent adds user tags to a single event.
+func (d *PgDatastore) AddTagsToEvent(ctx context.Context, eventId string, tags []string, user core.User) error {
+	return d.updateEventTags(ctx, eventId, tags,
+		newTagsHistoryEntry(elasticsearch.ACTION_TAGGED, tags, user))
+}
+
+// RemoveTagsFromEvent removes user tags from a single event.
+func (d *PgDatastore) RemoveTagsFromEvent(ctx context.Context, eventId string, tags []string, user core.User) error {
+	return d.updateEventTags(ctx, eventId, tags,
+		newTagsHistoryEntry(elasticsearch.ACTION_UNTAGGED, tags, user))
+}
+
+// AddTagsToAlertGroup adds user tags to each event in the alert group.
+func (d *PgDatastore) AddTagsToAlertGroup(ctx context.Context, p core.AlertGroupQueryParams, tags []string, user core.User) error {
+	return d.updateAlertGroupTags(ctx, p, tags, elasticsearch.ACTION_TAGGED, user)
+}
+
+// RemoveTagsFromAlertGroup removes user tags from each event in the alert
+// group.
+func (d *PgDatastore) RemoveTagsFromAlertGroup(ctx context.Context, p core.AlertGroupQueryParams, tags []string, user core.User) error {
+	return d.updateAlertGroupTags(ctx, p, tags, elasticsearch.ACTION_UNTAGGED, user)
+}
+
+// TagCounts returns the number of events matching the options with each
+// tag, including the archived and escalated tags.
+func (d *PgDatastore) TagCounts(ctx context.Context, options core.EventQueryOptions) ([]core.TagCount, error) {
+	filters, err := newReportFilters(core.ReportOptions{
+		EventType:   options.EventType,
+		QueryString: options.QueryString,
+		TimeRange:   options.TimeRange,
+	})
+	if err != nil {
+		return nil, err
+	}
+	filters.joinEvents = true
+	if options.TimeRange == "" {
+		if !options.MinTs.IsZero() {
+			filters.add("events_source.timestamp >= $?::timestamptz",
+				options.MinTs)
+		}
+		if !options.MaxTs.IsZero() {
+			filters.add("events_source.timestamp <= $?::timestamptz",
+				options.MaxTs)
+		}
+	}
+
+	query := fmt.Sprintf(`select tag, count(*) from (
+  select jsonb_array_elements_text(
+    coalesce(events.metadata->'tags', '[]'::jsonb)) as tag
+  from %s where %s
+  union all
+  select 'archived' from %s where %s and events.archived = true
+  union all
+  select 'escalated' from %s where %s and events.escalated = true
+) as tags
+group by tag
+order by count(*) desc, tag`,
+		filters.from(), filters.where(),
+		filters.from(), filters.where(),
+		filters.from(), filters.where())
+
+	rows, err := d.pg.QueryContext(ctx, query, filters.args...)
+	if err != nil {
+		log.Error("query failed: %v", err)
+		return nil, errors.Wrap(err, "query failed")
+	}
+	defer rows.Close()
+
+	counts := []core.TagCount{}
+	for rows.Next() {
+		var count core.TagCount
+		if err := rows.Scan(&count.Tag, &count.Count); err != nil {
+			return nil, errors.Wrap(err, "failed to scan result")
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// tagAlertsByQuery adds the tags to the alerts matching the filters that
+// do not already have all of them, returning the number of events updated.
+func (d *PgDatastore) tagAlertsByQuery(ctx context.Context, filters *reportFilters, tags []string, user core.User) (int64, error) {
+	filters.add("not coalesce(events.metadata @> $?::jsonb, false)", tagsJson(tags))
+	filters.args = append(filters.args, util.ToJson(tags),
+		util.ToJson(newTagsHistoryEntry(elasticsearch.ACTION_TAGGED, tags, user)))
+	query := fmt.Sprintf(`update events
+set
+  metadata = %s
+where uuid in (
+  select events_source.uuid from %s where %s
+)`, tagsSql(elasticsearch.ACTION_TAGGED, len(filters.args)-1, len(filters.args)),
+		filters.from(), filters.where())
+
+	qstart := time.Now()
+	result, err := d.pg.ExecContext(ctx, query, filters.args...)
+	if err != nil {
+		return 0, errors.Wrap(err, "query failed")
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get affected row count")
+	}
+	log.Info("Tagged %d events in %v", count, time.Now().Sub(qstart))
+
+	return count, nil
+}
@@ -27,6 +27,7 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -34,7 +35,6 @@ import (
 	"github.com/jasonish/evebox/elasticsearch"
 	"github.com/jasonish/evebox/eve"
 	"github.com/jasonish/evebox/log"
-	"github.com/jasonish/evebox/sqlite"
 	"github.com/jasonish/evebox/util"
 	"github.com/pkg/errors"
 	"regexp"
@@ -57,16 +57,17 @@ func (d *PgDatastore) GetEveEventSink() core.EveEventSink {
 	return NewPgEventIndexer(d.pg)
 }
 
-func (d *PgDatastore) GetEventById(eventId string) (map[string]interface{}, error) {
+func (d *PgDatastore) GetEventById(ctx context.Context, eventId string) (map[string]interface{}, error) {
 	sqlTemplate := `
 SELECT
-  e.uuid, e.archived, e.escalated, e.metadata->>'history', s.source
+  e.uuid, e.archived, e.escalated, e.metadata->>'history', s.source,
+  e.metadata->>'tags'
 FROM
   events as e, events_source as s
 WHERE
   e.uuid = $1 AND e.uuid = s.uuid`
 	startTime := time.Now()
-	rows, err := d.pg.Query(sqlTemplate, eventId)
+	rows, err := d.pg.QueryContext(ctx, sqlTemplate, eventId)
 	if err != nil {
 		return nil, errors.Wrap(err, "query failed")
 	}
@@ -77,7 +78,9 @@ WHERE
 		var escalated bool
 		var rawHistory sql.NullString
 		var rawSource string
-		err = rows.Scan(&eventId, &archived, &escalated, &rawHistory, &rawSource)
+		var rawTags sql.NullString
+		err = rows.Scan(&eventId, &archived, &escalated, &rawHistory, &rawSource,
+			&rawTags)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to scan result")
 		}
@@ -95,6 +98,8 @@ WHERE
 			source.AddTag("escalated")
 		}
 
+		addEventTags(source, rawTags)
+
 		if rawHistory.Valid {
 			var history []interface{}
 			if err := json.Unmarshal([]byte(rawHistory.String), &history); err != nil {
@@ -114,11 +119,39 @@ WHERE
 	return nil, nil
 }
 
-func (d *PgDatastore) AlertQuery(options core.AlertQueryOptions) ([]core.AlertGroup, error) {
+// Expressions for the keys alerts can be grouped by. Events without a host
+// are grouped together under an empty host.
+var alertGroupKeyExpressions = map[string]string{
+	core.ALERT_GROUP_BY_SIGNATURE_ID: "(events_source.source->'alert'->>'signature_id')::bigint",
+	core.ALERT_GROUP_BY_SRC_IP:       "(events_source.source->>'src_ip')::inet",
+	core.ALERT_GROUP_BY_DEST_IP:      "(events_source.source->>'dest_ip')::inet",
+	core.ALERT_GROUP_BY_HOST:         "coalesce(events_source.source->>'host', '')",
+}
+
+var alertGroupKeyCasts = map[string]string{
+	core.ALERT_GROUP_BY_SIGNATURE_ID: "::bigint",
+	core.ALERT_GROUP_BY_SRC_IP:       "::inet",
+	core.ALERT_GROUP_BY_DEST_IP:      "::inet",
+}
+
+// alertGroupFilters returns the filters on events_source matching the
+// events in an alert group, appending the filter arguments to args.
+func alertGroupFilters(p core.AlertGroupQueryParams, args *[]interface{}) string {
+	filters := []string{}
+	for _, key := range p.Keys() {
+		filters = append(filters, fmt.Sprintf("%s = $%d%s",
+			alertGroupKeyExpressions[key], len(*args)+1,
+			alertGroupKeyCasts[key]))
+		*args = append(*args, p.Value(key))
+	}
+	return strings.Join(filters, " AND ")
+}
+
+func (d *PgDatastore) AlertQuery(ctx context.Context, options core.AlertQueryOptions) ([]core.AlertGroup, error) {
 	log.Info("Must have tags: %v", options.MustHaveTags)
 	log.Info("Must not have tags: %v", options.MustNotHaveTags)
 	sqlTemplate := `SELECT
-DISTINCT ON (maxts, grouped.sig_id, grouped.src_ip, grouped.dest_ip)
+DISTINCT ON (maxts, %%GROUP_COLUMNS%%)
   grouped.count as count,
   grouped.escalated_count as escalated_count,
   events.uuid as uuid,
@@ -127,7 +160,8 @@ DISTINCT ON (maxts, grouped.sig_id, grouped.src_ip, grouped.dest_ip)
   events_source.source,
   grouped.archived_count as archived_count,
   events.archived as archived,
-  metadata->>'history' as history
+  metadata->>'history' as history,
+  metadata->>'tags' as tags
 FROM (
        SELECT
          count(events_source.source -> 'alert' ->> 'signature_id')      AS count,
@@ -137,36 +171,27 @@ FROM (
            THEN 1 END)                                      AS archived_count,
          max(events.timestamp)                              AS maxts,
          min(events.timestamp)                              AS mints,
-         (events_source.source -> 'alert' ->> 'signature_id') :: BIGINT AS sig_id,
-         (events_source.source ->> 'src_ip') :: INET                    AS src_ip,
-         (events_source.source ->> 'dest_ip') :: INET                   AS dest_ip
+         %%GROUP_SELECT%%
        FROM
          events, events_source
        WHERE
          events.uuid = events_source.uuid
          AND events_source.source ->> 'event_type' = 'alert'
-         %%AND_EVENTS_ARCHIVED%%
-         %%AND_EVENTS_ESCALATED%%
+         %%AND_EVENTS_TAGS%%
          %%AND_EVENTS_SOURCE_MINTS%%
          %%AND_EVENTS_MINTS%%
          %%QUERYSTRING%%
-       GROUP BY (events_source.source -> 'alert' ->> 'signature_id') :: BIGINT,
-         (events_source.source ->> 'src_ip') :: INET,
-         (events_source.source ->> 'dest_ip') :: INET
+       GROUP BY %%GROUP_BY%%
      ) AS grouped
   JOIN events_source
     ON events_source.timestamp = grouped.maxts
        AND events_source.source ->> 'event_type' = 'alert'
-       AND (events_source.source -> 'alert' ->> 'signature_id') :: BIGINT =
-           grouped.sig_id
-       AND (events_source.source ->> 'src_ip') :: INET = grouped.src_ip
-       AND (events_source.source ->> 'dest_ip') :: INET = grouped.dest_ip
+       %%AND_GROUP_JOIN%%
        %%AND_EVENTS_SOURCE_MINTS%%
   , events
 WHERE
   events.uuid = events_source.uuid
-  %%AND_EVENTS_ARCHIVED%%
-  %%AND_EVENTS_ESCALATED%%
+  %%AND_EVENTS_TAGS%%
   %%AND_EVENTS_MINTS%%
 ORDER BY maxts DESC;
 `
@@ -174,6 +199,35 @@ ORDER BY maxts DESC;
 
 	args := []interface{}{}
 
+	groupBy := options.GroupBy
+	if len(groupBy) == 0 {
+		groupBy = core.DefaultAlertGroupBy
+	}
+	groupColumns := []string{}
+	groupSelect := []string{}
+	groupExpressions := []string{}
+	groupJoin := []string{}
+	for _, key := range groupBy {
+		expression, ok := alertGroupKeyExpressions[key]
+		if !ok {
+			return nil, errors.Errorf("invalid alert group key: %s", key)
+		}
+		groupColumns = append(groupColumns, "grouped."+key)
+		groupSelect = append(groupSelect,
+			fmt.Sprintf("%s AS %s", expression, key))
+		groupExpressions = append(groupExpressions, expression)
+		groupJoin = append(groupJoin,
+			fmt.Sprintf("AND %s = grouped.%s", expression, key))
+	}
+	sqlTemplate = strings.Replace(sqlTemplate, "%%GROUP_COLUMNS%%",
+		strings.Join(groupColumns, ", "), -1)
+	sqlTemplate = strings.Replace(sqlTemplate, "%%GROUP_SELECT%%",
+		strings.Join(groupSelect, ",\n         "), -1)
+	sqlTemplate = strings.Replace(sqlTemplate, "%%GROUP_BY%%",
+		strings.Join(groupExpressions, ", "), -1)
+	sqlTemplate = strings.Replace(sqlTemplate, "%%AND_GROUP_JOIN%%",
+		strings.Join(groupJoin, "\n       "), -1)
+
 	if options.TimeRange != "" {
 		duration, err := time.ParseDuration(options.TimeRange)
 		if err != nil {
@@ -194,32 +248,17 @@ ORDER BY maxts DESC;
 		args = append(args, minTs)
 	}
 
-	for _, tag := range options.MustNotHaveTags {
-		switch tag {
-		case "archived":
-			sqlTemplate = strings.Replace(sqlTemplate,
-				"%%AND_EVENTS_ARCHIVED%%",
-				"AND events.archived = false", -1)
-		default:
-			log.Warning("Unsupported must-not-have-tag: %s", tag)
-		}
-	}
-
-	for _, tag := range options.MustHaveTags {
-		switch tag {
-		case "escalated":
-			sqlTemplate = strings.Replace(sqlTemplate,
-				"%%AND_EVENTS_ESCALATED%%",
-				"AND events.escalated = true",
-				-1)
-		default:
-			log.Warning("Unsupported must-not-have-tag: %s", tag)
-		}
+	tags := tagFilters(options.MustHaveTags, options.MustNotHaveTags, &args)
+	if len(tags) > 0 {
+		sqlTemplate = strings.Replace(sqlTemplate, "%%AND_EVENTS_TAGS%%",
+			"AND "+strings.Join(tags, " AND "), -1)
 	}
 
 	if options.QueryString != "" {
 		filters := []string{}
-		parseQueryString(options.QueryString, &filters, &args)
+		if err := parseQueryString(options.QueryString, &filters, &args); err != nil {
+			return nil, err
+		}
 		if len(filters) > 0 {
 			where := fmt.Sprintf("AND %s", strings.Join(filters, " AND "))
 			sqlTemplate = strings.Replace(sqlTemplate, "%%QUERYSTRING%%",
@@ -232,7 +271,7 @@ ORDER BY maxts DESC;
 
 	qStart := time.Now()
 
-	rows, err := d.pg.Query(sqlTemplate, args...)
+	rows, err := d.pg.QueryContext(ctx, sqlTemplate, args...)
 	if err != nil {
 		log.Error("Alert query failed: %v", err)
 		return nil, errors.Wrap(err, "query error")
@@ -251,6 +290,7 @@ ORDER BY maxts DESC;
 		var archivedCount int64
 		var archived bool
 		var rawHistory sql.NullString
+		var rawTags sql.NullString
 		err = rows.Scan(&count,
 			&escalatedCount,
 			&eventId,
@@ -259,7 +299,8 @@ ORDER BY maxts DESC;
 			&rawSource,
 			&archivedCount,
 			&archived,
-			&rawHistory)
+			&rawHistory,
+			&rawTags)
 		if err != nil {
 			log.Error("scan: %v", err)
 			continue
@@ -287,6 +328,8 @@ ORDER BY maxts DESC;
 			source.AddTag("evebox.archived")
 		}
 
+		addEventTags(source, rawTags)
+
 		alert := core.AlertGroup{
 			Count: count,
 			Event: map[string]interface{}{
@@ -305,7 +348,7 @@ ORDER BY maxts DESC;
 	return alerts, nil
 }
 
-func (d *PgDatastore) FindFlow(flowId uint64, proto string, timestamp string,
+func (d *PgDatastore) FindFlow(ctx context.Context, flowId uint64, proto string, timestamp string,
 	srcIp string, destIp string) (interface{}, error) {
 	sqlTemplate := `select s.uuid, s.source
 from events_source as s
@@ -323,7 +366,7 @@ and cast(s.source #>> '{flow,end}' AS timestamptz) >= $5
 		return nil, errors.Wrap(err, "failed to parse timestamp")
 	}
 
-	rows, err := d.pg.Query(sqlTemplate, flowId, srcIp, destIp,
+	rows, err := d.pg.QueryContext(ctx, sqlTemplate, flowId, srcIp, destIp,
 		strings.ToLower(proto), ts)
 	if err != nil {
 		return nil, errors.Wrap(err, "query failed")
@@ -351,7 +394,103 @@ and cast(s.source #>> '{flow,end}' AS timestamptz) >= $5
 	return nil, nil
 }
 
-func (d *PgDatastore) ArchiveAlertGroup(p core.AlertGroupQueryParams, user core.User) (err error) {
+// FindNetflow finds netflow events matching the parameters in options,
+// sorted by the field named in sortBy, for example netflow.bytes.
+func (d *PgDatastore) FindNetflow(ctx context.Context, options core.EventQueryOptions, sortBy string,
+	order string) (interface{}, error) {
+
+	size := int64(10)
+	if options.Size > 0 {
+		size = options.Size
+	}
+
+	// Sorting on the jsonb value sorts numbers numerically and
+	// timestamps, as strings, chronologically.
+	orderBy := "events_source.timestamp"
+	if sortBy != "" {
+		if !aggFieldPattern.MatchString(sortBy) {
+			return nil, errors.Errorf("invalid sort field: %s", sortBy)
+		}
+		orderBy = fmt.Sprintf("events_source.source #> '{%s}'",
+			strings.Replace(sortBy, ".", ",", -1))
+	}
+
+	if order == "asc" {
+		orderBy += " ASC NULLS LAST"
+	} else {
+		orderBy += " DESC NULLS LAST"
+	}
+
+	filters, err := newReportFilters(core.ReportOptions{
+		EventType:   "netflow",
+		QueryString: options.QueryString,
+		TimeRange:   options.TimeRange,
+	})
+	if err != nil {
+		return nil, err
+	}
+	filters.joinEvents = true
+	if options.TimeRange == "" {
+		if !options.MinTs.IsZero() {
+			filters.add("events_source.timestamp >= $?::timestamptz",
+				options.MinTs)
+		}
+		if !options.MaxTs.IsZero() {
+			filters.add("events_source.timestamp <= $?::timestamptz",
+				options.MaxTs)
+		}
+	}
+
+	query := fmt.Sprintf(`select events_source.uuid, events_source.source,
+  events.archived, events.metadata->>'tags'
+from %s
+where %s
+order by %s
+limit %d`, filters.from(), filters.where(), orderBy, size)
+
+	rows, err := d.pg.QueryContext(ctx, query, filters.args...)
+	if err != nil {
+		log.Error("query failed: %v", err)
+		return nil, errors.Wrap(err, "query failed")
+	}
+	defer rows.Close()
+
+	events := []interface{}{}
+
+	for rows.Next() {
+		var eventId string
+		var rawSource string
+		var archived bool
+		var rawTags sql.NullString
+		if err := rows.Scan(&eventId, &rawSource, &archived, &rawTags); err != nil {
+			return nil, errors.Wrap(err, "failed to scan result")
+		}
+		source, err := eve.NewEveEventFromString(rawSource)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse event")
+		}
+
+		if archived {
+			source.AddTag("archived")
+			source.AddTag("evebox.archived")
+		}
+
+		addEventTags(source, rawTags)
+
+		source["@timestamp"] = source["timestamp"]
+
+		events = append(events, map[string]interface{}{
+			"_id":     eventId,
+			"_source": source,
+		})
+	}
+
+	return map[string]interface{}{
+		"data": events,
+	}, nil
+}
+
+func (d *PgDatastore) ArchiveAlertGroup(ctx context.Context, p core.AlertGroupQueryParams, user core.User) (err error) {
 	var maxTime time.Time
 	if !p.MaxTimestamp.IsZero() {
 		maxTime = p.MaxTimestamp
@@ -377,35 +516,32 @@ set
     metadata,
     '{"history"}',
     case when metadata->'history' is null then '[]'::jsonb
-      else metadata->'history' end || $6::jsonb
+      else metadata->'history' end || $3::jsonb
     )
 where
   archived = false
-  and timestamp <= $4::timestamptz
-  and timestamp >= $5::timestamptz
+  and timestamp <= $1::timestamptz
+  and timestamp >= $2::timestamptz
   and uuid in (
     select uuid from events_source
     where
       source->>'event_type' = 'alert'
-      AND (source->>'src_ip')::inet = $1::inet
-      AND (source->>'dest_ip')::inet = $2::inet
-      AND (source->'alert'->>'signature_id')::bigint = $3
-      AND timestamp <= $4::timestamptz
-      AND timestamp >= $5::timestamptz
+      AND %%ALERT_GROUP%%
+      AND timestamp <= $1::timestamptz
+      AND timestamp >= $2::timestamptz
   )
 `
 
 	args := []interface{}{
-		p.SrcIP,
-		p.DstIP,
-		p.SignatureID,
 		maxTime,
 		minTime,
 		util.ToJson(history),
 	}
+	sqlTemplate = strings.Replace(sqlTemplate, "%%ALERT_GROUP%%",
+		alertGroupFilters(p, &args), 1)
 
 	qstart := time.Now()
-	_, err = d.pg.Exec(sqlTemplate, args...)
+	_, err = d.pg.ExecContext(ctx, sqlTemplate, args...)
 	log.Info("Update time: %v", time.Now().Sub(qstart))
 	if err != nil {
 		return errors.Wrap(err, "query failed")
@@ -413,7 +549,7 @@ where
 	return nil
 }
 
-func (d *PgDatastore) EscalateAlertGroup(p core.AlertGroupQueryParams, user core.User) (err error) {
+func (d *PgDatastore) EscalateAlertGroup(ctx context.Context, p core.AlertGroupQueryParams, user core.User) (err error) {
 	var maxTime time.Time
 	if p.MaxTimestamp.IsZero() {
 		maxTime = time.Now()
@@ -434,21 +570,19 @@ set
     metadata,
     '{"history"}',
     case when metadata->'history' is null then '[]'::jsonb
-      else metadata->'history' end || $6::jsonb
+      else metadata->'history' end || $3::jsonb
     )
 where
   escalated = false
-  and timestamp <= $4
-  and timestamp >= $5
+  and timestamp <= $1
+  and timestamp >= $2
   and uuid in (
     select uuid from events_source
     where
       source->>'event_type' = 'alert'
-      AND (source->>'src_ip')::inet = $1::inet
-      AND (source->>'dest_ip')::inet = $2::inet
-      AND (source->'alert'->>'signature_id')::bigint = $3
-      AND timestamp <= $4
-      AND timestamp >= $5
+      AND %%ALERT_GROUP%%
+      AND timestamp <= $1
+      AND timestamp >= $2
     )
 `
 
@@ -458,14 +592,16 @@ where
 		Action:    elasticsearch.ACTION_ESCALATED,
 	}
 
-	qstart := time.Now()
-	_, err = d.pg.Exec(sqlTemplate,
-		p.SrcIP,
-		p.DstIP,
-		p.SignatureID,
+	args := []interface{}{
 		maxTime,
 		minTime,
-		util.ToJson(history))
+		util.ToJson(history),
+	}
+	sqlTemplate = strings.Replace(sqlTemplate, "%%ALERT_GROUP%%",
+		alertGroupFilters(p, &args), 1)
+
+	qstart := time.Now()
+	_, err = d.pg.ExecContext(ctx, sqlTemplate, args...)
 	log.Info("Update time: %v", time.Now().Sub(qstart))
 	if err != nil {
 		return errors.Wrap(err, "query failed")
@@ -473,7 +609,7 @@ where
 	return nil
 }
 
-func (d *PgDatastore) DeEscalateAlertGroup(p core.AlertGroupQueryParams, user core.User) (err error) {
+func (d *PgDatastore) DeEscalateAlertGroup(ctx context.Context, p core.AlertGroupQueryParams, user core.User) (err error) {
 	var maxTime time.Time
 	if !p.MaxTimestamp.IsZero() {
 		maxTime = p.MaxTimestamp
@@ -494,21 +630,19 @@ set
     metadata,
     '{"history"}',
     case when metadata->'history' is null then '[]'::jsonb
-      else metadata->'history' end || $6::jsonb
+      else metadata->'history' end || $3::jsonb
     )
 where
   escalated = true
-  and timestamp <= $4
-  and timestamp >= $5
+  and timestamp <= $1
+  and timestamp >= $2
   and uuid in (
     select uuid from events_source
     where
       source->>'event_type' = 'alert'
-      AND (source->>'src_ip')::inet = $1
-      AND (source->>'dest_ip')::inet = $2
-      AND (source->'alert'->>'signature_id')::bigint = $3
-      AND timestamp <= $4
-      AND timestamp >= $5
+      AND %%ALERT_GROUP%%
+      AND timestamp <= $1
+      AND timestamp >= $2
     )
 `
 
@@ -518,14 +652,16 @@ where
 		Action:    elasticsearch.ACTION_DEESCALATED,
 	}
 
-	qstart := time.Now()
-	_, err = d.pg.Exec(sqlTemplate,
-		p.SrcIP,
-		p.DstIP,
-		p.SignatureID,
+	args := []interface{}{
 		maxTime,
 		minTime,
-		util.ToJson(history))
+		util.ToJson(history),
+	}
+	sqlTemplate = strings.Replace(sqlTemplate, "%%ALERT_GROUP%%",
+		alertGroupFilters(p, &args), 1)
+
+	qstart := time.Now()
+	_, err = d.pg.ExecContext(ctx, sqlTemplate, args...)
 	log.Info("Update time: %v", time.Now().Sub(qstart))
 	if err != nil {
 		return errors.Wrap(err, "query failed")
@@ -533,70 +669,112 @@ where
 	return nil
 }
 
-func (s *PgDatastore) EventQuery(options core.EventQueryOptions) (interface{}, error) {
-	sqlTemplate := `
-select
-  events_source.uuid,
-  events_source.source,
-  events.archived
-from events_source, events
-where
-  events_source.source->>'event_type' != 'stats'
-  AND events_source.uuid = events.uuid
-  %%AND_EVENT_TYPE%%
-  %%QUERYSTRING%%
-order by events_source.timestamp %%ORDER%%
-limit 500
-	`
+// Cursor positions are the event timestamp and uuid, the uuid breaking
+// ties between events with the same timestamp.
+func encodeEventPosition(timestamp time.Time, uuid string) string {
+	return fmt.Sprintf("%s/%s", timestamp.UTC().Format(time.RFC3339Nano), uuid)
+}
 
-	args := []interface{}{}
+func decodeEventPosition(position string) (time.Time, string, error) {
+	parts := strings.SplitN(position, "/", 2)
+	if len(parts) != 2 {
+		return time.Time{}, "", errors.Errorf("invalid cursor position: %s", position)
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, "", errors.Errorf("invalid cursor position: %s", position)
+	}
+	return timestamp, parts[1], nil
+}
 
-	if options.EventType != "" {
-		sqlTemplate = strings.Replace(sqlTemplate,
-			"%%AND_EVENT_TYPE%%",
-			fmt.Sprintf("AND events_source.source->>'event_type' = $%d", len(args)+1),
-			-1)
-		args = append(args, options.EventType)
+// Counting all the events matching a loose query can mean scanning the
+// whole table, so the count stops at this limit.
+const eventCountLimit = 10000
+
+func (s *PgDatastore) EventQuery(ctx context.Context, options core.EventQueryOptions) (*core.EventQueryResult, error) {
+	pager, err := core.NewEventPager(options)
+	if err != nil {
+		return nil, err
 	}
 
-	if options.QueryString != "" {
-		filters := []string{}
-		parseQueryString(options.QueryString, &filters, &args)
-		if len(filters) > 0 {
-			where := fmt.Sprintf("AND %s", strings.Join(filters, " AND "))
-			sqlTemplate = strings.Replace(sqlTemplate, "%%QUERYSTRING%%",
-				where, -1)
-		}
+	filters, err := newReportFilters(core.ReportOptions{
+		EventType:   options.EventType,
+		QueryString: options.QueryString,
+		TimeRange:   options.TimeRange,
+	})
+	if err != nil {
+		return nil, err
+	}
+	filters.joinEvents = true
+	filters.filters = append(filters.filters,
+		"events_source.source->>'event_type' != 'stats'")
+	if !options.MinTs.IsZero() {
+		filters.add("events_source.timestamp >= $?::timestamptz",
+			options.MinTs)
+	}
+	if !options.MaxTs.IsZero() {
+		filters.add("events_source.timestamp <= $?::timestamptz",
+			options.MaxTs)
 	}
 
-	if options.Order == "asc" {
-		sqlTemplate = strings.Replace(sqlTemplate, "%%ORDER%%", "asc", -1)
-	} else {
-		sqlTemplate = strings.Replace(sqlTemplate, "%%ORDER%%", "desc", -1)
+	// The total ignores the cursor, so is counted before adding it.
+	var total int64
+	countQuery := fmt.Sprintf(`select count(*) from (
+  select 1 from %s where %s limit %d
+) as matched`, filters.from(), filters.where(), eventCountLimit)
+	if err := s.pg.QueryRowContext(ctx, countQuery, filters.args...).Scan(&total); err != nil {
+		return nil, errors.Wrap(err, "count query failed")
 	}
 
-	re := regexp.MustCompile("%%[A-Z_]+%%")
-	sqlTemplate = re.ReplaceAllLiteralString(sqlTemplate, " ")
+	if pager.Position != "" {
+		timestamp, uuid, err := decodeEventPosition(pager.Position)
+		if err != nil {
+			return nil, err
+		}
+		op := "<"
+		if pager.Ascending() {
+			op = ">"
+		}
+		filters.filters = append(filters.filters, fmt.Sprintf(
+			"(events_source.timestamp, events_source.uuid) %s ($%d::timestamptz, $%d::uuid)",
+			op, len(filters.args)+1, len(filters.args)+2))
+		filters.args = append(filters.args, timestamp, uuid)
+	}
 
-	events := []interface{}{}
+	order := "desc"
+	if pager.Ascending() {
+		order = "asc"
+	}
+
+	query := fmt.Sprintf(`select events_source.uuid, events_source.timestamp,
+  events_source.source, events.archived, events.metadata->>'tags'
+from %s
+where %s
+order by events_source.timestamp %s, events_source.uuid %s
+limit %d`, filters.from(), filters.where(), order, order, pager.Limit())
 
-	rows, err := s.pg.Query(sqlTemplate, args...)
+	rows, err := s.pg.QueryContext(ctx, query, filters.args...)
 	if err != nil {
 		log.Error("query failed: %v", err)
 		return nil, errors.Wrap(err, "query failed")
 	}
+	defer rows.Close()
+
+	events := []map[string]interface{}{}
+	positions := []string{}
+
 	for rows.Next() {
 		var eventId string
+		var timestamp time.Time
 		var rawSource string
 		var archived bool
-		if err := rows.Scan(&eventId, &rawSource, &archived); err != nil {
-			log.Error("Failed to scan raw: %v", err)
-			continue
+		var rawTags sql.NullString
+		if err := rows.Scan(&eventId, &timestamp, &rawSource, &archived, &rawTags); err != nil {
+			return nil, errors.Wrap(err, "failed to scan result")
 		}
 		source, err := eve.NewEveEventFromString(rawSource)
 		if err != nil {
-			log.Error("Failed to convert source to event: %v", source)
-			continue
+			return nil, errors.Wrap(err, "failed to parse event")
 		}
 
 		if archived {
@@ -604,15 +782,26 @@ limit 500
 			source.AddTag("evebox.archived")
 		}
 
+		addEventTags(source, rawTags)
+
 		events = append(events, map[string]interface{}{
 			"_id":     eventId,
 			"_source": source,
 		})
+		positions = append(positions, encodeEventPosition(timestamp, eventId))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "query failed")
 	}
 
-	return map[string]interface{}{
-		"data": events,
-	}, nil
+	result := pager.Result(events, positions)
+	result.Total = total
+	result.Estimated = total >= eventCountLimit
+	return result, nil
+}
+
+func (s *PgDatastore) ExportEvents(ctx context.Context, options core.EventQueryOptions, fn func(event map[string]interface{}) error) error {
+	return core.ExportEventsByCursor(ctx, s, options, fn)
 }
 
 func dumpQuery(query string, args []interface{}) {
@@ -640,70 +829,7 @@ func dumpQuery(query string, args []interface{}) {
 	log.Println(strings.Replace(query, "\n", " ", -1))
 }
 
-// Parse a query string for a PostgreSQL search.
-//
-// Supported keywords and args:
-//     src_ip:ADDR
-//     dest_ip:ADDR
-//     was:escalated
-//     is:escalated
-//     has:comment
-//     comment:STRING
-//
-// Any other key/value pair will be constructed into a query doing an 'ILIKE'
-// on the JSON field of the event.
-//
-// An argument with no key will be done with an 'ILIKE' on the text representation
-// of the JSON event.
-func parseQueryString(queryString string, filters *[]string, args *[]interface{}) {
-	parser := sqlite.NewQueryStringParser(queryString)
-	for {
-		key, val := parser.Next()
-
-		if key != "" && val != "" {
-			if key == "src_ip" {
-				filter := fmt.Sprintf("(events_source.source->>'src_ip')::inet = $%d::inet", len(*args)+1)
-				*filters = append(*filters, filter)
-				*args = append(*args, val)
-				log.Debug("Adding query filter %s with arg %v", filter, val)
-			} else if key == "dest_ip" {
-				filter := fmt.Sprintf("(events_source.source->>'dest_ip')::inet = $%d::inet", len(*args)+1)
-				*filters = append(*filters, filter)
-				*args = append(*args, val)
-				log.Debug("Adding query filter %s with arg %v", filter, val)
-			} else if key == "was" && val == "escalated" {
-				*filters = append(*filters,
-					`events.metadata->'history' @> '[{"action": "escalated"}]'::jsonb`)
-			} else if key == "is" && val == "escalated" {
-				*filters = append(*filters, `events.escalated = true`)
-			} else if key == "has" && val == "comment" {
-				*filters = append(*filters,
-					`events.metadata @> '{"history": [{"action": "comment"}]}'::jsonb`)
-			} else if key == "comment" {
-				*filters = append(*filters,
-					fmt.Sprintf(`
-					    events.metadata @> '{"history": [{"action": "comment"}]}'
-					    AND (events.metadata->'history')::text ILIKE $%d`, len(*args)+1))
-				*args = append(*args, fmt.Sprintf("%%%s%%", val))
-			} else {
-				path := strings.Replace(key, ".", ",", -1)
-				filter := fmt.Sprintf("events_source.source #>> '{%s}' ILIKE $%d", path, len(*args)+1)
-				*filters = append(*filters, filter)
-				*args = append(*args, val)
-				log.Debug("Adding query filter %s with arg %v", filter, val)
-			}
-		} else if val != "" {
-			*filters = append(*filters, fmt.Sprintf("events_source.source::text ILIKE $%d", len(*args)+1))
-			arg := fmt.Sprintf("%%%s%%", val)
-			*args = append(*args, arg)
-			log.Debug("Adding query filter %s (%v)", (*filters)[len(*filters)-1], arg)
-		} else if key == "" && val == "" {
-			break
-		}
-	}
-}
-
-func (d *PgDatastore) CommentOnAlertGroup(p core.AlertGroupQueryParams, user core.User, comment string) (err error) {
+func (d *PgDatastore) CommentOnAlertGroup(ctx context.Context, p core.AlertGroupQueryParams, user core.User, comment string) (err error) {
 
 	var maxTime time.Time
 	if !p.MaxTimestamp.IsZero() {
@@ -724,20 +850,18 @@ set
     metadata,
     '{"history"}',
     case when metadata->'history' is null then '[]'::jsonb
-      else metadata->'history' end || $6::jsonb
+      else metadata->'history' end || $3::jsonb
     )
 where
-  timestamp <= $4
-  and timestamp >= $5
+  timestamp <= $1
+  and timestamp >= $2
   and uuid in (
     select uuid from events_source
     where
       source->>'event_type' = 'alert'
-      AND (source->>'src_ip')::inet = $1
-      AND (source->>'dest_ip')::inet = $2
-      AND (source->'alert'->>'signature_id')::bigint = $3
-      AND timestamp <= $4
-      AND timestamp >= $5
+      AND %%ALERT_GROUP%%
+      AND timestamp <= $1
+      AND timestamp >= $2
     )
 `
 
@@ -748,14 +872,16 @@ where
 		Comment:   comment,
 	}
 
-	qstart := time.Now()
-	_, err = d.pg.Exec(sqlTemplate,
-		p.SrcIP,
-		p.DstIP,
-		p.SignatureID,
+	args := []interface{}{
 		maxTime,
 		minTime,
-		util.ToJson(history))
+		util.ToJson(history),
+	}
+	sqlTemplate = strings.Replace(sqlTemplate, "%%ALERT_GROUP%%",
+		alertGroupFilters(p, &args), 1)
+
+	qstart := time.Now()
+	_, err = d.pg.ExecContext(ctx, sqlTemplate, args...)
 	log.Info("Update time: %v", time.Now().Sub(qstart))
 	if err != nil {
 		return errors.Wrap(err, "query failed")
@@ -763,15 +889,16 @@ where
 	return nil
 }
 
-func (d *PgDatastore) CommentOnEventId(eventId string, user core.User, comment string) error {
-
-	history := elasticsearch.HistoryEntry{
+func (d *PgDatastore) CommentOnEventId(ctx context.Context, eventId string, user core.User, comment string) error {
+	return d.commentOnEvent(ctx, eventId, elasticsearch.HistoryEntry{
 		Timestamp: elasticsearch.FormatTimestampUTC(time.Now()),
 		Username:  user.Username,
 		Action:    elasticsearch.ACTION_COMMENT,
 		Comment:   comment,
-	}
+	})
+}
 
+func (d *PgDatastore) commentOnEvent(ctx context.Context, eventId string, history elasticsearch.HistoryEntry) error {
 	sqlTemplate := `update events
 set
   metadata = jsonb_set(
@@ -783,10 +910,105 @@ set
 where
   uuid = $2
 `
-	_, err := d.pg.Exec(sqlTemplate, util.ToJson(history), eventId)
+	_, err := d.pg.ExecContext(ctx, sqlTemplate, util.ToJson(history), eventId)
 	if err != nil {
 		return errors.Wrap(err, "update query failed")
 	}
 
 	return nil
 }
+
+// alertQueryFilters converts alert query options into filters for a query
+// against events_source joined with events.
+func alertQueryFilters(options core.AlertQueryOptions) (*reportFilters, error) {
+	filters, err := newReportFilters(core.ReportOptions{
+		EventType:   "alert",
+		QueryString: options.QueryString,
+		TimeRange:   options.TimeRange,
+	})
+	if err != nil {
+		return nil, err
+	}
+	filters.joinEvents = true
+
+	if options.TimeRange == "" {
+		if !options.MinTs.IsZero() {
+			filters.add("events_source.timestamp >= $?::timestamptz",
+				options.MinTs)
+		}
+		if !options.MaxTs.IsZero() {
+			filters.add("events_source.timestamp <= $?::timestamptz",
+				options.MaxTs)
+		}
+	}
+
+	filters.filters = append(filters.filters, tagFilters(options.MustHaveTags,
+		options.MustNotHaveTags, &filters.args)...)
+
+	return filters, nil
+}
+
+func (d *PgDatastore) UpdateAlertsByQuery(ctx context.Context, options core.AlertQueryOptions, action core.AlertAction, user core.User) (int64, error) {
+	if err := action.Validate(); err != nil {
+		return 0, err
+	}
+
+	filters, err := alertQueryFilters(options)
+	if err != nil {
+		return 0, err
+	}
+
+	history := elasticsearch.HistoryEntry{
+		Timestamp: eve.FormatTimestampUTC(time.Now()),
+		Username:  user.Username,
+	}
+
+	var set string
+	switch action.Action {
+	case core.ALERT_ACTION_ARCHIVE:
+		set = "archived = true"
+		filters.filters = append(filters.filters, "events.archived = false")
+		history.Action = elasticsearch.ACTION_ARCHIVED
+	case core.ALERT_ACTION_ESCALATE:
+		set = "escalated = true"
+		filters.filters = append(filters.filters, "events.escalated = false")
+		history.Action = elasticsearch.ACTION_ESCALATED
+	case core.ALERT_ACTION_DEESCALATE:
+		set = "escalated = false"
+		filters.filters = append(filters.filters, "events.escalated = true")
+		history.Action = elasticsearch.ACTION_DEESCALATED
+	case core.ALERT_ACTION_TAG:
+		return d.tagAlertsByQuery(ctx, filters, action.Tags, user)
+	default:
+		return 0, errors.Errorf("alert action %s not supported by this datastore",
+			action.Action)
+	}
+
+	query := fmt.Sprintf(`update events
+set
+  %s,
+  metadata = jsonb_set(
+    metadata,
+    '{"history"}',
+    case when metadata->'history' is null then '[]'::jsonb
+      else metadata->'history' end || $%d::jsonb
+    )
+where uuid in (
+  select events_source.uuid from %s where %s
+)`, set, len(filters.args)+1, filters.from(), filters.where())
+	args := append(filters.args, util.ToJson(history))
+
+	qstart := time.Now()
+	result, err := d.pg.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, "query failed")
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get affected row count")
+	}
+	log.Info("Applied action %s to %d events in %v", action.Action, count,
+		time.Now().Sub(qstart))
+
+	return count, nil
+}
@@ -27,11 +27,14 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/eve"
 	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/util"
 	"github.com/satori/go.uuid"
 )
 
@@ -48,14 +51,14 @@ func NewPgEventIndexer(pg *PgDB) *PgEventIndexer {
 	return indexer
 }
 
-func (i *PgEventIndexer) CreateTable(timestamp string) {
-	tx, err := i.pg.Begin()
+func (i *PgEventIndexer) CreateTable(ctx context.Context, timestamp string) {
+	tx, err := i.pg.BeginTx(ctx, nil)
 	if err != nil {
 		log.Warning("Failed to begin transaction to create event table %s", timestamp)
 		return
 	}
 
-	_, err = tx.Exec("select evebox_create_events_table($1)", timestamp)
+	_, err = tx.ExecContext(ctx, "select evebox_create_events_table($1)", timestamp)
 	if err != nil {
 		log.Warning("Failed to create event table %s: %v", timestamp, err)
 		tx.Rollback()
@@ -68,23 +71,28 @@ func (i *PgEventIndexer) CreateTable(timestamp string) {
 	i.tables[timestamp] = true
 }
 
-func (i *PgEventIndexer) Submit(event eve.EveEvent) error {
+func (i *PgEventIndexer) Submit(ctx context.Context, event eve.EveEvent) error {
 
 	timestamp := event.Timestamp()
 	yyyymmdd := timestamp.UTC().Format("20060102")
 
 	if !i.tables[yyyymmdd] {
-		i.CreateTable(yyyymmdd)
+		i.CreateTable(ctx, yyyymmdd)
 	}
 
 	if i.tx == nil {
 		var err error
-		i.tx, err = i.pg.Begin()
+		i.tx, err = i.pg.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
 	}
 
+	// The history, such as the archive by a suppression rule, is kept in
+	// the metadata rather than the source.
+	history := event.History()
+	delete(event, "evebox")
+
 	encoded, err := json.Marshal(event)
 	if err != nil {
 		log.Error("Failed to marshal event to JSON: %v", err)
@@ -101,15 +109,34 @@ func (i *PgEventIndexer) Submit(event eve.EveEvent) error {
 		archived = true
 	}
 
+	// Events may arrive already archived, for example by a suppression
+	// rule, or with user tags.
+	metadata := map[string]interface{}{}
+	userTags := []string{}
+	for _, tag := range event.Tags() {
+		if tag == core.TAG_ARCHIVED {
+			archived = true
+		} else if !core.IsReservedTag(tag) {
+			userTags = append(userTags, tag)
+		}
+	}
+	if len(userTags) > 0 {
+		metadata["tags"] = userTags
+	}
+	if history != nil {
+		metadata["history"] = history
+	}
+
 	eventsSql := fmt.Sprintf(`insert into events_%s
-	    (uuid, timestamp, archived)
-	    values ($1, $2, $3)`,
+	    (uuid, timestamp, archived, metadata)
+	    values ($1, $2, $3, $4)`,
 		yyyymmdd)
 
-	_, err = i.tx.Exec(eventsSql,
+	_, err = i.tx.ExecContext(ctx, eventsSql,
 		id,
 		timestamp,
-		archived)
+		archived,
+		util.ToJson(metadata))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -119,7 +146,7 @@ func (i *PgEventIndexer) Submit(event eve.EveEvent) error {
 	    values ($1, $2, $3)`,
 		yyyymmdd)
 
-	_, err = i.tx.Exec(sourceSql,
+	_, err = i.tx.ExecContext(ctx, sourceSql,
 		id,
 		timestamp,
 		encoded)
@@ -130,7 +157,7 @@ func (i *PgEventIndexer) Submit(event eve.EveEvent) error {
 	return nil
 }
 
-func (i *PgEventIndexer) Commit() (interface{}, error) {
+func (i *PgEventIndexer) Commit(ctx context.Context) (interface{}, error) {
 	err := i.tx.Commit()
 	i.tx = nil
 	return nil, err
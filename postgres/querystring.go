@@ -0,0 +1,249 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package postgres
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/querystring"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+// Parse a query string for a PostgreSQL search, adding a filter on
+// events_source joined with events to filters, and its arguments to args.
+//
+// In addition to field:value terms on the event, the following are
+// supported:
+//
+//	tags:archived, tags:escalated, tags:TAG
+//	is:archived, is:escalated
+//	was:escalated, was:archived
+//	has:comment
+//	comment:STRING
+//
+// A term with no field is done as an 'ILIKE' on the text representation
+// of the JSON event.
+func parseQueryString(queryString string, filters *[]string, args *[]interface{}) error {
+	node, err := querystring.Parse(queryString)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse query string")
+	}
+	if node == nil {
+		return nil
+	}
+	translator := &queryStringTranslator{args: *args}
+	filter, err := translator.translate(node)
+	if err != nil {
+		return err
+	}
+	*filters = append(*filters, fmt.Sprintf("(%s)", filter))
+	*args = translator.args
+	return nil
+}
+
+// addressFilter adds a filter for events where the source or destination
+// address is the address, or within the network or range of addresses.
+func addressFilter(address string, filters *[]string, args *[]interface{}) error {
+	translator := &queryStringTranslator{args: *args}
+	filter, err := translator.translate(&querystring.Term{
+		Field: "ip",
+		Value: address,
+	})
+	if err != nil {
+		return err
+	}
+	*filters = append(*filters, fmt.Sprintf("(%s)", filter))
+	*args = translator.args
+	return nil
+}
+
+// queryStringTranslator translates a parsed query string into a
+// PostgreSQL expression.
+type queryStringTranslator struct {
+	args []interface{}
+}
+
+func (t *queryStringTranslator) arg(arg interface{}) string {
+	t.args = append(t.args, arg)
+	return fmt.Sprintf("$%d", len(t.args))
+}
+
+func (t *queryStringTranslator) translate(node querystring.Node) (string, error) {
+	switch node := node.(type) {
+	case *querystring.And:
+		return t.join(node.Nodes, " AND ")
+	case *querystring.Or:
+		return t.join(node.Nodes, " OR ")
+	case *querystring.Not:
+		expr, err := t.translate(node.Node)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("NOT (%s)", expr), nil
+	case *querystring.Term:
+		return t.term(node)
+	case *querystring.Range:
+		return t.rangeExpr(node)
+	}
+	return "", errors.Errorf("unsupported query node: %v", node)
+}
+
+func (t *queryStringTranslator) join(nodes []querystring.Node, sep string) (string, error) {
+	exprs := []string{}
+	for _, node := range nodes {
+		expr, err := t.translate(node)
+		if err != nil {
+			return "", err
+		}
+		exprs = append(exprs, fmt.Sprintf("(%s)", expr))
+	}
+	return strings.Join(exprs, sep), nil
+}
+
+func (t *queryStringTranslator) ilike(expr string, value string) string {
+	return fmt.Sprintf(`%s ILIKE %s`, expr,
+		t.arg(querystring.WildcardToLike(value)))
+}
+
+func (t *queryStringTranslator) term(term *querystring.Term) (string, error) {
+	switch term.Field {
+	case "":
+		return t.ilike("events_source.source::text",
+			fmt.Sprintf("*%s*", term.Value)), nil
+	case "tags", "is":
+		switch term.Value {
+		case core.TAG_ARCHIVED, core.TAG_ESCALATED:
+			return fmt.Sprintf("events.%s = true", term.Value), nil
+		}
+		if term.Field == "is" {
+			return "", errors.Errorf("unsupported is: value: %s", term.Value)
+		}
+		return fmt.Sprintf("events.metadata @> %s::jsonb",
+			t.arg(tagsJson([]string{term.Value}))), nil
+	case "was":
+		history, err := json.Marshal([]map[string]string{
+			{"action": term.Value},
+		})
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf(`events.metadata->'history' @> %s::jsonb`,
+			t.arg(string(history))), nil
+	case "has":
+		if term.Value == "comment" {
+			return `events.metadata @> '{"history": [{"action": "comment"}]}'::jsonb`, nil
+		}
+		return "", errors.Errorf("unsupported has: value: %s", term.Value)
+	case "comment":
+		return `events.metadata @> '{"history": [{"action": "comment"}]}'::jsonb AND ` +
+			t.ilike("(events.metadata->'history')::text",
+				fmt.Sprintf("*%s*", term.Value)), nil
+	}
+
+	if querystring.IsAddressField(term.Field) && !term.Wildcard {
+		addresses, err := querystring.ParseAddressRange(term.Value)
+		if err != nil {
+			return "", err
+		}
+		return t.address(term.Field, addresses), nil
+	}
+
+	expr := t.field(term.Field)
+	if term.Wildcard {
+		return t.ilike(expr, term.Value), nil
+	}
+	return fmt.Sprintf("%s = %s", expr, t.arg(term.Value)), nil
+}
+
+// address matches events where the address field is within the range
+// using the inet operators.
+func (t *queryStringTranslator) address(field string, addresses *querystring.AddressRange) string {
+	var op string
+	if addresses.IsSingle() {
+		op = fmt.Sprintf("= %s::inet", t.arg(addresses.Start.String()))
+	} else if addresses.Network != nil {
+		op = fmt.Sprintf("<<= %s::inet", t.arg(addresses.Network.String()))
+	} else {
+		op = fmt.Sprintf("BETWEEN %s::inet AND %s::inet",
+			t.arg(addresses.Start.String()), t.arg(addresses.End.String()))
+	}
+	exprs := []string{}
+	for _, field := range querystring.AddressFields(field) {
+		exprs = append(exprs, fmt.Sprintf("(events_source.source->>'%s')::inet %s",
+			field, op))
+	}
+	return strings.Join(exprs, " OR ")
+}
+
+func (t *queryStringTranslator) rangeExpr(r *querystring.Range) (string, error) {
+	if querystring.IsAddressField(r.Field) {
+		addresses, err := r.AddressRange()
+		if err != nil {
+			return "", err
+		}
+		return t.address(r.Field, addresses), nil
+	}
+	exprs := []string{}
+	bound := func(value string, op string) {
+		// Numbers are compared as JSON numbers, anything else as text.
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			exprs = append(exprs, fmt.Sprintf("events_source.source #> '{%s}' %s to_jsonb(%s::numeric)",
+				t.path(r.Field), op, t.arg(value)))
+		} else {
+			exprs = append(exprs, fmt.Sprintf("%s %s %s", t.field(r.Field), op, t.arg(value)))
+		}
+	}
+	if r.Min != "" {
+		if r.MinInclusive {
+			bound(r.Min, ">=")
+		} else {
+			bound(r.Min, ">")
+		}
+	}
+	if r.Max != "" {
+		if r.MaxInclusive {
+			bound(r.Max, "<=")
+		} else {
+			bound(r.Max, "<")
+		}
+	}
+	if len(exprs) == 0 {
+		return fmt.Sprintf("%s IS NOT NULL", t.field(r.Field)), nil
+	}
+	return strings.Join(exprs, " AND "), nil
+}
+
+func (t *queryStringTranslator) path(field string) string {
+	return strings.Replace(field, ".", ",", -1)
+}
+
+func (t *queryStringTranslator) field(field string) string {
+	return fmt.Sprintf("events_source.source #>> '{%s}'", t.path(field))
+}
@@ -0,0 +1,363 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/log"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Fields that can be used as aggregation keys are interpolated into the
+// JSON path so they must be limited to a safe set of characters.
+var aggFieldPattern = regexp.MustCompile(`^[a-zA-Z0-9_\.]+$`)
+
+// Histogram intervals that map directly onto a date_trunc field.
+var dateTruncIntervals = map[string]bool{
+	"second": true,
+	"minute": true,
+	"hour":   true,
+	"day":    true,
+	"week":   true,
+}
+
+type ReportService struct {
+	pg *PgDB
+}
+
+func NewReportService(pg *PgDB) *ReportService {
+	return &ReportService{
+		pg: pg,
+	}
+}
+
+// reportFilters converts the report options into a list of SQL filters
+// against events_source, and the arguments for those filters.
+type reportFilters struct {
+	filters []string
+	args    []interface{}
+
+	// Set if the filters reference the events table, for example from
+	// a query string of is:escalated.
+	joinEvents bool
+}
+
+func (f *reportFilters) add(filter string, arg interface{}) {
+	f.filters = append(f.filters,
+		strings.Replace(filter, "$?", fmt.Sprintf("$%d", len(f.args)+1), -1))
+	f.args = append(f.args, arg)
+}
+
+func (f *reportFilters) from() string {
+	if f.joinEvents {
+		return "events_source, events"
+	}
+	return "events_source"
+}
+
+func (f *reportFilters) where() string {
+	filters := f.filters
+	if f.joinEvents {
+		filters = append([]string{"events.uuid = events_source.uuid"},
+			filters...)
+	}
+	if len(filters) == 0 {
+		return "true"
+	}
+	return strings.Join(filters, " AND ")
+}
+
+func newReportFilters(options core.ReportOptions) (*reportFilters, error) {
+	f := &reportFilters{}
+
+	if options.EventType != "" {
+		f.add("events_source.source->>'event_type' = $?", options.EventType)
+	}
+
+	if options.DnsType != "" {
+		f.add("events_source.source->'dns'->>'type' = $?", options.DnsType)
+	}
+
+	if options.SensorFilter != "" {
+		f.add("events_source.source->>'host' = $?", options.SensorFilter)
+	}
+
+	if options.AddressFilter != "" {
+		if strings.HasSuffix(options.AddressFilter, ".") {
+			f.add(`(events_source.source->>'src_ip' LIKE $?
+			    OR events_source.source->>'dest_ip' LIKE $?)`,
+				fmt.Sprintf("%s%%", options.AddressFilter))
+		} else if err := addressFilter(options.AddressFilter, &f.filters, &f.args); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.QueryString != "" {
+		if err := parseQueryString(options.QueryString, &f.filters, &f.args); err != nil {
+			return nil, err
+		}
+		f.joinEvents = true
+	}
+
+	if options.TimeRange != "" {
+		duration, err := time.ParseDuration(options.TimeRange)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse time range")
+		}
+		f.add("events_source.timestamp >= $?::timestamptz",
+			time.Now().Add(duration*-1))
+	}
+
+	return f, nil
+}
+
+// ReportDnsRequestRrnames returns the top requests rrnames.
+func (s *ReportService) ReportDnsRequestRrnames(ctx context.Context, options core.ReportOptions) (interface{}, error) {
+	options.EventType = "dns"
+	options.DnsType = "query"
+	data, err := s.aggregate(ctx, "dns.rrname", options)
+	if err != nil {
+		return nil, err
+	}
+	response := make([]interface{}, 0, len(data))
+	for _, entry := range data {
+		response = append(response, entry)
+	}
+	return response, nil
+}
+
+// ReportAggs returns the top values for the given field along with their
+// count in descending order.
+func (s *ReportService) ReportAggs(ctx context.Context, agg string, options core.ReportOptions) (interface{}, error) {
+	data, err := s.aggregate(ctx, agg, options)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"data": data,
+	}, nil
+}
+
+func (s *ReportService) aggregate(ctx context.Context, agg string, options core.ReportOptions) ([]map[string]interface{}, error) {
+	size := int64(10)
+	if options.Size > 0 {
+		size = options.Size
+	}
+
+	if !aggFieldPattern.MatchString(agg) {
+		return nil, errors.Errorf("invalid aggregation field: %s", agg)
+	}
+	path := strings.Replace(agg, ".", ",", -1)
+
+	filters, err := newReportFilters(options)
+	if err != nil {
+		return nil, err
+	}
+	filters.filters = append(filters.filters,
+		fmt.Sprintf("events_source.source #> '{%s}' IS NOT NULL", path))
+
+	sqlTemplate := `
+SELECT
+  events_source.source #> '{%%PATH%%}' AS key,
+  count(*) AS count
+FROM %%FROM%%
+WHERE %%WHERE%%
+GROUP BY key
+ORDER BY count DESC
+LIMIT %%SIZE%%
+`
+	sqlTemplate = strings.Replace(sqlTemplate, "%%PATH%%", path, -1)
+	sqlTemplate = strings.Replace(sqlTemplate, "%%FROM%%", filters.from(), -1)
+	sqlTemplate = strings.Replace(sqlTemplate, "%%WHERE%%", filters.where(), -1)
+	sqlTemplate = strings.Replace(sqlTemplate, "%%SIZE%%",
+		fmt.Sprintf("%d", size), -1)
+
+	qstart := time.Now()
+	rows, err := s.pg.QueryContext(ctx, sqlTemplate, filters.args...)
+	if err != nil {
+		log.Error("Report query failed: %v", err)
+		return nil, errors.Wrap(err, "query failed")
+	}
+	defer rows.Close()
+	log.Debug("Report aggregation query time: %v", time.Now().Sub(qstart))
+
+	data := []map[string]interface{}{}
+
+	for rows.Next() {
+		var rawKey []byte
+		var count int64
+		if err := rows.Scan(&rawKey, &count); err != nil {
+			return nil, errors.Wrap(err, "failed to scan result")
+		}
+
+		// The key is JSON so numbers remain numbers, and strings
+		// remain strings.
+		var key interface{}
+		decoder := json.NewDecoder(strings.NewReader(string(rawKey)))
+		decoder.UseNumber()
+		if err := decoder.Decode(&key); err != nil {
+			return nil, errors.Wrap(err, "failed to decode key")
+		}
+
+		data = append(data, map[string]interface{}{
+			"key":   key,
+			"count": count,
+		})
+	}
+
+	return data, nil
+}
+
+// bucketExpression returns an SQL expression that truncates the timestamp
+// expression to the start of its histogram bucket in UTC.
+func bucketExpression(interval string, duration time.Duration, expr string) string {
+	if dateTruncIntervals[interval] {
+		return fmt.Sprintf("date_trunc('%s', (%s) AT TIME ZONE 'UTC')",
+			interval, expr)
+	}
+	seconds := int64(duration.Seconds())
+	return fmt.Sprintf(
+		"(to_timestamp(floor(extract(epoch FROM (%s)) / %d) * %d) AT TIME ZONE 'UTC')",
+		expr, seconds, seconds)
+}
+
+// ReportHistogram returns the count of events per interval. Intervals with no
+// events are filled in with a count of 0 using generate_series.
+func (s *ReportService) ReportHistogram(ctx context.Context, interval string, options core.ReportOptions) (interface{}, error) {
+	duration, err := core.ParseHistogramInterval(interval)
+	if err != nil {
+		return nil, err
+	}
+	if duration < time.Second {
+		return nil, errors.Errorf("histogram interval too small: %s", interval)
+	}
+
+	filters, err := newReportFilters(options)
+	if err != nil {
+		return nil, err
+	}
+
+	data := []map[string]interface{}{}
+
+	var minTs time.Time
+	var maxTs time.Time
+
+	if options.TimeRange != "" {
+		timeRange, _ := time.ParseDuration(options.TimeRange)
+		maxTs = time.Now()
+		minTs = maxTs.Add(timeRange * -1)
+	} else {
+		// Without a time range the bounds come from the events.
+		var min, max pq.NullTime
+		query := fmt.Sprintf(`SELECT
+		    min(events_source.timestamp), max(events_source.timestamp)
+		    FROM %s WHERE %s`, filters.from(), filters.where())
+		if err := s.pg.QueryRowContext(ctx, query, filters.args...).Scan(&min, &max); err != nil {
+			return nil, errors.Wrap(err, "query failed")
+		}
+		if !min.Valid || !max.Valid {
+			return map[string]interface{}{
+				"data": data,
+			}, nil
+		}
+		minTs = min.Time
+		maxTs = max.Time
+	}
+
+	args := filters.args
+	minArg := fmt.Sprintf("$%d::timestamptz", len(args)+1)
+	maxArg := fmt.Sprintf("$%d::timestamptz", len(args)+2)
+	intervalArg := fmt.Sprintf("$%d::interval", len(args)+3)
+	args = append(args, minTs, maxTs,
+		fmt.Sprintf("%d seconds", int64(duration.Seconds())))
+
+	sqlTemplate := `
+SELECT
+  buckets.bucket,
+  count(filtered.uuid)
+FROM generate_series(%%MIN_BUCKET%%, %%MAX_BUCKET%%, %%INTERVAL%%)
+  AS buckets(bucket)
+  LEFT JOIN (
+    SELECT
+      events_source.uuid,
+      %%EVENT_BUCKET%% AS bucket
+    FROM %%FROM%%
+    WHERE %%WHERE%%
+      AND events_source.timestamp >= %%MIN%%
+      AND events_source.timestamp <= %%MAX%%
+  ) AS filtered
+    ON filtered.bucket = buckets.bucket
+GROUP BY buckets.bucket
+ORDER BY buckets.bucket
+`
+	replacements := []string{
+		"%%MIN_BUCKET%%", bucketExpression(interval, duration, minArg),
+		"%%MAX_BUCKET%%", bucketExpression(interval, duration, maxArg),
+		"%%EVENT_BUCKET%%", bucketExpression(interval, duration,
+			"events_source.timestamp"),
+		"%%INTERVAL%%", intervalArg,
+		"%%FROM%%", filters.from(),
+		"%%WHERE%%", filters.where(),
+		"%%MIN%%", minArg,
+		"%%MAX%%", maxArg,
+	}
+	sqlTemplate = strings.NewReplacer(replacements...).Replace(sqlTemplate)
+
+	qstart := time.Now()
+	rows, err := s.pg.QueryContext(ctx, sqlTemplate, args...)
+	if err != nil {
+		log.Error("Histogram query failed: %v", err)
+		return nil, errors.Wrap(err, "query failed")
+	}
+	defer rows.Close()
+	log.Debug("Histogram query time: %v", time.Now().Sub(qstart))
+
+	for rows.Next() {
+		var bucket time.Time
+		var count int64
+		if err := rows.Scan(&bucket, &count); err != nil {
+			return nil, errors.Wrap(err, "failed to scan result")
+		}
+		data = append(data, map[string]interface{}{
+			"key":           bucket.UnixNano() / int64(time.Millisecond),
+			"count":         count,
+			"key_as_string": eve.FormatTimestampUTC(bucket),
+		})
+	}
+
+	return map[string]interface{}{
+		"data": data,
+	}, nil
+}
@@ -0,0 +1,71 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package postgres
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestReportFilters(t *testing.T) {
+	r := require.New(t)
+
+	filters, err := newReportFilters(core.ReportOptions{
+		EventType:     "alert",
+		AddressFilter: "10.0.0.1",
+		SensorFilter:  "sensor",
+		TimeRange:     "1h",
+	})
+	r.Nil(err)
+	r.False(filters.joinEvents)
+	r.Len(filters.filters, 4)
+	r.Len(filters.args, 4)
+	r.Contains(filters.filters[2], "$3::inet")
+	r.NotContains(filters.filters[2], "$?")
+	r.Equal("events_source", filters.from())
+
+	filters, err = newReportFilters(core.ReportOptions{
+		QueryString: "is:escalated",
+	})
+	r.Nil(err)
+	r.True(filters.joinEvents)
+	r.Equal("events_source, events", filters.from())
+
+	_, err = newReportFilters(core.ReportOptions{
+		TimeRange: "bad",
+	})
+	r.NotNil(err)
+}
+
+func TestBucketExpression(t *testing.T) {
+	r := require.New(t)
+	r.Equal("date_trunc('hour', (ts) AT TIME ZONE 'UTC')",
+		bucketExpression("hour", time.Hour, "ts"))
+	r.Contains(bucketExpression("6h", time.Hour*6, "ts"), "/ 21600) * 21600")
+}
@@ -0,0 +1,175 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package querystring
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"net"
+	"strings"
+)
+
+// IsAddressField returns true if the field holds an IP address. The
+// pseudo field "ip" matches either the source or destination address.
+func IsAddressField(field string) bool {
+	switch field {
+	case "ip", "src_ip", "dest_ip":
+		return true
+	}
+	return false
+}
+
+// AddressFields returns the event fields searched for an address field.
+func AddressFields(field string) []string {
+	if field == "ip" {
+		return []string{"src_ip", "dest_ip"}
+	}
+	return []string{field}
+}
+
+// AddressRange is an inclusive range of IP addresses. IPv4 addresses are
+// in their 4 byte form.
+type AddressRange struct {
+	Start net.IP
+	End   net.IP
+
+	// Set if the range was given as a network in CIDR notation.
+	Network *net.IPNet
+}
+
+// ParseAddressRange parses a single address, a network in CIDR notation
+// such as 10.20.0.0/16 or 2001:db8::/32, or a range of addresses such as
+// 10.0.0.1-10.0.0.50.
+func ParseAddressRange(value string) (*AddressRange, error) {
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, errors.Errorf("invalid network: %s", value)
+		}
+		start := normalizeIP(network.IP)
+		end := make(net.IP, len(start))
+		for i := range start {
+			end[i] = start[i] | ^network.Mask[i]
+		}
+		return &AddressRange{Start: start, End: end, Network: network}, nil
+	}
+
+	if i := strings.Index(value, "-"); i > -1 {
+		return NewAddressRange(value[:i], value[i+1:])
+	}
+
+	ip := parseIP(value)
+	if ip == nil {
+		return nil, errors.Errorf("invalid IP address: %s", value)
+	}
+	return &AddressRange{Start: ip, End: ip}, nil
+}
+
+// NewAddressRange returns the range of addresses from start to end. An
+// empty bound is open.
+func NewAddressRange(start string, end string) (*AddressRange, error) {
+	if start == "" && end == "" {
+		return nil, errors.New("empty address range")
+	}
+	r := &AddressRange{}
+	if start != "" {
+		if r.Start = parseIP(start); r.Start == nil {
+			return nil, errors.Errorf("invalid IP address: %s", start)
+		}
+	}
+	if end != "" {
+		if r.End = parseIP(end); r.End == nil {
+			return nil, errors.Errorf("invalid IP address: %s", end)
+		}
+	}
+	if r.Start == nil {
+		r.Start = make(net.IP, len(r.End))
+	}
+	if r.End == nil {
+		r.End = make(net.IP, len(r.Start))
+		for i := range r.End {
+			r.End[i] = 0xff
+		}
+	}
+	if len(r.Start) != len(r.End) {
+		return nil, errors.Errorf("address range mixes IPv4 and IPv6: %s-%s",
+			start, end)
+	}
+	if bytes.Compare(r.Start, r.End) > 0 {
+		return nil, errors.Errorf("invalid address range: %s-%s", start, end)
+	}
+	return r, nil
+}
+
+// IsSingle returns true if the range is a single address.
+func (r *AddressRange) IsSingle() bool {
+	return r.Start.Equal(r.End)
+}
+
+// Contains returns true if the address is within the range.
+func (r *AddressRange) Contains(addr string) bool {
+	ip := parseIP(addr)
+	if ip == nil || len(ip) != len(r.Start) {
+		return false
+	}
+	return bytes.Compare(ip, r.Start) >= 0 && bytes.Compare(ip, r.End) <= 0
+}
+
+// AddressKey returns the address as a fixed length hex string that sorts
+// in address order, or an empty string if the address is not valid. IPv4
+// addresses sort before IPv6 addresses.
+func AddressKey(ip net.IP) string {
+	ip = normalizeIP(ip)
+	if ip == nil {
+		return ""
+	}
+	if len(ip) == net.IPv4len {
+		return fmt.Sprintf("4%x", []byte(ip))
+	}
+	return fmt.Sprintf("6%x", []byte(ip))
+}
+
+func parseIP(value string) net.IP {
+	return normalizeIP(net.ParseIP(value))
+}
+
+func normalizeIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
+
+// AddressRange returns the range of addresses for a range on an address
+// field. Only inclusive bounds are supported.
+func (n *Range) AddressRange() (*AddressRange, error) {
+	if (n.Min != "" && !n.MinInclusive) || (n.Max != "" && !n.MaxInclusive) {
+		return nil, errors.Errorf("exclusive address ranges are not supported: %s", n)
+	}
+	return NewAddressRange(n.Min, n.Max)
+}
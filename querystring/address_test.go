@@ -0,0 +1,87 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package querystring
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseAddressRange(t *testing.T) {
+	r, err := ParseAddressRange("10.20.0.0/16")
+	require.Nil(t, err)
+	assert.Equal(t, "10.20.0.0", r.Start.String())
+	assert.Equal(t, "10.20.255.255", r.End.String())
+	assert.NotNil(t, r.Network)
+	assert.True(t, r.Contains("10.20.1.1"))
+	assert.False(t, r.Contains("10.21.0.0"))
+
+	r, err = ParseAddressRange("2001:db8::/32")
+	require.Nil(t, err)
+	assert.Equal(t, "2001:db8::", r.Start.String())
+	assert.Equal(t, "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff", r.End.String())
+	assert.True(t, r.Contains("2001:db8::1"))
+	assert.False(t, r.Contains("10.20.0.1"))
+
+	r, err = ParseAddressRange("10.0.0.1-10.0.0.50")
+	require.Nil(t, err)
+	assert.Nil(t, r.Network)
+	assert.False(t, r.IsSingle())
+	assert.True(t, r.Contains("10.0.0.50"))
+	assert.False(t, r.Contains("10.0.0.51"))
+
+	r, err = ParseAddressRange("10.0.0.1")
+	require.Nil(t, err)
+	assert.True(t, r.IsSingle())
+
+	for _, input := range []string{"10.0.0/8", "10.0.0.50-10.0.0.1",
+		"10.0.0.1-2001:db8::1", "example.com"} {
+		_, err := ParseAddressRange(input)
+		assert.NotNil(t, err, input)
+	}
+}
+
+func TestRangeAddressRange(t *testing.T) {
+	node, err := Parse("src_ip:[10.0.0.1 TO *]")
+	require.Nil(t, err)
+	r, err := node.(*Range).AddressRange()
+	require.Nil(t, err)
+	assert.Equal(t, "255.255.255.255", r.End.String())
+
+	node, err = Parse("src_ip:{10.0.0.1 TO 10.0.0.2}")
+	require.Nil(t, err)
+	_, err = node.(*Range).AddressRange()
+	assert.NotNil(t, err)
+}
+
+func TestAddressKey(t *testing.T) {
+	assert.True(t, AddressKey(parseIP("10.0.0.2")) < AddressKey(parseIP("10.0.0.10")))
+	assert.True(t, AddressKey(parseIP("255.255.255.255")) < AddressKey(parseIP("::1")))
+	assert.Equal(t, AddressKey(parseIP("::ffff:10.0.0.1")), AddressKey(parseIP("10.0.0.1")))
+	assert.Equal(t, "", AddressKey(nil))
+}
@@ -0,0 +1,326 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package querystring
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Matcher returns true if an event, as decoded from JSON, matches the
+// query it was compiled from.
+type Matcher func(event map[string]interface{}) bool
+
+// Compile a parsed query into a Matcher for matching events in memory,
+// such as on ingest before they are stored. A nil node matches all
+// events.
+//
+// Matching follows the SQLite translation where possible. Free text is
+// a case insensitive search of all values, and as events in memory have
+// no comments, comment: and has:comment never match.
+func Compile(node Node) (Matcher, error) {
+	switch node := node.(type) {
+	case nil:
+		return func(event map[string]interface{}) bool {
+			return true
+		}, nil
+	case *And:
+		matchers, err := compileNodes(node.Nodes)
+		if err != nil {
+			return nil, err
+		}
+		return func(event map[string]interface{}) bool {
+			for _, matcher := range matchers {
+				if !matcher(event) {
+					return false
+				}
+			}
+			return true
+		}, nil
+	case *Or:
+		matchers, err := compileNodes(node.Nodes)
+		if err != nil {
+			return nil, err
+		}
+		return func(event map[string]interface{}) bool {
+			for _, matcher := range matchers {
+				if matcher(event) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case *Not:
+		matcher, err := Compile(node.Node)
+		if err != nil {
+			return nil, err
+		}
+		return func(event map[string]interface{}) bool {
+			return !matcher(event)
+		}, nil
+	case *Term:
+		return compileTerm(node)
+	case *Range:
+		return compileRange(node)
+	}
+	return nil, errors.Errorf("unsupported query node: %v", node)
+}
+
+// CompileString parses and compiles a query string.
+func CompileString(input string) (Matcher, error) {
+	node, err := Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(node)
+}
+
+func compileNodes(nodes []Node) ([]Matcher, error) {
+	matchers := []Matcher{}
+	for _, node := range nodes {
+		matcher, err := Compile(node)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
+
+func compileTerm(term *Term) (Matcher, error) {
+	switch term.Field {
+	case "":
+		if term.Wildcard {
+			pattern, err := WildcardToRegexp(fmt.Sprintf("*%s*", term.Value), true)
+			if err != nil {
+				return nil, err
+			}
+			return func(event map[string]interface{}) bool {
+				return anyValue(event, pattern.MatchString)
+			}, nil
+		}
+		value := strings.ToLower(term.Value)
+		return func(event map[string]interface{}) bool {
+			return anyValue(event, func(s string) bool {
+				return strings.Contains(strings.ToLower(s), value)
+			})
+		}, nil
+	case "tags", "is":
+		return func(event map[string]interface{}) bool {
+			return fieldMatches(event, "tags", func(s string) bool {
+				return s == term.Value
+			})
+		}, nil
+	case "has":
+		if term.Value == "comment" {
+			return never, nil
+		}
+		return nil, errors.Errorf("unsupported has: value: %s", term.Value)
+	case "comment":
+		return never, nil
+	}
+
+	if IsAddressField(term.Field) && !term.Wildcard {
+		addresses, err := ParseAddressRange(term.Value)
+		if err != nil {
+			return nil, err
+		}
+		return addressMatcher(term.Field, addresses), nil
+	}
+
+	if term.Wildcard {
+		pattern, err := WildcardToRegexp(term.Value, false)
+		if err != nil {
+			return nil, err
+		}
+		return func(event map[string]interface{}) bool {
+			return fieldMatches(event, term.Field, pattern.MatchString)
+		}, nil
+	}
+
+	if number, err := strconv.ParseFloat(term.Value, 64); err == nil {
+		return func(event map[string]interface{}) bool {
+			return fieldMatches(event, term.Field, func(s string) bool {
+				if value, err := strconv.ParseFloat(s, 64); err == nil {
+					return value == number
+				}
+				return s == term.Value
+			})
+		}, nil
+	}
+
+	return func(event map[string]interface{}) bool {
+		return fieldMatches(event, term.Field, func(s string) bool {
+			return s == term.Value
+		})
+	}, nil
+}
+
+func compileRange(r *Range) (Matcher, error) {
+	if IsAddressField(r.Field) {
+		addresses, err := r.AddressRange()
+		if err != nil {
+			return nil, err
+		}
+		return addressMatcher(r.Field, addresses), nil
+	}
+	return func(event map[string]interface{}) bool {
+		return fieldMatches(event, r.Field, func(s string) bool {
+			if r.Min != "" {
+				c := compareValues(s, r.Min)
+				if c < 0 || (c == 0 && !r.MinInclusive) {
+					return false
+				}
+			}
+			if r.Max != "" {
+				c := compareValues(s, r.Max)
+				if c > 0 || (c == 0 && !r.MaxInclusive) {
+					return false
+				}
+			}
+			return true
+		})
+	}, nil
+}
+
+func addressMatcher(field string, addresses *AddressRange) Matcher {
+	fields := AddressFields(field)
+	return func(event map[string]interface{}) bool {
+		for _, field := range fields {
+			if fieldMatches(event, field, addresses.Contains) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func never(event map[string]interface{}) bool {
+	return false
+}
+
+// compareValues compares a and b as numbers if both are numbers,
+// otherwise as strings.
+func compareValues(a string, b string) int {
+	x, err := strconv.ParseFloat(a, 64)
+	if err == nil {
+		if y, err := strconv.ParseFloat(b, 64); err == nil {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// WildcardToRegexp converts a wildcard value to an anchored regular
+// expression. Values that are not valid UTF-8 are an error.
+func WildcardToRegexp(value string, caseInsensitive bool) (*regexp.Regexp, error) {
+	replacer := strings.NewReplacer(`\*`, ".*", `\?`, ".")
+	pattern := "^" + replacer.Replace(regexp.QuoteMeta(value)) + "$"
+	if caseInsensitive {
+		pattern = "(?is)" + pattern
+	} else {
+		pattern = "(?s)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid wildcard value: %q", value)
+	}
+	return re, nil
+}
+
+// fieldMatches returns true if the value of the dotted field, or any
+// element of it if it is a list, matches.
+func fieldMatches(event map[string]interface{}, field string, match func(string) bool) bool {
+	var value interface{} = event
+	for _, key := range strings.Split(field, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		value = object[key]
+	}
+	if list, ok := value.([]interface{}); ok {
+		for _, element := range list {
+			if s, ok := scalarString(element); ok && match(s) {
+				return true
+			}
+		}
+		return false
+	}
+	s, ok := scalarString(value)
+	return ok && match(s)
+}
+
+// anyValue returns true if any scalar value in the event matches.
+func anyValue(value interface{}, match func(string) bool) bool {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, element := range value {
+			// Internal fields, such as a parsed timestamp.
+			if strings.HasPrefix(key, "__") {
+				continue
+			}
+			if anyValue(element, match) {
+				return true
+			}
+		}
+		return false
+	case []interface{}:
+		for _, element := range value {
+			if anyValue(element, match) {
+				return true
+			}
+		}
+		return false
+	}
+	s, ok := scalarString(value)
+	return ok && match(s)
+}
+
+func scalarString(value interface{}) (string, bool) {
+	switch value := value.(type) {
+	case string:
+		return value, true
+	case json.Number:
+		return value.String(), true
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(value), true
+	}
+	return "", false
+}
@@ -0,0 +1,119 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package querystring
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const matchTestEvent = `{
+  "event_type": "alert",
+  "src_ip": "10.20.1.5",
+  "src_port": 51000,
+  "dest_ip": "192.168.1.1",
+  "dest_port": 53,
+  "proto": "UDP",
+  "host": "sensor-1",
+  "tags": ["one", "two"],
+  "alert": {
+    "signature_id": 2013028,
+    "signature": "ET POLICY curl User-Agent Outbound"
+  }
+}`
+
+func match(t *testing.T, input string) bool {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(matchTestEvent)))
+	decoder.UseNumber()
+	var event map[string]interface{}
+	require.Nil(t, decoder.Decode(&event))
+	matcher, err := CompileString(input)
+	require.Nil(t, err, input)
+	return matcher(event)
+}
+
+func TestMatch(t *testing.T) {
+	assert.True(t, match(t, ""))
+
+	// Free text.
+	assert.True(t, match(t, "curl"))
+	assert.True(t, match(t, "CURL"))
+	assert.True(t, match(t, `"policy curl"`))
+	assert.True(t, match(t, "user-agent*"))
+	assert.False(t, match(t, "wget"))
+
+	// Fields.
+	assert.True(t, match(t, "alert.signature_id:2013028"))
+	assert.False(t, match(t, "alert.signature_id:2013029"))
+	assert.True(t, match(t, "host:sensor-1"))
+	assert.True(t, match(t, "host:sensor-*"))
+	assert.False(t, match(t, "host:Sensor-1"))
+	assert.False(t, match(t, "missing:value"))
+	assert.False(t, match(t, "alert.signature_id.nested:1"))
+	assert.True(t, match(t, "tags:two"))
+	assert.False(t, match(t, "tags:three"))
+	assert.False(t, match(t, "has:comment"))
+
+	// Ranges.
+	assert.True(t, match(t, "dest_port:[1 TO 1024]"))
+	assert.True(t, match(t, "dest_port:[53 TO 53]"))
+	assert.False(t, match(t, "dest_port:{53 TO 1024]"))
+	assert.True(t, match(t, "src_port:[50000 TO *]"))
+	assert.False(t, match(t, "src_port:[* TO 1024]"))
+
+	// Addresses.
+	assert.True(t, match(t, "src_ip:10.20.1.5"))
+	assert.True(t, match(t, "src_ip:10.20.0.0/16"))
+	assert.False(t, match(t, "src_ip:10.21.0.0/16"))
+	assert.True(t, match(t, "ip:192.168.1.0/24"))
+	assert.True(t, match(t, "dest_ip:[192.168.1.1 TO 192.168.1.10]"))
+	assert.True(t, match(t, "ip:10.20.1.1-10.20.1.10"))
+
+	// Boolean.
+	assert.True(t, match(t, "curl AND dest_port:53"))
+	assert.False(t, match(t, "curl AND dest_port:54"))
+	assert.True(t, match(t, "wget OR dest_port:53"))
+	assert.True(t, match(t, "NOT wget"))
+	assert.False(t, match(t, "-curl"))
+	assert.True(t, match(t, "(wget OR curl) AND proto:UDP"))
+}
+
+func TestCompileErrors(t *testing.T) {
+	_, err := CompileString("src_ip:10.0.0.0/33")
+	assert.NotNil(t, err)
+	_, err = CompileString("has:nothing")
+	assert.NotNil(t, err)
+
+	// Wildcards with invalid UTF-8.
+	_, err = CompileString("\xab*")
+	assert.NotNil(t, err)
+	_, err = CompileString("alert.signature:\xab*")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,419 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Package querystring parses the query language used to search events into
+// an abstract syntax tree that each datastore translates into its own
+// query.
+//
+// The syntax is a subset of the Lucene query syntax:
+//
+//	term                     free text search
+//	"quoted term"            free text search for a phrase
+//	field:value              field equals value
+//	field:"quoted value"
+//	field:val*               wildcard, * matches any characters, ? one
+//	field:[1 TO 1024]        inclusive range, * for an open bound
+//	field:{1 TO 1024}        exclusive range
+//	src_ip:10.20.0.0/16      address in a network, ip: matches either address
+//	ip:10.0.0.1-10.0.0.50    address in a range
+//	a AND b, a b             both must match
+//	a OR b                   either must match
+//	NOT a, -a, !a            must not match
+//	(a OR b) AND c           grouping
+package querystring
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// Node is a node in the parsed query.
+type Node interface {
+	String() string
+}
+
+// And matches if all of its nodes match.
+type And struct {
+	Nodes []Node
+}
+
+// Or matches if any of its nodes match.
+type Or struct {
+	Nodes []Node
+}
+
+// Not matches if its node does not match.
+type Not struct {
+	Node Node
+}
+
+// Term matches events where the field has the value, or if there is no
+// field, where the value is found anywhere in the event.
+type Term struct {
+	Field string
+	Value string
+
+	// Set if the value contains the wildcards * or ? and was not quoted.
+	Wildcard bool
+}
+
+// Range matches events where the field is between Min and Max. An empty
+// bound is open.
+type Range struct {
+	Field        string
+	Min          string
+	Max          string
+	MinInclusive bool
+	MaxInclusive bool
+}
+
+func (n *And) String() string {
+	return joinNodes(n.Nodes, " AND ")
+}
+
+func (n *Or) String() string {
+	return joinNodes(n.Nodes, " OR ")
+}
+
+func (n *Not) String() string {
+	return fmt.Sprintf("NOT %s", n.Node)
+}
+
+func (n *Term) String() string {
+	value := n.Value
+	if !n.Wildcard {
+		value = fmt.Sprintf("%q", n.Value)
+	}
+	if n.Field == "" {
+		return value
+	}
+	return fmt.Sprintf("%s:%s", n.Field, value)
+}
+
+func (n *Range) String() string {
+	open, close := "{", "}"
+	if n.MinInclusive {
+		open = "["
+	}
+	if n.MaxInclusive {
+		close = "]"
+	}
+	min, max := n.Min, n.Max
+	if min == "" {
+		min = "*"
+	}
+	if max == "" {
+		max = "*"
+	}
+	return fmt.Sprintf("%s:%s%s TO %s%s", n.Field, open, min, max, close)
+}
+
+func joinNodes(nodes []Node, sep string) string {
+	parts := []string{}
+	for _, node := range nodes {
+		parts = append(parts, node.String())
+	}
+	return fmt.Sprintf("(%s)", strings.Join(parts, sep))
+}
+
+// WildcardToLike converts a wildcard value to an SQL LIKE pattern using
+// \ as the escape character.
+func WildcardToLike(value string) string {
+	replacer := strings.NewReplacer(
+		`\`, `\\`,
+		"%", `\%`,
+		"_", `\_`,
+		"*", "%",
+		"?", "_",
+	)
+	return replacer.Replace(value)
+}
+
+type parser struct {
+	input string
+	pos   int
+}
+
+// Parse parses a query string. An empty query string returns a nil Node,
+// which matches everything.
+func Parse(input string) (Node, error) {
+	p := &parser{input: input}
+	p.skipSpace()
+	if p.eof() {
+		return nil, nil
+	}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.eof() {
+		return nil, errors.Errorf("unexpected %q at position %d",
+			p.input[p.pos], p.pos)
+	}
+	return node, nil
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *parser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *parser) skipSpace() {
+	for !p.eof() && isSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// isFieldChar returns true for the characters allowed in a field name.
+// These are limited as the field name may be interpolated into a query.
+func isFieldChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9') || c == '_' || c == '.' || c == '@'
+}
+
+// keyword consumes the keyword if it is next in the input and is followed
+// by white space, a parenthesis or the end of input.
+func (p *parser) keyword(keyword string) bool {
+	if !strings.HasPrefix(p.input[p.pos:], keyword) {
+		return false
+	}
+	end := p.pos + len(keyword)
+	if end < len(p.input) {
+		c := p.input[end]
+		if !isSpace(c) && c != '(' && c != ')' {
+			return false
+		}
+	}
+	p.pos = end
+	return true
+}
+
+func (p *parser) parseOr() (Node, error) {
+	nodes := []Node{}
+	for {
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		p.skipSpace()
+		if !p.keyword("OR") && !p.keyword("||") {
+			break
+		}
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return &Or{Nodes: nodes}, nil
+}
+
+func (p *parser) parseAnd() (Node, error) {
+	nodes := []Node{}
+	for {
+		p.skipSpace()
+		if p.eof() {
+			if len(nodes) == 0 {
+				return nil, errors.New("unexpected end of query")
+			}
+			break
+		}
+		if p.peek() == ')' {
+			if len(nodes) == 0 {
+				return nil, errors.Errorf("unexpected ')' at position %d", p.pos)
+			}
+			break
+		}
+		if len(nodes) > 0 {
+			start := p.pos
+			if p.keyword("OR") || p.keyword("||") {
+				p.pos = start
+				break
+			}
+			if p.keyword("AND") || p.keyword("&&") {
+				p.skipSpace()
+			}
+		}
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return &And{Nodes: nodes}, nil
+}
+
+func (p *parser) parseUnary() (Node, error) {
+	p.skipSpace()
+	if p.keyword("NOT") {
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{Node: node}, nil
+	}
+	if c := p.peek(); (c == '-' || c == '!' || c == '+') &&
+		p.pos+1 < len(p.input) && !isSpace(p.input[p.pos+1]) {
+		p.pos++
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if c == '+' {
+			return node, nil
+		}
+		return &Not{Node: node}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Node, error) {
+	if p.peek() == '(' {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.peek() != ')' {
+			return nil, errors.New("missing ')'")
+		}
+		p.pos++
+		return node, nil
+	}
+
+	if p.peek() == '"' {
+		return &Term{Value: p.quoted()}, nil
+	}
+
+	// A field name followed by a colon, otherwise a free text term.
+	start := p.pos
+	for !p.eof() && isFieldChar(p.input[p.pos]) {
+		p.pos++
+	}
+	if p.pos > start && p.peek() == ':' {
+		field := p.input[start:p.pos]
+		p.pos++
+		return p.parseValue(field)
+	}
+	p.pos = start
+
+	return newTerm("", p.word()), nil
+}
+
+func (p *parser) parseValue(field string) (Node, error) {
+	switch p.peek() {
+	case '"':
+		return &Term{Field: field, Value: p.quoted()}, nil
+	case '[', '{':
+		return p.parseRange(field)
+	}
+	value := p.word()
+	if value == "" {
+		return nil, errors.Errorf("missing value for field %s", field)
+	}
+	return newTerm(field, value), nil
+}
+
+func (p *parser) parseRange(field string) (Node, error) {
+	r := &Range{Field: field, MinInclusive: p.peek() == '['}
+	p.pos++
+
+	bound := func() string {
+		p.skipSpace()
+		start := p.pos
+		for !p.eof() && !isSpace(p.peek()) && p.peek() != ']' && p.peek() != '}' {
+			p.pos++
+		}
+		value := p.input[start:p.pos]
+		if value == "*" {
+			return ""
+		}
+		return value
+	}
+
+	r.Min = bound()
+	p.skipSpace()
+	if !p.keyword("TO") {
+		return nil, errors.Errorf("expected TO in range for field %s", field)
+	}
+	r.Max = bound()
+	p.skipSpace()
+	switch p.peek() {
+	case ']':
+		r.MaxInclusive = true
+	case '}':
+	default:
+		return nil, errors.Errorf("unterminated range for field %s", field)
+	}
+	p.pos++
+	return r, nil
+}
+
+// quoted reads a quoted string. A missing end quote is forgiven, the
+// string runs to the end of the input.
+func (p *parser) quoted() string {
+	p.pos++
+	start := p.pos
+	end := strings.IndexByte(p.input[start:], '"')
+	if end < 0 {
+		p.pos = len(p.input)
+		return p.input[start:]
+	}
+	p.pos = start + end + 1
+	return p.input[start : start+end]
+}
+
+// word reads up to the next white space or parenthesis.
+func (p *parser) word() string {
+	start := p.pos
+	for !p.eof() && !isSpace(p.peek()) && p.peek() != '(' && p.peek() != ')' {
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+func newTerm(field string, value string) *Term {
+	return &Term{
+		Field:    field,
+		Value:    value,
+		Wildcard: strings.ContainsAny(value, "*?"),
+	}
+}
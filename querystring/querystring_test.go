@@ -0,0 +1,141 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package querystring
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func parse(t *testing.T, input string) string {
+	node, err := Parse(input)
+	require.Nil(t, err, input)
+	require.NotNil(t, node, input)
+	return node.String()
+}
+
+func TestParseEmpty(t *testing.T) {
+	node, err := Parse("  ")
+	assert.Nil(t, err)
+	assert.Nil(t, node)
+}
+
+func TestParseTerms(t *testing.T) {
+	assert.Equal(t, `"one"`, parse(t, "one"))
+	assert.Equal(t, `"testing"`, parse(t, "testing "))
+	assert.Equal(t, `("one" AND "two" AND "three")`, parse(t, "one two three"))
+	assert.Equal(t, `"quoted string"`, parse(t, `"quoted string"`))
+	assert.Equal(t, `("quoted string" AND "and another one")`,
+		parse(t, `"quoted string" "and another one"`))
+
+	// A missing end quote runs to the end of the input.
+	assert.Equal(t, `"quoted string missing end"`,
+		parse(t, `"quoted string missing end`))
+
+	// Quotes only have meaning at the start of a value.
+	node, err := Parse(`justonelongstringperhapswithsome"*&specialchars`)
+	assert.Nil(t, err)
+	assert.Equal(t, &Term{
+		Value:    `justonelongstringperhapswithsome"*&specialchars`,
+		Wildcard: true,
+	}, node)
+}
+
+func TestParseFields(t *testing.T) {
+	assert.Equal(t, `key:"val"`, parse(t, "key:val"))
+	assert.Equal(t, `(key1:"val1" AND key2:"val2")`, parse(t, "key1:val1 key2:val2"))
+	assert.Equal(t, `(key1:"val1" AND key3:"this is key 3")`,
+		parse(t, `key1:val1 key3:"this is key 3"`))
+	assert.Equal(t, `alert.signature_id:"2100498"`,
+		parse(t, "alert.signature_id:2100498"))
+
+	// Only the first colon separates the field from the value.
+	assert.Equal(t, `dest_ip:"fe80::1"`, parse(t, "dest_ip:fe80::1"))
+
+	// Quoted values are never wildcards.
+	node, err := Parse(`http.url:"/a*"`)
+	assert.Nil(t, err)
+	assert.Equal(t, &Term{Field: "http.url", Value: "/a*"}, node)
+
+	node, err = Parse(`http.url:/a*`)
+	assert.Nil(t, err)
+	assert.Equal(t, &Term{Field: "http.url", Value: "/a*", Wildcard: true}, node)
+
+	_, err = Parse("key:")
+	assert.NotNil(t, err)
+}
+
+func TestParseBoolean(t *testing.T) {
+	assert.Equal(t, `(a:"1" OR b:"2")`, parse(t, "a:1 OR b:2"))
+	assert.Equal(t, `(a:"1" AND b:"2")`, parse(t, "a:1 AND b:2"))
+	assert.Equal(t, `((a:"1" AND b:"2") OR c:"3")`, parse(t, "a:1 b:2 OR c:3"))
+	assert.Equal(t, `(a:"1" AND (b:"2" OR c:"3"))`, parse(t, "a:1 AND (b:2 OR c:3)"))
+	assert.Equal(t, `(a:"1" AND NOT b:"2")`, parse(t, "a:1 NOT b:2"))
+	assert.Equal(t, `(a:"1" AND NOT b:"2")`, parse(t, "a:1 -b:2"))
+	assert.Equal(t, `NOT (a:"1" OR b:"2")`, parse(t, "!(a:1 || b:2)"))
+	assert.Equal(t, `NOT tags:"archived"`, parse(t, "-tags:archived"))
+	assert.Equal(t, `tags:"archived"`, parse(t, "+tags:archived"))
+
+	// Keywords are case sensitive, and only keywords on their own.
+	assert.Equal(t, `("a" AND "or" AND "b")`, parse(t, "a or b"))
+	assert.Equal(t, `("a" AND "ORDER")`, parse(t, "a ORDER"))
+
+	for _, input := range []string{"(a:1", "a:1)", "a OR", "()"} {
+		_, err := Parse(input)
+		assert.NotNil(t, err, input)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	node, err := Parse("dest_port:[1 TO 1024]")
+	assert.Nil(t, err)
+	assert.Equal(t, &Range{
+		Field:        "dest_port",
+		Min:          "1",
+		Max:          "1024",
+		MinInclusive: true,
+		MaxInclusive: true,
+	}, node)
+
+	node, err = Parse("flow.bytes_toserver:{1000 TO *}")
+	assert.Nil(t, err)
+	assert.Equal(t, &Range{Field: "flow.bytes_toserver", Min: "1000"}, node)
+
+	assert.Equal(t, `(dest_port:[1 TO 1024} AND proto:"TCP")`,
+		parse(t, "dest_port:[1 TO 1024} proto:TCP"))
+
+	for _, input := range []string{"a:[1 1024]", "a:[1 TO 1024"} {
+		_, err := Parse(input)
+		assert.NotNil(t, err, input)
+	}
+}
+
+func TestWildcardToLike(t *testing.T) {
+	assert.Equal(t, `%.example.com`, WildcardToLike("*.example.com"))
+	assert.Equal(t, `a\_b_\%%`, WildcardToLike("a_b?%*"))
+}
@@ -0,0 +1,28 @@
+CREATE TABLE saved_searches (
+  uuid               string UNIQUE NOT NULL,
+  owner              string NOT NULL,
+  name               string NOT NULL,
+
+  -- alerts or events.
+  type               string NOT NULL,
+
+  query_string       string,
+  time_range         string,
+
+  -- JSON arrays.
+  must_have_tags     string,
+  must_not_have_tags string,
+
+  -- Comma separated list of alert group keys.
+  group_by           string,
+
+  event_type         string,
+  shared             INTEGER NOT NULL DEFAULT 0,
+  created            TEXT NOT NULL,
+  updated            TEXT NOT NULL,
+
+  UNIQUE (owner, name)
+);
+
+CREATE INDEX saved_searches_owner_index
+  ON saved_searches (owner);
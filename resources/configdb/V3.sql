@@ -0,0 +1,59 @@
+CREATE TABLE cases (
+  uuid        string UNIQUE NOT NULL,
+  title       string NOT NULL,
+  description string,
+
+  -- open, in-progress or closed.
+  status      string NOT NULL,
+
+  -- low, medium, high or critical.
+  severity    string NOT NULL,
+
+  assignee    string,
+  owner       string NOT NULL,
+  created     TEXT NOT NULL,
+  updated     TEXT NOT NULL
+);
+
+CREATE INDEX cases_status_index
+  ON cases (status);
+
+CREATE TABLE case_events (
+  case_uuid string NOT NULL,
+  event_id  string NOT NULL,
+  username  string NOT NULL,
+  added     TEXT NOT NULL,
+
+  UNIQUE (case_uuid, event_id)
+);
+
+CREATE TABLE case_alert_groups (
+  uuid          string UNIQUE NOT NULL,
+  case_uuid     string NOT NULL,
+  signature_id  INTEGER NOT NULL,
+  src_ip        string,
+  dest_ip       string,
+  host          string,
+  min_timestamp TEXT NOT NULL,
+  max_timestamp TEXT NOT NULL,
+
+  -- Comma separated list of alert group keys.
+  group_by      string,
+
+  username      string NOT NULL,
+  added         TEXT NOT NULL
+);
+
+CREATE INDEX case_alert_groups_case_uuid_index
+  ON case_alert_groups (case_uuid);
+
+CREATE TABLE case_timeline (
+  case_uuid string NOT NULL,
+  timestamp TEXT NOT NULL,
+  username  string NOT NULL,
+  action    string NOT NULL,
+  comment   string
+);
+
+CREATE INDEX case_timeline_case_uuid_index
+  ON case_timeline (case_uuid);
@@ -0,0 +1,19 @@
+CREATE TABLE suppression_rules (
+  uuid         string UNIQUE NOT NULL,
+  name         string NOT NULL,
+  enabled      INTEGER NOT NULL DEFAULT 1,
+
+  -- Match criteria, a NULL matches anything.
+  signature_id INTEGER,
+  src_ip       string,
+  dest_ip      string,
+  src_port     INTEGER,
+  dest_port    INTEGER,
+  host         string,
+  query_string string,
+
+  comment      string,
+  owner        string NOT NULL,
+  created      TEXT NOT NULL,
+  updated      TEXT NOT NULL
+);
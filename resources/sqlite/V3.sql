@@ -0,0 +1,3 @@
+-- Event metadata such as the history of actions taken on the event.
+ALTER TABLE events
+  ADD COLUMN metadata JSON;
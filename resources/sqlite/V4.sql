@@ -0,0 +1,20 @@
+CREATE TABLE comments (
+  -- The rowid of the event the comment is on.
+  event_id  INTEGER NOT NULL,
+
+  -- Timestamp in nanoseconds since the epoch.
+  timestamp INTEGER NOT NULL,
+
+  username  TEXT,
+  comment   TEXT    NOT NULL
+);
+
+CREATE INDEX comments_event_id_index
+  ON comments (event_id);
+
+-- Remove comments along with their event, for example when purged.
+CREATE TRIGGER events_delete_comments
+AFTER DELETE ON events
+BEGIN
+  DELETE FROM comments WHERE event_id = old.rowid;
+END;
@@ -0,0 +1,18 @@
+-- User tags on events. The archived and escalated tags are columns on
+-- the events table.
+CREATE TABLE event_tags (
+  -- The rowid of the tagged event.
+  event_id INTEGER NOT NULL,
+  tag      TEXT    NOT NULL,
+  UNIQUE (event_id, tag)
+);
+
+CREATE INDEX event_tags_tag_index
+  ON event_tags (tag);
+
+-- Remove tags along with their event, for example when purged.
+CREATE TRIGGER events_delete_event_tags
+AFTER DELETE ON events
+BEGIN
+  DELETE FROM event_tags WHERE event_id = old.rowid;
+END;
@@ -30,6 +30,8 @@ import (
 	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/eve"
 	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/server/sessions"
+	"github.com/jasonish/evebox/stream"
 	"github.com/pkg/errors"
 	"net/http"
 	"strings"
@@ -56,11 +58,54 @@ import (
 //     max_ts: specify the latest timestamp for the range of the query.
 //         format: YYYY-MM-DDTHH:MM:SS.UUUUUUZ
 //                 YYYY-MM-DDTHH:MM:SS.UUUUUU-0600
+//
+//     group_by: a comma separated list of keys to group alerts by, from
+//         signature_id, src_ip, dest_ip and host. Defaults to
+//         signature_id,src_ip,dest_ip.
 func (c *ApiContext) AlertsHandler(w *ResponseWriter, r *http.Request) error {
 
+	queryString := r.FormValue("query_string")
+	if queryString == "" {
+		queryString = r.FormValue("queryString")
+	}
+
+	timeRange := r.FormValue("time_range")
+	if timeRange == "" {
+		timeRange = r.FormValue("timeRange")
+	}
+
+	options, err := newAlertQueryOptions(r.FormValue("tags"), queryString,
+		timeRange, r.FormValue("min_ts"), r.FormValue("max_ts"))
+	if err != nil {
+		return err
+	}
+
+	groupBy, err := core.ParseAlertGroupBy(r.FormValue("group_by"))
+	if err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	options.GroupBy = groupBy
+
+	alerts, err := c.appContext.DataStore.AlertQuery(r.Context(), options)
+	if err != nil {
+		return err
+	}
+
+	response := map[string]interface{}{
+		"alerts": alerts,
+	}
+
+	return w.OkJSON(response)
+}
+
+// newAlertQueryOptions builds alert query options from the tags,
+// query_string, time_range, min_ts and max_ts parameters as described for
+// AlertsHandler.
+func newAlertQueryOptions(tags string, queryString string, timeRange string,
+	minTs string, maxTs string) (core.AlertQueryOptions, error) {
+
 	options := core.AlertQueryOptions{}
 
-	tags := r.FormValue("tags")
 	if tags != "" {
 		for _, tag := range strings.Split(tags, ",") {
 			if strings.HasPrefix(tag, "-") {
@@ -72,31 +117,19 @@ func (c *ApiContext) AlertsHandler(w *ResponseWriter, r *http.Request) error {
 		}
 	}
 
-	options.QueryString = r.FormValue("query_string")
-	if options.QueryString == "" {
-		options.QueryString = r.FormValue("queryString")
-	}
+	options.QueryString = queryString
 
-	timeRange := r.FormValue("time_range")
-	if timeRange == "" {
-		timeRange = r.FormValue("timeRange")
-	}
-	minTs := r.FormValue("min_ts")
-	maxTs := r.FormValue("max_ts")
 	if timeRange != "" && (minTs != "" || maxTs != "") {
-		return newHttpErrorResponse(http.StatusBadRequest,
+		return options, newHttpErrorResponse(http.StatusBadRequest,
 			errors.Errorf("time_range not allowed with min_ts or max_ts"))
 	}
 
-	options.TimeRange = r.FormValue("time_range")
-	if options.TimeRange == "" {
-		options.TimeRange = r.FormValue("timeRange")
-	}
+	options.TimeRange = timeRange
 
 	if minTs != "" {
 		ts, err := eve.ParseTimestamp(minTs)
 		if err != nil {
-			return newHttpErrorResponse(http.StatusBadRequest,
+			return options, newHttpErrorResponse(http.StatusBadRequest,
 				errors.Errorf("Failed to parse '%s' as timestamp", minTs))
 		}
 		log.Debug("Parsed %s as %v", minTs, ts)
@@ -106,21 +139,86 @@ func (c *ApiContext) AlertsHandler(w *ResponseWriter, r *http.Request) error {
 	if maxTs != "" {
 		ts, err := eve.ParseTimestamp(maxTs)
 		if err != nil {
-			return newHttpErrorResponse(http.StatusBadRequest,
-				errors.Errorf("Failed to parse '%s' as timestamp", minTs))
+			return options, newHttpErrorResponse(http.StatusBadRequest,
+				errors.Errorf("Failed to parse '%s' as timestamp", maxTs))
 		}
-		log.Debug("Parsed %s as %v", minTs, ts)
+		log.Debug("Parsed %s as %v", maxTs, ts)
 		options.MaxTs = ts
 	}
 
-	alerts, err := c.appContext.DataStore.AlertQuery(options)
+	return options, nil
+}
+
+// AlertsActionHandler handles POST requests to /api/1/alerts/action,
+// applying an action to every alert matching a query.
+//
+// The request body is a JSON object with the following fields:
+//
+//     action: one of archive, escalate, de-escalate or tag.
+//
+//     add_tags: the list of tags to add for the tag action.
+//
+//     tags, query_string, time_range, min_ts, max_ts: select the alerts
+//         as for AlertsHandler.
+//
+// The response contains the number of events updated.
+func (c *ApiContext) AlertsActionHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+
+	var request struct {
+		Action      string   `json:"action"`
+		AddTags     []string `json:"add_tags"`
+		Tags        string   `json:"tags"`
+		QueryString string   `json:"query_string"`
+		TimeRange   string   `json:"time_range"`
+		MinTs       string   `json:"min_ts"`
+		MaxTs       string   `json:"max_ts"`
+	}
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	action := core.AlertAction{
+		Action: request.Action,
+		Tags:   request.AddTags,
+	}
+	if err := action.Validate(); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	options, err := newAlertQueryOptions(request.Tags, request.QueryString,
+		request.TimeRange, request.MinTs, request.MaxTs)
 	if err != nil {
 		return err
 	}
 
-	response := map[string]interface{}{
-		"alerts": alerts,
+	count, err := c.appContext.DataStore.UpdateAlertsByQuery(r.Context(),
+		options, action, session.User)
+	if err != nil {
+		log.Error("Failed to %s alerts by query: %v", action.Action, err)
+		return err
 	}
+	c.publishAction(r, stream.Action{
+		Action: action.Action,
+		Query: map[string]interface{}{
+			"tags":         request.Tags,
+			"query_string": request.QueryString,
+			"time_range":   request.TimeRange,
+			"min_ts":       request.MinTs,
+			"max_ts":       request.MaxTs,
+		},
+		Tags:  action.Tags,
+		Count: count,
+	})
 
-	return w.OkJSON(response)
+	// The alerts have been escalated, so only log errors.
+	if action.Action == core.ALERT_ACTION_ESCALATE {
+		if err := c.forwardEscalatedAlerts(options); err != nil {
+			log.Error("Failed to forward escalated alerts to syslog: %v", err)
+		}
+	}
+
+	return w.OkJSON(map[string]interface{}{
+		"count": count,
+	})
 }
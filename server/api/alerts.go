@@ -31,6 +31,7 @@ import (
 	"github.com/jasonish/evebox/eve"
 	"github.com/jasonish/evebox/log"
 	"github.com/jasonish/evebox/server/sessions"
+	"github.com/jasonish/evebox/stream"
 	"github.com/pkg/errors"
 	"net/http"
 )
@@ -39,8 +40,13 @@ type AlertGroupQueryParameters struct {
 	SignatureId  uint64 `json:"signature_id"`
 	SrcIp        string `json:"src_ip"`
 	DestIp       string `json:"dest_ip"`
+	Host         string `json:"host"`
 	MinTimestamp string `json:"min_timestamp"`
 	MaxTimestamp string `json:"max_timestamp"`
+
+	// The group_by the alert group was queried with, a comma separated
+	// list of keys.
+	GroupBy string `json:"group_by"`
 }
 
 func (a *AlertGroupQueryParameters) ToCoreAlertGroupQueryParams() (core.AlertGroupQueryParams, error) {
@@ -66,6 +72,13 @@ func (a *AlertGroupQueryParameters) ToCoreAlertGroupQueryParams() (core.AlertGro
 	params.SignatureID = a.SignatureId
 	params.SrcIP = a.SrcIp
 	params.DstIP = a.DestIp
+	params.Host = a.Host
+
+	groupBy, err := core.ParseAlertGroupBy(a.GroupBy)
+	if err != nil {
+		return params, err
+	}
+	params.GroupBy = groupBy
 
 	return params, nil
 }
@@ -84,11 +97,15 @@ func (c *ApiContext) AlertGroupArchiveHandler(w *ResponseWriter, r *http.Request
 		return errors.WithStack(err)
 	}
 
-	err = c.appContext.DataStore.ArchiveAlertGroup(params, session.User)
+	err = c.appContext.DataStore.ArchiveAlertGroup(r.Context(), params, session.User)
 	if err != nil {
 		log.Error("%v", err)
 		return err
 	}
+	c.publishAction(r, stream.Action{
+		Action:     core.ALERT_ACTION_ARCHIVE,
+		AlertGroup: request,
+	})
 	return w.Ok()
 }
 
@@ -105,10 +122,20 @@ func (c *ApiContext) EscalateAlertGroupHandler(w *ResponseWriter, r *http.Reques
 		return errors.WithStack(err)
 	}
 
-	if err := c.appContext.DataStore.EscalateAlertGroup(params, session.User); err != nil {
+	if err := c.appContext.DataStore.EscalateAlertGroup(r.Context(), params, session.User); err != nil {
 		log.Error("%v", err)
 		return errors.WithStack(err)
 	}
+	c.publishAction(r, stream.Action{
+		Action:     core.ALERT_ACTION_ESCALATE,
+		AlertGroup: request,
+	})
+
+	// The alert group has been escalated, so only log errors.
+	if err := c.forwardEscalatedAlertGroup(params); err != nil {
+		log.Error("Failed to forward escalated alert group to syslog: %v", err)
+	}
+
 	return w.Ok()
 }
 
@@ -125,9 +152,13 @@ func (c *ApiContext) DeEscalateAlertGroupHandler(w *ResponseWriter, r *http.Requ
 		return errors.WithStack(err)
 	}
 
-	if err := c.appContext.DataStore.DeEscalateAlertGroup(params, session.User); err != nil {
+	if err := c.appContext.DataStore.DeEscalateAlertGroup(r.Context(), params, session.User); err != nil {
 		log.Error("%v", err)
 		return errors.WithStack(err)
 	}
+	c.publishAction(r, stream.Action{
+		Action:     core.ALERT_ACTION_DEESCALATE,
+		AlertGroup: request,
+	})
 	return w.Ok()
 }
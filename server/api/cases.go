@@ -0,0 +1,336 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/server/sessions"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+// CaseRequest is the body of requests to create and update a case. When
+// updating, fields that are not set are left unchanged.
+type CaseRequest struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Status      *string `json:"status"`
+	Severity    *string `json:"severity"`
+	Assignee    *string `json:"assignee"`
+}
+
+func (r *CaseRequest) apply(c *core.Case) {
+	if r.Title != nil {
+		c.Title = *r.Title
+	}
+	if r.Description != nil {
+		c.Description = *r.Description
+	}
+	if r.Status != nil {
+		c.Status = *r.Status
+	}
+	if r.Severity != nil {
+		c.Severity = *r.Severity
+	}
+	if r.Assignee != nil {
+		c.Assignee = *r.Assignee
+	}
+}
+
+// CasesHandler handles GET requests to /api/1/cases, returning the cases
+// optionally limited by the status and assignee parameters.
+func (c *ApiContext) CasesHandler(w *ResponseWriter, r *http.Request) error {
+	cases, err := c.appContext.CaseStore.Find(core.CaseQuery{
+		Status:   r.FormValue("status"),
+		Assignee: r.FormValue("assignee"),
+	})
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(map[string]interface{}{
+		"cases": cases,
+	})
+}
+
+// AddCaseHandler handles POST requests to /api/1/cases. The status
+// defaults to open and the severity to medium, the owner will be the
+// current user.
+func (c *ApiContext) AddCaseHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+
+	var request CaseRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	newCase := core.Case{
+		Status:   core.CASE_STATUS_OPEN,
+		Severity: core.CASE_SEVERITY_MEDIUM,
+		Owner:    session.Username(),
+	}
+	request.apply(&newCase)
+	if err := newCase.Validate(); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	id, err := c.appContext.CaseStore.Add(newCase)
+	if err != nil {
+		log.Error("Failed to add case: %v", err)
+		return err
+	}
+
+	newCase, err = c.appContext.CaseStore.FindById(id)
+	if err != nil {
+		return err
+	}
+	return w.StatusJSON(http.StatusCreated, newCase)
+}
+
+func (c *ApiContext) GetCaseHandler(w *ResponseWriter, r *http.Request) error {
+	found, err := c.findCase(r)
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(found)
+}
+
+// UpdateCaseHandler handles PUT requests to /api/1/cases/{id}.
+func (c *ApiContext) UpdateCaseHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+
+	existing, err := c.findCase(r)
+	if err != nil {
+		return err
+	}
+
+	var request CaseRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	request.apply(&existing)
+	if err := existing.Validate(); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	if err := c.appContext.CaseStore.Update(existing, session.Username()); err != nil {
+		log.Error("Failed to update case %s: %v", existing.Id, err)
+		return err
+	}
+
+	return c.caseResponse(w, existing.Id)
+}
+
+// DeleteCaseHandler handles DELETE requests to /api/1/cases/{id}. Only
+// the owner can delete a case.
+func (c *ApiContext) DeleteCaseHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+
+	found, err := c.findCase(r)
+	if err != nil {
+		return err
+	}
+	if found.Owner != session.Username() {
+		return newHttpErrorResponse(http.StatusForbidden,
+			errors.New("case is owned by another user"))
+	}
+	if err := c.appContext.CaseStore.Delete(found.Id); err != nil {
+		return err
+	}
+	return w.Ok()
+}
+
+// AddCaseEventHandler handles POST requests to /api/1/cases/{id}/events,
+// attaching the event with the event_id in the request body.
+func (c *ApiContext) AddCaseEventHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+
+	found, err := c.findCase(r)
+	if err != nil {
+		return err
+	}
+
+	var request struct {
+		EventId string `json:"event_id"`
+	}
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	event, err := c.appContext.DataStore.GetEventById(r.Context(), request.EventId)
+	if err != nil {
+		return err
+	}
+	if event == nil {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.Errorf("no event with ID %s", request.EventId))
+	}
+
+	if err := c.appContext.CaseStore.AddEvent(found.Id, request.EventId,
+		session.Username()); err != nil {
+		return err
+	}
+
+	return c.caseResponse(w, found.Id)
+}
+
+// RemoveCaseEventHandler handles DELETE requests to
+// /api/1/cases/{id}/events/{eventId}.
+func (c *ApiContext) RemoveCaseEventHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+
+	found, err := c.findCase(r)
+	if err != nil {
+		return err
+	}
+	eventId := mux.Vars(r)["eventId"]
+	if err := c.appContext.CaseStore.RemoveEvent(found.Id, eventId,
+		session.Username()); err != nil {
+		return newHttpErrorResponse(http.StatusNotFound, err)
+	}
+
+	return c.caseResponse(w, found.Id)
+}
+
+// CaseEventsHandler handles GET requests to /api/1/cases/{id}/events,
+// returning the events attached to a case. Events no longer in the
+// datastore, for example if purged, are skipped.
+func (c *ApiContext) CaseEventsHandler(w *ResponseWriter, r *http.Request) error {
+	found, err := c.findCase(r)
+	if err != nil {
+		return err
+	}
+
+	events := []interface{}{}
+	for _, caseEvent := range found.Events {
+		event, err := c.appContext.DataStore.GetEventById(r.Context(),
+			caseEvent.EventId)
+		if err != nil {
+			return err
+		}
+		if event != nil {
+			events = append(events, event)
+		}
+	}
+
+	return w.OkJSON(map[string]interface{}{
+		"data": events,
+	})
+}
+
+// AddCaseAlertGroupHandler handles POST requests to
+// /api/1/cases/{id}/alert-groups. The body contains the alert_group in the
+// same form as the other alert group requests.
+func (c *ApiContext) AddCaseAlertGroupHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+
+	found, err := c.findCase(r)
+	if err != nil {
+		return err
+	}
+
+	var request struct {
+		AlertGroup AlertGroupQueryParameters `json:"alert_group"`
+	}
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	params, err := request.AlertGroup.ToCoreAlertGroupQueryParams()
+	if err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	if _, err := c.appContext.CaseStore.AddAlertGroup(found.Id,
+		core.NewCaseAlertGroup(params), session.Username()); err != nil {
+		return err
+	}
+
+	return c.caseResponse(w, found.Id)
+}
+
+// RemoveCaseAlertGroupHandler handles DELETE requests to
+// /api/1/cases/{id}/alert-groups/{groupId}.
+func (c *ApiContext) RemoveCaseAlertGroupHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+
+	found, err := c.findCase(r)
+	if err != nil {
+		return err
+	}
+	groupId := mux.Vars(r)["groupId"]
+	if err := c.appContext.CaseStore.RemoveAlertGroup(found.Id, groupId,
+		session.Username()); err != nil {
+		return newHttpErrorResponse(http.StatusNotFound, err)
+	}
+
+	return c.caseResponse(w, found.Id)
+}
+
+// CommentOnCaseHandler handles POST requests to
+// /api/1/cases/{id}/comments.
+func (c *ApiContext) CommentOnCaseHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+
+	found, err := c.findCase(r)
+	if err != nil {
+		return err
+	}
+
+	var request CommentOnEventIdRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	if request.Comment == "" {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.New("empty comment"))
+	}
+
+	if err := c.appContext.CaseStore.AddComment(found.Id, session.Username(),
+		request.Comment); err != nil {
+		return err
+	}
+
+	return c.caseResponse(w, found.Id)
+}
+
+// findCase returns the case for the id in the request path.
+func (c *ApiContext) findCase(r *http.Request) (core.Case, error) {
+	id := mux.Vars(r)["id"]
+	found, err := c.appContext.CaseStore.FindById(id)
+	if err == core.ErrCaseNotFound {
+		return found, httpNotFoundResponse("No case with ID " + id)
+	}
+	return found, err
+}
+
+// caseResponse responds with the current state of a case.
+func (c *ApiContext) caseResponse(w *ResponseWriter, id string) error {
+	found, err := c.appContext.CaseStore.FindById(id)
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(found)
+}
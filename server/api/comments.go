@@ -52,7 +52,7 @@ func (c *ApiContext) CommentOnAlertGroupHandler(w *ResponseWriter, r *http.Reque
 		return errors.WithStack(err)
 	}
 
-	err = c.appContext.DataStore.CommentOnAlertGroup(params, session.User, request.Comment)
+	err = c.appContext.DataStore.CommentOnAlertGroup(r.Context(), params, session.User, request.Comment)
 	if err != nil {
 		log.Error("%v", err)
 		return errors.WithStack(err)
@@ -78,7 +78,7 @@ func (c *ApiContext) CommentOnEventHandler(w *ResponseWriter, r *http.Request) e
 
 	log.Info("Got comment on event %s comment from user %s", eventId, session.Username())
 
-	if err := c.appContext.DataStore.CommentOnEventId(eventId, session.User, request.Comment); err != nil {
+	if err := c.appContext.DataStore.CommentOnEventId(r.Context(), eventId, session.User, request.Comment); err != nil {
 		log.Error("%v", err)
 		return errors.WithStack(err)
 	}
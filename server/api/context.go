@@ -27,14 +27,18 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/jasonish/evebox/appcontext"
 	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
 	"github.com/jasonish/evebox/server/auth"
 	"github.com/jasonish/evebox/server/router"
 	"github.com/jasonish/evebox/server/sessions"
 	"github.com/pkg/errors"
 	"net/http"
+	"time"
 )
 
 type ApiError struct {
@@ -76,13 +80,37 @@ func newHttpErrorResponse(statusCode int, err error) *httpErrorResponse {
 
 type apiHandlerFunc func(w *ResponseWriter, r *http.Request) error
 
-func apiFuncWrapper(handler apiHandlerFunc) http.Handler {
+// apiFuncWrapper converts an apiHandlerFunc to an http.Handler, encoding any
+// error returned as JSON. If timeout is non-zero the request context will be
+// cancelled after the timeout and a 504 returned.
+func apiFuncWrapper(handler apiHandlerFunc, timeout time.Duration) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if timeout > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+
 		err := handler(NewResponseWriter(w), r)
 		if err == nil {
 			return
 		}
 
+		switch r.Context().Err() {
+		case context.Canceled:
+			// The client went away, there is nobody to respond to.
+			log.Debug("Request %s cancelled: %v", r.URL.Path, err)
+			return
+		case context.DeadlineExceeded:
+			log.Warning("Request %s timed out after %v: %v",
+				r.URL.Path, timeout, err)
+			err = ApiError{
+				Status: http.StatusGatewayTimeout,
+				Message: fmt.Sprintf("Request timed out after %v",
+					timeout),
+			}
+		}
+
 		w.Header().Set("content-type", "application/json")
 		encoder := json.NewEncoder(w)
 		status := http.StatusInternalServerError
@@ -112,19 +140,28 @@ func apiFuncWrapper(handler apiHandlerFunc) http.Handler {
 // apiRouter wraps the provided router with some helper functions for
 // registering API handlers of type apiHandlerFunc.
 type apiRouter struct {
-	router *router.Router
+	router  *router.Router
+	timeout time.Duration
 }
 
 func (r *apiRouter) GET(path string, handler apiHandlerFunc) {
-	r.router.GET(path, apiFuncWrapper(handler))
+	r.router.GET(path, apiFuncWrapper(handler, r.timeout))
 }
 
 func (r *apiRouter) POST(path string, handler apiHandlerFunc) {
-	r.router.POST(path, apiFuncWrapper(handler))
+	r.router.POST(path, apiFuncWrapper(handler, r.timeout))
+}
+
+func (r *apiRouter) PUT(path string, handler apiHandlerFunc) {
+	r.router.PUT(path, apiFuncWrapper(handler, r.timeout))
+}
+
+func (r *apiRouter) DELETE(path string, handler apiHandlerFunc) {
+	r.router.DELETE(path, apiFuncWrapper(handler, r.timeout))
 }
 
 func (r *apiRouter) OPTIONS(path string, handler apiHandlerFunc) {
-	r.router.OPTIONS(path, apiFuncWrapper(handler))
+	r.router.OPTIONS(path, apiFuncWrapper(handler, r.timeout))
 }
 
 type ApiContext struct {
@@ -143,7 +180,7 @@ func NewApiContext(appContext *appcontext.AppContext,
 }
 
 func (c *ApiContext) InitRoutes(router *router.Router) {
-	r := apiRouter{router}
+	r := apiRouter{router, c.appContext.Config.Http.QueryTimeout}
 
 	r.POST("/login", c.LoginHandler)
 	r.OPTIONS("/login", c.LoginOptions)
@@ -154,17 +191,25 @@ func (c *ApiContext) InitRoutes(router *router.Router) {
 	r.POST("/alert-group/star", c.EscalateAlertGroupHandler)
 	r.POST("/alert-group/unstar", c.DeEscalateAlertGroupHandler)
 	r.POST("/alert-group/comment", c.CommentOnAlertGroupHandler)
+	r.POST("/alert-group/tag", c.TagAlertGroupHandler)
+	r.POST("/alert-group/untag", c.UntagAlertGroupHandler)
+	r.POST("/alerts/action", c.AlertsActionHandler)
 
 	r.GET("/version", c.VersionHandler)
 	r.POST("/submit", c.SubmitHandler)
 	r.POST("/eve2pcap", c.Eve2PcapHandler)
+	r.POST("/pcap", c.PcapHandler)
+	r.POST("/pcap/flow", c.PcapFlowHandler)
 	r.POST("/query", c.QueryHandler)
 	r.GET("/config", c.ConfigHandler)
 	r.POST("/event/{id}/archive", c.ArchiveEventHandler)
 	r.POST("/event/{id}/escalate", c.EscalateEventHandler)
 	r.POST("/event/{id}/de-escalate", c.DeEscalateEventHandler)
 	r.POST("/event/{id}/comment", c.CommentOnEventHandler)
+	r.POST("/event/{id}/tag", c.TagEventHandler)
+	r.POST("/event/{id}/untag", c.UntagEventHandler)
 	r.GET("/event/{id}", c.GetEventByIdHandler)
+	r.GET("/event/{id}/history", c.GetEventHistoryHandler)
 	r.GET("/event-query", c.EventQueryHandler)
 	r.GET("/report/dns/requests/rrnames", c.ReportDnsRequestRrnames)
 	r.POST("/report/dns/requests/rrnames", c.ReportDnsRequestRrnames)
@@ -172,6 +217,42 @@ func (c *ApiContext) InitRoutes(router *router.Router) {
 	r.GET("/report/agg", c.ReportAggs)
 	r.GET("/report/histogram", c.ReportHistogram)
 	r.POST("/find-flow", c.FindFlowHandler)
+	r.GET("/tags", c.TagsHandler)
+
+	// Exports are not subject to the query timeout as they can take a
+	// long time to stream.
+	router.GET("/export", apiFuncWrapper(c.ExportHandler, 0))
+
+	// Streams are open until the client disconnects.
+	router.GET("/stream", apiFuncWrapper(c.StreamHandler, 0))
+	router.GET("/stream/ws", apiFuncWrapper(c.StreamWebSocketHandler, 0))
+
+	r.GET("/saved-searches", c.SavedSearchesHandler)
+	r.POST("/saved-searches", c.AddSavedSearchHandler)
+	r.GET("/saved-searches/{id}", c.GetSavedSearchHandler)
+	r.PUT("/saved-searches/{id}", c.UpdateSavedSearchHandler)
+	r.DELETE("/saved-searches/{id}", c.DeleteSavedSearchHandler)
+	r.GET("/saved-searches/{id}/results", c.SavedSearchResultsHandler)
+
+	r.GET("/cases", c.CasesHandler)
+	r.POST("/cases", c.AddCaseHandler)
+	r.GET("/cases/{id}", c.GetCaseHandler)
+	r.PUT("/cases/{id}", c.UpdateCaseHandler)
+	r.DELETE("/cases/{id}", c.DeleteCaseHandler)
+	r.GET("/cases/{id}/events", c.CaseEventsHandler)
+	r.POST("/cases/{id}/events", c.AddCaseEventHandler)
+	r.DELETE("/cases/{id}/events/{eventId}", c.RemoveCaseEventHandler)
+	r.POST("/cases/{id}/alert-groups", c.AddCaseAlertGroupHandler)
+	r.DELETE("/cases/{id}/alert-groups/{groupId}", c.RemoveCaseAlertGroupHandler)
+	r.POST("/cases/{id}/comments", c.CommentOnCaseHandler)
+
+	r.GET("/suppression-rules", c.SuppressionRulesHandler)
+	r.POST("/suppression-rules", c.AddSuppressionRuleHandler)
+	r.GET("/suppression-rules/{id}", c.GetSuppressionRuleHandler)
+	r.PUT("/suppression-rules/{id}", c.UpdateSuppressionRuleHandler)
+	r.DELETE("/suppression-rules/{id}", c.DeleteSuppressionRuleHandler)
+
+	r.POST("/notifications/test", c.NotificationTestHandler)
 }
 
 // DecodeRequestBody is a helper functio to decoder request bodies into a
@@ -39,7 +39,7 @@ func (c *ApiContext) QueryHandler(w *ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	response, err := c.appContext.ElasticSearch.Search(query)
+	response, err := c.appContext.ElasticSearch.Search(r.Context(), query)
 	if err != nil {
 		return err
 	}
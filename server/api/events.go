@@ -33,6 +33,7 @@ import (
 	"github.com/jasonish/evebox/eve"
 	"github.com/jasonish/evebox/log"
 	"github.com/jasonish/evebox/server/sessions"
+	"github.com/jasonish/evebox/stream"
 	"github.com/pkg/errors"
 	"net/http"
 	"strconv"
@@ -42,7 +43,7 @@ import (
 
 func (c *ApiContext) GetEventByIdHandler(w *ResponseWriter, r *http.Request) error {
 	eventId := mux.Vars(r)["id"]
-	event, err := c.appContext.DataStore.GetEventById(eventId)
+	event, err := c.appContext.DataStore.GetEventById(r.Context(), eventId)
 	if err != nil {
 		log.Error("%v", err)
 		return err
@@ -55,16 +56,66 @@ func (c *ApiContext) GetEventByIdHandler(w *ResponseWriter, r *http.Request) err
 	return w.OkJSON(event)
 }
 
+// GetEventHistoryHandler handles GET requests to /api/1/event/{id}/history,
+// returning the actions taken on the event and its comments, oldest
+// first.
+func (c *ApiContext) GetEventHistoryHandler(w *ResponseWriter, r *http.Request) error {
+	eventId := mux.Vars(r)["id"]
+	event, err := c.appContext.DataStore.GetEventById(r.Context(), eventId)
+	if err != nil {
+		log.Error("%v", err)
+		return err
+	}
+	if event == nil {
+		return httpNotFoundResponse(fmt.Sprintf("No event with ID %s", eventId))
+	}
+	return w.OkJSON(map[string]interface{}{
+		"history": eventHistory(event),
+	})
+}
+
+// eventHistory returns the evebox.history of an event as returned by
+// GetEventById.
+func eventHistory(event map[string]interface{}) []interface{} {
+	history := []interface{}{}
+	source := asMap(event["_source"])
+	if source == nil {
+		return history
+	}
+	evebox := asMap(source["evebox"])
+	if evebox == nil {
+		return history
+	}
+	if entries, ok := evebox["history"].([]interface{}); ok {
+		history = append(history, entries...)
+	}
+	return history
+}
+
+func asMap(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return v
+	case eve.EveEvent:
+		return v
+	}
+	return nil
+}
+
 // Archive a single event.
 func (c *ApiContext) ArchiveEventHandler(w *ResponseWriter, r *http.Request) error {
 	session := r.Context().Value("session").(*sessions.Session)
 	eventId := mux.Vars(r)["id"]
 
-	err := c.appContext.DataStore.ArchiveEvent(eventId, session.User)
+	err := c.appContext.DataStore.ArchiveEvent(r.Context(), eventId, session.User)
 	if err != nil {
 		log.Error("Failed to archive event: %v", err)
 		return err
 	}
+	c.publishAction(r, stream.Action{
+		Action:  core.ALERT_ACTION_ARCHIVE,
+		EventId: eventId,
+	})
 	return w.Ok()
 }
 
@@ -72,11 +123,21 @@ func (c *ApiContext) EscalateEventHandler(w *ResponseWriter, r *http.Request) er
 	session := r.Context().Value("session").(*sessions.Session)
 	eventId := mux.Vars(r)["id"]
 
-	err := c.appContext.DataStore.EscalateEvent(eventId, session.User)
+	err := c.appContext.DataStore.EscalateEvent(r.Context(), eventId, session.User)
 	if err != nil {
 		log.Error("Failed to escalated event: %v", err)
 		return err
 	}
+	c.publishAction(r, stream.Action{
+		Action:  core.ALERT_ACTION_ESCALATE,
+		EventId: eventId,
+	})
+
+	// The event has been escalated, so only log errors.
+	if err := c.forwardEscalatedEvent(r.Context(), eventId); err != nil {
+		log.Error("Failed to forward escalated event to syslog: %v", err)
+	}
+
 	return w.Ok()
 }
 
@@ -84,11 +145,15 @@ func (c *ApiContext) DeEscalateEventHandler(w *ResponseWriter, r *http.Request)
 	session := r.Context().Value("session").(*sessions.Session)
 	eventId := mux.Vars(r)["id"]
 
-	err := c.appContext.DataStore.DeEscalateEvent(eventId, session.User)
+	err := c.appContext.DataStore.DeEscalateEvent(r.Context(), eventId, session.User)
 	if err != nil {
 		log.Error("Failed to de-escalated event: %v", err)
 		return err
 	}
+	c.publishAction(r, stream.Action{
+		Action:  core.ALERT_ACTION_DEESCALATE,
+		EventId: eventId,
+	})
 	return w.Ok()
 }
 
@@ -122,7 +187,14 @@ func (c *ApiContext) EventQueryHandler(w *ResponseWriter, r *http.Request) error
 	options.EventType = r.FormValue("event_type")
 	options.Size, _ = strconv.ParseInt(r.FormValue("size"), 0, 64)
 
-	response, err := c.appContext.DataStore.EventQuery(options)
+	options.Cursor = r.FormValue("cursor")
+	if options.Cursor != "" {
+		if _, err := core.DecodeEventCursor(options.Cursor); err != nil {
+			return newHttpErrorResponse(http.StatusBadRequest, err)
+		}
+	}
+
+	response, err := c.appContext.DataStore.EventQuery(r.Context(), options)
 	if err != nil {
 		return err
 	}
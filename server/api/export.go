@@ -0,0 +1,303 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Flush the export to the client every this many events.
+const exportFlushInterval = 1000
+
+// The CSV columns if none are requested.
+var defaultExportFields = []string{
+	"timestamp",
+	"event_type",
+	"src_ip",
+	"src_port",
+	"dest_ip",
+	"dest_port",
+	"proto",
+	"alert.signature_id",
+	"alert.signature",
+}
+
+type eventExporter interface {
+	Write(event map[string]interface{}) error
+	Flush() error
+}
+
+// ndjsonExporter writes events one per line in eve format, suitable for
+// re-import. EveBox metadata, such as the history and archived and
+// escalated tags, is removed.
+type ndjsonExporter struct {
+	encoder *json.Encoder
+}
+
+func newNdjsonExporter(w io.Writer) *ndjsonExporter {
+	return &ndjsonExporter{
+		encoder: json.NewEncoder(w),
+	}
+}
+
+func (e *ndjsonExporter) Write(event map[string]interface{}) error {
+	source := asMap(event["_source"])
+	if source == nil {
+		return nil
+	}
+	out := map[string]interface{}{}
+	for key, value := range source {
+		switch {
+		case strings.HasPrefix(key, "__"):
+		case key == "evebox" || key == "@timestamp":
+		case key == "tags":
+			out[key] = userTags(value)
+		default:
+			out[key] = value
+		}
+	}
+	return e.encoder.Encode(out)
+}
+
+func (e *ndjsonExporter) Flush() error {
+	return nil
+}
+
+// csvExporter writes the requested fields of each event as CSV with a
+// header row.
+type csvExporter struct {
+	writer *csv.Writer
+	fields []string
+	header bool
+}
+
+func newCsvExporter(w io.Writer, fields []string) *csvExporter {
+	return &csvExporter{
+		writer: csv.NewWriter(w),
+		fields: fields,
+	}
+}
+
+func (e *csvExporter) writeHeader() error {
+	if !e.header {
+		e.header = true
+		return e.writer.Write(e.fields)
+	}
+	return nil
+}
+
+func (e *csvExporter) Write(event map[string]interface{}) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	source := asMap(event["_source"])
+	record := make([]string, len(e.fields))
+	for i, field := range e.fields {
+		if field == "_id" {
+			record[i] = csvValue(event["_id"])
+		} else {
+			record[i] = csvValue(lookupField(source, field))
+		}
+	}
+	return e.writer.Write(record)
+}
+
+func (e *csvExporter) Flush() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// userTags returns the tags less those used by EveBox to track state.
+func userTags(value interface{}) []interface{} {
+	tags := []interface{}{}
+	if values, ok := value.([]interface{}); ok {
+		for _, tag := range values {
+			if tag, ok := tag.(string); ok && core.IsReservedTag(tag) {
+				continue
+			}
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// lookupField returns the value of a field, like alert.signature_id,
+// from an event.
+func lookupField(source map[string]interface{}, field string) interface{} {
+	var value interface{} = source
+	for _, key := range strings.Split(field, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+func csvValue(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	}
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(buf)
+}
+
+// ExportHandler streams all the events matching the query as NDJSON or
+// CSV. Unlike the event query there is no size limit.
+//
+// Parameters:
+//
+//	format: ndjson (default) or csv
+//	fields: comma separated list of fields to export as CSV columns
+//	query_string, time_range, min_ts, max_ts, event_type
+//	order: asc or desc (default)
+func (c *ApiContext) ExportHandler(w *ResponseWriter, r *http.Request) error {
+	var options core.EventQueryOptions
+
+	if err := r.ParseForm(); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	options.QueryString = r.FormValue("query_string")
+	options.EventType = r.FormValue("event_type")
+	options.Order = r.FormValue("order")
+
+	minTs, err := parseFormTimestamp(r, "min_ts")
+	if err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.Wrap(err, "failed to parse min_ts"))
+	}
+	options.MinTs = minTs
+
+	maxTs, err := parseFormTimestamp(r, "max_ts")
+	if err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.Wrap(err, "failed to parse max_ts"))
+	}
+	options.MaxTs = maxTs
+
+	// Not all datastores apply the time range to event queries, so
+	// convert it to a minimum timestamp.
+	if timeRange := r.FormValue("time_range"); timeRange != "" {
+		duration, err := time.ParseDuration(timeRange)
+		if err != nil {
+			return newHttpErrorResponse(http.StatusBadRequest,
+				errors.Wrap(err, "failed to parse time_range"))
+		}
+		if options.MinTs.IsZero() {
+			options.MinTs = time.Now().Add(duration * -1)
+		}
+	}
+
+	var exporter eventExporter
+	var contentType string
+	format := r.FormValue("format")
+	switch format {
+	case "", "ndjson":
+		format = "ndjson"
+		contentType = "application/x-ndjson"
+		exporter = newNdjsonExporter(w)
+	case "csv":
+		fields := defaultExportFields
+		if r.FormValue("fields") != "" {
+			fields = []string{}
+			for _, field := range strings.Split(r.FormValue("fields"), ",") {
+				if field = strings.TrimSpace(field); field != "" {
+					fields = append(fields, field)
+				}
+			}
+		}
+		contentType = "text/csv"
+		exporter = newCsvExporter(w, fields)
+	default:
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.Errorf("unsupported format: %s", format))
+	}
+
+	count := 0
+	setHeaders := func() {
+		w.Header().Set("content-type", contentType)
+		w.Header().Set("content-disposition",
+			fmt.Sprintf(`attachment; filename="events.%s"`, format))
+	}
+	flusher, _ := w.ResponseWriter.(http.Flusher)
+
+	err = c.appContext.DataStore.ExportEvents(r.Context(), options,
+		func(event map[string]interface{}) error {
+			if count == 0 {
+				setHeaders()
+			}
+			if err := exporter.Write(event); err != nil {
+				return err
+			}
+			count++
+			if count%exportFlushInterval == 0 {
+				if err := exporter.Flush(); err != nil {
+					return err
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		if count == 0 {
+			return err
+		}
+		// Too late to send an error response, the client will get a
+		// truncated export.
+		log.Error("Export failed after %d events: %v", count, err)
+		return nil
+	}
+
+	if count == 0 {
+		setHeaders()
+	}
+	return exporter.Flush()
+}
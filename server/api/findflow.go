@@ -46,7 +46,7 @@ func (c *ApiContext) FindFlowHandler(w *ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	result, err := c.appContext.DataStore.FindFlow(request.FlowId,
+	result, err := c.appContext.DataStore.FindFlow(r.Context(), request.FlowId,
 		request.Proto, request.Timestamp, request.SrcIp, request.DestIp)
 	if err != nil {
 		return err
@@ -0,0 +1,64 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"github.com/jasonish/evebox/notify"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+type NotificationTestRequest struct {
+	Output string `json:"output"`
+}
+
+// NotificationTestHandler handles POST requests to
+// /api/1/notifications/test, sending a test notification to the named
+// output.
+func (c *ApiContext) NotificationTestHandler(w *ResponseWriter, r *http.Request) error {
+	if c.appContext.Notifier == nil {
+		return newHttpErrorResponse(http.StatusNotImplemented,
+			errors.New("notifications not enabled"))
+	}
+
+	var request NotificationTestRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	if request.Output == "" {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.New("output is required"))
+	}
+
+	err := c.appContext.Notifier.Test(request.Output)
+	if err == notify.ErrUnknownOutput {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	} else if err != nil {
+		return newHttpErrorResponse(http.StatusBadGateway, err)
+	}
+	return w.Ok()
+}
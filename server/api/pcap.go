@@ -27,11 +27,33 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/pcap"
+	"github.com/jasonish/evebox/pcapstore"
+	"github.com/pkg/errors"
+	"net"
 	"net/http"
+	"strconv"
 )
 
+// The maximum number of events converted into a single PCAP.
+const maxPcapEvents = 10000
+
+var errPcapLimit = errors.New("pcap event limit reached")
+
+type PcapRequest struct {
+	AlertGroup *AlertGroupQueryParameters `json:"alert_group"`
+	EventIds   []string                   `json:"event_ids"`
+
+	// pcap (default) or pcapng. PCAP-NG files have a comment on each
+	// packet describing its event.
+	Format string `json:"format"`
+}
+
 func (c *ApiContext) Eve2PcapHandler(w *ResponseWriter, r *http.Request) error {
 	var event eve.EveEvent
 	var err error
@@ -62,12 +84,20 @@ func (c *ApiContext) Eve2PcapHandler(w *ResponseWriter, r *http.Request) error {
 		}
 	}
 
+	// Optionally PCAP-NG with the event described in a packet comment.
+	pcapng := r.FormValue("format") == "pcapng"
+	id := r.FormValue("id")
+
 	if what == "payload" {
 		if len(event.Payload()) == 0 {
 			return fmt.Errorf("Payload conversion requested but event does not contain the payload.")
 		}
 
-		pcap, err = eve.EvePayloadToPcap(event)
+		if pcapng {
+			pcap, err = eve.EvePayloadToPcapNg(event, id, pcapApplication())
+		} else {
+			pcap, err = eve.EvePayloadToPcap(event)
+		}
 		if err != nil {
 			return fmt.Errorf("Failed to convert payload to pcap: %v", err)
 		}
@@ -76,14 +106,264 @@ func (c *ApiContext) Eve2PcapHandler(w *ResponseWriter, r *http.Request) error {
 		if len(event.Packet()) == 0 {
 			return fmt.Errorf("Packet conversion requested but event not contain the packet.")
 		}
-		pcap, err = eve.EvePacket2Pcap(event)
+		if pcapng {
+			pcap, err = eve.EvePacket2PcapNg(event, id, pcapApplication())
+		} else {
+			pcap, err = eve.EvePacket2Pcap(event)
+		}
 		if err != nil {
 			return fmt.Errorf("Failed to convert packet to pcap: %v", err)
 		}
 	}
 
-	w.Header().Set("content-type", "application/vnc.tcpdump.pcap")
-	w.Header().Set("content-disposition", "attachment; filename=event.pcap")
-	_, err = w.Write(pcap)
-	return err
+	writePcap(w, pcap, "event", pcapng)
+	return nil
+}
+
+// pcapApplication is recorded as the application that created PCAP-NG
+// files.
+func pcapApplication() string {
+	return fmt.Sprintf("EveBox %s (rev %s)", core.BuildVersion, core.BuildRev)
+}
+
+func writePcap(w *ResponseWriter, pcap []byte, name string, pcapng bool) {
+	if pcapng {
+		w.Header().Set("content-type", "application/x-pcapng")
+		w.Header().Set("content-disposition",
+			fmt.Sprintf("attachment; filename=%s.pcapng", name))
+	} else {
+		w.Header().Set("content-type", "application/vnc.tcpdump.pcap")
+		w.Header().Set("content-disposition",
+			fmt.Sprintf("attachment; filename=%s.pcap", name))
+	}
+	w.Write(pcap)
+}
+
+// PcapHandler returns a PCAP file with the packet, or a packet built from
+// the payload, of every event in an alert group or list of events.
+func (c *ApiContext) PcapHandler(w *ResponseWriter, r *http.Request) error {
+	var request PcapRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	pcapng := false
+	switch request.Format {
+	case "", "pcap":
+	case "pcapng":
+		pcapng = true
+	default:
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.Errorf("unsupported format: %s", request.Format))
+	}
+
+	events := []eve.EveEvent{}
+	ids := []string{}
+
+	switch {
+	case request.AlertGroup != nil && len(request.EventIds) > 0:
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.New("only one of alert_group and event_ids allowed"))
+	case request.AlertGroup != nil:
+		params, err := request.AlertGroup.ToCoreAlertGroupQueryParams()
+		if err != nil {
+			return newHttpErrorResponse(http.StatusBadRequest, err)
+		}
+		err = c.appContext.DataStore.ExportEvents(r.Context(), params.EventQueryOptions(),
+			func(event map[string]interface{}) error {
+				if len(events) == maxPcapEvents {
+					return errPcapLimit
+				}
+				source, err := toEveEvent(event["_source"])
+				if err != nil {
+					return err
+				}
+				events = append(events, source)
+				ids = append(ids, fmt.Sprint(event["_id"]))
+				return nil
+			})
+		if err == errPcapLimit {
+			log.Warning("Alert group has more than %d events, only the first %d will be in the PCAP",
+				maxPcapEvents, maxPcapEvents)
+		} else if err != nil {
+			return err
+		}
+	case len(request.EventIds) > 0:
+		if len(request.EventIds) > maxPcapEvents {
+			return newHttpErrorResponse(http.StatusBadRequest,
+				errors.Errorf("too many events, the limit is %d", maxPcapEvents))
+		}
+		for _, id := range request.EventIds {
+			event, err := c.appContext.DataStore.GetEventById(r.Context(), id)
+			if err != nil {
+				return err
+			}
+			if event == nil {
+				return httpNotFoundResponse(fmt.Sprintf("event %s not found", id))
+			}
+			source, err := toEveEvent(event["_source"])
+			if err != nil {
+				return err
+			}
+			events = append(events, source)
+			ids = append(ids, id)
+		}
+	default:
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.New("alert_group or event_ids required"))
+	}
+
+	var pcap []byte
+	var count int
+	var err error
+	if pcapng {
+		pcap, count, err = eve.EveEventsToPcapNg(events, ids, pcapApplication())
+	} else {
+		pcap, count, err = eve.EveEventsToPcap(events)
+	}
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return httpNotFoundResponse("no events with a packet or payload")
+	}
+
+	writePcap(w, pcap, "events", pcapng)
+	return nil
+}
+
+// toEveEvent converts an event source as returned by a datastore to an
+// EveEvent.
+func toEveEvent(source interface{}) (eve.EveEvent, error) {
+	if event, ok := source.(eve.EveEvent); ok {
+		if _, ok := event["__parsed_timestamp"]; ok {
+			return event, nil
+		}
+	}
+	buf, err := json.Marshal(source)
+	if err != nil {
+		return nil, err
+	}
+	return eve.NewEveEventFromBytes(buf)
+}
+
+// PcapFlowRequest identifies a flow to extract from the PCAP store. If
+// EventId is set the flow is taken from that event, otherwise the
+// 5-tuple, protocol and flow start time are required. Timestamps are in
+// the eve format, and without an end time all packets of the flow after
+// the start time are extracted.
+type PcapFlowRequest struct {
+	EventId  string `json:"event_id"`
+	FlowId   string `json:"flow_id"`
+	SrcIp    string `json:"src_ip"`
+	SrcPort  uint16 `json:"src_port"`
+	DestIp   string `json:"dest_ip"`
+	DestPort uint16 `json:"dest_port"`
+	Proto    string `json:"proto"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+}
+
+// fromEvent fills in the flow from an eve event.
+func (r *PcapFlowRequest) fromEvent(event eve.EveEvent) {
+	flow := event.GetMap("flow")
+	switch flowId := event["flow_id"].(type) {
+	case json.Number:
+		r.FlowId = flowId.String()
+	case float64:
+		r.FlowId = strconv.FormatFloat(flowId, 'f', -1, 64)
+	}
+	r.SrcIp = event.SrcIp()
+	r.SrcPort = event.SrcPort()
+	r.DestIp = event.DestIp()
+	r.DestPort = event.DestPort()
+	r.Proto = event.Proto()
+	r.Start = flow.GetString("start")
+	r.End = flow.GetString("end")
+	if r.Start == "" {
+		r.Start = event.GetString("timestamp")
+	}
+}
+
+func (r *PcapFlowRequest) toFlow() (flow pcapstore.Flow, err error) {
+	if flow.SrcIp = net.ParseIP(r.SrcIp); flow.SrcIp == nil {
+		return flow, errors.Errorf("invalid src_ip: %s", r.SrcIp)
+	}
+	if flow.DestIp = net.ParseIP(r.DestIp); flow.DestIp == nil {
+		return flow, errors.Errorf("invalid dest_ip: %s", r.DestIp)
+	}
+	flow.SrcPort = r.SrcPort
+	flow.DestPort = r.DestPort
+	if flow.Proto, err = eve.ProtoNumber(r.Proto); err != nil {
+		return flow, errors.Errorf("invalid proto: %s", r.Proto)
+	}
+	if flow.Start, err = eve.ParseTimestamp(r.Start); err != nil {
+		return flow, errors.Errorf("invalid start: %s", r.Start)
+	}
+	if r.End != "" {
+		if flow.End, err = eve.ParseTimestamp(r.End); err != nil {
+			return flow, errors.Errorf("invalid end: %s", r.End)
+		}
+	}
+	return flow, nil
+}
+
+// PcapFlowHandler returns a PCAP file with all the packets of a flow found
+// in the PCAP store.
+func (c *ApiContext) PcapFlowHandler(w *ResponseWriter, r *http.Request) error {
+	if c.appContext.PcapStore == nil {
+		return newHttpErrorResponse(http.StatusNotImplemented,
+			errors.New("pcap-store not configured"))
+	}
+
+	var request PcapFlowRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	if request.EventId != "" {
+		event, err := c.appContext.DataStore.GetEventById(r.Context(), request.EventId)
+		if err != nil {
+			return err
+		}
+		if event == nil {
+			return httpNotFoundResponse(fmt.Sprintf("event %s not found", request.EventId))
+		}
+		source, err := toEveEvent(event["_source"])
+		if err != nil {
+			return err
+		}
+		request.fromEvent(source)
+	}
+
+	flow, err := request.toFlow()
+	if err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	packets, linktype, err := c.appContext.PcapStore.ExtractFlow(r.Context(), flow)
+	if err == pcapstore.ErrNoFiles {
+		return httpNotFoundResponse(err.Error())
+	} else if err == pcapstore.ErrFlowTooLarge {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.Errorf("flow has more than %d packets or %d bytes, set an end time",
+				c.appContext.PcapStore.MaxPackets, c.appContext.PcapStore.MaxBytes))
+	} else if err != nil {
+		return err
+	}
+	if len(packets) == 0 {
+		return httpNotFoundResponse("no packets found for flow")
+	}
+
+	buf, err := pcap.CreatePcapFromPackets(packets, linktype)
+	if err != nil {
+		return err
+	}
+
+	name := "flow"
+	if request.FlowId != "" {
+		name = fmt.Sprintf("flow-%s", request.FlowId)
+	}
+	writePcap(w, buf, name, false)
+	return nil
 }
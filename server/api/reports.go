@@ -52,7 +52,7 @@ func (c *ApiContext) ReportDnsRequestRrnames(w *ResponseWriter, r *http.Request)
 		options.QueryString = r.FormValue("queryString")
 	}
 
-	data, err := c.appContext.ReportService.ReportDnsRequestRrnames(options)
+	data, err := c.appContext.ReportService.ReportDnsRequestRrnames(r.Context(), options)
 	if err != nil {
 		return err
 	}
@@ -76,7 +76,7 @@ func (c *ApiContext) ReportAggs(w *ResponseWriter, r *http.Request) error {
 
 	options.DnsType = r.FormValue("dnsType")
 
-	response, err := c.appContext.ReportService.ReportAggs(agg, options)
+	response, err := c.appContext.ReportService.ReportAggs(r.Context(), agg, options)
 	if err != nil {
 		return err
 	}
@@ -95,7 +95,7 @@ func (c *ApiContext) ReportHistogram(w *ResponseWriter, r *http.Request) error {
 
 	interval := r.FormValue("interval")
 
-	response, err := c.appContext.ReportService.ReportHistogram(interval, options)
+	response, err := c.appContext.ReportService.ReportHistogram(r.Context(), interval, options)
 	if err != nil {
 		return err
 	}
@@ -111,7 +111,7 @@ func (c *ApiContext) NetflowHandler(w *ResponseWriter, r *http.Request) error {
 
 	sortBy := r.FormValue("sortBy")
 
-	response, err := c.appContext.DataStore.FindNetflow(options, sortBy, "")
+	response, err := c.appContext.DataStore.FindNetflow(r.Context(), options, sortBy, "")
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,187 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/server/sessions"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+// SavedSearchesHandler handles GET requests to /api/1/saved-searches,
+// returning the searches owned by the user and those shared by others.
+func (c *ApiContext) SavedSearchesHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	searches, err := c.appContext.SavedSearchStore.FindVisible(session.Username())
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(map[string]interface{}{
+		"saved_searches": searches,
+	})
+}
+
+// AddSavedSearchHandler handles POST requests to /api/1/saved-searches.
+// The body is a saved search as returned by the GET handlers, the
+// owner will be the current user.
+func (c *ApiContext) AddSavedSearchHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+
+	var search core.SavedSearch
+	if err := DecodeRequestBody(r, &search); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	search.Owner = session.Username()
+	if err := search.Validate(); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	id, err := c.appContext.SavedSearchStore.Add(search)
+	if err != nil {
+		log.Error("Failed to add saved search: %v", err)
+		return err
+	}
+
+	search, err = c.appContext.SavedSearchStore.FindById(id)
+	if err != nil {
+		return err
+	}
+	return w.StatusJSON(http.StatusCreated, search)
+}
+
+func (c *ApiContext) GetSavedSearchHandler(w *ResponseWriter, r *http.Request) error {
+	search, err := c.findSavedSearch(r)
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(search)
+}
+
+// UpdateSavedSearchHandler handles PUT requests to
+// /api/1/saved-searches/{id}. Only the owner can update a search.
+func (c *ApiContext) UpdateSavedSearchHandler(w *ResponseWriter, r *http.Request) error {
+	existing, err := c.findOwnedSavedSearch(r)
+	if err != nil {
+		return err
+	}
+
+	var search core.SavedSearch
+	if err := DecodeRequestBody(r, &search); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	search.Id = existing.Id
+	search.Owner = existing.Owner
+	if err := search.Validate(); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	if err := c.appContext.SavedSearchStore.Update(search); err != nil {
+		log.Error("Failed to update saved search %s: %v", search.Id, err)
+		return err
+	}
+
+	search, err = c.appContext.SavedSearchStore.FindById(search.Id)
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(search)
+}
+
+// DeleteSavedSearchHandler handles DELETE requests to
+// /api/1/saved-searches/{id}. Only the owner can delete a search.
+func (c *ApiContext) DeleteSavedSearchHandler(w *ResponseWriter, r *http.Request) error {
+	search, err := c.findOwnedSavedSearch(r)
+	if err != nil {
+		return err
+	}
+	if err := c.appContext.SavedSearchStore.Delete(search.Id); err != nil {
+		return err
+	}
+	return w.Ok()
+}
+
+// SavedSearchResultsHandler handles GET requests to
+// /api/1/saved-searches/{id}/results, running the search as an alert or
+// event query depending on its type.
+func (c *ApiContext) SavedSearchResultsHandler(w *ResponseWriter, r *http.Request) error {
+	search, err := c.findSavedSearch(r)
+	if err != nil {
+		return err
+	}
+
+	switch search.Type {
+	case core.SAVED_SEARCH_ALERTS:
+		alerts, err := c.appContext.DataStore.AlertQuery(r.Context(),
+			search.AlertQueryOptions())
+		if err != nil {
+			return err
+		}
+		return w.OkJSON(map[string]interface{}{
+			"alerts": alerts,
+		})
+	case core.SAVED_SEARCH_EVENTS:
+		response, err := c.appContext.DataStore.EventQuery(r.Context(),
+			search.EventQueryOptions())
+		if err != nil {
+			return err
+		}
+		return w.OkJSON(response)
+	}
+
+	return errors.Errorf("unknown saved search type: %s", search.Type)
+}
+
+// findSavedSearch returns the saved search for the id in the request path
+// if visible to the current user.
+func (c *ApiContext) findSavedSearch(r *http.Request) (core.SavedSearch, error) {
+	session := r.Context().Value("session").(*sessions.Session)
+	id := mux.Vars(r)["id"]
+
+	search, err := c.appContext.SavedSearchStore.FindById(id)
+	if err == core.ErrSavedSearchNotFound || (err == nil && !search.VisibleTo(session.User)) {
+		return search, httpNotFoundResponse("No saved search with ID " + id)
+	}
+	return search, err
+}
+
+// findOwnedSavedSearch is like findSavedSearch but the search must also
+// be owned by the current user.
+func (c *ApiContext) findOwnedSavedSearch(r *http.Request) (core.SavedSearch, error) {
+	session := r.Context().Value("session").(*sessions.Session)
+	search, err := c.findSavedSearch(r)
+	if err != nil {
+		return search, err
+	}
+	if search.Owner != session.Username() {
+		return search, newHttpErrorResponse(http.StatusForbidden,
+			errors.New("saved search is owned by another user"))
+	}
+	return search, nil
+}
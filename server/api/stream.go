@@ -0,0 +1,238 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"fmt"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/server/sessions"
+	"github.com/jasonish/evebox/stream"
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How often a keepalive is sent to stream clients so idle connections
+// are not closed by proxies.
+const streamKeepaliveInterval = 30 * time.Second
+
+// parseStreamOptions returns the stream options from the request
+// parameters:
+//
+//     events: send received events, default true.
+//
+//     event_type: a comma separated list of the event types to send.
+//
+//     query_string: only send events matching the query string.
+//
+//     sensor: only send events from this sensor, the host field.
+//
+//     actions: send archive, escalate and tag actions, default true.
+func parseStreamOptions(r *http.Request) (stream.Options, error) {
+	options := stream.Options{
+		Events:  true,
+		Actions: true,
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return options, err
+	}
+
+	for _, key := range []string{"events", "actions"} {
+		value := r.FormValue(key)
+		if value == "" {
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return options, errors.Errorf("invalid value for %s: %s", key, value)
+		}
+		if key == "events" {
+			options.Events = enabled
+		} else {
+			options.Actions = enabled
+		}
+	}
+
+	for _, eventType := range strings.Split(r.FormValue("event_type"), ",") {
+		if eventType = strings.TrimSpace(eventType); eventType != "" {
+			options.EventTypes = append(options.EventTypes, eventType)
+		}
+	}
+
+	options.QueryString = r.FormValue("query_string")
+	options.Sensor = r.FormValue("sensor")
+
+	return options, nil
+}
+
+func (c *ApiContext) subscribe(r *http.Request) (*stream.Subscriber, error) {
+	options, err := parseStreamOptions(r)
+	if err != nil {
+		return nil, newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	subscriber, err := c.appContext.Stream.Subscribe(options)
+	if err != nil {
+		return nil, newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	return subscriber, nil
+}
+
+// StreamHandler handles GET requests to /api/1/stream, sending received
+// events and the actions of users as Server-Sent Events until the client
+// disconnects. The event name is the message type, and the data is the
+// message as JSON.
+func (c *ApiContext) StreamHandler(w *ResponseWriter, r *http.Request) error {
+	flusher, ok := w.ResponseWriter.(http.Flusher)
+	if !ok {
+		return errors.New("streaming not supported")
+	}
+
+	subscriber, err := c.subscribe(r)
+	if err != nil {
+		return err
+	}
+	defer c.appContext.Stream.Unsubscribe(subscriber)
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	// Disable buffering by nginx.
+	w.Header().Set("x-accel-buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(streamKeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case message := <-subscriber.C:
+			if dropped := subscriber.Dropped(); dropped > 0 {
+				writeServerSentEvent(w, stream.NewMessage(stream.TYPE_DROPPED, dropped))
+			}
+			writeServerSentEvent(w, message)
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+func writeServerSentEvent(w *ResponseWriter, message stream.Message) {
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", message.Type, message.Data)
+}
+
+// StreamWebSocketHandler handles WebSocket connections to
+// /api/1/stream/ws, sending the same messages as StreamHandler as text
+// messages. Messages from the client are ignored.
+func (c *ApiContext) StreamWebSocketHandler(w *ResponseWriter, r *http.Request) error {
+	subscriber, err := c.subscribe(r)
+	if err != nil {
+		return err
+	}
+	defer c.appContext.Stream.Unsubscribe(subscriber)
+
+	server := websocket.Server{
+		Handshake: checkWebSocketOrigin,
+		Handler: func(conn *websocket.Conn) {
+			streamWebSocket(conn, subscriber)
+		},
+	}
+	server.ServeHTTP(w.ResponseWriter, r)
+	return nil
+}
+
+func streamWebSocket(conn *websocket.Conn, subscriber *stream.Subscriber) {
+	// Read until the client closes the connection.
+	closed := make(chan bool)
+	go func() {
+		var ignored string
+		for websocket.Message.Receive(conn, &ignored) == nil {
+		}
+		close(closed)
+	}()
+
+	keepalive := time.NewTicker(streamKeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		var err error
+		select {
+		case <-closed:
+			return
+		case message := <-subscriber.C:
+			if dropped := subscriber.Dropped(); dropped > 0 {
+				err = sendWebSocketMessage(conn,
+					stream.NewMessage(stream.TYPE_DROPPED, dropped))
+			}
+			if err == nil {
+				err = sendWebSocketMessage(conn, message)
+			}
+		case <-keepalive.C:
+			err = sendWebSocketMessage(conn,
+				stream.NewMessage(stream.TYPE_KEEPALIVE, nil))
+		}
+		if err != nil {
+			log.Debug("Failed to send to stream WebSocket: %v", err)
+			return
+		}
+	}
+}
+
+func sendWebSocketMessage(conn *websocket.Conn, message stream.Message) error {
+	return websocket.Message.Send(conn, string(message.Data))
+}
+
+// checkWebSocketOrigin only allows browsers to connect from the same
+// origin, as WebSockets are not subject to the same-origin policy.
+// Other clients do not send an origin.
+func checkWebSocketOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("origin")
+	if origin == "" {
+		return nil
+	}
+	originUrl, err := url.Parse(origin)
+	if err != nil || originUrl.Host != r.Host {
+		return errors.Errorf("origin %s not allowed", origin)
+	}
+	return nil
+}
+
+// publishAction publishes an action taken by the user of the request to
+// stream subscribers.
+func (c *ApiContext) publishAction(r *http.Request, action stream.Action) {
+	if session, ok := r.Context().Value("session").(*sessions.Session); ok {
+		action.Username = session.Username()
+	}
+	c.appContext.Stream.PublishAction(action)
+}
@@ -0,0 +1,118 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"bufio"
+	"github.com/jasonish/evebox/appcontext"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/stream"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newStreamTestContext(t *testing.T) (*ApiContext, *stream.Broker) {
+	broker := stream.NewBroker()
+	return &ApiContext{
+		appContext: &appcontext.AppContext{
+			Stream: broker,
+		},
+	}, broker
+}
+
+func streamTestEvent(t *testing.T, eventType string) eve.EveEvent {
+	event, err := eve.NewEveEventFromString(`{"timestamp":"2017-06-01T10:00:00.000000+0000","event_type":"` +
+		eventType + `","host":"sensor1","src_ip":"10.0.0.1"}`)
+	require.Nil(t, err)
+	return event
+}
+
+func TestStreamHandler(t *testing.T) {
+	c, broker := newStreamTestContext(t)
+	server := httptest.NewServer(apiFuncWrapper(c.StreamHandler, 0))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "?event_type=alert&actions=false")
+	require.Nil(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "text/event-stream", response.Header.Get("content-type"))
+
+	// The handler has subscribed once the response has started.
+	broker.PublishAction(stream.Action{Action: "archive", EventId: "1"})
+	broker.Filter(streamTestEvent(t, "dns"))
+	broker.Filter(streamTestEvent(t, "alert"))
+
+	reader := bufio.NewReader(response.Body)
+	line, err := reader.ReadString('\n')
+	require.Nil(t, err)
+	require.Equal(t, "event: event\n", line)
+	line, err = reader.ReadString('\n')
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(line, "data: {"), line)
+	require.Contains(t, line, `"event_type":"alert"`)
+}
+
+func TestStreamHandlerBadRequest(t *testing.T) {
+	c, _ := newStreamTestContext(t)
+	server := httptest.NewServer(apiFuncWrapper(c.StreamHandler, 0))
+	defer server.Close()
+
+	for _, query := range []string{"?query_string=src_ip:10.0.0.0/33", "?query_string=%AB*", "?events=maybe"} {
+		response, err := http.Get(server.URL + query)
+		require.Nil(t, err)
+		response.Body.Close()
+		require.Equal(t, http.StatusBadRequest, response.StatusCode, query)
+	}
+}
+
+func TestStreamWebSocketHandler(t *testing.T) {
+	c, broker := newStreamTestContext(t)
+	server := httptest.NewServer(apiFuncWrapper(c.StreamWebSocketHandler, 0))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// Browsers on other origins are refused.
+	_, err := websocket.Dial(url, "", "http://example.com")
+	require.NotNil(t, err)
+
+	conn, err := websocket.Dial(url+"?events=false", "", server.URL)
+	require.Nil(t, err)
+	defer conn.Close()
+
+	broker.Filter(streamTestEvent(t, "alert"))
+	broker.PublishAction(stream.Action{Action: "escalate", EventId: "1"})
+
+	var message string
+	require.Nil(t, websocket.Message.Receive(conn, &message))
+	require.Contains(t, message, `"type":"action"`)
+	require.Contains(t, message, `"event_id":"1"`)
+}
@@ -75,8 +75,18 @@ func (c *ApiContext) SubmitHandler(w *ResponseWriter, r *http.Request) error {
 		tagsFilter.Filter(event)
 		geoFilter.Filter(event)
 		uaFilter.Filter(event)
+		if c.appContext.SuppressionFilter != nil {
+			c.appContext.SuppressionFilter.Filter(event)
+		}
+		if c.appContext.Notifier != nil {
+			c.appContext.Notifier.Filter(event)
+		}
+		if c.appContext.SyslogForwarder != nil {
+			c.appContext.SyslogForwarder.Filter(event)
+		}
+		c.appContext.Stream.Filter(event)
 
-		eventSink.Submit(event)
+		eventSink.Submit(r.Context(), event)
 
 		count++
 
@@ -85,7 +95,7 @@ func (c *ApiContext) SubmitHandler(w *ResponseWriter, r *http.Request) error {
 		}
 	}
 
-	_, err := eventSink.Commit()
+	_, err := eventSink.Commit(r.Context())
 	if err != nil {
 		log.Error("Failed to commit events: %v", err)
 		return err
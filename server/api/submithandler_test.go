@@ -0,0 +1,82 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"context"
+	"github.com/jasonish/evebox/appcontext"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/stream"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testEventSink records the events submitted to it.
+type testEventSink struct {
+	events []eve.EveEvent
+}
+
+func (s *testEventSink) Submit(ctx context.Context, event eve.EveEvent) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *testEventSink) Commit(ctx context.Context) (interface{}, error) {
+	return nil, nil
+}
+
+// testSinkDatastore only provides an event sink.
+type testSinkDatastore struct {
+	core.Datastore
+	sink *testEventSink
+}
+
+func (d *testSinkDatastore) GetEveEventSink() core.EveEventSink {
+	return d.sink
+}
+
+// Oneshot has no suppression filter.
+func TestSubmitHandlerWithoutSuppressionFilter(t *testing.T) {
+	sink := &testEventSink{}
+	c := &ApiContext{
+		appContext: &appcontext.AppContext{
+			DataStore: &testSinkDatastore{sink: sink},
+			Stream:    stream.NewBroker(),
+		},
+	}
+
+	body := `{"timestamp":"2017-06-01T10:00:00.000000+0000","event_type":"alert","src_ip":"10.0.0.1","dest_ip":"10.0.0.2","alert":{"signature_id":1}}` + "\n"
+	recorder := httptest.NewRecorder()
+	apiFuncWrapper(c.SubmitHandler, 0).ServeHTTP(recorder,
+		httptest.NewRequest("POST", "/api/1/submit", strings.NewReader(body)))
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	require.Len(t, sink.events, 1)
+}
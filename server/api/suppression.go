@@ -0,0 +1,150 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/server/sessions"
+	"net/http"
+)
+
+// SuppressionRulesHandler handles GET requests to
+// /api/1/suppression-rules. Rules are not owned, they apply to all
+// received alerts.
+func (c *ApiContext) SuppressionRulesHandler(w *ResponseWriter, r *http.Request) error {
+	rules, err := c.appContext.SuppressionRuleStore.FindAll()
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(map[string]interface{}{
+		"suppression_rules": rules,
+	})
+}
+
+// AddSuppressionRuleHandler handles POST requests to
+// /api/1/suppression-rules. Rules are enabled unless the body sets
+// enabled to false.
+func (c *ApiContext) AddSuppressionRuleHandler(w *ResponseWriter, r *http.Request) error {
+	session := r.Context().Value("session").(*sessions.Session)
+
+	rule := core.SuppressionRule{
+		Enabled: true,
+	}
+	if err := DecodeRequestBody(r, &rule); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	rule.Owner = session.Username()
+	if err := rule.Validate(); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	id, err := c.appContext.SuppressionRuleStore.Add(rule)
+	if err != nil {
+		log.Error("Failed to add suppression rule: %v", err)
+		return err
+	}
+	if err := c.appContext.SuppressionFilter.Reload(); err != nil {
+		return err
+	}
+
+	rule, err = c.appContext.SuppressionRuleStore.FindById(id)
+	if err != nil {
+		return err
+	}
+	return w.StatusJSON(http.StatusCreated, rule)
+}
+
+func (c *ApiContext) GetSuppressionRuleHandler(w *ResponseWriter, r *http.Request) error {
+	rule, err := c.findSuppressionRule(r)
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(rule)
+}
+
+// UpdateSuppressionRuleHandler handles PUT requests to
+// /api/1/suppression-rules/{id}.
+func (c *ApiContext) UpdateSuppressionRuleHandler(w *ResponseWriter, r *http.Request) error {
+	existing, err := c.findSuppressionRule(r)
+	if err != nil {
+		return err
+	}
+
+	var rule core.SuppressionRule
+	if err := DecodeRequestBody(r, &rule); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	rule.Id = existing.Id
+	rule.Owner = existing.Owner
+	if err := rule.Validate(); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	if err := c.appContext.SuppressionRuleStore.Update(rule); err != nil {
+		log.Error("Failed to update suppression rule %s: %v", rule.Id, err)
+		return err
+	}
+	if err := c.appContext.SuppressionFilter.Reload(); err != nil {
+		return err
+	}
+
+	rule, err = c.appContext.SuppressionRuleStore.FindById(rule.Id)
+	if err != nil {
+		return err
+	}
+	return w.OkJSON(rule)
+}
+
+// DeleteSuppressionRuleHandler handles DELETE requests to
+// /api/1/suppression-rules/{id}. Alerts already suppressed by the rule
+// remain archived.
+func (c *ApiContext) DeleteSuppressionRuleHandler(w *ResponseWriter, r *http.Request) error {
+	rule, err := c.findSuppressionRule(r)
+	if err != nil {
+		return err
+	}
+	if err := c.appContext.SuppressionRuleStore.Delete(rule.Id); err != nil {
+		return err
+	}
+	if err := c.appContext.SuppressionFilter.Reload(); err != nil {
+		return err
+	}
+	return w.Ok()
+}
+
+// findSuppressionRule returns the suppression rule for the id in the
+// request path.
+func (c *ApiContext) findSuppressionRule(r *http.Request) (core.SuppressionRule, error) {
+	id := mux.Vars(r)["id"]
+	rule, err := c.appContext.SuppressionRuleStore.FindById(id)
+	if err == core.ErrSuppressionRuleNotFound {
+		return rule, httpNotFoundResponse("No suppression rule with ID " + id)
+	}
+	return rule, err
+}
@@ -0,0 +1,88 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"context"
+	"github.com/jasonish/evebox/core"
+	"github.com/pkg/errors"
+)
+
+// forwardEscalatedEvent forwards an event that has been escalated to
+// syslog if enabled.
+func (c *ApiContext) forwardEscalatedEvent(ctx context.Context, eventId string) error {
+	forwarder := c.appContext.SyslogForwarder
+	if forwarder == nil || !forwarder.Escalated() {
+		return nil
+	}
+	event, err := c.appContext.DataStore.GetEventById(ctx, eventId)
+	if err != nil {
+		return err
+	}
+	if event == nil {
+		return errors.Errorf("event %s not found", eventId)
+	}
+	source, err := toEveEvent(event["_source"])
+	if err != nil {
+		return err
+	}
+	return forwarder.ForwardEscalated(ctx, eventId, source)
+}
+
+// forwardEscalatedAlertGroup forwards the events of an alert group that
+// has been escalated to syslog if enabled. The events are exported by the
+// forwarder in the background.
+func (c *ApiContext) forwardEscalatedAlertGroup(params core.AlertGroupQueryParams) error {
+	forwarder := c.appContext.SyslogForwarder
+	if forwarder == nil || !forwarder.Escalated() {
+		return nil
+	}
+	return forwarder.ForwardEscalatedQuery(c.appContext.DataStore,
+		params.EventQueryOptions())
+}
+
+// forwardEscalatedAlerts forwards the alerts matching the options of an
+// escalate by query to syslog if enabled. Only alerts that are escalated
+// are exported, so alerts received after the escalation are not
+// forwarded.
+func (c *ApiContext) forwardEscalatedAlerts(options core.AlertQueryOptions) error {
+	forwarder := c.appContext.SyslogForwarder
+	if forwarder == nil || !forwarder.Escalated() {
+		return nil
+	}
+	mustNotHaveTags := []string{}
+	for _, tag := range options.MustNotHaveTags {
+		if tag != core.TAG_ESCALATED {
+			mustNotHaveTags = append(mustNotHaveTags, tag)
+		}
+	}
+	options.MustNotHaveTags = mustNotHaveTags
+	options.MustHaveTags = append([]string{core.TAG_ESCALATED},
+		options.MustHaveTags...)
+	return forwarder.ForwardEscalatedQuery(c.appContext.DataStore,
+		options.EventQueryOptions())
+}
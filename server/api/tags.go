@@ -0,0 +1,170 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package api
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/server/sessions"
+	"github.com/jasonish/evebox/stream"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+type TagEventRequest struct {
+	Tags []string `json:"tags"`
+}
+
+type TagAlertGroupRequest struct {
+	AlertGroup AlertGroupQueryParameters `json:"alert_group"`
+	Tags       []string                  `json:"tags"`
+}
+
+// TagEventHandler handles POST requests to /api/1/event/{id}/tag, adding
+// the tags in the request body to the event.
+func (c *ApiContext) TagEventHandler(w *ResponseWriter, r *http.Request) error {
+	return c.updateEventTags(w, r, core.ALERT_ACTION_TAG,
+		c.appContext.DataStore.AddTagsToEvent)
+}
+
+// UntagEventHandler handles POST requests to /api/1/event/{id}/untag,
+// removing the tags in the request body from the event.
+func (c *ApiContext) UntagEventHandler(w *ResponseWriter, r *http.Request) error {
+	return c.updateEventTags(w, r, stream.ACTION_UNTAG,
+		c.appContext.DataStore.RemoveTagsFromEvent)
+}
+
+func (c *ApiContext) updateEventTags(w *ResponseWriter, r *http.Request, action string,
+	update func(ctx context.Context, eventId string, tags []string, user core.User) error) error {
+	session := r.Context().Value("session").(*sessions.Session)
+	eventId := mux.Vars(r)["id"]
+
+	var request TagEventRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	if err := core.ValidateTags(request.Tags); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	if err := update(r.Context(), eventId, request.Tags, session.User); err != nil {
+		log.Error("Failed to update tags on event %s: %v", eventId, err)
+		return err
+	}
+	c.publishAction(r, stream.Action{
+		Action:  action,
+		EventId: eventId,
+		Tags:    request.Tags,
+	})
+
+	return w.Ok()
+}
+
+// TagAlertGroupHandler handles POST requests to /api/1/alert-group/tag,
+// adding tags to each event in an alert group.
+func (c *ApiContext) TagAlertGroupHandler(w *ResponseWriter, r *http.Request) error {
+	return c.updateAlertGroupTags(w, r, core.ALERT_ACTION_TAG,
+		c.appContext.DataStore.AddTagsToAlertGroup)
+}
+
+// UntagAlertGroupHandler handles POST requests to
+// /api/1/alert-group/untag, removing tags from each event in an alert
+// group.
+func (c *ApiContext) UntagAlertGroupHandler(w *ResponseWriter, r *http.Request) error {
+	return c.updateAlertGroupTags(w, r, stream.ACTION_UNTAG,
+		c.appContext.DataStore.RemoveTagsFromAlertGroup)
+}
+
+func (c *ApiContext) updateAlertGroupTags(w *ResponseWriter, r *http.Request, action string,
+	update func(ctx context.Context, p core.AlertGroupQueryParams, tags []string, user core.User) error) error {
+	session := r.Context().Value("session").(*sessions.Session)
+
+	var request TagAlertGroupRequest
+	if err := DecodeRequestBody(r, &request); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+	if err := core.ValidateTags(request.Tags); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	params, err := request.AlertGroup.ToCoreAlertGroupQueryParams()
+	if err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	if err := update(r.Context(), params, request.Tags, session.User); err != nil {
+		log.Error("Failed to update tags on alert group: %v", err)
+		return errors.WithStack(err)
+	}
+	c.publishAction(r, stream.Action{
+		Action:     action,
+		AlertGroup: request.AlertGroup,
+		Tags:       request.Tags,
+	})
+
+	return w.Ok()
+}
+
+// TagsHandler handles GET requests to /api/1/tags, returning the tags in
+// use on events matching the query_string, time_range, min_ts, max_ts and
+// event_type parameters along with the number of events having each.
+func (c *ApiContext) TagsHandler(w *ResponseWriter, r *http.Request) error {
+	var options core.EventQueryOptions
+
+	if err := r.ParseForm(); err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest, err)
+	}
+
+	options.QueryString = r.FormValue("query_string")
+	options.TimeRange = r.FormValue("time_range")
+	options.EventType = r.FormValue("event_type")
+
+	minTs, err := parseFormTimestamp(r, "min_ts")
+	if err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.Wrap(err, "failed to parse min_ts"))
+	}
+	options.MinTs = minTs
+
+	maxTs, err := parseFormTimestamp(r, "max_ts")
+	if err != nil {
+		return newHttpErrorResponse(http.StatusBadRequest,
+			errors.Wrap(err, "failed to parse max_ts"))
+	}
+	options.MaxTs = maxTs
+
+	counts, err := c.appContext.DataStore.TagCounts(r.Context(), options)
+	if err != nil {
+		return err
+	}
+
+	return w.OkJSON(map[string]interface{}{
+		"data": counts,
+	})
+}
@@ -100,6 +100,15 @@ func SessionHandler(handler http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
+		// Browser EventSource and WebSocket clients can't set headers,
+		// so the stream API also takes the session ID as a parameter.
+		if strings.HasPrefix(r.URL.Path, "/api/1/stream") &&
+			r.Header.Get(SESSION_HEADER) == "" {
+			if sessionId := r.URL.Query().Get("session_id"); sessionId != "" {
+				r.Header.Set(SESSION_HEADER, sessionId)
+			}
+		}
+
 		ctx := r.Context()
 		session := sessionStore.FindSession(r)
 		if session != nil {
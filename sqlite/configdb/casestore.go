@@ -0,0 +1,512 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package configdb
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+	"strings"
+	"time"
+)
+
+var caseFields = []string{
+	"uuid",
+	"title",
+	"description",
+	"status",
+	"severity",
+	"assignee",
+	"owner",
+	"created",
+	"updated",
+}
+
+type CaseStore struct {
+	db *sql.DB
+}
+
+func NewCaseStore(db *sql.DB) *CaseStore {
+	return &CaseStore{
+		db: db,
+	}
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func (s *CaseStore) Add(c core.Case) (string, error) {
+	if c.Owner == "" {
+		return "", errors.New("owner is required")
+	}
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+
+	id := uuid.NewV4().String()
+	now := formatTime(time.Now())
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(fmt.Sprintf(`insert into cases (%s)
+	    values (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		strings.Join(caseFields, ", ")),
+		id,
+		c.Title,
+		toNullString(c.Description),
+		c.Status,
+		c.Severity,
+		toNullString(c.Assignee),
+		c.Owner,
+		now,
+		now)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to insert case")
+	}
+	if err := addTimelineEntry(tx, id, c.Owner, core.CASE_ACTION_CREATED, ""); err != nil {
+		return "", err
+	}
+
+	return id, tx.Commit()
+}
+
+func (s *CaseStore) Update(c core.Case, username string) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	existing, err := findCases(tx, "where uuid = ?", c.Id)
+	if err != nil {
+		return err
+	}
+	if len(existing) == 0 {
+		return core.ErrCaseNotFound
+	}
+
+	// Record each changed field in the timeline.
+	changes := []string{}
+	change := func(field string, from string, to string) {
+		if from != to {
+			changes = append(changes, fmt.Sprintf("%s: %q -> %q", field, from, to))
+		}
+	}
+	change("title", existing[0].Title, c.Title)
+	change("description", existing[0].Description, c.Description)
+	change("status", existing[0].Status, c.Status)
+	change("severity", existing[0].Severity, c.Severity)
+	change("assignee", existing[0].Assignee, c.Assignee)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(`update cases set
+	      title = ?,
+	      description = ?,
+	      status = ?,
+	      severity = ?,
+	      assignee = ?,
+	      updated = ?
+	    where uuid = ?`,
+		c.Title,
+		toNullString(c.Description),
+		c.Status,
+		c.Severity,
+		toNullString(c.Assignee),
+		formatTime(time.Now()),
+		c.Id)
+	if err != nil {
+		return errors.Wrap(err, "failed to update case")
+	}
+	if err := addTimelineEntry(tx, c.Id, username, core.CASE_ACTION_UPDATED,
+		strings.Join(changes, "; ")); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *CaseStore) Delete(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	r, err := tx.Exec("delete from cases where uuid = ?", id)
+	if err != nil {
+		return err
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return core.ErrCaseNotFound
+	}
+	for _, table := range []string{"case_events", "case_alert_groups", "case_timeline"} {
+		if _, err := tx.Exec(fmt.Sprintf("delete from %s where case_uuid = ?", table), id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *CaseStore) FindById(id string) (core.Case, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return core.Case{}, err
+	}
+	defer tx.Rollback()
+
+	cases, err := findCases(tx, "where uuid = ?", id)
+	if err != nil {
+		return core.Case{}, err
+	}
+	if len(cases) == 0 {
+		return core.Case{}, core.ErrCaseNotFound
+	}
+	c := cases[0]
+
+	if c.Events, err = findCaseEvents(tx, id); err != nil {
+		return c, errors.Wrap(err, "failed to read case events")
+	}
+	if c.AlertGroups, err = findCaseAlertGroups(tx, id); err != nil {
+		return c, errors.Wrap(err, "failed to read case alert groups")
+	}
+	if c.Timeline, err = findCaseTimeline(tx, id); err != nil {
+		return c, errors.Wrap(err, "failed to read case timeline")
+	}
+
+	return c, nil
+}
+
+func (s *CaseStore) Find(query core.CaseQuery) ([]core.Case, error) {
+	filters := []string{}
+	args := []interface{}{}
+	if query.Status != "" {
+		filters = append(filters, "status = ?")
+		args = append(args, query.Status)
+	}
+	if query.Assignee != "" {
+		filters = append(filters, "assignee = ?")
+		args = append(args, query.Assignee)
+	}
+	where := ""
+	if len(filters) > 0 {
+		where = "where " + strings.Join(filters, " and ")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return findCases(tx, where+" order by updated desc", args...)
+}
+
+func (s *CaseStore) AddEvent(caseId string, eventId string, username string) error {
+	return s.update(caseId, func(tx *sql.Tx, now string) error {
+		r, err := tx.Exec(`insert or ignore into case_events
+		    (case_uuid, event_id, username, added) values (?, ?, ?, ?)`,
+			caseId, eventId, username, now)
+		if err != nil {
+			return err
+		}
+		if n, _ := r.RowsAffected(); n == 0 {
+			return nil
+		}
+		return addTimelineEntry(tx, caseId, username, core.CASE_ACTION_EVENT_ADDED, eventId)
+	})
+}
+
+func (s *CaseStore) RemoveEvent(caseId string, eventId string, username string) error {
+	return s.update(caseId, func(tx *sql.Tx, now string) error {
+		r, err := tx.Exec("delete from case_events where case_uuid = ? and event_id = ?",
+			caseId, eventId)
+		if err != nil {
+			return err
+		}
+		if n, _ := r.RowsAffected(); n == 0 {
+			return errors.Errorf("event %s is not attached to case", eventId)
+		}
+		return addTimelineEntry(tx, caseId, username, core.CASE_ACTION_EVENT_REMOVED, eventId)
+	})
+}
+
+func (s *CaseStore) AddAlertGroup(caseId string, group core.CaseAlertGroup, username string) (string, error) {
+	id := uuid.NewV4().String()
+	err := s.update(caseId, func(tx *sql.Tx, now string) error {
+		_, err := tx.Exec(`insert into case_alert_groups
+		    (uuid, case_uuid, signature_id, src_ip, dest_ip, host,
+		      min_timestamp, max_timestamp, group_by, username, added)
+		    values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			id,
+			caseId,
+			group.SignatureId,
+			toNullString(group.SrcIp),
+			toNullString(group.DestIp),
+			toNullString(group.Host),
+			formatTime(group.MinTimestamp),
+			formatTime(group.MaxTimestamp),
+			toNullString(strings.Join(group.GroupBy, ",")),
+			username,
+			now)
+		if err != nil {
+			return err
+		}
+		return addTimelineEntry(tx, caseId, username,
+			core.CASE_ACTION_ALERT_GROUP_ADDED, id)
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (s *CaseStore) RemoveAlertGroup(caseId string, groupId string, username string) error {
+	return s.update(caseId, func(tx *sql.Tx, now string) error {
+		r, err := tx.Exec("delete from case_alert_groups where case_uuid = ? and uuid = ?",
+			caseId, groupId)
+		if err != nil {
+			return err
+		}
+		if n, _ := r.RowsAffected(); n == 0 {
+			return errors.Errorf("alert group %s is not attached to case", groupId)
+		}
+		return addTimelineEntry(tx, caseId, username,
+			core.CASE_ACTION_ALERT_GROUP_REMOVED, groupId)
+	})
+}
+
+func (s *CaseStore) AddComment(caseId string, username string, comment string) error {
+	if comment == "" {
+		return errors.New("empty comment")
+	}
+	return s.update(caseId, func(tx *sql.Tx, now string) error {
+		return addTimelineEntry(tx, caseId, username, core.CASE_ACTION_COMMENT, comment)
+	})
+}
+
+// update runs fn in a transaction, then sets the updated time of the case.
+// Returns core.ErrCaseNotFound if the case does not exist.
+func (s *CaseStore) update(caseId string, fn func(tx *sql.Tx, now string) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := formatTime(time.Now())
+	r, err := tx.Exec("update cases set updated = ? where uuid = ?", now, caseId)
+	if err != nil {
+		return err
+	}
+	if n, err := r.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return core.ErrCaseNotFound
+	}
+
+	if err := fn(tx, now); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func addTimelineEntry(tx *sql.Tx, caseId string, username string, action string, comment string) error {
+	_, err := tx.Exec(`insert into case_timeline
+	    (case_uuid, timestamp, username, action, comment) values (?, ?, ?, ?, ?)`,
+		caseId, formatTime(time.Now()), username, action, toNullString(comment))
+	if err != nil {
+		return errors.Wrap(err, "failed to add case timeline entry")
+	}
+	return nil
+}
+
+func findCases(tx *sql.Tx, where string, args ...interface{}) ([]core.Case, error) {
+	rows, err := tx.Query(fmt.Sprintf("select %s from cases %s",
+		strings.Join(caseFields, ", "), where), args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query cases")
+	}
+	defer rows.Close()
+
+	cases := []core.Case{}
+	for rows.Next() {
+		c := core.Case{}
+		var description sql.NullString
+		var assignee sql.NullString
+		var created string
+		var updated string
+		err := rows.Scan(
+			&c.Id,
+			&c.Title,
+			&description,
+			&c.Status,
+			&c.Severity,
+			&assignee,
+			&c.Owner,
+			&created,
+			&updated,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read case")
+		}
+		c.Description = description.String
+		c.Assignee = assignee.String
+		if c.Created, err = time.Parse(time.RFC3339Nano, created); err != nil {
+			return nil, err
+		}
+		if c.Updated, err = time.Parse(time.RFC3339Nano, updated); err != nil {
+			return nil, err
+		}
+		cases = append(cases, c)
+	}
+
+	return cases, rows.Err()
+}
+
+func findCaseEvents(tx *sql.Tx, caseId string) ([]core.CaseEvent, error) {
+	rows, err := tx.Query(`select event_id, username, added from case_events
+	    where case_uuid = ? order by added`, caseId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []core.CaseEvent{}
+	for rows.Next() {
+		event := core.CaseEvent{}
+		var added string
+		if err := rows.Scan(&event.EventId, &event.Username, &added); err != nil {
+			return nil, err
+		}
+		if event.Added, err = time.Parse(time.RFC3339Nano, added); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+func findCaseAlertGroups(tx *sql.Tx, caseId string) ([]core.CaseAlertGroup, error) {
+	rows, err := tx.Query(`select uuid, signature_id, src_ip, dest_ip, host,
+	      min_timestamp, max_timestamp, group_by, username, added
+	    from case_alert_groups where case_uuid = ? order by added`, caseId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []core.CaseAlertGroup{}
+	for rows.Next() {
+		group := core.CaseAlertGroup{}
+		var srcIp sql.NullString
+		var destIp sql.NullString
+		var host sql.NullString
+		var minTimestamp string
+		var maxTimestamp string
+		var groupBy sql.NullString
+		var added string
+		err := rows.Scan(
+			&group.Id,
+			&group.SignatureId,
+			&srcIp,
+			&destIp,
+			&host,
+			&minTimestamp,
+			&maxTimestamp,
+			&groupBy,
+			&group.Username,
+			&added,
+		)
+		if err != nil {
+			return nil, err
+		}
+		group.SrcIp = srcIp.String
+		group.DestIp = destIp.String
+		group.Host = host.String
+		if groupBy.String != "" {
+			group.GroupBy = strings.Split(groupBy.String, ",")
+		}
+		if group.MinTimestamp, err = time.Parse(time.RFC3339Nano, minTimestamp); err != nil {
+			return nil, err
+		}
+		if group.MaxTimestamp, err = time.Parse(time.RFC3339Nano, maxTimestamp); err != nil {
+			return nil, err
+		}
+		if group.Added, err = time.Parse(time.RFC3339Nano, added); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, rows.Err()
+}
+
+func findCaseTimeline(tx *sql.Tx, caseId string) ([]core.CaseTimelineEntry, error) {
+	rows, err := tx.Query(`select timestamp, username, action, comment
+	    from case_timeline where case_uuid = ? order by rowid`, caseId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []core.CaseTimelineEntry{}
+	for rows.Next() {
+		entry := core.CaseTimelineEntry{}
+		var timestamp string
+		var comment sql.NullString
+		if err := rows.Scan(&timestamp, &entry.Username, &entry.Action, &comment); err != nil {
+			return nil, err
+		}
+		entry.Comment = comment.String
+		if entry.Timestamp, err = time.Parse(time.RFC3339Nano, timestamp); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
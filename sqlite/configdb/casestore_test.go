@@ -0,0 +1,137 @@
+package configdb
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func SetupCaseStore(t *testing.T) *CaseStore {
+	db, err := NewConfigDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewCaseStore(db.DB)
+}
+
+func newTestCase() core.Case {
+	return core.Case{
+		Title:    "Beaconing from 10.0.0.1",
+		Owner:    "alice",
+		Status:   core.CASE_STATUS_OPEN,
+		Severity: core.CASE_SEVERITY_HIGH,
+	}
+}
+
+func TestCaseAdd(t *testing.T) {
+	store := SetupCaseStore(t)
+
+	id, err := store.Add(newTestCase())
+	require.Nil(t, err)
+
+	c, err := store.FindById(id)
+	require.Nil(t, err)
+	assert.Equal(t, "alice", c.Owner)
+	assert.Equal(t, core.CASE_STATUS_OPEN, c.Status)
+	assert.False(t, c.Created.IsZero())
+	require.Len(t, c.Timeline, 1)
+	assert.Equal(t, core.CASE_ACTION_CREATED, c.Timeline[0].Action)
+
+	for _, c := range []core.Case{
+		{Owner: "alice", Status: core.CASE_STATUS_OPEN, Severity: core.CASE_SEVERITY_LOW},
+		{Owner: "alice", Title: "a", Status: "bad", Severity: core.CASE_SEVERITY_LOW},
+		{Owner: "alice", Title: "a", Status: core.CASE_STATUS_OPEN, Severity: "bad"},
+		{Title: "a", Status: core.CASE_STATUS_OPEN, Severity: core.CASE_SEVERITY_LOW},
+	} {
+		_, err := store.Add(c)
+		assert.NotNil(t, err, "%+v", c)
+	}
+
+	_, err = store.FindById("bogus")
+	assert.Equal(t, core.ErrCaseNotFound, err)
+}
+
+func TestCaseUpdate(t *testing.T) {
+	store := SetupCaseStore(t)
+
+	id, err := store.Add(newTestCase())
+	require.Nil(t, err)
+	c, err := store.FindById(id)
+	require.Nil(t, err)
+
+	c.Status = core.CASE_STATUS_IN_PROGRESS
+	c.Assignee = "bob"
+	require.Nil(t, store.Update(c, "bob"))
+
+	c, err = store.FindById(id)
+	require.Nil(t, err)
+	assert.Equal(t, "bob", c.Assignee)
+	require.Len(t, c.Timeline, 2)
+	assert.Equal(t, core.CASE_ACTION_UPDATED, c.Timeline[1].Action)
+	assert.Equal(t, "bob", c.Timeline[1].Username)
+	assert.Contains(t, c.Timeline[1].Comment, `status: "open" -> "in-progress"`)
+
+	cases, err := store.Find(core.CaseQuery{Assignee: "bob"})
+	require.Nil(t, err)
+	assert.Len(t, cases, 1)
+	cases, err = store.Find(core.CaseQuery{Status: core.CASE_STATUS_CLOSED})
+	require.Nil(t, err)
+	assert.Len(t, cases, 0)
+
+	c.Id = "bogus"
+	assert.Equal(t, core.ErrCaseNotFound, store.Update(c, "bob"))
+}
+
+func TestCaseAttachments(t *testing.T) {
+	store := SetupCaseStore(t)
+
+	id, err := store.Add(newTestCase())
+	require.Nil(t, err)
+
+	require.Nil(t, store.AddEvent(id, "event-1", "alice"))
+
+	// Adding an event twice is not an error, or recorded twice.
+	require.Nil(t, store.AddEvent(id, "event-1", "alice"))
+
+	minTs := time.Date(2017, 6, 1, 10, 0, 0, 0, time.UTC)
+	groupId, err := store.AddAlertGroup(id, core.NewCaseAlertGroup(core.AlertGroupQueryParams{
+		SignatureID:  1,
+		SrcIP:        "10.0.0.1",
+		DstIP:        "10.0.0.2",
+		MinTimestamp: minTs,
+		MaxTimestamp: minTs.Add(time.Minute),
+	}), "alice")
+	require.Nil(t, err)
+	require.Nil(t, store.AddComment(id, "bob", "looks bad"))
+
+	c, err := store.FindById(id)
+	require.Nil(t, err)
+	require.Len(t, c.Events, 1)
+	assert.Equal(t, "event-1", c.Events[0].EventId)
+	require.Len(t, c.AlertGroups, 1)
+	params := c.AlertGroups[0].Params()
+	assert.Equal(t, uint64(1), params.SignatureID)
+	assert.Equal(t, "10.0.0.2", params.DstIP)
+	assert.True(t, minTs.Equal(params.MinTimestamp))
+	require.Len(t, c.Timeline, 4)
+	assert.Equal(t, core.CASE_ACTION_COMMENT, c.Timeline[3].Action)
+	assert.Equal(t, "looks bad", c.Timeline[3].Comment)
+
+	require.Nil(t, store.RemoveEvent(id, "event-1", "alice"))
+	assert.NotNil(t, store.RemoveEvent(id, "event-1", "alice"))
+	require.Nil(t, store.RemoveAlertGroup(id, groupId, "alice"))
+
+	c, err = store.FindById(id)
+	require.Nil(t, err)
+	assert.Len(t, c.Events, 0)
+	assert.Len(t, c.AlertGroups, 0)
+
+	assert.Equal(t, core.ErrCaseNotFound, store.AddEvent("bogus", "event-1", "alice"))
+
+	require.Nil(t, store.Delete(id))
+	_, err = store.FindById(id)
+	assert.Equal(t, core.ErrCaseNotFound, err)
+	assert.Equal(t, core.ErrCaseNotFound, store.Delete(id))
+}
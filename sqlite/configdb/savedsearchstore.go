@@ -0,0 +1,276 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package configdb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+	"strings"
+	"time"
+)
+
+var savedSearchFields = []string{
+	"uuid",
+	"owner",
+	"name",
+	"type",
+	"query_string",
+	"time_range",
+	"must_have_tags",
+	"must_not_have_tags",
+	"group_by",
+	"event_type",
+	"shared",
+	"created",
+	"updated",
+}
+
+type SavedSearchStore struct {
+	db *sql.DB
+}
+
+func NewSavedSearchStore(db *sql.DB) *SavedSearchStore {
+	return &SavedSearchStore{
+		db: db,
+	}
+}
+
+func (s *SavedSearchStore) Add(search core.SavedSearch) (string, error) {
+	if search.Owner == "" {
+		return "", errors.New("owner is required")
+	}
+	if err := search.Validate(); err != nil {
+		return "", err
+	}
+
+	id := uuid.NewV4().String()
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	mustHaveTags, mustNotHaveTags, err := encodeTags(search)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.db.Exec(fmt.Sprintf(`insert into saved_searches (%s)
+	    values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		strings.Join(savedSearchFields, ", ")),
+		id,
+		search.Owner,
+		search.Name,
+		search.Type,
+		toNullString(search.QueryString),
+		toNullString(search.TimeRange),
+		mustHaveTags,
+		mustNotHaveTags,
+		toNullString(strings.Join(search.GroupBy, ",")),
+		toNullString(search.EventType),
+		search.Shared,
+		now,
+		now)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to insert saved search")
+	}
+
+	return id, nil
+}
+
+func (s *SavedSearchStore) Update(search core.SavedSearch) error {
+	if err := search.Validate(); err != nil {
+		return err
+	}
+
+	mustHaveTags, mustNotHaveTags, err := encodeTags(search)
+	if err != nil {
+		return err
+	}
+
+	r, err := s.db.Exec(`update saved_searches set
+	      name = ?,
+	      type = ?,
+	      query_string = ?,
+	      time_range = ?,
+	      must_have_tags = ?,
+	      must_not_have_tags = ?,
+	      group_by = ?,
+	      event_type = ?,
+	      shared = ?,
+	      updated = ?
+	    where uuid = ?`,
+		search.Name,
+		search.Type,
+		toNullString(search.QueryString),
+		toNullString(search.TimeRange),
+		mustHaveTags,
+		mustNotHaveTags,
+		toNullString(strings.Join(search.GroupBy, ",")),
+		toNullString(search.EventType),
+		search.Shared,
+		time.Now().UTC().Format(time.RFC3339Nano),
+		search.Id)
+	if err != nil {
+		return errors.Wrap(err, "failed to update saved search")
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return core.ErrSavedSearchNotFound
+	}
+	return nil
+}
+
+func (s *SavedSearchStore) Delete(id string) error {
+	r, err := s.db.Exec("delete from saved_searches where uuid = ?", id)
+	if err != nil {
+		return err
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return core.ErrSavedSearchNotFound
+	}
+	return nil
+}
+
+func (s *SavedSearchStore) FindById(id string) (core.SavedSearch, error) {
+	searches, err := s.find("where uuid = ?", id)
+	if err != nil {
+		return core.SavedSearch{}, err
+	}
+	if len(searches) == 0 {
+		return core.SavedSearch{}, core.ErrSavedSearchNotFound
+	}
+	return searches[0], nil
+}
+
+func (s *SavedSearchStore) FindVisible(username string) ([]core.SavedSearch, error) {
+	return s.find("where owner = ? or shared = 1 order by name", username)
+}
+
+func (s *SavedSearchStore) find(where string, args ...interface{}) ([]core.SavedSearch, error) {
+	rows, err := s.db.Query(fmt.Sprintf("select %s from saved_searches %s",
+		strings.Join(savedSearchFields, ", "), where), args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query saved searches")
+	}
+	defer rows.Close()
+
+	searches := []core.SavedSearch{}
+	for rows.Next() {
+		search, err := mapSavedSearch(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read saved search")
+		}
+		searches = append(searches, search)
+	}
+
+	return searches, rows.Err()
+}
+
+func mapSavedSearch(rows *sql.Rows) (core.SavedSearch, error) {
+	search := core.SavedSearch{}
+
+	var queryString sql.NullString
+	var timeRange sql.NullString
+	var mustHaveTags sql.NullString
+	var mustNotHaveTags sql.NullString
+	var groupBy sql.NullString
+	var eventType sql.NullString
+	var created string
+	var updated string
+
+	err := rows.Scan(
+		&search.Id,
+		&search.Owner,
+		&search.Name,
+		&search.Type,
+		&queryString,
+		&timeRange,
+		&mustHaveTags,
+		&mustNotHaveTags,
+		&groupBy,
+		&eventType,
+		&search.Shared,
+		&created,
+		&updated,
+	)
+	if err != nil {
+		return search, err
+	}
+
+	search.QueryString = queryString.String
+	search.TimeRange = timeRange.String
+	search.EventType = eventType.String
+	if groupBy.String != "" {
+		search.GroupBy = strings.Split(groupBy.String, ",")
+	}
+	if mustHaveTags.Valid {
+		if err := json.Unmarshal([]byte(mustHaveTags.String), &search.MustHaveTags); err != nil {
+			return search, err
+		}
+	}
+	if mustNotHaveTags.Valid {
+		if err := json.Unmarshal([]byte(mustNotHaveTags.String), &search.MustNotHaveTags); err != nil {
+			return search, err
+		}
+	}
+	if search.Created, err = time.Parse(time.RFC3339Nano, created); err != nil {
+		return search, err
+	}
+	if search.Updated, err = time.Parse(time.RFC3339Nano, updated); err != nil {
+		return search, err
+	}
+
+	return search, nil
+}
+
+// encodeTags returns the must have and must not have tags as JSON arrays.
+func encodeTags(search core.SavedSearch) (sql.NullString, sql.NullString, error) {
+	encode := func(tags []string) (sql.NullString, error) {
+		if len(tags) == 0 {
+			return sql.NullString{}, nil
+		}
+		buf, err := json.Marshal(tags)
+		if err != nil {
+			return sql.NullString{}, err
+		}
+		return toNullString(string(buf)), nil
+	}
+	mustHaveTags, err := encode(search.MustHaveTags)
+	if err != nil {
+		return mustHaveTags, mustHaveTags, err
+	}
+	mustNotHaveTags, err := encode(search.MustNotHaveTags)
+	return mustHaveTags, mustNotHaveTags, err
+}
@@ -0,0 +1,112 @@
+package configdb
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func SetupSavedSearchStore(t *testing.T) *SavedSearchStore {
+	db, err := NewConfigDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSavedSearchStore(db.DB)
+}
+
+func TestSavedSearchAdd(t *testing.T) {
+	store := SetupSavedSearchStore(t)
+
+	id, err := store.Add(core.SavedSearch{
+		Owner:           "alice",
+		Name:            "Escalated DNS",
+		Type:            core.SAVED_SEARCH_ALERTS,
+		QueryString:     "dns.rrname:*.example.com",
+		TimeRange:       "24h",
+		MustHaveTags:    []string{"escalated"},
+		MustNotHaveTags: []string{"archived"},
+		GroupBy:         []string{"signature_id", "host"},
+	})
+	require.Nil(t, err)
+
+	search, err := store.FindById(id)
+	require.Nil(t, err)
+	assert.Equal(t, "alice", search.Owner)
+	assert.Equal(t, []string{"escalated"}, search.MustHaveTags)
+	assert.Equal(t, []string{"signature_id", "host"}, search.GroupBy)
+	assert.False(t, search.Shared)
+	assert.False(t, search.Created.IsZero())
+
+	options := search.AlertQueryOptions()
+	assert.Equal(t, "24h", options.TimeRange)
+	assert.Equal(t, []string{"archived"}, options.MustNotHaveTags)
+
+	// Names are unique per owner.
+	_, err = store.Add(core.SavedSearch{
+		Owner: "alice", Name: "Escalated DNS", Type: core.SAVED_SEARCH_EVENTS,
+	})
+	assert.NotNil(t, err)
+
+	for _, search := range []core.SavedSearch{
+		{Owner: "alice", Type: core.SAVED_SEARCH_ALERTS},
+		{Owner: "alice", Name: "a", Type: "bad"},
+		{Owner: "alice", Name: "a", Type: core.SAVED_SEARCH_ALERTS, TimeRange: "1 day"},
+		{Owner: "alice", Name: "a", Type: core.SAVED_SEARCH_ALERTS, GroupBy: []string{"proto"}},
+		{Owner: "alice", Name: "a", Type: core.SAVED_SEARCH_EVENTS, MustHaveTags: []string{"a"}},
+		{Name: "a", Type: core.SAVED_SEARCH_EVENTS},
+	} {
+		_, err := store.Add(search)
+		assert.NotNil(t, err, "%+v", search)
+	}
+}
+
+func TestSavedSearchVisibility(t *testing.T) {
+	store := SetupSavedSearchStore(t)
+
+	_, err := store.Add(core.SavedSearch{Owner: "alice", Name: "private",
+		Type: core.SAVED_SEARCH_EVENTS})
+	require.Nil(t, err)
+	_, err = store.Add(core.SavedSearch{Owner: "alice", Name: "shared",
+		Type: core.SAVED_SEARCH_EVENTS, Shared: true})
+	require.Nil(t, err)
+	_, err = store.Add(core.SavedSearch{Owner: "bob", Name: "bob's",
+		Type: core.SAVED_SEARCH_EVENTS})
+	require.Nil(t, err)
+
+	searches, err := store.FindVisible("alice")
+	require.Nil(t, err)
+	assert.Len(t, searches, 2)
+
+	searches, err = store.FindVisible("bob")
+	require.Nil(t, err)
+	require.Len(t, searches, 2)
+	assert.Equal(t, "bob's", searches[0].Name)
+	assert.Equal(t, "shared", searches[1].Name)
+}
+
+func TestSavedSearchUpdateDelete(t *testing.T) {
+	store := SetupSavedSearchStore(t)
+
+	id, err := store.Add(core.SavedSearch{Owner: "alice", Name: "flows",
+		Type: core.SAVED_SEARCH_EVENTS, EventType: "flow"})
+	require.Nil(t, err)
+
+	search, err := store.FindById(id)
+	require.Nil(t, err)
+	search.QueryString = "dest_port:443"
+	search.Shared = true
+	require.Nil(t, store.Update(search))
+
+	search, err = store.FindById(id)
+	require.Nil(t, err)
+	assert.Equal(t, "dest_port:443", search.QueryString)
+	assert.True(t, search.Shared)
+	assert.Equal(t, "flow", search.EventQueryOptions().EventType)
+
+	require.Nil(t, store.Delete(id))
+	_, err = store.FindById(id)
+	assert.Equal(t, core.ErrSavedSearchNotFound, err)
+	assert.Equal(t, core.ErrSavedSearchNotFound, store.Delete(id))
+	assert.Equal(t, core.ErrSavedSearchNotFound, store.Update(search))
+}
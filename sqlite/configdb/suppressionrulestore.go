@@ -0,0 +1,244 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package configdb
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+	"strings"
+	"time"
+)
+
+var suppressionRuleFields = []string{
+	"uuid",
+	"name",
+	"enabled",
+	"signature_id",
+	"src_ip",
+	"dest_ip",
+	"src_port",
+	"dest_port",
+	"host",
+	"query_string",
+	"comment",
+	"owner",
+	"created",
+	"updated",
+}
+
+type SuppressionRuleStore struct {
+	db *sql.DB
+}
+
+func NewSuppressionRuleStore(db *sql.DB) *SuppressionRuleStore {
+	return &SuppressionRuleStore{
+		db: db,
+	}
+}
+
+func (s *SuppressionRuleStore) Add(rule core.SuppressionRule) (string, error) {
+	if rule.Owner == "" {
+		return "", errors.New("owner is required")
+	}
+	if err := rule.Validate(); err != nil {
+		return "", err
+	}
+
+	id := uuid.NewV4().String()
+	now := formatTime(time.Now())
+
+	_, err := s.db.Exec(fmt.Sprintf(`insert into suppression_rules (%s)
+	    values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		strings.Join(suppressionRuleFields, ", ")),
+		id,
+		rule.Name,
+		rule.Enabled,
+		toNullInt64(int64(rule.SignatureId)),
+		toNullString(rule.SrcIp),
+		toNullString(rule.DestIp),
+		toNullInt64(int64(rule.SrcPort)),
+		toNullInt64(int64(rule.DestPort)),
+		toNullString(rule.Host),
+		toNullString(rule.QueryString),
+		toNullString(rule.Comment),
+		rule.Owner,
+		now,
+		now)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to insert suppression rule")
+	}
+
+	return id, nil
+}
+
+func (s *SuppressionRuleStore) Update(rule core.SuppressionRule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+
+	r, err := s.db.Exec(`update suppression_rules set
+	      name = ?,
+	      enabled = ?,
+	      signature_id = ?,
+	      src_ip = ?,
+	      dest_ip = ?,
+	      src_port = ?,
+	      dest_port = ?,
+	      host = ?,
+	      query_string = ?,
+	      comment = ?,
+	      updated = ?
+	    where uuid = ?`,
+		rule.Name,
+		rule.Enabled,
+		toNullInt64(int64(rule.SignatureId)),
+		toNullString(rule.SrcIp),
+		toNullString(rule.DestIp),
+		toNullInt64(int64(rule.SrcPort)),
+		toNullInt64(int64(rule.DestPort)),
+		toNullString(rule.Host),
+		toNullString(rule.QueryString),
+		toNullString(rule.Comment),
+		formatTime(time.Now()),
+		rule.Id)
+	if err != nil {
+		return errors.Wrap(err, "failed to update suppression rule")
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return core.ErrSuppressionRuleNotFound
+	}
+	return nil
+}
+
+func (s *SuppressionRuleStore) Delete(id string) error {
+	r, err := s.db.Exec("delete from suppression_rules where uuid = ?", id)
+	if err != nil {
+		return err
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return core.ErrSuppressionRuleNotFound
+	}
+	return nil
+}
+
+func (s *SuppressionRuleStore) FindById(id string) (core.SuppressionRule, error) {
+	rules, err := s.find("where uuid = ?", id)
+	if err != nil {
+		return core.SuppressionRule{}, err
+	}
+	if len(rules) == 0 {
+		return core.SuppressionRule{}, core.ErrSuppressionRuleNotFound
+	}
+	return rules[0], nil
+}
+
+func (s *SuppressionRuleStore) FindAll() ([]core.SuppressionRule, error) {
+	return s.find("order by name")
+}
+
+func (s *SuppressionRuleStore) find(where string, args ...interface{}) ([]core.SuppressionRule, error) {
+	rows, err := s.db.Query(fmt.Sprintf("select %s from suppression_rules %s",
+		strings.Join(suppressionRuleFields, ", "), where), args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query suppression rules")
+	}
+	defer rows.Close()
+
+	rules := []core.SuppressionRule{}
+	for rows.Next() {
+		rule, err := mapSuppressionRule(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read suppression rule")
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func mapSuppressionRule(rows *sql.Rows) (core.SuppressionRule, error) {
+	rule := core.SuppressionRule{}
+
+	var signatureId sql.NullInt64
+	var srcIp sql.NullString
+	var destIp sql.NullString
+	var srcPort sql.NullInt64
+	var destPort sql.NullInt64
+	var host sql.NullString
+	var queryString sql.NullString
+	var comment sql.NullString
+	var created string
+	var updated string
+
+	err := rows.Scan(
+		&rule.Id,
+		&rule.Name,
+		&rule.Enabled,
+		&signatureId,
+		&srcIp,
+		&destIp,
+		&srcPort,
+		&destPort,
+		&host,
+		&queryString,
+		&comment,
+		&rule.Owner,
+		&created,
+		&updated,
+	)
+	if err != nil {
+		return rule, err
+	}
+
+	rule.SignatureId = uint64(signatureId.Int64)
+	rule.SrcIp = srcIp.String
+	rule.DestIp = destIp.String
+	rule.SrcPort = uint16(srcPort.Int64)
+	rule.DestPort = uint16(destPort.Int64)
+	rule.Host = host.String
+	rule.QueryString = queryString.String
+	rule.Comment = comment.String
+	if rule.Created, err = time.Parse(time.RFC3339Nano, created); err != nil {
+		return rule, err
+	}
+	if rule.Updated, err = time.Parse(time.RFC3339Nano, updated); err != nil {
+		return rule, err
+	}
+
+	return rule, nil
+}
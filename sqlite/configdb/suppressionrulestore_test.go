@@ -0,0 +1,75 @@
+package configdb
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func SetupSuppressionRuleStore(t *testing.T) *SuppressionRuleStore {
+	db, err := NewConfigDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSuppressionRuleStore(db.DB)
+}
+
+func TestSuppressionRuleStore(t *testing.T) {
+	store := SetupSuppressionRuleStore(t)
+
+	id, err := store.Add(core.SuppressionRule{
+		Owner:       "alice",
+		Name:        "Scanner",
+		Enabled:     true,
+		SignatureId: 2013028,
+		SrcIp:       "10.20.0.0/16",
+		DestPort:    53,
+		Host:        "sensor-1",
+		QueryString: "alert.category:*Policy*",
+		Comment:     "Known vulnerability scanner",
+	})
+	require.Nil(t, err)
+
+	rule, err := store.FindById(id)
+	require.Nil(t, err)
+	assert.Equal(t, "alice", rule.Owner)
+	assert.True(t, rule.Enabled)
+	assert.Equal(t, uint64(2013028), rule.SignatureId)
+	assert.Equal(t, "10.20.0.0/16", rule.SrcIp)
+	assert.Equal(t, "", rule.DestIp)
+	assert.Equal(t, uint16(0), rule.SrcPort)
+	assert.Equal(t, uint16(53), rule.DestPort)
+	assert.Equal(t, "sensor-1", rule.Host)
+	assert.Equal(t, "Known vulnerability scanner", rule.Comment)
+	assert.False(t, rule.Created.IsZero())
+
+	rule.Enabled = false
+	rule.SignatureId = 0
+	require.Nil(t, store.Update(rule))
+	rule, err = store.FindById(id)
+	require.Nil(t, err)
+	assert.False(t, rule.Enabled)
+	assert.Equal(t, uint64(0), rule.SignatureId)
+
+	rules, err := store.FindAll()
+	require.Nil(t, err)
+	assert.Len(t, rules, 1)
+
+	for _, rule := range []core.SuppressionRule{
+		{Owner: "alice", SignatureId: 1},
+		{Owner: "alice", Name: "no criteria"},
+		{Owner: "alice", Name: "a", SrcIp: "10.0.0.0/33"},
+		{Owner: "alice", Name: "a", QueryString: "has:nothing"},
+		{Name: "a", SignatureId: 1},
+	} {
+		_, err := store.Add(rule)
+		assert.NotNil(t, err, "%+v", rule)
+	}
+
+	require.Nil(t, store.Delete(id))
+	_, err = store.FindById(id)
+	assert.Equal(t, core.ErrSuppressionRuleNotFound, err)
+	assert.Equal(t, core.ErrSuppressionRuleNotFound, store.Delete(id))
+	assert.Equal(t, core.ErrSuppressionRuleNotFound, store.Update(rule))
+}
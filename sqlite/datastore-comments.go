@@ -0,0 +1,185 @@
+// +build cgo
+
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Event comments.
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/elasticsearch"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// CommentOnEventId adds a comment to a single event.
+func (d *DataStore) CommentOnEventId(ctx context.Context, eventId string, user core.User, comment string) error {
+	return d.commentOnEvent(ctx, eventId, user.Username, comment, time.Now())
+}
+
+// commentOnEvent adds a comment made at the given time to a single event.
+func (d *DataStore) commentOnEvent(ctx context.Context, eventId string, username string, comment string, timestamp time.Time) error {
+	rowid, err := strconv.ParseInt(eventId, 10, 64)
+	if err != nil {
+		return core.NewEventNotFoundError(eventId)
+	}
+
+	tx, err := d.db.GetTx(ctx)
+	if err != nil {
+		log.Error("%v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int64
+	err = tx.QueryRowContext(ctx, "SELECT count(*) FROM events WHERE rowid = ?",
+		rowid).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return core.NewEventNotFoundError(eventId)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO comments (event_id, timestamp, username, comment)
+            VALUES (?, ?, ?, ?)`,
+		rowid, timestamp.UnixNano(), username, comment)
+	if err != nil {
+		log.Error("Failed to add comment to event %s: %v", eventId, err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CommentOnAlertGroup adds the comment to each event in the alert group.
+func (d *DataStore) CommentOnAlertGroup(ctx context.Context, p core.AlertGroupQueryParams, user core.User, comment string) error {
+	b := SqlBuilder{}
+	b.Select("rowid, ?, ?, ?")
+	b.args = append(b.args, time.Now().UnixNano(), user.Username, comment)
+	b.From("events")
+	b.WhereEquals("json_extract(events.source, '$.event_type')", "alert")
+	whereAlertGroup(&b, p)
+
+	query := "INSERT INTO comments (event_id, timestamp, username, comment) " +
+		b.Build()
+
+	tx, err := d.db.GetTx(ctx)
+	if err != nil {
+		log.Error("%v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	start := time.Now()
+	r, err := tx.ExecContext(ctx, query, b.Args()...)
+	if err != nil {
+		log.Error("Failed to comment on alert group: %v", err)
+		return err
+	}
+	count, _ := r.RowsAffected()
+	log.Debug("Commented on %d events in %v", count, time.Now().Sub(start))
+
+	return tx.Commit()
+}
+
+// getComments returns the comments for an event as history entries in the
+// order they were made.
+func getComments(ctx context.Context, tx *sql.Tx, rowid int64) ([]interface{}, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT timestamp, username, comment
+            FROM comments WHERE event_id = ? ORDER BY timestamp`, rowid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []interface{}{}
+	for rows.Next() {
+		var timestamp int64
+		var username sql.NullString
+		var comment string
+		if err := rows.Scan(&timestamp, &username, &comment); err != nil {
+			return nil, err
+		}
+		comments = append(comments, map[string]interface{}{
+			"action":    elasticsearch.ACTION_COMMENT,
+			"username":  username.String,
+			"comment":   comment,
+			"timestamp": eve.FormatTimestampUTC(time.Unix(0, timestamp)),
+		})
+	}
+
+	return comments, rows.Err()
+}
+
+// addEventHistory sets the history of an event, the entries in its
+// metadata along with its comments, in time order.
+func addEventHistory(ctx context.Context, tx *sql.Tx, event eve.EveEvent, rowid int64, rawMetadata []byte) error {
+	history := []interface{}{}
+	if rawMetadata != nil {
+		var metadata map[string]interface{}
+		if err := json.Unmarshal(rawMetadata, &metadata); err != nil {
+			log.Error("Failed to decode event metadata: %v", err)
+		} else if entries, ok := metadata["history"].([]interface{}); ok {
+			history = append(history, entries...)
+		}
+	}
+
+	comments, err := getComments(ctx, tx, rowid)
+	if err != nil {
+		return err
+	}
+	history = append(history, comments...)
+
+	if len(history) > 0 {
+		// Timestamps are all UTC in the same format so sort as strings.
+		sort.SliceStable(history, func(i, j int) bool {
+			return historyTimestamp(history[i]) < historyTimestamp(history[j])
+		})
+		event["evebox"] = map[string]interface{}{
+			"history": history,
+		}
+	}
+
+	return nil
+}
+
+func historyTimestamp(entry interface{}) string {
+	if entry, ok := entry.(map[string]interface{}); ok {
+		if timestamp, ok := entry["timestamp"].(string); ok {
+			return timestamp
+		}
+	}
+	return ""
+}
@@ -0,0 +1,151 @@
+// +build cgo
+
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Event operations.
+
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/elasticsearch"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/util"
+	"github.com/pkg/errors"
+	"strconv"
+	"time"
+)
+
+// appendHistorySql is an SQL expression that appends a history entry, a JSON
+// argument which must be provided twice, to the history in the metadata
+// column of an event.
+const appendHistorySql = `CASE
+    WHEN json_extract(metadata, '$.history') IS NULL
+      THEN json_set(coalesce(metadata, '{}'), '$.history', json_array(json(?)))
+    ELSE json_insert(metadata,
+      '$.history[' || json_array_length(metadata, '$.history') || ']', json(?))
+  END`
+
+// newHistoryEntry returns a history entry for an action by the user as
+// JSON, for use with appendHistorySql.
+func newHistoryEntry(action string, user core.User) string {
+	return util.ToJson(elasticsearch.HistoryEntry{
+		Action:    action,
+		Username:  user.Username,
+		Timestamp: eve.FormatTimestampUTC(time.Now()),
+	})
+}
+
+// updateEvent applies set to the event with the given ID, recording the
+// history entry, as JSON, in the events history. The update is only applied
+// if the optional condition is true, but it is not an error if its not.
+func (d *DataStore) updateEvent(ctx context.Context, eventId string, set string, condition string,
+	history string) error {
+
+	rowid, err := strconv.ParseInt(eventId, 10, 64)
+	if err != nil {
+		return core.NewEventNotFoundError(eventId)
+	}
+
+	query := fmt.Sprintf("UPDATE events SET %s, metadata = %s WHERE rowid = ?",
+		set, appendHistorySql)
+	if condition != "" {
+		query += fmt.Sprintf(" AND %s", condition)
+	}
+
+	tx, err := d.db.GetTx(ctx)
+	if err != nil {
+		log.Error("%v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int64
+	err = tx.QueryRowContext(ctx, "SELECT count(*) FROM events WHERE rowid = ?",
+		rowid).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return core.NewEventNotFoundError(eventId)
+	}
+
+	start := time.Now()
+	if _, err := tx.ExecContext(ctx, query, history, history, rowid); err != nil {
+		log.Error("Failed to update event %s: %v", eventId, err)
+		return err
+	}
+	log.Debug("Event %s updated in %v", eventId, time.Now().Sub(start))
+
+	return tx.Commit()
+}
+
+// ArchiveEvent archives an individual event by ID.
+func (d *DataStore) ArchiveEvent(ctx context.Context, eventId string, user core.User) error {
+	return d.updateEvent(ctx, eventId, "archived = 1", "",
+		newHistoryEntry(elasticsearch.ACTION_ARCHIVED, user))
+}
+
+// EscalateEvent escalates an individual event by ID.
+func (d *DataStore) EscalateEvent(ctx context.Context, eventId string, user core.User) error {
+	return d.updateEvent(ctx, eventId, "escalated = 1", "escalated = 0",
+		newHistoryEntry(elasticsearch.ACTION_ESCALATED, user))
+}
+
+// DeEscalateEvent de-escalates an individual event by ID.
+func (d *DataStore) DeEscalateEvent(ctx context.Context, eventId string, user core.User) error {
+	return d.updateEvent(ctx, eventId, "escalated = 0", "escalated = 1",
+		newHistoryEntry(elasticsearch.ACTION_DEESCALATED, user))
+}
+
+// ImportHistoryEntry applies an action from the history of an event in
+// another datastore, recording it with its original timestamp and user.
+// Only used when migrating events.
+func (d *DataStore) ImportHistoryEntry(ctx context.Context, eventId string, entry elasticsearch.HistoryEntry) error {
+	switch entry.Action {
+	case elasticsearch.ACTION_ARCHIVED:
+		return d.updateEvent(ctx, eventId, "archived = 1", "", util.ToJson(entry))
+	case elasticsearch.ACTION_ESCALATED:
+		return d.updateEvent(ctx, eventId, "escalated = 1", "escalated = 0", util.ToJson(entry))
+	case elasticsearch.ACTION_DEESCALATED:
+		return d.updateEvent(ctx, eventId, "escalated = 0", "escalated = 1", util.ToJson(entry))
+	case elasticsearch.ACTION_TAGGED:
+		return d.updateEventTags(ctx, eventId, entry.Tags, tagEvents, util.ToJson(entry))
+	case elasticsearch.ACTION_UNTAGGED:
+		return d.updateEventTags(ctx, eventId, entry.Tags, untagEvents, util.ToJson(entry))
+	case elasticsearch.ACTION_COMMENT:
+		timestamp, err := eve.ParseTimestamp(entry.Timestamp)
+		if err != nil {
+			return errors.Wrapf(err, "bad comment timestamp: %s", entry.Timestamp)
+		}
+		return d.commentOnEvent(ctx, eventId, entry.Username, entry.Comment, timestamp)
+	}
+	return errors.Errorf("unsupported history action: %s", entry.Action)
+}
@@ -0,0 +1,358 @@
+// +build cgo
+
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Event tags.
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/elasticsearch"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/util"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// eventTagsSql returns an SQL expression selecting the user tags of an
+// event as a JSON array.
+func eventTagsSql(eventTable string) string {
+	return fmt.Sprintf(
+		"(SELECT json_group_array(tag) FROM event_tags WHERE event_tags.event_id = %s.rowid)",
+		eventTable)
+}
+
+// addEventTags adds the tags selected with eventTagsSql to an event.
+func addEventTags(event eve.EveEvent, rawTags sql.NullString) {
+	if !rawTags.Valid {
+		return
+	}
+	var tags []string
+	if err := json.Unmarshal([]byte(rawTags.String), &tags); err != nil {
+		log.Error("Failed to decode event tags: %v", err)
+		return
+	}
+	for _, tag := range tags {
+		event.AddTag(tag)
+	}
+}
+
+// whereTags limits the query being built to events having all of the
+// mustHave tags and none of the mustNot tags.
+func whereTags(b *SqlBuilder, mustHave []string, mustNot []string) {
+	for _, tag := range mustHave {
+		switch tag {
+		case core.TAG_ARCHIVED, core.TAG_ESCALATED:
+			b.WhereEquals("events."+tag, 1)
+		default:
+			b.WhereArgs("events.rowid IN (SELECT event_id FROM event_tags WHERE tag = ?)", tag)
+		}
+	}
+	for _, tag := range mustNot {
+		switch tag {
+		case core.TAG_ARCHIVED, core.TAG_ESCALATED:
+			b.WhereEquals("events."+tag, 0)
+		default:
+			b.WhereArgs("events.rowid NOT IN (SELECT event_id FROM event_tags WHERE tag = ?)", tag)
+		}
+	}
+}
+
+// newTagsHistoryEntry returns a history entry for user tags added or
+// removed by the user as JSON, for use with appendHistorySql.
+func newTagsHistoryEntry(action string, tags []string, user core.User) string {
+	return util.ToJson(elasticsearch.HistoryEntry{
+		Action:    action,
+		Username:  user.Username,
+		Timestamp: eve.FormatTimestampUTC(time.Now()),
+		Tags:      tags,
+	})
+}
+
+// recordTagsHistory appends the history entry to the events with a rowid
+// selected by the builder for which the condition is true, returning the
+// number of events.
+func recordTagsHistory(ctx context.Context, tx *sql.Tx, b *SqlBuilder, condition string, tags []string, history string) (int64, error) {
+	query := fmt.Sprintf("UPDATE events SET metadata = %s WHERE rowid IN (%s) AND %s",
+		appendHistorySql, b.Build(), condition)
+	args := append([]interface{}{history, history}, b.Args()...)
+	r, err := tx.ExecContext(ctx, query, append(args, util.ToJson(tags))...)
+	if err != nil {
+		return 0, err
+	}
+	return r.RowsAffected()
+}
+
+// tagEvents adds the tags to the events with a rowid selected by the
+// builder, recording the history entry on those missing any of the tags.
+// Returns the number of events tagged.
+func tagEvents(ctx context.Context, tx *sql.Tx, b *SqlBuilder, tags []string, history string) (int64, error) {
+	count, err := recordTagsHistory(ctx, tx, b, `EXISTS (SELECT 1 FROM json_each(?)
+  WHERE json_each.value NOT IN (SELECT tag FROM event_tags WHERE event_id = events.rowid))`,
+		tags, history)
+	if err != nil {
+		return 0, err
+	}
+	for _, tag := range tags {
+		query := fmt.Sprintf(
+			"INSERT OR IGNORE INTO event_tags (event_id, tag) SELECT rowid, ? FROM (%s)",
+			b.Build())
+		args := append([]interface{}{tag}, b.Args()...)
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+// untagEvents removes the tags from the events with a rowid selected by
+// the builder, recording the history entry on those having any of the
+// tags. Returns the number of events untagged.
+func untagEvents(ctx context.Context, tx *sql.Tx, b *SqlBuilder, tags []string, history string) (int64, error) {
+	count, err := recordTagsHistory(ctx, tx, b, `rowid IN (SELECT event_id FROM event_tags
+  WHERE tag IN (SELECT json_each.value FROM json_each(?)))`,
+		tags, history)
+	if err != nil {
+		return 0, err
+	}
+	query := fmt.Sprintf(
+		"DELETE FROM event_tags WHERE tag IN (?%s) AND event_id IN (%s)",
+		strings.Repeat(", ?", len(tags)-1), b.Build())
+	args := []interface{}{}
+	for _, tag := range tags {
+		args = append(args, tag)
+	}
+	if _, err := tx.ExecContext(ctx, query, append(args, b.Args()...)...); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+type tagFunc func(ctx context.Context, tx *sql.Tx, b *SqlBuilder, tags []string, history string) (int64, error)
+
+func (d *DataStore) updateEventTags(ctx context.Context, eventId string, tags []string, fn tagFunc, history string) error {
+	if err := core.ValidateTags(tags); err != nil {
+		return err
+	}
+	rowid, err := strconv.ParseInt(eventId, 10, 64)
+	if err != nil {
+		return core.NewEventNotFoundError(eventId)
+	}
+
+	tx, err := d.db.GetTx(ctx)
+	if err != nil {
+		log.Error("%v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int64
+	err = tx.QueryRowContext(ctx, "SELECT count(*) FROM events WHERE rowid = ?",
+		rowid).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return core.NewEventNotFoundError(eventId)
+	}
+
+	b := &SqlBuilder{}
+	b.Select("rowid")
+	b.From("events")
+	b.WhereEquals("rowid", rowid)
+	if _, err := fn(ctx, tx, b, tags, history); err != nil {
+		log.Error("Failed to update tags on event %s: %v", eventId, err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (d *DataStore) updateAlertGroupTags(ctx context.Context, p core.AlertGroupQueryParams, tags []string, fn tagFunc, history string) error {
+	if err := core.ValidateTags(tags); err != nil {
+		return err
+	}
+
+	b := &SqlBuilder{}
+	b.Select("rowid")
+	b.From("events")
+	b.WhereEquals("json_extract(events.source, '$.event_type')", "alert")
+	whereAlertGroup(b, p)
+
+	tx, err := d.db.GetTx(ctx)
+	if err != nil {
+		log.Error("%v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	start := time.Now()
+	count, err := fn(ctx, tx, b, tags, history)
+	if err != nil {
+		log.Error("Failed to update tags on alert group: %v", err)
+		return err
+	}
+	log.Debug("Updated tags on %d events in %v", count, time.Now().Sub(start))
+
+	return tx.Commit()
+}
+
+// AddTagsToEvent adds user tags to a single event.
+func (d *DataStore) AddTagsToEvent(ctx context.Context, eventId string, tags []string, user core.User) error {
+	return d.updateEventTags(ctx, eventId, tags, tagEvents,
+		newTagsHistoryEntry(elasticsearch.ACTION_TAGGED, tags, user))
+}
+
+// RemoveTagsFromEvent removes user tags from a single event.
+func (d *DataStore) RemoveTagsFromEvent(ctx context.Context, eventId string, tags []string, user core.User) error {
+	return d.updateEventTags(ctx, eventId, tags, untagEvents,
+		newTagsHistoryEntry(elasticsearch.ACTION_UNTAGGED, tags, user))
+}
+
+// AddTagsToAlertGroup adds user tags to each event in the alert group.
+func (d *DataStore) AddTagsToAlertGroup(ctx context.Context, p core.AlertGroupQueryParams, tags []string, user core.User) error {
+	return d.updateAlertGroupTags(ctx, p, tags, tagEvents,
+		newTagsHistoryEntry(elasticsearch.ACTION_TAGGED, tags, user))
+}
+
+// RemoveTagsFromAlertGroup removes user tags from each event in the alert
+// group.
+func (d *DataStore) RemoveTagsFromAlertGroup(ctx context.Context, p core.AlertGroupQueryParams, tags []string, user core.User) error {
+	return d.updateAlertGroupTags(ctx, p, tags, untagEvents,
+		newTagsHistoryEntry(elasticsearch.ACTION_UNTAGGED, tags, user))
+}
+
+// TagCounts returns the number of events matching the options with each
+// tag, including the archived and escalated tags.
+func (d *DataStore) TagCounts(ctx context.Context, options core.EventQueryOptions) ([]core.TagCount, error) {
+	b := &SqlBuilder{}
+	b.From("events")
+	if options.EventType != "" {
+		b.WhereEquals("json_extract(events.source, '$.event_type')", options.EventType)
+	}
+	if options.QueryString != "" {
+		if err := parseQueryString(b, options.QueryString, "events"); err != nil {
+			return nil, err
+		}
+	}
+	if options.TimeRange != "" {
+		duration, err := time.ParseDuration(options.TimeRange)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse time range")
+		}
+		b.WhereGte("events.timestamp", time.Now().Add(duration*-1).UnixNano())
+	} else {
+		if !options.MinTs.IsZero() {
+			b.WhereGte("events.timestamp", options.MinTs.UnixNano())
+		}
+		if !options.MaxTs.IsZero() {
+			b.WhereLte("events.timestamp", options.MaxTs.UnixNano())
+		}
+	}
+
+	// The filters are used three times, once for each source of tags.
+	from := b.BuildFrom()
+	and := " WHERE"
+	if b.HasWhere() {
+		from += b.BuildWhere()
+		and = " AND"
+	}
+	query := fmt.Sprintf(`
+SELECT tag, count FROM (
+  SELECT event_tags.tag AS tag, count(*) AS count
+    FROM event_tags
+    WHERE event_tags.event_id IN (SELECT events.rowid %s)
+    GROUP BY event_tags.tag
+  UNION ALL
+  SELECT 'archived', count(*) %s%s events.archived = 1
+  UNION ALL
+  SELECT 'escalated', count(*) %s%s events.escalated = 1
+)
+WHERE count > 0
+ORDER BY count DESC, tag`, from, from, and, from, and)
+	args := []interface{}{}
+	for i := 0; i < 3; i++ {
+		args = append(args, b.Args()...)
+	}
+
+	tx, err := d.db.GetTx(ctx)
+	if err != nil {
+		log.Error("%v", err)
+		return nil, err
+	}
+	defer tx.Commit()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Error("%v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []core.TagCount{}
+	for rows.Next() {
+		var count core.TagCount
+		if err := rows.Scan(&count.Tag, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// tagAlertsByQuery adds the tags to the alerts with a rowid selected by
+// the builder, returning the number of events tagged.
+func (d *DataStore) tagAlertsByQuery(ctx context.Context, b *SqlBuilder, tags []string, user core.User) (int64, error) {
+	tx, err := d.db.GetTx(ctx)
+	if err != nil {
+		log.Error("%v", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	start := time.Now()
+	count, err := tagEvents(ctx, tx, b, tags,
+		newTagsHistoryEntry(elasticsearch.ACTION_TAGGED, tags, user))
+	if err != nil {
+		log.Error("Failed to tag alerts: %v", err)
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	log.Info("Tagged %d alerts in %v", count, time.Now().Sub(start))
+
+	return count, nil
+}
@@ -29,11 +29,13 @@
 package sqlite
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/elasticsearch"
 	"github.com/jasonish/evebox/eve"
 	"github.com/jasonish/evebox/log"
-	"github.com/jasonish/evebox/util"
 	"github.com/pkg/errors"
 	"strconv"
 	"strings"
@@ -55,28 +57,35 @@ func (d *DataStore) GetEveEventSink() core.EveEventSink {
 	return NewSqliteIndexer(d.db)
 }
 
-func (s *DataStore) GetEventById(id string) (map[string]interface{}, error) {
+func (s *DataStore) GetEventById(ctx context.Context, id string) (map[string]interface{}, error) {
 	builder := SqlBuilder{}
-	builder.Select("source")
+	builder.Select("rowid, archived, escalated, metadata, source, " +
+		eventTagsSql("events"))
 	builder.From("events")
 	builder.WhereEquals("rowid", id)
 
-	tx, err := s.db.GetTx()
+	tx, err := s.db.GetTx(ctx)
 	if err != nil {
 		log.Error("%v", err)
 		return nil, err
 	}
 	defer tx.Commit()
 
-	rows, err := tx.Query(builder.Build(), builder.Args()...)
+	rows, err := tx.QueryContext(ctx, builder.Build(), builder.Args()...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
+		var rowid int64
+		var archived int8
+		var escalated int8
+		var rawMetadata []byte
 		var rawEvent []byte
-		err = rows.Scan(&rawEvent)
+		var rawTags sql.NullString
+		err = rows.Scan(&rowid, &archived, &escalated, &rawMetadata, &rawEvent,
+			&rawTags)
 		if err != nil {
 			return nil, err
 		}
@@ -85,6 +94,22 @@ func (s *DataStore) GetEventById(id string) (map[string]interface{}, error) {
 			return nil, err
 		}
 
+		if archived > 0 {
+			event.AddTag("archived")
+			event.AddTag("evebox.archived")
+		}
+
+		if escalated > 0 {
+			event.AddTag("escalated")
+			event.AddTag("evebox.escalated")
+		}
+
+		addEventTags(event, rawTags)
+
+		if err := addEventHistory(ctx, tx, event, rowid, rawMetadata); err != nil {
+			return nil, err
+		}
+
 		wrapper := map[string]interface{}{
 			"_id":     id,
 			"_source": event,
@@ -96,7 +121,65 @@ func (s *DataStore) GetEventById(id string) (map[string]interface{}, error) {
 	return nil, nil
 }
 
-func (s *DataStore) AlertQuery(options core.AlertQueryOptions) ([]core.AlertGroup, error) {
+// Expressions for the keys alerts can be grouped by. Events without a host
+// are grouped together under an empty host.
+var alertGroupKeyExpressions = map[string]string{
+	core.ALERT_GROUP_BY_SIGNATURE_ID: "json_extract(events.source, '$.alert.signature_id')",
+	core.ALERT_GROUP_BY_SRC_IP:       "json_extract(events.source, '$.src_ip')",
+	core.ALERT_GROUP_BY_DEST_IP:      "json_extract(events.source, '$.dest_ip')",
+	core.ALERT_GROUP_BY_HOST:         "coalesce(json_extract(events.source, '$.host'), '')",
+}
+
+// whereAlertGroup limits the query being built to the events in the
+// alert group.
+func whereAlertGroup(b *SqlBuilder, p core.AlertGroupQueryParams) {
+	for _, key := range p.Keys() {
+		b.WhereEquals(alertGroupKeyExpressions[key], p.Value(key))
+	}
+	if !p.MinTimestamp.IsZero() {
+		b.WhereGte("timestamp", p.MinTimestamp.UnixNano())
+	}
+	if !p.MaxTimestamp.IsZero() {
+		b.WhereLte("timestamp", p.MaxTimestamp.UnixNano())
+	}
+}
+
+// alertQueryFilters returns a builder selecting from events the alerts
+// matching the query options.
+func alertQueryFilters(options core.AlertQueryOptions) (*SqlBuilder, error) {
+	builder := &SqlBuilder{}
+	builder.From("events")
+
+	builder.WhereEquals("json_extract(events.source, '$.event_type')", "alert")
+
+	whereTags(builder, options.MustHaveTags, options.MustNotHaveTags)
+
+	if options.QueryString != "" {
+		if err := parseQueryString(builder, options.QueryString, "events"); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.TimeRange != "" {
+		duration, err := time.ParseDuration(options.TimeRange)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse duration string)")
+		}
+		minTs := time.Now().Add(duration * -1)
+		builder.WhereGte("timestamp", minTs.UnixNano())
+	} else {
+		if !options.MinTs.IsZero() {
+			builder.WhereGte("timestamp", options.MinTs.UnixNano())
+		}
+		if !options.MaxTs.IsZero() {
+			builder.WhereLte("timestamp", options.MaxTs.UnixNano())
+		}
+	}
+
+	return builder, nil
+}
+
+func (s *DataStore) AlertQuery(ctx context.Context, options core.AlertQueryOptions) ([]core.AlertGroup, error) {
 
 	query := `
 SELECT b.count,
@@ -104,7 +187,9 @@ SELECT b.count,
   b.mints as mints,
   b.escalated_count,
   a.archived,
-  a.source
+  a.metadata,
+  a.source,
+  %TAGS%
 FROM events a
   INNER JOIN
   (
@@ -116,64 +201,43 @@ FROM events a
       sum(escalated) as escalated_count
     %FROM%
     %WHERE%
-    GROUP BY
-      json_extract(events.source, '$.alert.signature_id'),
-      json_extract(events.source, '$.src_ip'),
-      json_extract(events.source, '$.dest_ip')
+    GROUP BY %GROUPBY%
   ) AS b
 WHERE a.rowid = b.rowid AND a.timestamp = b.maxts
 ORDER BY timestamp DESC`
 
-	builder := SqlBuilder{}
-	builder.From("events")
-
-	builder.WhereEquals("json_extract(events.source, '$.event_type')", "alert")
-
-	if util.StringSliceContains(options.MustHaveTags, "archived") {
-		builder.WhereEquals("archived", 1)
-	}
-
-	if util.StringSliceContains(options.MustNotHaveTags, "archived") {
-		builder.WhereEquals("archived", 0)
-	}
-
-	if util.StringSliceContains(options.MustHaveTags, "escalated") {
-		builder.WhereEquals("escalated", 1)
+	builder, err := alertQueryFilters(options)
+	if err != nil {
+		return nil, err
 	}
 
-	if options.QueryString != "" {
-		parseQueryString(&builder, options.QueryString, "events")
+	groupBy := options.GroupBy
+	if len(groupBy) == 0 {
+		groupBy = core.DefaultAlertGroupBy
 	}
-
-	now := time.Now()
-
-	if options.TimeRange != "" {
-		duration, err := time.ParseDuration(options.TimeRange)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to parse duration string)")
-		}
-		minTs := now.Add(duration * -1)
-		builder.WhereGte("timestamp", minTs.UnixNano())
-	} else {
-		if !options.MinTs.IsZero() {
-			builder.WhereGte("timestamp", options.MinTs.UnixNano())
-		}
-		if !options.MaxTs.IsZero() {
-			builder.WhereLte("timestamp", options.MaxTs.UnixNano())
+	groupByExpressions := []string{}
+	for _, key := range groupBy {
+		expression, ok := alertGroupKeyExpressions[key]
+		if !ok {
+			return nil, errors.Errorf("invalid alert group key: %s", key)
 		}
+		groupByExpressions = append(groupByExpressions, expression)
 	}
 
 	query = strings.Replace(query, "%WHERE%", builder.BuildWhere(), 1)
 	query = strings.Replace(query, "%FROM%", builder.BuildFrom(), 1)
+	query = strings.Replace(query, "%TAGS%", eventTagsSql("a"), 1)
+	query = strings.Replace(query, "%GROUPBY%",
+		strings.Join(groupByExpressions, ", "), 1)
 
-	tx, err := s.db.GetTx()
+	tx, err := s.db.GetTx(ctx)
 	if err != nil {
 		log.Error("%v", err)
 		return nil, err
 	}
 	defer tx.Commit()
 	queryStart := time.Now()
-	rows, err := tx.Query(query, builder.args...)
+	rows, err := tx.QueryContext(ctx, query, builder.args...)
 	if err != nil {
 		log.Error("%v", err)
 		return nil, err
@@ -188,14 +252,18 @@ ORDER BY timestamp DESC`
 		var id int64
 		var escalated int64
 		var archived int8
+		var rawMetadata []byte
 		var rawEvent []byte
+		var rawTags sql.NullString
 
 		err = rows.Scan(&count,
 			&id,
 			&minTsNanos,
 			&escalated,
 			&archived,
-			&rawEvent)
+			&rawMetadata,
+			&rawEvent,
+			&rawTags)
 		if err != nil {
 			log.Error("%v", err)
 			return nil, err
@@ -211,6 +279,14 @@ ORDER BY timestamp DESC`
 				"archived")
 		}
 
+		addEventTags(event, rawTags)
+
+		// The history of the most recent event, which includes the
+		// actions taken on the alert group.
+		if err := addEventHistory(ctx, tx, event, id, rawMetadata); err != nil {
+			return nil, err
+		}
+
 		alert := core.AlertGroup{
 			Count: count,
 			Event: map[string]interface{}{
@@ -229,41 +305,30 @@ ORDER BY timestamp DESC`
 	return alerts, nil
 }
 
-func (s *DataStore) ArchiveAlertGroup(p core.AlertGroupQueryParams, user core.User) error {
+func (s *DataStore) ArchiveAlertGroup(ctx context.Context, p core.AlertGroupQueryParams, user core.User) error {
 
 	b := SqlBuilder{}
 
 	b.Select("rowid")
 	b.From("events")
 	b.WhereEquals("archived", 0)
-	b.WhereEquals(
-		"json_extract(events.source, '$.alert.signature_id')",
-		p.SignatureID)
-	b.WhereEquals(
-		"json_extract(events.source, '$.src_ip')",
-		p.SrcIP)
-	b.WhereEquals(
-		"json_extract(events.source, '$.dest_ip')",
-		p.DstIP)
-	if !p.MinTimestamp.IsZero() {
-		b.WhereGte("timestamp", p.MinTimestamp.UnixNano())
-	}
-	if !p.MaxTimestamp.IsZero() {
-		b.WhereLte("timestamp", p.MaxTimestamp.UnixNano())
-	}
+	whereAlertGroup(&b, p)
 
 	// TODO - query string
 
-	query := fmt.Sprintf("UPDATE events SET archived = 1 WHERE rowid IN (%s)", b.Build())
+	query := fmt.Sprintf("UPDATE events SET archived = 1, metadata = %s WHERE rowid IN (%s)",
+		appendHistorySql, b.Build())
+	history := newHistoryEntry(elasticsearch.ACTION_ARCHIVED, user)
+	args := append([]interface{}{history, history}, b.args...)
 
-	tx, err := s.db.GetTx()
+	tx, err := s.db.GetTx(ctx)
 	if err != nil {
 		log.Error("%v", err)
 	}
 	defer tx.Commit()
 
 	start := time.Now()
-	r, err := tx.Exec(query, b.args...)
+	r, err := tx.ExecContext(ctx, query, args...)
 	if err != nil {
 		log.Error("error archiving alerts: %v", err)
 		return err
@@ -278,44 +343,29 @@ func (s *DataStore) ArchiveAlertGroup(p core.AlertGroupQueryParams, user core.Us
 	return err
 }
 
-func (s *DataStore) EscalateAlertGroup(p core.AlertGroupQueryParams, user core.User) error {
+func (s *DataStore) EscalateAlertGroup(ctx context.Context, p core.AlertGroupQueryParams, user core.User) error {
 
-	query := `UPDATE events SET escalated = 1 WHERE`
+	query := `UPDATE events SET escalated = 1, metadata = ` + appendHistorySql + ` WHERE`
 
+	history := newHistoryEntry(elasticsearch.ACTION_ESCALATED, user)
 	builder := SqlBuilder{}
+	builder.args = append(builder.args, history, history)
 
-	builder.WhereEquals(
-		"json_extract(events.source, '$.alert.signature_id')",
-		p.SignatureID)
-
-	builder.WhereEquals(
-		"json_extract(events.source, '$.src_ip')",
-		p.SrcIP)
-
-	builder.WhereEquals(
-		"json_extract(events.source, '$.dest_ip')",
-		p.DstIP)
-
-	if !p.MinTimestamp.IsZero() {
-		builder.WhereGte("timestamp", p.MinTimestamp.UnixNano())
-	}
-
-	if !p.MaxTimestamp.IsZero() {
-		builder.WhereLte("timestamp", p.MaxTimestamp.UnixNano())
-	}
+	builder.WhereEquals("escalated", 0)
+	whereAlertGroup(&builder, p)
 
-	query = strings.Replace(query, "WHERE", builder.BuildWhere(), 1)
+	query = strings.Replace(query, " WHERE", builder.BuildWhere(), 1)
 
 	start := time.Now()
 
 	log.Debug("Query: |%s|; Args: %v", query, builder.args)
 
-	tx, err := s.db.GetTx()
+	tx, err := s.db.GetTx(ctx)
 	if err != nil {
 		log.Error("%v", err)
 	}
 	defer tx.Commit()
-	result, err := tx.Exec(query, builder.args...)
+	result, err := tx.ExecContext(ctx, query, builder.args...)
 	if err != nil {
 		log.Error("error starring alerts: %v", err)
 		return err
@@ -328,40 +378,25 @@ func (s *DataStore) EscalateAlertGroup(p core.AlertGroupQueryParams, user core.U
 	return err
 }
 
-func (s *DataStore) DeEscalateAlertGroup(p core.AlertGroupQueryParams, user core.User) error {
+func (s *DataStore) DeEscalateAlertGroup(ctx context.Context, p core.AlertGroupQueryParams, user core.User) error {
 
-	query := `UPDATE events SET escalated = 0 WHERE`
+	query := `UPDATE events SET escalated = 0, metadata = ` + appendHistorySql + ` WHERE`
 
+	history := newHistoryEntry(elasticsearch.ACTION_DEESCALATED, user)
 	builder := SqlBuilder{}
+	builder.args = append(builder.args, history, history)
 
-	builder.WhereEquals(
-		"json_extract(events.source, '$.alert.signature_id')",
-		p.SignatureID)
-
-	builder.WhereEquals(
-		"json_extract(events.source, '$.src_ip')",
-		p.SrcIP)
+	builder.WhereEquals("escalated", 1)
+	whereAlertGroup(&builder, p)
 
-	builder.WhereEquals(
-		"json_extract(events.source, '$.dest_ip')",
-		p.DstIP)
+	query = strings.Replace(query, " WHERE", builder.BuildWhere(), 1)
 
-	if !p.MinTimestamp.IsZero() {
-		builder.WhereGte("timestamp", p.MinTimestamp.UnixNano())
-	}
-
-	if !p.MaxTimestamp.IsZero() {
-		builder.WhereLte("timestamp", p.MaxTimestamp.UnixNano())
-	}
-
-	query = strings.Replace(query, "WHERE", builder.BuildWhere(), 1)
-
-	tx, err := s.db.GetTx()
+	tx, err := s.db.GetTx(ctx)
 	if err != nil {
 		log.Error("%v", err)
 	}
 	defer tx.Commit()
-	r, err := tx.Exec(query, builder.args...)
+	r, err := tx.ExecContext(ctx, query, builder.args...)
 	if err != nil {
 		log.Error("error archiving alerts: %v", err)
 		return err
@@ -377,15 +412,95 @@ func (s *DataStore) DeEscalateAlertGroup(p core.AlertGroupQueryParams, user core
 	return err
 }
 
-func (s *DataStore) EventQuery(options core.EventQueryOptions) (interface{}, error) {
+func (s *DataStore) UpdateAlertsByQuery(ctx context.Context, options core.AlertQueryOptions, action core.AlertAction, user core.User) (int64, error) {
+	if err := action.Validate(); err != nil {
+		return 0, err
+	}
 
-	size := int64(500)
+	b, err := alertQueryFilters(options)
+	if err != nil {
+		return 0, err
+	}
+	b.Select("rowid")
 
-	if options.Size > 0 {
-		size = options.Size
+	var set string
+	var history string
+	switch action.Action {
+	case core.ALERT_ACTION_ARCHIVE:
+		set = "archived = 1"
+		b.WhereEquals("archived", 0)
+		history = newHistoryEntry(elasticsearch.ACTION_ARCHIVED, user)
+	case core.ALERT_ACTION_ESCALATE:
+		set = "escalated = 1"
+		b.WhereEquals("escalated", 0)
+		history = newHistoryEntry(elasticsearch.ACTION_ESCALATED, user)
+	case core.ALERT_ACTION_DEESCALATE:
+		set = "escalated = 0"
+		b.WhereEquals("escalated", 1)
+		history = newHistoryEntry(elasticsearch.ACTION_DEESCALATED, user)
+	case core.ALERT_ACTION_TAG:
+		return s.tagAlertsByQuery(ctx, b, action.Tags, user)
+	default:
+		return 0, errors.Errorf("alert action %s not supported by this datastore",
+			action.Action)
+	}
+
+	query := fmt.Sprintf("UPDATE events SET %s, metadata = %s WHERE rowid IN (%s)",
+		set, appendHistorySql, b.Build())
+	args := append([]interface{}{history, history}, b.Args()...)
+
+	tx, err := s.db.GetTx(ctx)
+	if err != nil {
+		log.Error("%v", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	start := time.Now()
+	r, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		log.Error("Failed to %s alerts: %v", action.Action, err)
+		return 0, err
+	}
+	count, err := r.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
 	}
+	log.Info("Applied action %s to %d events in %v", action.Action, count,
+		time.Now().Sub(start))
 
-	query := `select events.rowid as id, events.archived, events.source`
+	return count, nil
+}
+
+// Cursor positions are the event timestamp and rowid, the rowid breaking
+// ties between events with the same timestamp.
+func encodeEventPosition(timestamp int64, rowid int64) string {
+	return fmt.Sprintf("%d:%d", timestamp, rowid)
+}
+
+func decodeEventPosition(position string) (timestamp int64, rowid int64, err error) {
+	parts := strings.SplitN(position, ":", 2)
+	if len(parts) != 2 {
+		return 0, 0, errors.Errorf("invalid cursor position: %s", position)
+	}
+	if timestamp, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return 0, 0, errors.Errorf("invalid cursor position: %s", position)
+	}
+	if rowid, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return 0, 0, errors.Errorf("invalid cursor position: %s", position)
+	}
+	return timestamp, rowid, nil
+}
+
+func (s *DataStore) EventQuery(ctx context.Context, options core.EventQueryOptions) (*core.EventQueryResult, error) {
+
+	pager, err := core.NewEventPager(options)
+	if err != nil {
+		return nil, err
+	}
 
 	sqlBuilder := SqlBuilder{}
 
@@ -398,7 +513,9 @@ func (s *DataStore) EventQuery(options core.EventQueryOptions) (interface{}, err
 	}
 
 	if options.QueryString != "" {
-		parseQueryString(&sqlBuilder, options.QueryString, "events")
+		if err := parseQueryString(&sqlBuilder, options.QueryString, "events"); err != nil {
+			return nil, err
+		}
 	}
 
 	if !options.MaxTs.IsZero() {
@@ -409,39 +526,65 @@ func (s *DataStore) EventQuery(options core.EventQueryOptions) (interface{}, err
 		sqlBuilder.WhereGte("events.timestamp", options.MinTs.UnixNano())
 	}
 
+	tx, err := s.db.GetTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+
+	// The total ignores the cursor, so is counted before adding it.
+	total, estimated, err := countEvents(ctx, tx, &sqlBuilder)
+	if err != nil {
+		return nil, err
+	}
+
+	if pager.Position != "" {
+		timestamp, rowid, err := decodeEventPosition(pager.Position)
+		if err != nil {
+			return nil, err
+		}
+		op := "<"
+		if pager.Ascending() {
+			op = ">"
+		}
+		sqlBuilder.WhereArgs(fmt.Sprintf(
+			"(events.timestamp %s ? OR (events.timestamp = ? AND events.rowid %s ?))", op, op),
+			timestamp, timestamp, rowid)
+	}
+
+	query := `select events.rowid as id, events.timestamp, events.archived, events.source, ` +
+		eventTagsSql("events")
+
 	query += sqlBuilder.BuildFrom()
 
 	if sqlBuilder.HasWhere() {
 		query += sqlBuilder.BuildWhere()
 	}
 
-	if options.Order == "asc" {
-		query += fmt.Sprintf(" ORDER BY events.timestamp ASC")
+	if pager.Ascending() {
+		query += " ORDER BY events.timestamp ASC, events.rowid ASC"
 	} else {
-		query += fmt.Sprintf(" ORDER BY events.timestamp DESC")
+		query += " ORDER BY events.timestamp DESC, events.rowid DESC"
 	}
 
-	query += fmt.Sprintf(" LIMIT %d", size)
-
-	tx, err := s.db.GetTx()
-	if err != nil {
-		return nil, err
-	}
-	defer tx.Commit()
+	query += fmt.Sprintf(" LIMIT %d", pager.Limit())
 
-	rows, err := tx.Query(query, sqlBuilder.args...)
+	rows, err := tx.QueryContext(ctx, query, sqlBuilder.args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	events := []interface{}{}
+	events := []map[string]interface{}{}
+	positions := []string{}
 
 	for rows.Next() {
 		var id int64
+		var timestamp int64
 		var archived int8
 		var rawSource []byte
-		err = rows.Scan(&id, &archived, &rawSource)
+		var rawTags sql.NullString
+		err = rows.Scan(&id, &timestamp, &archived, &rawSource, &rawTags)
 		if err != nil {
 			return nil, err
 		}
@@ -456,20 +599,50 @@ func (s *DataStore) EventQuery(options core.EventQueryOptions) (interface{}, err
 			source.AddTag("archived")
 		}
 
+		addEventTags(source, rawTags)
+
 		source["@timestamp"] = source["timestamp"]
 
 		events = append(events, map[string]interface{}{
 			"_id":     id,
 			"_source": source,
 		})
+		positions = append(positions, encodeEventPosition(timestamp, id))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return map[string]interface{}{
-		"data": events,
-	}, nil
+	result := pager.Result(events, positions)
+	result.Total = total
+	result.Estimated = estimated
+	return result, nil
 }
 
-func (d *DataStore) FindFlow(flowId uint64, proto string, timestamp string, srcIp string, destIp string) (interface{}, error) {
+func (s *DataStore) ExportEvents(ctx context.Context, options core.EventQueryOptions, fn func(event map[string]interface{}) error) error {
+	return core.ExportEventsByCursor(ctx, s, options, fn)
+}
+
+// Counting all the events matching a loose query can mean scanning the
+// whole database, so the count stops at this limit.
+const eventCountLimit = 10000
+
+// countEvents returns the number of events matching the from and where
+// clauses of the builder, up to eventCountLimit. If the limit is reached
+// estimated is set to true.
+func countEvents(ctx context.Context, tx *sql.Tx, sqlBuilder *SqlBuilder) (count int64, estimated bool, err error) {
+	query := "SELECT count(*) FROM (SELECT 1" + sqlBuilder.BuildFrom()
+	if sqlBuilder.HasWhere() {
+		query += sqlBuilder.BuildWhere()
+	}
+	query += fmt.Sprintf(" LIMIT %d)", eventCountLimit)
+	if err := tx.QueryRowContext(ctx, query, sqlBuilder.args...).Scan(&count); err != nil {
+		return 0, false, err
+	}
+	return count, count >= eventCountLimit, nil
+}
+
+func (d *DataStore) FindFlow(ctx context.Context, flowId uint64, proto string, timestamp string, srcIp string, destIp string) (interface{}, error) {
 
 	query := `select
                     rowid as id, source
@@ -493,14 +666,14 @@ func (d *DataStore) FindFlow(flowId uint64, proto string, timestamp string, srcI
 		return nil, err
 	}
 
-	tx, err := d.db.GetTx()
+	tx, err := d.db.GetTx(ctx)
 	if err != nil {
 		log.Error("%v", err)
 		return nil, err
 	}
 	defer tx.Commit()
 
-	rows, err := tx.Query(query, flowId, srcIp, destIp, srcIp, destIp, timestamp, timestamp)
+	rows, err := tx.QueryContext(ctx, query, flowId, srcIp, destIp, srcIp, destIp, timestamp, timestamp)
 	if err != nil {
 		log.Error("%v", err)
 		return nil, err
@@ -532,52 +705,108 @@ func (d *DataStore) FindFlow(flowId uint64, proto string, timestamp string, srcI
 	return events, nil
 }
 
-// Parse the query string and populate the sqlbuilder with parsed data.
-//
-// eventTable is the column name to join against events_fts.id, as it may
-//   not always be the events table in case of aliasing.
-func parseQueryString(builder *SqlBuilder, queryString string, eventTable string) {
-	fts := []string{}
+// FindNetflow finds netflow events matching the parameters in options,
+// sorted by the field named in sortBy, for example netflow.bytes.
+func (d *DataStore) FindNetflow(ctx context.Context, options core.EventQueryOptions, sortBy string,
+	order string) (interface{}, error) {
 
-	parser := NewQueryStringParser(queryString)
-	for {
-		key, val := parser.Next()
-		if key != "" && val != "" {
+	size := int64(10)
+	if options.Size > 0 {
+		size = options.Size
+	}
 
-			var arg interface{}
+	orderBy := "events.timestamp"
+	if sortBy != "" {
+		if !aggFieldPattern.MatchString(sortBy) {
+			return nil, errors.Errorf("invalid sort field: %s", sortBy)
+		}
+		orderBy = fmt.Sprintf("json_extract(events.source, '$.%s')", sortBy)
+	}
 
-			// Check if the value is a string...
-			valInt, err := strconv.ParseInt(val, 0, 64)
-			if err == nil {
-				arg = valInt
-			} else {
-				arg = val
-			}
+	if order == "asc" {
+		orderBy += " ASC"
+	} else {
+		orderBy += " DESC"
+	}
 
-			log.Debug("Parsed keyword: %s : %v", key, arg)
+	builder := SqlBuilder{}
+	builder.Select("events.rowid, events.archived, events.source, " +
+		eventTagsSql("events"))
+	builder.From("events")
+	builder.WhereEquals("json_extract(events.source, '$.event_type')", "netflow")
 
-			switch {
-			case key == "-tags" && arg == "archived":
-				builder.WhereEquals("archived", 0)
-			case (key == "tags" || key == "+tags") && arg == "archived":
-				builder.WhereEquals("archived", 1)
-			default:
-				builder.WhereEquals(
-					fmt.Sprintf(" json_extract(%s.source, '$.%s')", eventTable, key),
-					arg)
-			}
+	if options.QueryString != "" {
+		if err := parseQueryString(&builder, options.QueryString, "events"); err != nil {
+			return nil, err
+		}
+	}
 
-		} else if val != "" {
-			fts = append(fts, fmt.Sprintf("\"%s\"", val))
+	if options.TimeRange != "" {
+		duration, err := time.ParseDuration(options.TimeRange)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse time range")
 		}
-		if key == "" && val == "" {
-			break
+		minTs := time.Now().Add(duration * -1)
+		builder.WhereGte("events.timestamp", minTs.UnixNano())
+	} else {
+		if !options.MinTs.IsZero() {
+			builder.WhereGte("events.timestamp", options.MinTs.UnixNano())
+		}
+		if !options.MaxTs.IsZero() {
+			builder.WhereLte("events.timestamp", options.MaxTs.UnixNano())
 		}
 	}
 
-	if len(fts) > 0 {
-		builder.From("events_fts")
-		builder.Where(fmt.Sprintf("%s.rowid = events_fts.rowid", eventTable))
-		builder.Where(fmt.Sprintf("events_fts MATCH '%s'", strings.Join(fts, " AND ")))
+	query := fmt.Sprintf("%s ORDER BY %s LIMIT %d", builder.Build(), orderBy,
+		size)
+
+	tx, err := d.db.GetTx(ctx)
+	if err != nil {
+		log.Error("%v", err)
+		return nil, err
+	}
+	defer tx.Commit()
+
+	rows, err := tx.QueryContext(ctx, query, builder.Args()...)
+	if err != nil {
+		log.Error("%v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []interface{}{}
+
+	for rows.Next() {
+		var id int64
+		var archived int8
+		var rawSource []byte
+		var rawTags sql.NullString
+		if err := rows.Scan(&id, &archived, &rawSource, &rawTags); err != nil {
+			return nil, err
+		}
+
+		source, err := eve.NewEveEventFromBytes(rawSource)
+		if err != nil {
+			return nil, err
+		}
+
+		if archived > 0 {
+			source.AddTag("evebox.archived")
+			source.AddTag("archived")
+		}
+
+		addEventTags(source, rawTags)
+
+		source["@timestamp"] = source["timestamp"]
+
+		events = append(events, map[string]interface{}{
+			"_id":     id,
+			"_source": source,
+		})
 	}
+
+	return map[string]interface{}{
+		"data": events,
+	}, nil
 }
+
@@ -0,0 +1,169 @@
+// +build cgo
+
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package sqlite
+
+import (
+	"context"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestArchiveEscalateEvent(t *testing.T) {
+	r := require.New(t)
+	db, teardown := Setup(t)
+	defer teardown()
+	SubmitEvents(t, db, reportEvents[0])
+
+	datastore := NewDataStore(db)
+	user := core.User{Username: "analyst"}
+
+	r.Nil(datastore.ArchiveEvent(context.Background(), "1", user))
+	r.Nil(datastore.EscalateEvent(context.Background(), "1", user))
+
+	event, err := datastore.GetEventById(context.Background(), "1")
+	r.Nil(err)
+	source := event["_source"].(eve.EveEvent)
+	r.Contains(source["tags"], "archived")
+	r.Contains(source["tags"], "escalated")
+	history := source["evebox"].(map[string]interface{})["history"].([]interface{})
+	r.Len(history, 2)
+	r.Equal("archived", history[0].(map[string]interface{})["action"])
+	r.Equal("analyst", history[0].(map[string]interface{})["username"])
+	r.Equal("escalated", history[1].(map[string]interface{})["action"])
+
+	// Escalating an already escalated event does not add to its history.
+	r.Nil(datastore.EscalateEvent(context.Background(), "1", user))
+	r.Nil(datastore.DeEscalateEvent(context.Background(), "1", user))
+
+	event, err = datastore.GetEventById(context.Background(), "1")
+	r.Nil(err)
+	source = event["_source"].(eve.EveEvent)
+	r.NotContains(source["tags"], "escalated")
+	history = source["evebox"].(map[string]interface{})["history"].([]interface{})
+	r.Len(history, 3)
+	r.Equal("de-escalated", history[2].(map[string]interface{})["action"])
+
+	err = datastore.ArchiveEvent(context.Background(), "100", user)
+	r.IsType(&core.EventNotFoundError{}, err)
+	err = datastore.EscalateEvent(context.Background(), "bad", user)
+	r.IsType(&core.EventNotFoundError{}, err)
+}
+
+func TestComments(t *testing.T) {
+	r := require.New(t)
+	db, teardown := Setup(t)
+	defer teardown()
+	SubmitEvents(t, db, reportEvents...)
+
+	datastore := NewDataStore(db)
+	user := core.User{Username: "analyst"}
+
+	r.Nil(datastore.ArchiveEvent(context.Background(), "2", user))
+	r.Nil(datastore.CommentOnEventId(context.Background(), "2", user, "a comment"))
+
+	event, err := datastore.GetEventById(context.Background(), "2")
+	r.Nil(err)
+	source := event["_source"].(eve.EveEvent)
+	history := source["evebox"].(map[string]interface{})["history"].([]interface{})
+	r.Len(history, 2)
+	r.Equal("archived", history[0].(map[string]interface{})["action"])
+	r.Equal("comment", history[1].(map[string]interface{})["action"])
+	r.Equal("a comment", history[1].(map[string]interface{})["comment"])
+	r.Equal("analyst", history[1].(map[string]interface{})["username"])
+
+	// Only the alerts in the group get the comment.
+	r.Nil(datastore.CommentOnAlertGroup(context.Background(), core.AlertGroupQueryParams{
+		SignatureID: 1,
+		SrcIP:       "10.0.0.1",
+		DstIP:       "10.0.0.2",
+	}, user, "group comment"))
+
+	event, err = datastore.GetEventById(context.Background(), "1")
+	r.Nil(err)
+	source = event["_source"].(eve.EveEvent)
+	history = source["evebox"].(map[string]interface{})["history"].([]interface{})
+	r.Len(history, 1)
+	r.Equal("group comment", history[0].(map[string]interface{})["comment"])
+
+	event, err = datastore.GetEventById(context.Background(), "2")
+	r.Nil(err)
+	source = event["_source"].(eve.EveEvent)
+	history = source["evebox"].(map[string]interface{})["history"].([]interface{})
+	r.Len(history, 2)
+
+	err = datastore.CommentOnEventId(context.Background(), "100", user, "no event")
+	r.IsType(&core.EventNotFoundError{}, err)
+}
+
+func TestFindNetflow(t *testing.T) {
+	r := require.New(t)
+	db, teardown := Setup(t)
+	defer teardown()
+	SubmitEvents(t, db,
+		`{"timestamp":"2017-06-01T10:00:00.000000+0000","event_type":"netflow","src_ip":"10.0.0.1","dest_ip":"10.0.0.2","netflow":{"pkts":10,"bytes":900,"start":"2017-06-01T09:59:00.000000+0000"}}`,
+		`{"timestamp":"2017-06-01T10:00:01.000000+0000","event_type":"netflow","src_ip":"10.0.0.3","dest_ip":"10.0.0.2","netflow":{"pkts":2,"bytes":1000,"start":"2017-06-01T09:58:00.000000+0000"}}`,
+		`{"timestamp":"2017-06-01T10:00:02.000000+0000","event_type":"netflow","src_ip":"10.0.0.1","dest_ip":"10.0.0.4","netflow":{"pkts":100,"bytes":50,"start":"2017-06-01T09:57:00.000000+0000"}}`,
+		reportEvents[0])
+
+	datastore := NewDataStore(db)
+
+	ids := func(response interface{}) []int64 {
+		ids := []int64{}
+		for _, event := range response.(map[string]interface{})["data"].([]interface{}) {
+			ids = append(ids, event.(map[string]interface{})["_id"].(int64))
+		}
+		return ids
+	}
+
+	response, err := datastore.FindNetflow(context.Background(), core.EventQueryOptions{},
+		"netflow.bytes", "")
+	r.Nil(err)
+	r.Equal([]int64{2, 1, 3}, ids(response))
+
+	response, err = datastore.FindNetflow(context.Background(), core.EventQueryOptions{Size: 2},
+		"netflow.pkts", "")
+	r.Nil(err)
+	r.Equal([]int64{3, 1}, ids(response))
+
+	response, err = datastore.FindNetflow(context.Background(), core.EventQueryOptions{},
+		"netflow.start", "asc")
+	r.Nil(err)
+	r.Equal([]int64{3, 2, 1}, ids(response))
+
+	response, err = datastore.FindNetflow(context.Background(), core.EventQueryOptions{
+		QueryString: "src_ip:10.0.0.1",
+	}, "netflow.bytes", "")
+	r.Nil(err)
+	r.Equal([]int64{1, 3}, ids(response))
+
+	_, err = datastore.FindNetflow(context.Background(), core.EventQueryOptions{}, "bad'field", "")
+	r.NotNil(err)
+}
@@ -29,7 +29,9 @@
 package sqlite
 
 import (
+	"context"
 	"encoding/json"
+	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/eve"
 	"github.com/jasonish/evebox/log"
 )
@@ -37,6 +39,9 @@ import (
 type op struct {
 	query string
 	args  []interface{}
+
+	// User tags to add to the event with the rowid inserted by this op.
+	tags []string
 }
 
 type SqliteIndexer struct {
@@ -50,7 +55,7 @@ func NewSqliteIndexer(db *SqliteService) *SqliteIndexer {
 	}
 }
 
-func (i *SqliteIndexer) Submit(event eve.EveEvent) error {
+func (i *SqliteIndexer) Submit(ctx context.Context, event eve.EveEvent) error {
 
 	// Convert flow timestamps for UTC.
 	if event.GetString("event_type") == "flow" {
@@ -80,35 +85,82 @@ func (i *SqliteIndexer) Submit(event eve.EveEvent) error {
 		}
 	}
 
+	// The history, such as the archive by a suppression rule, is kept in
+	// the metadata rather than the source.
+	var metadata interface{}
+	if history := event.History(); history != nil {
+		buf, err := json.Marshal(map[string]interface{}{"history": history})
+		if err != nil {
+			return err
+		}
+		metadata = string(buf)
+	}
+	delete(event, "evebox")
+
 	encoded, err := json.Marshal(event)
 	if err != nil {
 		return err
 	}
 
+	// Events may arrive already archived, for example by a suppression
+	// rule, or with user tags.
+	archived := 0
+	userTags := []string{}
+	for _, tag := range event.Tags() {
+		if tag == core.TAG_ARCHIVED {
+			archived = 1
+		} else if !core.IsReservedTag(tag) {
+			userTags = append(userTags, tag)
+		}
+	}
+
 	i.queue = append(i.queue, op{
-		query: "insert into events (timestamp, source) values ($1, $2)",
-		args:  []interface{}{event.Timestamp().UnixNano(), encoded},
+		query: "insert into events (timestamp, archived, metadata, source) values ($1, $2, $3, $4)",
+		args:  []interface{}{event.Timestamp().UnixNano(), archived, metadata, encoded},
 	})
+
+	// The tags are added after this op as they can't be added before
+	// last_insert_rowid() is used.
 	i.queue = append(i.queue, op{
 		query: "insert into events_fts (rowid, source) values (last_insert_rowid(), $1)",
 		args:  []interface{}{encoded},
+		tags:  userTags,
 	})
 
 	return nil
 }
 
-func (i *SqliteIndexer) Commit() (interface{}, error) {
+func (i *SqliteIndexer) Commit(ctx context.Context) (interface{}, error) {
 	queue := i.queue
 	i.queue = nil
 
-	tx, err := i.db.GetTx()
+	tx, err := i.db.GetTx(ctx)
 	if err != nil {
 		log.Error("%v", err)
 		return nil, err
 	}
 
 	for _, op := range queue {
-		_, err := tx.Exec(op.query, op.args...)
+		r, err := tx.ExecContext(ctx, op.query, op.args...)
+		if err != nil {
+			log.Error("%v", err)
+			tx.Rollback()
+			return nil, err
+		}
+		if len(op.tags) == 0 {
+			continue
+		}
+		rowid, err := r.LastInsertId()
+		if err == nil {
+			for _, tag := range op.tags {
+				_, err = tx.ExecContext(ctx,
+					"insert or ignore into event_tags (event_id, tag) values ($1, $2)",
+					rowid, tag)
+				if err != nil {
+					break
+				}
+			}
+		}
 		if err != nil {
 			log.Error("%v", err)
 			tx.Rollback()
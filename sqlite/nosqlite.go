@@ -30,10 +30,16 @@ package sqlite
 
 import (
 	"github.com/jasonish/evebox/appcontext"
+	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/log"
+	"github.com/pkg/errors"
 )
 
 func InitSqlite(appContext *appcontext.AppContext) error {
 	log.Fatal("SQLite support not build in.")
 	return nil
 }
+
+func OpenDataStore(filename string) (core.Datastore, error) {
+	return nil, errors.New("SQLite support not built in.")
+}
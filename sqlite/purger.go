@@ -29,6 +29,7 @@
 package sqlite
 
 import (
+	"context"
 	"github.com/jasonish/evebox/eve"
 	"github.com/jasonish/evebox/log"
 	"time"
@@ -61,7 +62,7 @@ func (p *SqlitePurger) Purge() (int64, error) {
 	then := now.AddDate(0, 0, (p.period+1)*-1)
 	log.Info("Deleting events prior to %v", eve.FormatTimestamp(then))
 
-	tx, err := p.db.GetTx()
+	tx, err := p.db.GetTx(context.Background())
 	if err != nil {
 		log.Error("%v", err)
 		return 0, err
@@ -0,0 +1,241 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package sqlite
+
+import (
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/querystring"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+// Parse the query string and add it to the builder as a where clause.
+//
+// eventTable is the name of the events table in the query, as it may not
+// always be "events" in the case of aliasing.
+func parseQueryString(builder *SqlBuilder, queryString string, eventTable string) error {
+	node, err := querystring.Parse(queryString)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse query string")
+	}
+	if node == nil {
+		return nil
+	}
+	translator := &queryStringTranslator{eventTable: eventTable}
+	where, err := translator.translate(node)
+	if err != nil {
+		return err
+	}
+	builder.WhereArgs(fmt.Sprintf("(%s)", where), translator.args...)
+	return nil
+}
+
+// addressFilter adds a filter for events where the source or destination
+// address is the address, or within the network or range of addresses.
+func addressFilter(builder *SqlBuilder, address string, eventTable string) error {
+	translator := &queryStringTranslator{eventTable: eventTable}
+	where, err := translator.translate(&querystring.Term{
+		Field: "ip",
+		Value: address,
+	})
+	if err != nil {
+		return err
+	}
+	builder.WhereArgs(fmt.Sprintf("(%s)", where), translator.args...)
+	return nil
+}
+
+// queryStringTranslator translates a parsed query string into an SQL
+// expression on the events table.
+type queryStringTranslator struct {
+	eventTable string
+	args       []interface{}
+}
+
+func (t *queryStringTranslator) arg(arg interface{}) string {
+	t.args = append(t.args, arg)
+	return "?"
+}
+
+func (t *queryStringTranslator) translate(node querystring.Node) (string, error) {
+	switch node := node.(type) {
+	case *querystring.And:
+		return t.join(node.Nodes, " AND ")
+	case *querystring.Or:
+		return t.join(node.Nodes, " OR ")
+	case *querystring.Not:
+		expr, err := t.translate(node.Node)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("NOT (%s)", expr), nil
+	case *querystring.Term:
+		return t.term(node)
+	case *querystring.Range:
+		return t.rangeExpr(node)
+	}
+	return "", errors.Errorf("unsupported query node: %v", node)
+}
+
+func (t *queryStringTranslator) join(nodes []querystring.Node, sep string) (string, error) {
+	exprs := []string{}
+	for _, node := range nodes {
+		expr, err := t.translate(node)
+		if err != nil {
+			return "", err
+		}
+		exprs = append(exprs, fmt.Sprintf("(%s)", expr))
+	}
+	return strings.Join(exprs, sep), nil
+}
+
+func (t *queryStringTranslator) like(expr string, value string) string {
+	return fmt.Sprintf(`%s LIKE %s ESCAPE '\'`, expr,
+		t.arg(querystring.WildcardToLike(value)))
+}
+
+func (t *queryStringTranslator) term(term *querystring.Term) (string, error) {
+	switch term.Field {
+	case "":
+		if term.Wildcard {
+			return t.like(fmt.Sprintf("%s.source", t.eventTable),
+				fmt.Sprintf("*%s*", term.Value)), nil
+		}
+		// Full text search, quoted as a phrase for FTS5.
+		return fmt.Sprintf(
+			"%s.rowid IN (SELECT rowid FROM events_fts WHERE events_fts MATCH %s)",
+			t.eventTable,
+			t.arg(fmt.Sprintf(`"%s"`, strings.Replace(term.Value, `"`, `""`, -1)))), nil
+	case "tags", "is":
+		switch term.Value {
+		case core.TAG_ARCHIVED, core.TAG_ESCALATED:
+			return fmt.Sprintf("%s.%s = 1", t.eventTable, term.Value), nil
+		}
+		if term.Field == "is" {
+			return "", errors.Errorf("unsupported is: value: %s", term.Value)
+		}
+		return fmt.Sprintf("%s.rowid IN (SELECT event_id FROM event_tags WHERE tag = %s)",
+			t.eventTable, t.arg(term.Value)), nil
+	case "was":
+		return fmt.Sprintf(`EXISTS (SELECT 1 FROM json_each(%s.metadata, '$.history')
+  WHERE json_extract(json_each.value, '$.action') = %s)`,
+			t.eventTable, t.arg(term.Value)), nil
+	case "has":
+		if term.Value == "comment" {
+			return t.commentExists(""), nil
+		}
+		return "", errors.Errorf("unsupported has: value: %s", term.Value)
+	case "comment":
+		return t.commentExists(" AND " + t.like("comments.comment",
+			fmt.Sprintf("*%s*", term.Value))), nil
+	}
+
+	if querystring.IsAddressField(term.Field) && !term.Wildcard {
+		addresses, err := querystring.ParseAddressRange(term.Value)
+		if err != nil {
+			return "", err
+		}
+		return t.address(term.Field, addresses), nil
+	}
+
+	expr := t.field(term.Field)
+	if term.Wildcard {
+		return t.like(expr, term.Value), nil
+	}
+	return fmt.Sprintf("%s = %s", expr, t.arg(t.value(term.Value))), nil
+}
+
+func (t *queryStringTranslator) commentExists(and string) string {
+	return fmt.Sprintf(
+		"EXISTS (SELECT 1 FROM comments WHERE comments.event_id = %s.rowid%s)",
+		t.eventTable, and)
+}
+
+// address matches events where the address field is within the range,
+// compared using the ip_key SQL function.
+func (t *queryStringTranslator) address(field string, addresses *querystring.AddressRange) string {
+	exprs := []string{}
+	for _, field := range querystring.AddressFields(field) {
+		expr := fmt.Sprintf("ip_key(%s)", t.field(field))
+		if addresses.IsSingle() {
+			exprs = append(exprs, fmt.Sprintf("%s = %s", expr,
+				t.arg(querystring.AddressKey(addresses.Start))))
+		} else {
+			exprs = append(exprs, fmt.Sprintf("%s BETWEEN %s AND %s", expr,
+				t.arg(querystring.AddressKey(addresses.Start)),
+				t.arg(querystring.AddressKey(addresses.End))))
+		}
+	}
+	return strings.Join(exprs, " OR ")
+}
+
+func (t *queryStringTranslator) rangeExpr(r *querystring.Range) (string, error) {
+	if querystring.IsAddressField(r.Field) {
+		addresses, err := r.AddressRange()
+		if err != nil {
+			return "", err
+		}
+		return t.address(r.Field, addresses), nil
+	}
+	expr := t.field(r.Field)
+	exprs := []string{}
+	if r.Min != "" {
+		op := ">"
+		if r.MinInclusive {
+			op = ">="
+		}
+		exprs = append(exprs, fmt.Sprintf("%s %s %s", expr, op, t.arg(t.value(r.Min))))
+	}
+	if r.Max != "" {
+		op := "<"
+		if r.MaxInclusive {
+			op = "<="
+		}
+		exprs = append(exprs, fmt.Sprintf("%s %s %s", expr, op, t.arg(t.value(r.Max))))
+	}
+	if len(exprs) == 0 {
+		return fmt.Sprintf("%s IS NOT NULL", expr), nil
+	}
+	return strings.Join(exprs, " AND "), nil
+}
+
+func (t *queryStringTranslator) field(field string) string {
+	return fmt.Sprintf("json_extract(%s.source, '$.%s')", t.eventTable, field)
+}
+
+// value converts numeric values to numbers so they compare as numbers.
+func (t *queryStringTranslator) value(value string) interface{} {
+	if i, err := strconv.ParseInt(value, 0, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+	return value
+}
@@ -0,0 +1,293 @@
+// +build cgo
+
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/log"
+	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Fields that can be used as aggregation keys are interpolated into the
+// json_extract path so they must be limited to a safe set of characters.
+var aggFieldPattern = regexp.MustCompile(`^[a-zA-Z0-9_\.]+$`)
+
+type ReportService struct {
+	db *SqliteService
+}
+
+func NewReportService(db *SqliteService) *ReportService {
+	return &ReportService{
+		db: db,
+	}
+}
+
+// applyReportOptions adds the filters common to all reports to the provided
+// SqlBuilder.
+func (s *ReportService) applyReportOptions(builder *SqlBuilder, options core.ReportOptions) error {
+	if options.EventType != "" {
+		builder.WhereEquals("json_extract(events.source, '$.event_type')",
+			options.EventType)
+	}
+
+	if options.DnsType != "" {
+		builder.WhereEquals("json_extract(events.source, '$.dns.type')",
+			options.DnsType)
+	}
+
+	if options.SensorFilter != "" {
+		builder.WhereEquals("json_extract(events.source, '$.host')",
+			options.SensorFilter)
+	}
+
+	if options.AddressFilter != "" {
+		if strings.HasSuffix(options.AddressFilter, ".") {
+			prefix := fmt.Sprintf("%s%%", options.AddressFilter)
+			builder.WhereArgs(`(json_extract(events.source, '$.src_ip') LIKE ?
+			    OR json_extract(events.source, '$.dest_ip') LIKE ?)`,
+				prefix, prefix)
+		} else if err := addressFilter(builder, options.AddressFilter, "events"); err != nil {
+			return err
+		}
+	}
+
+	if options.QueryString != "" {
+		if err := parseQueryString(builder, options.QueryString, "events"); err != nil {
+			return err
+		}
+	}
+
+	if options.TimeRange != "" {
+		duration, err := time.ParseDuration(options.TimeRange)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse time range")
+		}
+		minTs := time.Now().Add(duration * -1)
+		builder.WhereGte("events.timestamp", minTs.UnixNano())
+	}
+
+	return nil
+}
+
+// ReportDnsRequestRrnames returns the top requests rrnames.
+func (s *ReportService) ReportDnsRequestRrnames(ctx context.Context, options core.ReportOptions) (interface{}, error) {
+	size := int64(10)
+	if options.Size > 0 {
+		size = options.Size
+	}
+
+	builder := SqlBuilder{}
+	builder.From("events")
+	builder.WhereEquals("json_extract(events.source, '$.event_type')", "dns")
+	builder.WhereEquals("json_extract(events.source, '$.dns.type')", "query")
+	builder.Where("json_extract(events.source, '$.dns.rrname') IS NOT NULL")
+
+	// Event type and DNS type are fixed for this report.
+	options.EventType = ""
+	options.DnsType = ""
+	if err := s.applyReportOptions(&builder, options); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT json_extract(events.source, '$.dns.rrname') AS key,
+	    count(*) AS count`
+	query += builder.BuildFrom()
+	query += builder.BuildWhere()
+	query += fmt.Sprintf(" GROUP BY key ORDER BY count DESC LIMIT %d", size)
+
+	rows, err := s.query(ctx, query, builder.Args())
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]interface{}, 0)
+	for _, row := range rows {
+		data = append(data, row)
+	}
+
+	return data, nil
+}
+
+// ReportAggs returns the top values for the given field along with their
+// count in descending order.
+func (s *ReportService) ReportAggs(ctx context.Context, agg string, options core.ReportOptions) (interface{}, error) {
+	size := int64(10)
+	if options.Size > 0 {
+		size = options.Size
+	}
+
+	if !aggFieldPattern.MatchString(agg) {
+		return nil, errors.Errorf("invalid aggregation field: %s", agg)
+	}
+	field := fmt.Sprintf("json_extract(events.source, '$.%s')", agg)
+
+	builder := SqlBuilder{}
+	builder.From("events")
+	builder.Where(fmt.Sprintf("%s IS NOT NULL", field))
+	if err := s.applyReportOptions(&builder, options); err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("SELECT %s AS key, count(*) AS count", field)
+	query += builder.BuildFrom()
+	query += builder.BuildWhere()
+	query += fmt.Sprintf(" GROUP BY key ORDER BY count DESC LIMIT %d", size)
+
+	data, err := s.query(ctx, query, builder.Args())
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"data": data,
+	}, nil
+}
+
+// ReportHistogram returns the count of events per interval. If a time range
+// is provided, intervals with no events will be filled in with a count of 0
+// for the whole range.
+func (s *ReportService) ReportHistogram(ctx context.Context, interval string, options core.ReportOptions) (interface{}, error) {
+	duration, err := core.ParseHistogramInterval(interval)
+	if err != nil {
+		return nil, err
+	}
+	bucketSize := duration.Nanoseconds()
+
+	builder := SqlBuilder{}
+	builder.From("events")
+	builder.Where("events.timestamp IS NOT NULL")
+	if err := s.applyReportOptions(&builder, options); err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(
+		"SELECT (events.timestamp / %d) * %d AS bucket, count(*) AS count",
+		bucketSize, bucketSize)
+	query += builder.BuildFrom()
+	query += builder.BuildWhere()
+	query += " GROUP BY bucket ORDER BY bucket ASC"
+
+	tx, err := s.db.GetTx(ctx)
+	if err != nil {
+		log.Error("%v", err)
+		return nil, err
+	}
+	defer tx.Commit()
+
+	rows, err := tx.QueryContext(ctx, query, builder.Args()...)
+	if err != nil {
+		log.Error("%v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[int64]int64{}
+	var first int64 = -1
+	var last int64 = -1
+
+	for rows.Next() {
+		var bucket int64
+		var count int64
+		if err := rows.Scan(&bucket, &count); err != nil {
+			return nil, err
+		}
+		counts[bucket] = count
+		if first < 0 || bucket < first {
+			first = bucket
+		}
+		if bucket > last {
+			last = bucket
+		}
+	}
+
+	if options.TimeRange != "" {
+		timeRange, _ := time.ParseDuration(options.TimeRange)
+		now := time.Now().UnixNano()
+		first = ((now - timeRange.Nanoseconds()) / bucketSize) * bucketSize
+		last = (now / bucketSize) * bucketSize
+	}
+
+	data := []map[string]interface{}{}
+	if first >= 0 {
+		for bucket := first; bucket <= last; bucket += bucketSize {
+			data = append(data, map[string]interface{}{
+				"key":           bucket / int64(time.Millisecond),
+				"count":         counts[bucket],
+				"key_as_string": eve.FormatTimestampUTC(time.Unix(0, bucket)),
+			})
+		}
+	}
+
+	return map[string]interface{}{
+		"data": data,
+	}, nil
+}
+
+// query runs a key/count aggregation query returning the rows as a list of
+// maps.
+func (s *ReportService) query(ctx context.Context, query string, args []interface{}) ([]map[string]interface{}, error) {
+	tx, err := s.db.GetTx(ctx)
+	if err != nil {
+		log.Error("%v", err)
+		return nil, err
+	}
+	defer tx.Commit()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Error("%v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	data := []map[string]interface{}{}
+
+	for rows.Next() {
+		var key interface{}
+		var count int64
+		if err := rows.Scan(&key, &count); err != nil {
+			return nil, err
+		}
+		if bytes, ok := key.([]byte); ok {
+			key = string(bytes)
+		}
+		data = append(data, map[string]interface{}{
+			"key":   key,
+			"count": count,
+		})
+	}
+
+	return data, nil
+}
@@ -0,0 +1,121 @@
+// +build cgo
+
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package sqlite
+
+import (
+	"context"
+	"github.com/jasonish/evebox/core"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var reportEvents = []string{
+	`{"timestamp":"2017-06-01T10:00:01.000000+0000","event_type":"alert","src_ip":"10.0.0.1","src_port":1000,"dest_ip":"10.0.0.2","dest_port":80,"proto":"TCP","host":"sensor-a","alert":{"signature_id":1,"signature":"SIG ONE","category":"Cat A"}}`,
+	`{"timestamp":"2017-06-01T10:00:02.000000+0000","event_type":"alert","src_ip":"10.0.0.1","src_port":1001,"dest_ip":"10.0.0.3","dest_port":80,"proto":"TCP","host":"sensor-a","alert":{"signature_id":1,"signature":"SIG ONE","category":"Cat A"}}`,
+	`{"timestamp":"2017-06-01T10:05:00.000000+0000","event_type":"alert","src_ip":"10.0.0.4","src_port":1002,"dest_ip":"10.0.0.2","dest_port":443,"proto":"TCP","host":"sensor-b","alert":{"signature_id":2,"signature":"SIG TWO","category":"Cat B"}}`,
+	`{"timestamp":"2017-06-01T10:00:03.000000+0000","event_type":"dns","src_ip":"10.0.0.1","dest_ip":"8.8.8.8","host":"sensor-a","dns":{"type":"query","rrname":"example.com"}}`,
+	`{"timestamp":"2017-06-01T10:00:04.000000+0000","event_type":"dns","src_ip":"10.0.0.1","dest_ip":"8.8.8.8","host":"sensor-a","dns":{"type":"query","rrname":"example.com"}}`,
+	`{"timestamp":"2017-06-01T10:00:05.000000+0000","event_type":"dns","src_ip":"10.0.0.4","dest_ip":"8.8.8.8","host":"sensor-b","dns":{"type":"query","rrname":"example.org"}}`,
+}
+
+func TestReportAggs(t *testing.T) {
+	r := require.New(t)
+	db, teardown := Setup(t)
+	defer teardown()
+	SubmitEvents(t, db, reportEvents...)
+
+	service := NewReportService(db)
+
+	response, err := service.ReportAggs(context.Background(), "src_ip", core.ReportOptions{
+		EventType: "alert",
+	})
+	r.Nil(err)
+	data := response.(map[string]interface{})["data"].([]map[string]interface{})
+	r.Len(data, 2)
+	r.Equal("10.0.0.1", data[0]["key"])
+	r.Equal(int64(2), data[0]["count"])
+
+	response, err = service.ReportAggs(context.Background(), "dest_port", core.ReportOptions{
+		EventType:     "alert",
+		AddressFilter: "10.0.0.2",
+	})
+	r.Nil(err)
+	data = response.(map[string]interface{})["data"].([]map[string]interface{})
+	r.Len(data, 2)
+
+	response, err = service.ReportAggs(context.Background(), "alert.signature", core.ReportOptions{
+		EventType:    "alert",
+		SensorFilter: "sensor-b",
+	})
+	r.Nil(err)
+	data = response.(map[string]interface{})["data"].([]map[string]interface{})
+	r.Len(data, 1)
+	r.Equal("SIG TWO", data[0]["key"])
+
+	_, err = service.ReportAggs(context.Background(), "src_ip') --", core.ReportOptions{})
+	r.NotNil(err)
+}
+
+func TestReportHistogram(t *testing.T) {
+	r := require.New(t)
+	db, teardown := Setup(t)
+	defer teardown()
+	SubmitEvents(t, db, reportEvents...)
+
+	service := NewReportService(db)
+
+	response, err := service.ReportHistogram(context.Background(), "minute", core.ReportOptions{
+		EventType: "alert",
+	})
+	r.Nil(err)
+	data := response.(map[string]interface{})["data"].([]map[string]interface{})
+
+	// 10:00 through 10:05 inclusive, with the empty minutes filled in.
+	r.Len(data, 6)
+	r.Equal(int64(2), data[0]["count"])
+	r.Equal(int64(0), data[1]["count"])
+	r.Equal(int64(1), data[5]["count"])
+	r.Equal("2017-06-01T10:05:00.000000Z", data[5]["key_as_string"])
+}
+
+func TestReportDnsRequestRrnames(t *testing.T) {
+	r := require.New(t)
+	db, teardown := Setup(t)
+	defer teardown()
+	SubmitEvents(t, db, reportEvents...)
+
+	service := NewReportService(db)
+
+	response, err := service.ReportDnsRequestRrnames(context.Background(), core.ReportOptions{})
+	r.Nil(err)
+	data := response.([]interface{})
+	r.Len(data, 2)
+	r.Equal("example.com", data[0].(map[string]interface{})["key"])
+	r.Equal(int64(2), data[0].(map[string]interface{})["count"])
+}
@@ -56,6 +56,13 @@ func (b *SqlBuilder) Where(where string) {
 	b.where = append(b.where, where)
 }
 
+// WhereArgs adds a where clause that takes its own arguments, for example
+// an OR clause with multiple placeholders.
+func (b *SqlBuilder) WhereArgs(where string, args ...interface{}) {
+	b.where = append(b.where, where)
+	b.args = append(b.args, args...)
+}
+
 func (b *SqlBuilder) WhereEquals(field string, value interface{}) {
 	b.where = append(b.where, fmt.Sprintf("%s = ?", field))
 	b.args = append(b.args, value)
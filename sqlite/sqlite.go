@@ -29,14 +29,18 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"github.com/jasonish/evebox/appcontext"
+	"github.com/jasonish/evebox/core"
 	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/querystring"
 	"github.com/jasonish/evebox/sqlite/common"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
+	"net"
 	"os"
 	"path"
 	"strings"
@@ -46,7 +50,16 @@ import (
 const OLD_DB_FILENAME = "evebox.sqlite"
 const DB_FILENAME = "events.sqlite"
 
+// The SQLite driver with the EveBox SQL functions registered.
+const DRIVER = "sqlite3_evebox"
+
 func init() {
+	sql.Register(DRIVER, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("ip_key", ipKey, true)
+		},
+	})
+
 	viper.SetDefault("database.sqlite.disable-fsync", false)
 	viper.BindEnv("database.sqlite.disable-fsync", "DISABLE_FSYNC")
 }
@@ -60,7 +73,7 @@ func NewSqliteService(filename string) (*SqliteService, error) {
 	log.Debug("Opening SQLite database %s", filename)
 	dsn := fmt.Sprintf("file:%s?cache=shared&mode=rwc&_txlock=immediate",
 		filename)
-	db, err := sql.Open("sqlite3", dsn)
+	db, err := sql.Open(DRIVER, dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -72,11 +85,27 @@ func NewSqliteService(filename string) (*SqliteService, error) {
 	return service, nil
 }
 
-func (s *SqliteService) GetTx() (tx *sql.Tx, err error) {
+// ipKey is the SQL function ip_key(addr) returning an IP address as a
+// string that sorts in address order, or NULL if not an IP address.
+func ipKey(addr interface{}) interface{} {
+	value, ok := addr.(string)
+	if !ok {
+		return nil
+	}
+	key := querystring.AddressKey(net.ParseIP(value))
+	if key == "" {
+		return nil
+	}
+	return key
+}
+
+func (s *SqliteService) GetTx(ctx context.Context) (tx *sql.Tx, err error) {
 	for i := 0; i < 100; i++ {
-		tx, err = s.DB.Begin()
+		tx, err = s.DB.BeginTx(ctx, nil)
 		if err == nil {
 			return tx, nil
+		} else if ctx.Err() != nil {
+			return nil, ctx.Err()
 		} else {
 			time.Sleep(10 * time.Millisecond)
 		}
@@ -89,6 +118,20 @@ func (s *SqliteService) Migrate() error {
 	return migrator.Migrate()
 }
 
+// OpenDataStore opens, and migrates if needed, the SQLite database file
+// returning a datastore for it. Used by tools working on a database
+// outside of the server.
+func OpenDataStore(filename string) (core.Datastore, error) {
+	db, err := NewSqliteService(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Migrate(); err != nil {
+		return nil, err
+	}
+	return NewDataStore(db), nil
+}
+
 func InitPurger(db *SqliteService) {
 	retentionPeriod := viper.GetInt("database.retention-period")
 	log.Info("Retention period: %d days", retentionPeriod)
@@ -160,6 +203,9 @@ func InitSqlite(appContext *appcontext.AppContext) (err error) {
 	}
 
 	appContext.DataStore = NewDataStore(db)
+	appContext.ReportService = NewReportService(db)
+	appContext.SetFeature(core.FEATURE_REPORTING)
+	appContext.SetFeature(core.FEATURE_COMMENTS)
 
 	InitPurger(db)
 
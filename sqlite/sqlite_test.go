@@ -1,8 +1,73 @@
+// +build cgo
+
+/* Copyright (c) 2016 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
 package sqlite
 
 import (
+	"context"
+	"github.com/jasonish/evebox/eve"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path"
 	"testing"
 )
 
+// Setup creates a new migrated SQLite database in a temporary directory.
+// The returned function removes the database and should be deferred.
+func Setup(t *testing.T) (*SqliteService, func()) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "evebox-sqlite-test")
+	r.Nil(err)
+
+	db, err := NewSqliteService(path.Join(dir, DB_FILENAME))
+	r.Nil(err)
+	r.Nil(db.Migrate())
+
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// SubmitEvents adds the given raw eve events to the database.
+func SubmitEvents(t *testing.T, db *SqliteService, events ...string) {
+	r := require.New(t)
+	indexer := NewSqliteIndexer(db)
+	for _, raw := range events {
+		event, err := eve.NewEveEventFromString(raw)
+		r.Nil(err)
+		r.Nil(indexer.Submit(context.Background(), event))
+	}
+	_, err := indexer.Commit(context.Background())
+	r.Nil(err)
+}
+
 func TestSqlite(t *testing.T) {
 }
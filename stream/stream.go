@@ -0,0 +1,242 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Package stream publishes received events and changes to the state of
+// alerts, such as archiving and escalating, to the subscribers of the
+// live stream API.
+package stream
+
+import (
+	"encoding/json"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/querystring"
+	"github.com/jasonish/evebox/util"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The number of messages waiting to be sent to a subscriber before new
+// messages are dropped.
+const subscriberQueueSize = 256
+
+// Message types.
+const (
+	TYPE_EVENT     = "event"
+	TYPE_ACTION    = "action"
+	TYPE_DROPPED   = "dropped"
+	TYPE_KEEPALIVE = "keepalive"
+)
+
+// Untagging is not a core.AlertAction, the other actions are.
+const ACTION_UNTAG = "untag"
+
+// Action is a change to the state of alerts made by a user.
+type Action struct {
+	// One of the core.ALERT_ACTION values or ACTION_UNTAG.
+	Action    string    `json:"action"`
+	Username  string    `json:"username,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+
+	// What the action was applied to, one of an event ID, an alert
+	// group as sent by the client or the alert query of a bulk action.
+	EventId    string      `json:"event_id,omitempty"`
+	AlertGroup interface{} `json:"alert_group,omitempty"`
+	Query      interface{} `json:"query,omitempty"`
+
+	// The tags added or removed.
+	Tags []string `json:"tags,omitempty"`
+
+	// The number of events updated by a bulk action.
+	Count int64 `json:"count,omitempty"`
+}
+
+// Message is sent to subscribers. Data is the JSON encoded message with
+// the type and the event or action.
+type Message struct {
+	Type string
+	Data []byte
+}
+
+// NewMessage returns a message of a type, with the value, if any, under
+// a key of the same name.
+func NewMessage(messageType string, value interface{}) Message {
+	body := map[string]interface{}{
+		"type": messageType,
+	}
+	if value != nil {
+		body[messageType] = value
+	}
+	return Message{
+		Type: messageType,
+		Data: []byte(util.ToJson(body)),
+	}
+}
+
+// Options select the messages sent to a subscriber.
+type Options struct {
+	// Send received events matching EventTypes, all if empty, the
+	// query string and the sensor, the host field of the event.
+	Events      bool
+	EventTypes  []string
+	QueryString string
+	Sensor      string
+
+	// Send actions.
+	Actions bool
+}
+
+// Subscriber receives messages on C until unsubscribed.
+type Subscriber struct {
+	C <-chan Message
+
+	queue   chan Message
+	options Options
+	matcher querystring.Matcher
+
+	// Messages dropped as the subscriber fell behind.
+	dropped int64
+}
+
+// Dropped returns the number of messages dropped since it was last
+// called.
+func (s *Subscriber) Dropped() int64 {
+	return atomic.SwapInt64(&s.dropped, 0)
+}
+
+func (s *Subscriber) matches(event eve.EveEvent) bool {
+	if !s.options.Events {
+		return false
+	}
+	if len(s.options.EventTypes) > 0 {
+		found := false
+		for _, eventType := range s.options.EventTypes {
+			if eventType == event.EventType() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if s.options.Sensor != "" && event.GetString("host") != s.options.Sensor {
+		return false
+	}
+	return s.matcher(event)
+}
+
+func (s *Subscriber) send(message Message) {
+	select {
+	case s.queue <- message:
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+	}
+}
+
+// Broker is an eve.EveFilter that publishes the events it filters, and
+// the actions published to it, to its subscribers. Events are published
+// before they are stored so do not have an ID.
+type Broker struct {
+	lock        sync.RWMutex
+	subscribers map[*Subscriber]bool
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: map[*Subscriber]bool{},
+	}
+}
+
+// Subscribe returns a new subscriber, or an error if the query string
+// is invalid.
+func (b *Broker) Subscribe(options Options) (*Subscriber, error) {
+	matcher, err := querystring.CompileString(options.QueryString)
+	if err != nil {
+		return nil, err
+	}
+	queue := make(chan Message, subscriberQueueSize)
+	subscriber := &Subscriber{
+		C:       queue,
+		queue:   queue,
+		options: options,
+		matcher: matcher,
+	}
+	b.lock.Lock()
+	b.subscribers[subscriber] = true
+	b.lock.Unlock()
+	return subscriber, nil
+}
+
+// Unsubscribe stops sending messages to a subscriber.
+func (b *Broker) Unsubscribe(subscriber *Subscriber) {
+	b.lock.Lock()
+	delete(b.subscribers, subscriber)
+	b.lock.Unlock()
+}
+
+// Filter publishes an event to the subscribers it matches.
+func (b *Broker) Filter(event eve.EveEvent) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	// Only encode the event if a subscriber wants it.
+	var message *Message
+	for subscriber := range b.subscribers {
+		if !subscriber.matches(event) {
+			continue
+		}
+		if message == nil {
+			buf, err := json.Marshal(map[string]interface{}{
+				"type":     TYPE_EVENT,
+				TYPE_EVENT: event,
+			})
+			if err != nil {
+				log.Error("Failed to encode event for stream: %v", err)
+				return
+			}
+			message = &Message{Type: TYPE_EVENT, Data: buf}
+		}
+		subscriber.send(*message)
+	}
+}
+
+// PublishAction publishes an action to the subscribers wanting actions.
+func (b *Broker) PublishAction(action Action) {
+	if action.Timestamp.IsZero() {
+		action.Timestamp = time.Now()
+	}
+	message := NewMessage(TYPE_ACTION, action)
+
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for subscriber := range b.subscribers {
+		if subscriber.options.Actions {
+			subscriber.send(message)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package stream
+
+import (
+	"encoding/json"
+	"github.com/jasonish/evebox/eve"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func event(t *testing.T, s string) eve.EveEvent {
+	event, err := eve.NewEveEventFromString(s)
+	require.Nil(t, err)
+	return event
+}
+
+// receive returns the decoded messages waiting for a subscriber.
+func receive(t *testing.T, subscriber *Subscriber) []map[string]interface{} {
+	messages := []map[string]interface{}{}
+	for {
+		select {
+		case message := <-subscriber.C:
+			var decoded map[string]interface{}
+			require.Nil(t, json.Unmarshal(message.Data, &decoded))
+			require.Equal(t, message.Type, decoded["type"])
+			messages = append(messages, decoded)
+		default:
+			return messages
+		}
+	}
+}
+
+func TestBrokerEvents(t *testing.T) {
+	broker := NewBroker()
+
+	all, err := broker.Subscribe(Options{Events: true})
+	require.Nil(t, err)
+
+	alerts, err := broker.Subscribe(Options{
+		Events:      true,
+		EventTypes:  []string{"alert"},
+		QueryString: "src_ip:10.0.0.1",
+		Sensor:      "sensor1",
+	})
+	require.Nil(t, err)
+
+	actions, err := broker.Subscribe(Options{Actions: true})
+	require.Nil(t, err)
+
+	broker.Filter(event(t, `{"timestamp":"2017-06-01T10:00:00.000000+0000","event_type":"alert","host":"sensor1","src_ip":"10.0.0.1","alert":{"signature_id":1}}`))
+	broker.Filter(event(t, `{"timestamp":"2017-06-01T10:00:00.000000+0000","event_type":"alert","host":"sensor2","src_ip":"10.0.0.1","alert":{"signature_id":2}}`))
+	broker.Filter(event(t, `{"timestamp":"2017-06-01T10:00:00.000000+0000","event_type":"dns","host":"sensor1","src_ip":"10.0.0.1"}`))
+	broker.Filter(event(t, `{"timestamp":"2017-06-01T10:00:00.000000+0000","event_type":"alert","host":"sensor1","src_ip":"10.0.0.2","alert":{"signature_id":3}}`))
+
+	require.Len(t, receive(t, all), 4)
+
+	messages := receive(t, alerts)
+	require.Len(t, messages, 1)
+	require.Equal(t, TYPE_EVENT, messages[0]["type"])
+	alert := messages[0]["event"].(map[string]interface{})["alert"]
+	require.Equal(t, float64(1), alert.(map[string]interface{})["signature_id"])
+
+	require.Len(t, receive(t, actions), 0)
+
+	broker.Unsubscribe(all)
+	broker.Filter(event(t, `{"timestamp":"2017-06-01T10:00:00.000000+0000","event_type":"alert","host":"sensor1","src_ip":"10.0.0.1"}`))
+	require.Len(t, receive(t, all), 0)
+	require.Len(t, receive(t, alerts), 1)
+}
+
+func TestBrokerActions(t *testing.T) {
+	broker := NewBroker()
+
+	events, err := broker.Subscribe(Options{Events: true})
+	require.Nil(t, err)
+
+	actions, err := broker.Subscribe(Options{Actions: true})
+	require.Nil(t, err)
+
+	broker.PublishAction(Action{
+		Action:   "archive",
+		Username: "analyst",
+		EventId:  "1",
+	})
+
+	require.Len(t, receive(t, events), 0)
+
+	messages := receive(t, actions)
+	require.Len(t, messages, 1)
+	require.Equal(t, TYPE_ACTION, messages[0]["type"])
+	action := messages[0]["action"].(map[string]interface{})
+	require.Equal(t, "archive", action["action"])
+	require.Equal(t, "analyst", action["username"])
+	require.Equal(t, "1", action["event_id"])
+	require.NotEmpty(t, action["timestamp"])
+}
+
+func TestBrokerDropped(t *testing.T) {
+	broker := NewBroker()
+	subscriber, err := broker.Subscribe(Options{Actions: true})
+	require.Nil(t, err)
+
+	for i := 0; i < subscriberQueueSize+10; i++ {
+		broker.PublishAction(Action{Action: "archive"})
+	}
+	require.Equal(t, int64(10), subscriber.Dropped())
+	require.Equal(t, int64(0), subscriber.Dropped())
+	require.Len(t, receive(t, subscriber), subscriberQueueSize)
+}
+
+func TestBrokerBadQuery(t *testing.T) {
+	_, err := NewBroker().Subscribe(Options{Events: true, QueryString: "src_ip:10.0.0.0/33"})
+	require.NotNil(t, err)
+}
@@ -0,0 +1,184 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Package suppression archives alerts matching suppression rules as they
+// are received.
+package suppression
+
+import (
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/querystring"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+type compiledRule struct {
+	rule   core.SuppressionRule
+	srcIp  *querystring.AddressRange
+	destIp *querystring.AddressRange
+	query  querystring.Matcher
+}
+
+// Filter is an eve.EveFilter that archives alerts matching a suppression
+// rule, tags them as suppressed and records the ID of the rule in the
+// suppression.rule_id field. The archive is recorded in the history of the
+// event, evebox.history, as if done by a user.
+type Filter struct {
+	store core.SuppressionRuleStore
+	rules []compiledRule
+	lock  sync.RWMutex
+}
+
+func NewFilter(store core.SuppressionRuleStore) (*Filter, error) {
+	filter := &Filter{
+		store: store,
+	}
+	if err := filter.Reload(); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// Reload the rules from the store, to be called after the rules are
+// changed.
+func (f *Filter) Reload() error {
+	rules, err := f.store.FindAll()
+	if err != nil {
+		return errors.Wrap(err, "failed to load suppression rules")
+	}
+
+	compiled := []compiledRule{}
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		c, err := compileRule(rule)
+		if err != nil {
+			log.Error("Failed to load suppression rule %s: %v", rule.Id, err)
+			continue
+		}
+		compiled = append(compiled, c)
+	}
+
+	f.lock.Lock()
+	f.rules = compiled
+	f.lock.Unlock()
+
+	log.Debug("Loaded %d suppression rules", len(compiled))
+
+	return nil
+}
+
+func compileRule(rule core.SuppressionRule) (compiledRule, error) {
+	var err error
+	c := compiledRule{rule: rule}
+	if rule.SrcIp != "" {
+		if c.srcIp, err = querystring.ParseAddressRange(rule.SrcIp); err != nil {
+			return c, err
+		}
+	}
+	if rule.DestIp != "" {
+		if c.destIp, err = querystring.ParseAddressRange(rule.DestIp); err != nil {
+			return c, err
+		}
+	}
+	if c.query, err = querystring.CompileString(rule.QueryString); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+func (r *compiledRule) matches(event eve.EveEvent) bool {
+	if r.rule.SignatureId != 0 {
+		if id, ok := event.GetAlertSignatureId(); !ok || id != r.rule.SignatureId {
+			return false
+		}
+	}
+	if r.srcIp != nil && !r.srcIp.Contains(event.SrcIp()) {
+		return false
+	}
+	if r.destIp != nil && !r.destIp.Contains(event.DestIp()) {
+		return false
+	}
+	if r.rule.SrcPort != 0 && event.SrcPort() != r.rule.SrcPort {
+		return false
+	}
+	if r.rule.DestPort != 0 && event.DestPort() != r.rule.DestPort {
+		return false
+	}
+	if r.rule.Host != "" && event.GetString("host") != r.rule.Host {
+		return false
+	}
+	return r.query(event)
+}
+
+// Match returns the first enabled rule matching the event, or nil. Only
+// alerts are matched as other events are not archived.
+func (f *Filter) Match(event eve.EveEvent) *core.SuppressionRule {
+	if event.EventType() != "alert" {
+		return nil
+	}
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	for _, rule := range f.rules {
+		if rule.matches(event) {
+			match := rule.rule
+			return &match
+		}
+	}
+	return nil
+}
+
+func (f *Filter) Filter(event eve.EveEvent) {
+	rule := f.Match(event)
+	if rule == nil {
+		return
+	}
+	event.AddTag(core.TAG_ARCHIVED)
+	event.AddTag("evebox.archived")
+	event.AddTag(core.TAG_SUPPRESSED)
+	event["suppression"] = map[string]interface{}{
+		"rule_id": rule.Id,
+	}
+	event.AddHistory(map[string]interface{}{
+		"timestamp": eve.FormatTimestampUTC(time.Now()),
+		"username":  "",
+		"action":    "archived",
+		"comment":   suppressedComment(rule),
+	})
+}
+
+// suppressedComment names the rule an alert was suppressed by.
+func suppressedComment(rule *core.SuppressionRule) string {
+	if rule.Name == "" {
+		return fmt.Sprintf("Suppressed by rule %s", rule.Id)
+	}
+	return fmt.Sprintf("Suppressed by rule %s (%s)", rule.Name, rule.Id)
+}
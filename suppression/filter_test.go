@@ -0,0 +1,109 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package suppression
+
+import (
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type testStore struct {
+	core.SuppressionRuleStore
+	rules []core.SuppressionRule
+}
+
+func (s *testStore) FindAll() ([]core.SuppressionRule, error) {
+	return s.rules, nil
+}
+
+func newEvent(t *testing.T, raw string) eve.EveEvent {
+	event, err := eve.NewEveEventFromString(raw)
+	require.Nil(t, err)
+	return event
+}
+
+func TestFilter(t *testing.T) {
+	r := require.New(t)
+
+	store := &testStore{
+		rules: []core.SuppressionRule{
+			{
+				Id:          "disabled",
+				SignatureId: 1,
+			},
+			{
+				Id:          "scanner",
+				Enabled:     true,
+				SignatureId: 1,
+				SrcIp:       "10.20.0.0/16",
+				DestPort:    80,
+				Host:        "sensor-1",
+			},
+			{
+				Id:          "query",
+				Enabled:     true,
+				QueryString: "alert.category:*Policy* AND NOT dest_ip:192.168.0.0/16",
+			},
+		},
+	}
+	filter, err := NewFilter(store)
+	r.Nil(err)
+
+	event := newEvent(t, `{"timestamp":"2017-06-01T10:00:01.000000+0000","event_type":"alert","host":"sensor-1","src_ip":"10.20.1.1","src_port":1000,"dest_ip":"10.0.0.2","dest_port":80,"tags":[],"alert":{"signature_id":1,"category":"Attempted Recon"}}`)
+	filter.Filter(event)
+	r.Contains(event.Tags(), core.TAG_ARCHIVED)
+	r.Contains(event.Tags(), core.TAG_SUPPRESSED)
+	r.Equal("scanner", event["suppression"].(map[string]interface{})["rule_id"])
+	history := event["evebox"].(map[string]interface{})["history"].([]interface{})
+	r.Len(history, 1)
+	r.Equal("archived", history[0].(map[string]interface{})["action"])
+	r.Equal("Suppressed by rule scanner",
+		history[0].(map[string]interface{})["comment"])
+
+	// Wrong host.
+	event = newEvent(t, `{"timestamp":"2017-06-01T10:00:01.000000+0000","event_type":"alert","host":"sensor-2","src_ip":"10.20.1.1","src_port":1000,"dest_ip":"10.0.0.2","dest_port":80,"tags":[],"alert":{"signature_id":1,"category":"Attempted Recon"}}`)
+	filter.Filter(event)
+	r.Empty(event.Tags())
+	r.Nil(event["suppression"])
+
+	event = newEvent(t, `{"timestamp":"2017-06-01T10:00:01.000000+0000","event_type":"alert","src_ip":"10.0.0.1","dest_ip":"10.0.0.2","tags":[],"alert":{"signature_id":2,"category":"Potential Corporate Privacy Violation, Policy"}}`)
+	r.Equal("query", filter.Match(event).Id)
+	event = newEvent(t, `{"timestamp":"2017-06-01T10:00:01.000000+0000","event_type":"alert","src_ip":"10.0.0.1","dest_ip":"192.168.1.1","tags":[],"alert":{"signature_id":2,"category":"Potential Corporate Privacy Violation, Policy"}}`)
+	r.Nil(filter.Match(event))
+
+	// Only alerts are suppressed.
+	event = newEvent(t, `{"timestamp":"2017-06-01T10:00:01.000000+0000","event_type":"http","host":"sensor-1","src_ip":"10.20.1.1","dest_ip":"10.0.0.2","dest_port":80,"alert":{"category":"Policy"}}`)
+	r.Nil(filter.Match(event))
+
+	// Rules are replaced on reload.
+	store.rules = store.rules[:1]
+	r.Nil(filter.Reload())
+	event = newEvent(t, `{"timestamp":"2017-06-01T10:00:01.000000+0000","event_type":"alert","host":"sensor-1","src_ip":"10.20.1.1","src_port":1000,"dest_ip":"10.0.0.2","dest_port":80,"tags":[],"alert":{"signature_id":1,"category":"Attempted Recon"}}`)
+	r.Nil(filter.Match(event))
+}
@@ -0,0 +1,109 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package syslog
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// Config is the syslog section of the server configuration.
+type Config struct {
+	// Address of the collector as host:port.
+	Address string `mapstructure:"address"`
+
+	// One of udp, tcp or tls.
+	Protocol string `mapstructure:"protocol"`
+
+	// For tcp and tls, one of octet-counting or newline. Defaults to
+	// newline for tcp and octet-counting for tls as in RFC 5425.
+	Framing string `mapstructure:"framing"`
+
+	// File with the CA certificates to verify the collector with,
+	// otherwise the system certificates are used.
+	TlsCa         string `mapstructure:"tls-ca"`
+	TlsSkipVerify bool   `mapstructure:"tls-skip-verify"`
+
+	// One of cef, leef or rfc5424.
+	Format string `mapstructure:"format"`
+
+	// Syslog facility name, such as local0.
+	Facility string `mapstructure:"facility"`
+
+	// Hostname in the syslog header, defaults to the system hostname.
+	Hostname string `mapstructure:"hostname"`
+
+	// Forward alerts when they are escalated.
+	Escalated bool `mapstructure:"escalated"`
+
+	// Forward alerts as they are received if they match Filter, a
+	// query string. An empty filter matches all alerts.
+	Ingest bool   `mapstructure:"ingest"`
+	Filter string `mapstructure:"filter"`
+}
+
+// DefaultConfig returns the configuration that is used for settings
+// that are not set.
+func DefaultConfig() Config {
+	return Config{
+		Protocol:  "udp",
+		Format:    FORMAT_RFC5424,
+		Facility:  "local0",
+		Escalated: true,
+	}
+}
+
+var facilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+func parseFacility(name string) (int, error) {
+	facility, ok := facilities[strings.ToLower(name)]
+	if !ok {
+		return 0, errors.Errorf("unknown facility: %s", name)
+	}
+	return facility, nil
+}
@@ -0,0 +1,286 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package syslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FORMAT_CEF     = "cef"
+	FORMAT_LEEF    = "leef"
+	FORMAT_RFC5424 = "rfc5424"
+)
+
+// Why an alert was forwarded.
+const (
+	REASON_ESCALATED = "escalated"
+	REASON_MATCHED   = "matched"
+)
+
+// The SD-ID of the RFC 5424 structured data. 32473 is the private
+// enterprise number reserved for documentation by RFC 5612.
+const structuredDataId = "evebox@32473"
+
+// Message is an alert to be forwarded.
+type Message struct {
+	// The EveBox event ID, empty for alerts forwarded as they are
+	// received as they are not stored yet.
+	Id string
+
+	Reason string
+	Event  eve.EveEvent
+}
+
+// A formatter returns the structured data and message parts of a syslog
+// message for an alert. The structured data is "-" if there is none.
+type formatter func(message Message) (string, string)
+
+func newFormatter(format string) (formatter, error) {
+	switch strings.ToLower(format) {
+	case FORMAT_CEF:
+		return formatCef, nil
+	case FORMAT_LEEF:
+		return formatLeef, nil
+	case FORMAT_RFC5424:
+		return formatRfc5424, nil
+	}
+	return nil, fmt.Errorf("unsupported format: %s", format)
+}
+
+// A field is a key and value of the formatted alert, in the order they
+// are to be formatted.
+type field struct {
+	key   string
+	value string
+}
+
+type fields []field
+
+// add adds a field if the value is not empty.
+func (f *fields) add(key string, value interface{}) {
+	s := toString(value)
+	if s == "" {
+		return
+	}
+	*f = append(*f, field{key, s})
+}
+
+func formatCef(message Message) (string, string) {
+	event := message.Event
+	alert := event.GetAlert()
+
+	var ext fields
+	ext.add("rt", event.Timestamp().UnixNano()/int64(time.Millisecond))
+	if isIPv6(event.SrcIp()) || isIPv6(event.DestIp()) {
+		ext.add("c6a2Label", "Source IPv6 Address")
+		ext.add("c6a2", event.SrcIp())
+		ext.add("c6a3Label", "Destination IPv6 Address")
+		ext.add("c6a3", event.DestIp())
+	} else {
+		ext.add("src", event.SrcIp())
+		ext.add("dst", event.DestIp())
+	}
+	ext.add("spt", port(event.SrcPort()))
+	ext.add("dpt", port(event.DestPort()))
+	ext.add("proto", event.Proto())
+	ext.add("app", event["app_proto"])
+	ext.add("dvchost", event["host"])
+	ext.add("cat", alert.Get("category"))
+	ext.add("act", alert.Get("action"))
+	ext.add("externalId", message.Id)
+	if toString(event["flow_id"]) != "" {
+		ext.add("cs1Label", "Flow ID")
+		ext.add("cs1", event["flow_id"])
+	}
+	ext.add("cs2Label", "Reason")
+	ext.add("cs2", message.Reason)
+
+	parts := []string{}
+	for _, field := range ext {
+		parts = append(parts, field.key+"="+cefExtEscaper.Replace(field.value))
+	}
+
+	return "-", fmt.Sprintf("CEF:0|EveBox|EveBox|%s|%s|%s|%d|%s",
+		cefHeaderEscaper.Replace(core.BuildVersion),
+		cefHeaderEscaper.Replace(toString(alert.Get("signature_id"))),
+		cefHeaderEscaper.Replace(alert.GetString("signature")),
+		cefSeverity(severity(event)),
+		strings.Join(parts, " "))
+}
+
+var cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`,
+	"\r", " ", "\n", " ")
+
+var cefExtEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`,
+	"\r", `\r`, "\n", `\n`)
+
+// LEEF 2.0 with the default tab delimiter.
+func formatLeef(message Message) (string, string) {
+	event := message.Event
+	alert := event.GetAlert()
+
+	var attrs fields
+	attrs.add("devTime", event.Timestamp().UTC().Format(leefTimeFormat))
+	attrs.add("devTimeFormat", "MMM dd yyyy HH:mm:ss.SSS z")
+	attrs.add("cat", alert.Get("category"))
+	attrs.add("sev", cefSeverity(severity(event)))
+	attrs.add("src", event.SrcIp())
+	attrs.add("dst", event.DestIp())
+	attrs.add("srcPort", port(event.SrcPort()))
+	attrs.add("dstPort", port(event.DestPort()))
+	attrs.add("proto", event.Proto())
+	attrs.add("signature", alert.Get("signature"))
+	attrs.add("action", alert.Get("action"))
+	attrs.add("appProto", event["app_proto"])
+	attrs.add("sensor", event["host"])
+	attrs.add("flowId", event["flow_id"])
+	attrs.add("eventId", message.Id)
+	attrs.add("reason", message.Reason)
+
+	parts := []string{}
+	for _, field := range attrs {
+		parts = append(parts, field.key+"="+leefEscaper.Replace(field.value))
+	}
+
+	return "-", fmt.Sprintf("LEEF:2.0|EveBox|EveBox|%s|%s|%s",
+		cefHeaderEscaper.Replace(core.BuildVersion),
+		cefHeaderEscaper.Replace(toString(alert.Get("signature_id"))),
+		strings.Join(parts, "\t"))
+}
+
+const leefTimeFormat = "Jan 02 2006 15:04:05.000 MST"
+
+var leefEscaper = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+
+// RFC 5424 with the eve fields as structured data and the signature as
+// the message.
+func formatRfc5424(message Message) (string, string) {
+	event := message.Event
+	alert := event.GetAlert()
+
+	var params fields
+	params.add("signature_id", alert.Get("signature_id"))
+	params.add("signature", alert.Get("signature"))
+	params.add("category", alert.Get("category"))
+	params.add("severity", alert.Get("severity"))
+	params.add("action", alert.Get("action"))
+	params.add("src_ip", event.SrcIp())
+	params.add("src_port", port(event.SrcPort()))
+	params.add("dest_ip", event.DestIp())
+	params.add("dest_port", port(event.DestPort()))
+	params.add("proto", event.Proto())
+	params.add("app_proto", event["app_proto"])
+	params.add("host", event["host"])
+	params.add("flow_id", event["flow_id"])
+	params.add("event_id", message.Id)
+	params.add("reason", message.Reason)
+
+	var sd bytes.Buffer
+	sd.WriteString("[" + structuredDataId)
+	for _, field := range params {
+		sd.WriteString(" " + field.key + `="` + sdEscaper.Replace(field.value) + `"`)
+	}
+	sd.WriteString("]")
+
+	return sd.String(), alert.GetString("signature")
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// The alert severity, 0 if not set.
+func severity(event eve.EveEvent) int {
+	value, err := strconv.Atoi(toString(event.GetAlert().Get("severity")))
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+// cefSeverity maps an alert severity, 1 being the most severe, to the
+// CEF and LEEF severity of 0 to 10, 10 being the most severe.
+func cefSeverity(severity int) int {
+	switch severity {
+	case 1:
+		return 10
+	case 2:
+		return 7
+	case 3:
+		return 5
+	}
+	return 3
+}
+
+// syslogSeverity maps an alert severity to a syslog severity.
+func syslogSeverity(severity int) int {
+	switch severity {
+	case 1:
+		// Critical.
+		return 2
+	case 2:
+		// Error.
+		return 3
+	case 3:
+		// Warning.
+		return 4
+	}
+	// Notice.
+	return 5
+}
+
+func toString(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// port returns a port for formatting, nil if 0 so it is not added.
+func port(port uint16) interface{} {
+	if port == 0 {
+		return nil
+	}
+	return port
+}
+
+func isIPv6(addr string) bool {
+	return strings.Contains(addr, ":")
+}
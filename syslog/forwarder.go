@@ -0,0 +1,257 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Package syslog forwards alerts to a syslog collector over UDP, TCP or
+// TLS, formatted as CEF, LEEF or RFC 5424 structured data.
+package syslog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/jasonish/evebox/log"
+	"github.com/jasonish/evebox/querystring"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+	"sync"
+)
+
+// The number of messages waiting to be sent before alerts received are
+// dropped.
+const queueSize = 1000
+
+// The number of escalated alert queries waiting to be forwarded before
+// more are refused.
+const exportQueueSize = 100
+
+const timestampFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// Forwarder sends alerts to a syslog collector. It is an eve.EveFilter
+// for alerts as they are received, escalated alerts are forwarded with
+// ForwardEscalated or ForwardEscalatedQuery. Messages are sent in the
+// background.
+type Forwarder struct {
+	config   Config
+	format   formatter
+	facility int
+	hostname string
+
+	// Matches the alerts to forward as they are received, nil if
+	// disabled.
+	filter querystring.Matcher
+
+	sender *sender
+	queue  chan []byte
+	wg     sync.WaitGroup
+
+	// Escalated alert queries waiting to be exported into the queue.
+	exports     chan escalatedQuery
+	exportsWg   sync.WaitGroup
+	exportsStop context.CancelFunc
+	exportsCtx  context.Context
+}
+
+// escalatedQuery selects escalated alerts to export from a datastore.
+type escalatedQuery struct {
+	datastore core.Datastore
+	options   core.EventQueryOptions
+}
+
+func NewForwarder(config Config) (*Forwarder, error) {
+	if config.Address == "" {
+		return nil, errors.New("address is required")
+	}
+
+	format, err := newFormatter(config.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	facility, err := parseFacility(config.Facility)
+	if err != nil {
+		return nil, err
+	}
+
+	sender, err := newSender(config)
+	if err != nil {
+		return nil, err
+	}
+
+	forwarder := &Forwarder{
+		config:   config,
+		format:   format,
+		facility: facility,
+		hostname: config.Hostname,
+		sender:   sender,
+		queue:    make(chan []byte, queueSize),
+		exports:  make(chan escalatedQuery, exportQueueSize),
+	}
+	forwarder.exportsCtx, forwarder.exportsStop = context.WithCancel(
+		context.Background())
+
+	if forwarder.hostname == "" {
+		forwarder.hostname, _ = os.Hostname()
+	}
+	forwarder.hostname = strings.Join(strings.Fields(forwarder.hostname), "-")
+	if forwarder.hostname == "" {
+		forwarder.hostname = "-"
+	}
+
+	if config.Ingest {
+		forwarder.filter, err = querystring.CompileString(config.Filter)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid filter")
+		}
+	}
+
+	return forwarder, nil
+}
+
+// Start sending messages.
+func (f *Forwarder) Start() {
+	f.wg.Add(1)
+	go f.run()
+	f.exportsWg.Add(1)
+	go f.runExports()
+}
+
+// Stop sends the queued messages and stops. Escalated alert queries not
+// yet exported are abandoned. No alerts can be forwarded after stopping.
+func (f *Forwarder) Stop() {
+	f.exportsStop()
+	close(f.exports)
+	f.exportsWg.Wait()
+	close(f.queue)
+	f.wg.Wait()
+	f.sender.close()
+}
+
+func (f *Forwarder) run() {
+	defer f.wg.Done()
+	for msg := range f.queue {
+		if err := f.sender.send(msg); err != nil {
+			log.Error("Failed to send alert to syslog collector %s: %v",
+				f.config.Address, err)
+		}
+	}
+}
+
+// Filter forwards alerts matching the filter as they are received.
+// Archived alerts, such as those suppressed, are ignored.
+func (f *Forwarder) Filter(event eve.EveEvent) {
+	if f.filter == nil || event.EventType() != "alert" {
+		return
+	}
+	for _, tag := range event.Tags() {
+		if tag == core.TAG_ARCHIVED {
+			return
+		}
+	}
+	if !f.filter(event) {
+		return
+	}
+	select {
+	case f.queue <- f.encode(Message{Reason: REASON_MATCHED, Event: event}):
+	default:
+		log.Warning("Syslog queue full, dropping alert")
+	}
+}
+
+// Escalated returns true if escalated alerts are forwarded.
+func (f *Forwarder) Escalated() bool {
+	return f.config.Escalated
+}
+
+// ForwardEscalated forwards an escalated alert, waiting for room in the
+// queue if required.
+func (f *Forwarder) ForwardEscalated(ctx context.Context, id string, event eve.EveEvent) error {
+	if !f.config.Escalated || event.EventType() != "alert" {
+		return nil
+	}
+	select {
+	case f.queue <- f.encode(Message{Id: id, Reason: REASON_ESCALATED, Event: event}):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ForwardEscalatedQuery forwards the escalated alerts matching the
+// options. The alerts are exported from the datastore in the background,
+// an error is only returned if too many queries are already waiting.
+func (f *Forwarder) ForwardEscalatedQuery(datastore core.Datastore, options core.EventQueryOptions) error {
+	if !f.config.Escalated {
+		return nil
+	}
+	select {
+	case f.exports <- escalatedQuery{datastore: datastore, options: options}:
+		return nil
+	default:
+		return errors.New("too many escalated alert queries waiting to be forwarded")
+	}
+}
+
+func (f *Forwarder) runExports() {
+	defer f.exportsWg.Done()
+	for query := range f.exports {
+		if err := f.export(f.exportsCtx, query); err != nil {
+			log.Error("Failed to forward escalated alerts to syslog collector %s: %v",
+				f.config.Address, err)
+		}
+	}
+}
+
+// export queues the alerts matching an escalated alert query, waiting
+// for room in the queue as required.
+func (f *Forwarder) export(ctx context.Context, query escalatedQuery) error {
+	return query.datastore.ExportEvents(ctx, query.options,
+		func(event map[string]interface{}) error {
+			buf, err := json.Marshal(event["_source"])
+			if err != nil {
+				return err
+			}
+			source, err := eve.NewEveEventFromBytes(buf)
+			if err != nil {
+				return err
+			}
+			return f.ForwardEscalated(ctx, fmt.Sprint(event["_id"]), source)
+		})
+}
+
+// encode returns the syslog message for an alert, without framing.
+func (f *Forwarder) encode(message Message) []byte {
+	priority := f.facility*8 + syslogSeverity(severity(message.Event))
+	sd, msg := f.format(message)
+	header := fmt.Sprintf("<%d>1 %s %s evebox - alert %s", priority,
+		message.Event.Timestamp().Format(timestampFormat), f.hostname, sd)
+	if msg == "" {
+		return []byte(header)
+	}
+	return []byte(header + " " + msg)
+}
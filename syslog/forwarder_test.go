@@ -0,0 +1,246 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/jasonish/evebox/core"
+	"github.com/jasonish/evebox/eve"
+	"github.com/stretchr/testify/require"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAlert = `{"timestamp":"2017-06-01T10:00:00.123456+0000","event_type":"alert","host":"sensor1","flow_id":1234567890123456,"src_ip":"10.0.0.1","src_port":1000,"dest_ip":"10.0.0.2","dest_port":80,"proto":"TCP","app_proto":"http","tags":[],"alert":{"action":"allowed","signature_id":2000001,"signature":"ET TEST a|b=c \"d\"","category":"Trojan","severity":1}}`
+
+func testEvent(t *testing.T) eve.EveEvent {
+	event, err := eve.NewEveEventFromString(testAlert)
+	require.Nil(t, err)
+	return event
+}
+
+func TestFormatCef(t *testing.T) {
+	_, msg := formatCef(Message{Id: "abc", Reason: REASON_ESCALATED, Event: testEvent(t)})
+	require.Equal(t, `CEF:0|EveBox|EveBox||2000001|ET TEST a\|b=c "d"|10|`+
+		`rt=1496311200123 src=10.0.0.1 dst=10.0.0.2 spt=1000 dpt=80 proto=TCP `+
+		`app=http dvchost=sensor1 cat=Trojan act=allowed externalId=abc `+
+		`cs1Label=Flow ID cs1=1234567890123456 cs2Label=Reason cs2=escalated`, msg)
+}
+
+func TestFormatLeef(t *testing.T) {
+	_, msg := formatLeef(Message{Reason: REASON_MATCHED, Event: testEvent(t)})
+	require.Equal(t, "LEEF:2.0|EveBox|EveBox||2000001|"+
+		"devTime=Jun 01 2017 10:00:00.123 UTC\tdevTimeFormat=MMM dd yyyy HH:mm:ss.SSS z\t"+
+		"cat=Trojan\tsev=10\tsrc=10.0.0.1\tdst=10.0.0.2\tsrcPort=1000\tdstPort=80\t"+
+		"proto=TCP\tsignature=ET TEST a|b=c \"d\"\taction=allowed\tappProto=http\t"+
+		"sensor=sensor1\tflowId=1234567890123456\treason=matched", msg)
+}
+
+func TestFormatRfc5424(t *testing.T) {
+	sd, msg := formatRfc5424(Message{Id: "abc", Reason: REASON_ESCALATED, Event: testEvent(t)})
+	require.Equal(t, `[evebox@32473 signature_id="2000001" signature="ET TEST a|b=c \"d\"" `+
+		`category="Trojan" severity="1" action="allowed" src_ip="10.0.0.1" `+
+		`src_port="1000" dest_ip="10.0.0.2" dest_port="80" proto="TCP" `+
+		`app_proto="http" host="sensor1" flow_id="1234567890123456" `+
+		`event_id="abc" reason="escalated"]`, sd)
+	require.Equal(t, `ET TEST a|b=c "d"`, msg)
+}
+
+func TestForwardUdp(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer conn.Close()
+
+	config := DefaultConfig()
+	config.Address = conn.LocalAddr().String()
+	config.Hostname = "evebox.example.com"
+	config.Ingest = true
+	config.Filter = "alert.signature_id:2000001"
+	forwarder, err := NewForwarder(config)
+	require.Nil(t, err)
+	forwarder.Start()
+
+	// Does not match the filter.
+	other, err := eve.NewEveEventFromString(
+		strings.Replace(testAlert, "2000001", "2000002", 1))
+	require.Nil(t, err)
+	forwarder.Filter(other)
+
+	forwarder.Filter(testEvent(t))
+	forwarder.Stop()
+
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	require.Nil(t, err)
+	msg := string(buf[:n])
+
+	// local0 and critical.
+	require.True(t, strings.HasPrefix(msg,
+		"<130>1 2017-06-01T10:00:00.123456Z evebox.example.com evebox - alert [evebox@32473 "), msg)
+	require.Contains(t, msg, `reason="matched"`)
+	require.True(t, strings.HasSuffix(msg, `] ET TEST a|b=c "d"`), msg)
+
+	// Only one message was sent.
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err = conn.ReadFrom(buf)
+	require.NotNil(t, err)
+}
+
+func TestForwardTcp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+
+	received := make(chan string, 2)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				close(received)
+				return
+			}
+			received <- line
+		}
+	}()
+
+	config := DefaultConfig()
+	config.Address = listener.Addr().String()
+	config.Protocol = "tcp"
+	config.Format = FORMAT_CEF
+	forwarder, err := NewForwarder(config)
+	require.Nil(t, err)
+	forwarder.Start()
+
+	// Not forwarded as received as ingest is not enabled.
+	forwarder.Filter(testEvent(t))
+
+	require.Nil(t, forwarder.ForwardEscalated(context.Background(), "abc", testEvent(t)))
+	forwarder.Stop()
+
+	msg := <-received
+	require.True(t, strings.HasSuffix(msg, " alert - CEF:0|EveBox|EveBox||2000001|"+
+		`ET TEST a\|b=c "d"|10|rt=1496311200123 src=10.0.0.1 dst=10.0.0.2 spt=1000 `+
+		"dpt=80 proto=TCP app=http dvchost=sensor1 cat=Trojan act=allowed "+
+		"externalId=abc cs1Label=Flow ID cs1=1234567890123456 cs2Label=Reason "+
+		"cs2=escalated\n"), msg)
+
+	_, ok := <-received
+	require.False(t, ok)
+}
+
+// exportDatastore exports a single alert once released.
+type exportDatastore struct {
+	core.Datastore
+	release chan struct{}
+	options chan core.EventQueryOptions
+}
+
+func (d *exportDatastore) ExportEvents(ctx context.Context, options core.EventQueryOptions, fn func(event map[string]interface{}) error) error {
+	d.options <- options
+	<-d.release
+	var source map[string]interface{}
+	if err := json.Unmarshal([]byte(testAlert), &source); err != nil {
+		return err
+	}
+	return fn(map[string]interface{}{"_id": "abc", "_source": source})
+}
+
+func TestForwardEscalatedQuery(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer conn.Close()
+
+	config := DefaultConfig()
+	config.Address = conn.LocalAddr().String()
+	forwarder, err := NewForwarder(config)
+	require.Nil(t, err)
+	forwarder.Start()
+
+	// Returns without waiting for the export.
+	datastore := &exportDatastore{
+		release: make(chan struct{}),
+		options: make(chan core.EventQueryOptions, 1),
+	}
+	options := core.EventQueryOptions{QueryString: "tags:escalated", EventType: "alert"}
+	require.Nil(t, forwarder.ForwardEscalatedQuery(datastore, options))
+	require.Equal(t, options, <-datastore.options)
+	close(datastore.release)
+
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	require.Nil(t, err)
+	msg := string(buf[:n])
+	require.Contains(t, msg, `event_id="abc" reason="escalated"`)
+
+	forwarder.Stop()
+}
+
+func TestOctetCounting(t *testing.T) {
+	server, client := net.Pipe()
+	s := &sender{framing: FRAMING_OCTET_COUNTING, conn: client}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.send([]byte("<134>1 - - - - - - hello"))
+	}()
+	buf := make([]byte, 100)
+	n, _ := server.Read(buf)
+	require.Equal(t, "24 <134>1 - - - - - - hello", string(buf[:n]))
+	require.Nil(t, <-errs)
+}
+
+func TestNewForwarderErrors(t *testing.T) {
+	config := DefaultConfig()
+	_, err := NewForwarder(config)
+	require.NotNil(t, err)
+
+	config.Address = "127.0.0.1:514"
+	config.Format = "json"
+	_, err = NewForwarder(config)
+	require.NotNil(t, err)
+
+	config.Format = FORMAT_LEEF
+	config.Protocol = "sctp"
+	_, err = NewForwarder(config)
+	require.NotNil(t, err)
+
+	config.Protocol = "udp"
+	config.Facility = "local9"
+	_, err = NewForwarder(config)
+	require.NotNil(t, err)
+}
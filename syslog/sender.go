@@ -0,0 +1,158 @@
+/* Copyright (c) 2017 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package syslog
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+const (
+	FRAMING_OCTET_COUNTING = "octet-counting"
+	FRAMING_NEWLINE        = "newline"
+)
+
+const (
+	dialTimeout  = 10 * time.Second
+	writeTimeout = 10 * time.Second
+)
+
+// sender writes messages to the collector, connecting when required.
+// It is not safe for concurrent use.
+type sender struct {
+	network string
+	address string
+	framing string
+
+	// Set for TLS.
+	tlsConfig *tls.Config
+
+	conn net.Conn
+}
+
+func newSender(config Config) (*sender, error) {
+	s := &sender{
+		address: config.Address,
+		framing: config.Framing,
+	}
+
+	switch config.Protocol {
+	case "udp":
+		s.network = "udp"
+	case "tcp":
+		s.network = "tcp"
+		if s.framing == "" {
+			s.framing = FRAMING_NEWLINE
+		}
+	case "tls":
+		s.network = "tcp"
+		if s.framing == "" {
+			s.framing = FRAMING_OCTET_COUNTING
+		}
+		s.tlsConfig = &tls.Config{
+			InsecureSkipVerify: config.TlsSkipVerify,
+		}
+		if config.TlsCa != "" {
+			pem, err := ioutil.ReadFile(config.TlsCa)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read tls-ca")
+			}
+			s.tlsConfig.RootCAs = x509.NewCertPool()
+			if !s.tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, errors.Errorf("no certificates found in %s",
+					config.TlsCa)
+			}
+		}
+	default:
+		return nil, errors.Errorf("unsupported protocol: %s", config.Protocol)
+	}
+
+	switch s.framing {
+	case "", FRAMING_OCTET_COUNTING, FRAMING_NEWLINE:
+	default:
+		return nil, errors.Errorf("unsupported framing: %s", s.framing)
+	}
+
+	return s, nil
+}
+
+func (s *sender) connect() (err error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if s.tlsConfig != nil {
+		s.conn, err = tls.DialWithDialer(dialer, s.network, s.address,
+			s.tlsConfig)
+	} else {
+		s.conn, err = dialer.Dial(s.network, s.address)
+	}
+	return err
+}
+
+func (s *sender) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+func (s *sender) send(msg []byte) error {
+	switch s.framing {
+	case FRAMING_OCTET_COUNTING:
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	case FRAMING_NEWLINE:
+		msg = append(msg, '\n')
+	}
+
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+	if err := s.write(msg); err != nil {
+		// The collector may have closed the connection, reconnect
+		// and try once more.
+		s.close()
+		if err := s.connect(); err != nil {
+			return err
+		}
+		if err := s.write(msg); err != nil {
+			s.close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sender) write(msg []byte) error {
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := s.conn.Write(msg)
+	return err
+}